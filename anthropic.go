@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Settings of Anthropic Messages API
+const (
+	ANTHROPIC_MESSAGES_PATH  = "/v1/messages"
+	ANTHROPIC_MODELS_PATH    = "/v1/models"
+	ANTHROPIC_VERSION        = "2023-06-01"
+	ANTHROPIC_API_KEY_HEADER = "x-api-key"
+	// Sent when the request leaves max_tokens to the API, since Anthropic requires it
+	ANTHROPIC_DEFAULT_MAX_TOKENS = 1024
+)
+
+// Translation between OpenAI shaped chat requests and an API with another shape
+type apiAdapter interface {
+	// Paths of chat endpoint for model and of models endpoint under base URL, empty when there is no models endpoint
+	chatPath(model string) string
+	modelsPath() string
+	setAuthHeader(header http.Header, apiKey string)
+	apiKeyOf(header http.Header) string
+	encodeRequest(chatReq *chatRequest) ([]byte, error)
+	// Translate response body into an OpenAI shaped one
+	decodeResponse(body []byte) ([]byte, error)
+	// Translate data of a stream event into a chunk, nil for events without content.
+	// done reports the end of the stream.
+	decodeStreamEvent(data []byte) (chunk *chatStreamChunk, done bool, err error)
+}
+
+// Anthropic Messages API, translated to and from the chat completions shape.
+// Plain chat, images and streaming are supported, function calls are not.
+var Anthropic = Provider{
+	Name:          "anthropic",
+	BaseURL:       "https://api.anthropic.com",
+	APIKeyEnv:     "ANTHROPIC_API_KEY",
+	DefaultModel:  "claude-3-5-sonnet-latest",
+	ModelPrefixes: []string{"claude-"},
+	Capabilities:  &Capabilities{},
+	adapter:       anthropicAdapter{},
+}
+
+type anthropicAdapter struct{}
+
+// Request body of Messages API
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	System        string             `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	MaxTokens     int                `json:"max_tokens"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+// Content block of a message, text or image
+type anthropicBlock struct {
+	Type   string                `json:"type"`
+	Text   string                `json:"text,omitempty"`
+	Source *anthropicImageSource `json:"source,omitempty"`
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"`
+	URL       string `json:"url,omitempty"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+}
+
+// Response body of Messages API
+type anthropicResponse struct {
+	ID         string           `json:"id"`
+	Model      string           `json:"model"`
+	Role       string           `json:"role"`
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Usage      anthropicUsage   `json:"usage"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// Event of a streamed message
+type anthropicStreamEvent struct {
+	Type    string             `json:"type"`
+	Message *anthropicResponse `json:"message"`
+	Delta   struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Error *streamError `json:"error"`
+}
+
+// Stop reasons of Anthropic and the finish reasons they stand for
+var anthropicStopReasons = map[string]string{
+	"end_turn":      "stop",
+	"stop_sequence": "stop",
+	"max_tokens":    "length",
+	"tool_use":      "function_call",
+}
+
+func (anthropicAdapter) chatPath(model string) string {
+	return ANTHROPIC_MESSAGES_PATH
+}
+
+func (anthropicAdapter) modelsPath() string {
+	return ANTHROPIC_MODELS_PATH
+}
+
+func (anthropicAdapter) setAuthHeader(header http.Header, apiKey string) {
+	header.Set(ANTHROPIC_API_KEY_HEADER, apiKey)
+	header.Set("anthropic-version", ANTHROPIC_VERSION)
+}
+
+func (anthropicAdapter) apiKeyOf(header http.Header) string {
+	return header.Get(ANTHROPIC_API_KEY_HEADER)
+}
+
+// Move system messages to the top-level field and merge consecutive messages of the same role
+func (anthropicAdapter) encodeRequest(chatReq *chatRequest) ([]byte, error) {
+	req := anthropicRequest{
+		Model:         chatReq.Model,
+		MaxTokens:     chatReq.MaxTokens,
+		Temperature:   chatReq.Temperature,
+		StopSequences: chatReq.Stop,
+		Stream:        chatReq.Stream,
+	}
+	if req.MaxTokens == 0 {
+		req.MaxTokens = ANTHROPIC_DEFAULT_MAX_TOKENS
+	}
+
+	system, turns, err := splitTurns(chatReq.Messages, "Anthropic")
+	if err != nil {
+		return nil, err
+	}
+	req.System = strings.Join(system, "\n\n")
+	for _, t := range turns {
+		message := anthropicMessage{Role: t.role}
+		for _, m := range t.messages {
+			blocks, err := anthropicBlocks(m)
+			if err != nil {
+				return nil, err
+			}
+			message.Content = append(message.Content, blocks...)
+		}
+		req.Messages = append(req.Messages, message)
+	}
+
+	return json.Marshal(req)
+}
+
+// Consecutive messages of the same role
+type turn struct {
+	role     string
+	messages []reqMessage
+}
+
+// Take system messages apart and group the others into turns, for APIs such as Anthropic and Bedrock
+// which want the system prompt in a field of its own and user and assistant to take turns starting with user
+func splitTurns(messages []reqMessage, api string) ([]string, []turn, error) {
+	var system []string
+	var turns []turn
+	for i, m := range messages {
+		switch m.Role {
+		case "system":
+			system = append(system, m.text())
+			continue
+		case "user", "assistant":
+		default:
+			return nil, nil, fmt.Errorf("%w: message at index %d has role %q, which %s does not support", ErrInvalidRequest, i, m.Role, api)
+		}
+		if m.FunctionCall != nil {
+			return nil, nil, fmt.Errorf("%w: function calls are not supported by %s", ErrInvalidRequest, api)
+		}
+
+		if n := len(turns); n > 0 && turns[n-1].role == m.Role {
+			turns[n-1].messages = append(turns[n-1].messages, m)
+			continue
+		}
+		turns = append(turns, turn{role: m.Role, messages: []reqMessage{m}})
+	}
+	if len(turns) == 0 || turns[0].role != "user" {
+		return nil, nil, fmt.Errorf("%w: %s needs a user message before any assistant message", ErrInvalidRequest, api)
+	}
+	return system, turns, nil
+}
+
+// Convert content of message into blocks
+func anthropicBlocks(m reqMessage) ([]anthropicBlock, error) {
+	if len(m.Parts) == 0 {
+		return []anthropicBlock{anthropicBlock{Type: "text", Text: m.Content}}, nil
+	}
+
+	blocks := make([]anthropicBlock, 0, len(m.Parts))
+	for _, part := range m.Parts {
+		switch {
+		case part.Type == "text":
+			blocks = append(blocks, anthropicBlock{Type: "text", Text: part.Text})
+		case part.Type == "image_url" && part.ImageURL != nil:
+			source, err := anthropicImage(part.ImageURL.URL)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, anthropicBlock{Type: "image", Source: source})
+		default:
+			return nil, fmt.Errorf("%w: content part of type %q is not supported by Anthropic", ErrInvalidRequest, part.Type)
+		}
+	}
+	return blocks, nil
+}
+
+// Convert image URL into image source, inlining base64 data URLs
+func anthropicImage(url string) (*anthropicImageSource, error) {
+	if !strings.HasPrefix(url, "data:") {
+		return &anthropicImageSource{Type: "url", URL: url}, nil
+	}
+	meta, data, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	mediaType, isBase64 := strings.CutSuffix(meta, ";base64")
+	if !ok || !isBase64 {
+		return nil, fmt.Errorf("%w: image data URL must be base64 encoded", ErrInvalidRequest)
+	}
+	return &anthropicImageSource{Type: "base64", MediaType: mediaType, Data: data}, nil
+}
+
+// Map message to a chat completion with a single choice
+func (anthropicAdapter) decodeResponse(body []byte) ([]byte, error) {
+	res := anthropicResponse{}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	if res.Role == "" {
+		return nil, errors.New("Anthropic response has no role")
+	}
+
+	var content strings.Builder
+	for _, block := range res.Content {
+		if block.Type == "text" {
+			content.WriteString(block.Text)
+		}
+	}
+	return json.Marshal(chatResponse{
+		ID:     res.ID,
+		Object: "chat.completion",
+		Model:  res.Model,
+		Choices: []choice{
+			choice{
+				Message:      resMessage{Role: res.Role, Content: content.String()},
+				FinishReason: anthropicFinishReason(res.StopReason),
+			},
+		},
+		Usage: usage{
+			PromptTokens:     res.Usage.InputTokens,
+			CompletionTokens: res.Usage.OutputTokens,
+			TotalTokens:      res.Usage.InputTokens + res.Usage.OutputTokens,
+		},
+	})
+}
+
+func (anthropicAdapter) decodeStreamEvent(data []byte) (*chatStreamChunk, bool, error) {
+	event := anthropicStreamEvent{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, false, err
+	}
+
+	switch event.Type {
+	case "message_start":
+		chunk := &chatStreamChunk{Object: "chat.completion.chunk", Choices: []streamChoice{streamChoice{Delta: chunkDelta{Role: "assistant"}}}}
+		if event.Message != nil {
+			chunk.ID, chunk.Model = event.Message.ID, event.Message.Model
+		}
+		return chunk, false, nil
+	case "content_block_delta":
+		if event.Delta.Type != "text_delta" {
+			return nil, false, nil
+		}
+		return &chatStreamChunk{Choices: []streamChoice{streamChoice{Delta: chunkDelta{Content: event.Delta.Text}}}}, false, nil
+	case "message_delta":
+		return &chatStreamChunk{Choices: []streamChoice{streamChoice{FinishReason: anthropicFinishReason(event.Delta.StopReason)}}}, false, nil
+	case "message_stop":
+		return nil, true, nil
+	case "error":
+		if event.Error == nil {
+			event.Error = &streamError{Message: "unknown error"}
+		}
+		return &chatStreamChunk{Error: event.Error}, false, nil
+	default:
+		//ping, content_block_start and content_block_stop carry no text
+		return nil, false, nil
+	}
+}
+
+// Map stop reason to finish reason, unknown ones are kept as they are
+func anthropicFinishReason(stopReason string) string {
+	if reason, ok := anthropicStopReasons[stopReason]; ok {
+		return reason
+	}
+	return stopReason
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Start fake Messages API answering with handler, and create an Anthropic client pointed at it
+func newAnthropicServer(t *testing.T, handler http.HandlerFunc, opts ...Option) (*fakeServer, *Client) {
+	t.Helper()
+	f, _ := newFakeServer(t, handler)
+	return f, f.newClient(t, append([]Option{WithProvider(Anthropic), WithBaseURL(f.URL), WithAPIKey(testAPIKey), WithRetryPolicy(NoRetry)}, opts...)...)
+}
+
+// Handler answering with a Messages API response from testdata/anthropic, as a stream for streamed requests
+func respondAnthropic(t *testing.T) http.HandlerFunc {
+	t.Helper()
+	message, err := os.ReadFile(filepath.Join("testdata", "anthropic", "text.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := os.ReadFile(filepath.Join("testdata", "anthropic", "stream.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Stream bool `json:"stream"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write(stream)
+			return
+		}
+		respondJSON(http.StatusOK, string(message))(w, r)
+	}
+}
+
+func TestAnthropicRequestGolden(t *testing.T) {
+	ignore := func(string) error { return nil }
+	chat := func(messages ...reqMessage) func(ctx context.Context, c *Client) error {
+		return func(ctx context.Context, c *Client) error {
+			_, err := c.Chat(ctx, messages)
+			return err
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts []Option
+		send func(ctx context.Context, c *Client) error
+	}{
+		{
+			name: "prompt",
+			send: func(ctx context.Context, c *Client) error {
+				_, err := c.Complete(ctx, "Translate 'cat' into French.")
+				return err
+			},
+		},
+		{
+			name: "system_prompt",
+			opts: []Option{WithDefaultSystemPrompt("You are a concise translator.")},
+			send: chat(reqMessage{Role: "user", Content: "Translate 'cat' into French."}),
+		},
+		{
+			name: "system_messages",
+			send: chat(
+				reqMessage{Role: "system", Content: "You are a concise translator."},
+				reqMessage{Role: "system", Content: "Answer in one word."},
+				reqMessage{Role: "user", Content: "Translate 'cat' into French."},
+			),
+		},
+		{
+			name: "conversation",
+			send: chat(
+				reqMessage{Role: "user", Content: "Give the plural of mouse."},
+				reqMessage{Role: "assistant", Content: "mice"},
+				reqMessage{Role: "user", Content: "And of goose?"},
+				reqMessage{Role: "user", Content: "Answer in one word."},
+			),
+		},
+		{
+			name: "image_url",
+			send: chat(ImageMessage("What is in this picture?", "https://example.com/cat.png")),
+		},
+		{
+			name: "image_data",
+			send: chat(ImageMessage("What is in this picture?", "data:image/png;base64,iVBORw0KGgoAAAANSUhEUg==")),
+		},
+		{
+			name: "sampling",
+			opts: []Option{WithTemperature(0.2), WithMaxTokens(256), WithStop("###")},
+			send: chat(reqMessage{Role: "user", Content: "Write a haiku about autumn."}),
+		},
+		{
+			name: "stream",
+			send: func(ctx context.Context, c *Client) error {
+				_, err := c.ChatStream(ctx, []reqMessage{{Role: "user", Content: "Say hello"}}, ignore)
+				return err
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, client := newAnthropicServer(t, respondAnthropic(t), tc.opts...)
+			if err := tc.send(context.Background(), client); err != nil {
+				t.Fatalf("Failed to send request: %v", err)
+			}
+			requests := f.captured()
+			if len(requests) != 1 {
+				t.Fatalf("server got %d requests, want 1", len(requests))
+			}
+			req := requests[0]
+			if req.Path != ANTHROPIC_MESSAGES_PATH {
+				t.Errorf("sent to %s, want %s", req.Path, ANTHROPIC_MESSAGES_PATH)
+			}
+			if req.Header.Get(ANTHROPIC_API_KEY_HEADER) != testAPIKey || req.Header.Get("anthropic-version") != ANTHROPIC_VERSION {
+				t.Errorf("got auth headers %q and version %q", req.Header.Get(ANTHROPIC_API_KEY_HEADER), req.Header.Get("anthropic-version"))
+			}
+			if auth := req.Header.Get("Authorization"); auth != "" {
+				t.Errorf("sent Authorization %q, want the key in %s only", auth, ANTHROPIC_API_KEY_HEADER)
+			}
+			checkGolden(t, filepath.Join("testdata", "golden", "anthropic", "request_"+tc.name+".json"), req.Body)
+		})
+	}
+}
+
+func TestAnthropicRequestErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []reqMessage
+		want     string
+	}{
+		{name: "assistant first", messages: []reqMessage{{Role: "assistant", Content: "Hi"}}, want: "needs a user message"},
+		{name: "function role", messages: []reqMessage{{Role: "user", Content: "Hi"}, FunctionResultMessage("get_weather", "{}")}, want: `role "function"`},
+		{name: "image data not base64", messages: []reqMessage{ImageMessage("What is this?", "data:image/png,raw")}, want: "base64"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, client := newAnthropicServer(t, respondAnthropic(t))
+			captureLogs(t)
+			_, err := client.Chat(context.Background(), tc.messages)
+			if !errors.Is(err, ErrInvalidRequest) || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got error %v, want ErrInvalidRequest about %q", err, tc.want)
+			}
+			if n := len(f.captured()); n != 0 {
+				t.Errorf("server got %d requests, want none", n)
+			}
+		})
+	}
+}
+
+func TestAnthropicResponseGolden(t *testing.T) {
+	for _, name := range []string{"text", "blocks", "max_tokens"} {
+		t.Run(name, func(t *testing.T) {
+			body, err := os.ReadFile(filepath.Join("testdata", "anthropic", name+".json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			translated, err := anthropicAdapter{}.decodeResponse(body)
+			if err != nil {
+				t.Fatalf("decodeResponse: %v", err)
+			}
+			checkGolden(t, filepath.Join("testdata", "golden", "anthropic", "response_"+name+".json"), translated)
+		})
+	}
+
+	t.Run("result", func(t *testing.T) {
+		_, client := newAnthropicServer(t, respondAnthropic(t))
+		result, err := client.Complete(context.Background(), "Say hello in French")
+		if err != nil {
+			t.Fatalf("Complete: %v", err)
+		}
+		if result.Content != "Bonjour !" || result.FinishReason != "stop" || result.Usage.TotalTokens != 18 {
+			t.Errorf("got content %q, finish reason %q and usage %+v", result.Content, result.FinishReason, result.Usage)
+		}
+	})
+}
+
+func TestAnthropicStreamGolden(t *testing.T) {
+	stream, err := os.ReadFile(filepath.Join("testdata", "anthropic", "stream.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Chunks translated from the data of each event, nil for events without content
+	var chunks []*chatStreamChunk
+	scanner := bufio.NewScanner(bytes.NewReader(stream))
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		chunk, done, err := anthropicAdapter{}.decodeStreamEvent([]byte(data))
+		if err != nil {
+			t.Fatalf("decodeStreamEvent %s: %v", data, err)
+		}
+		chunks = append(chunks, chunk)
+		if done {
+			break
+		}
+	}
+	translated, err := json.Marshal(chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, filepath.Join("testdata", "golden", "anthropic", "stream.json"), translated)
+
+	_, client := newAnthropicServer(t, respondAnthropic(t))
+	var deltas []string
+	result, err := client.ChatStream(context.Background(), []reqMessage{{Role: "user", Content: "Say hello"}}, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	if result.Content != "Hello there" || result.FinishReason != "stop" || strings.Join(deltas, "|") != "Hello| there" {
+		t.Errorf("got content %q in deltas %q with finish reason %q", result.Content, deltas, result.FinishReason)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment variable naming a file which contains the API key, e.g. a mounted Kubernetes secret
+const API_KEY_FILE_ENV = "LLAMA_API_KEY_FILE"
+
+// Read API key from file, stripping surrounding whitespace and trailing newlines
+func readAPIKeyFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "", fmt.Errorf("API key file %s does not exist", path)
+	case errors.Is(err, fs.ErrPermission):
+		return "", fmt.Errorf("Permission denied reading API key file %s", path)
+	case err != nil:
+		return "", fmt.Errorf("Failed to read API key file %s: %w", path, err)
+	}
+
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return "", fmt.Errorf("API key file %s is empty", path)
+	}
+	return key, nil
+}
+
+// Set API key, overriding LLAMA_API_KEY environment variable
+func WithAPIKey(key string) Option {
+	return func(c *Client) error {
+		if key == "" {
+			return errors.New("API key must not be empty")
+		}
+		c.apiKey = key
+		return nil
+	}
+}
+
+// Key files warned about, so that the CLI resolving the key and NewClient reading it again warn once
+var warnedKeyFiles sync.Map
+
+// Warn that the key file at path is used instead of LLAMA_API_KEY when both are set
+func warnAPIKeyEnvIgnored(path string) {
+	if os.Getenv("LLAMA_API_KEY") == "" {
+		return
+	}
+	if _, warned := warnedKeyFiles.LoadOrStore(path, true); !warned {
+		log.Printf("WARNING: Both LLAMA_API_KEY and API key file %s are set, using the file", path)
+	}
+}
+
+// Read API key from file, as LLAMA_API_KEY_FILE environment variable does.
+// Key files win over LLAMA_API_KEY environment variable when both are set, a warning is logged.
+func WithAPIKeyFile(path string) Option {
+	return func(c *Client) error {
+		key, err := readAPIKeyFile(path)
+		if err != nil {
+			return err
+		}
+		warnAPIKeyEnvIgnored(path)
+		c.apiKey = key
+		return nil
+	}
+}
+
+// Use given API keys in turn, switching to the next one when the API answers 401 or 429.
+// Overrides LLAMA_API_KEYS environment variable.
+func WithAPIKeys(keys ...string) Option {
+	return func(c *Client) error {
+		if len(keys) == 0 {
+			return errors.New("API keys must not be empty")
+		}
+		for _, key := range keys {
+			if key == "" {
+				return errors.New("API key must not be empty")
+			}
+		}
+		c.apiKeys = append([]string{}, keys...)
+		return nil
+	}
+}
+
+// Set how long a key rejected with 401 or 429 is skipped before it is tried again
+func WithKeyCooldown(d time.Duration) Option {
+	return func(c *Client) error {
+		if d < 0 {
+			return errors.New("Key cool-down must not be negative")
+		}
+		c.keyCooldown = d
+		return nil
+	}
+}
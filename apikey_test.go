@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Log output safe to read while requests which outlive a call still log, e.g. a cancelled hedge
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// Capture log output for the rest of the test
+func captureLogs(t *testing.T) *logBuffer {
+	t.Helper()
+	buf := &logBuffer{}
+	log.SetOutput(buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return buf
+}
+
+// Write key file into a temporary directory, returning its path
+func writeKeyFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "api-key")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// NewClient, resolveAPIKey and -api-key-file must agree on which key wins
+func TestAPIKeyPrecedence(t *testing.T) {
+	tests := []struct {
+		name     string
+		envKey   string
+		keyFile  string
+		flagFile string
+		keychain string
+		want     string
+		// Source reported by resolveAPIKey
+		wantSource apiKeySource
+		wantWarn   bool
+	}{
+		{name: "environment only", envKey: "env-key", want: "env-key", wantSource: apiKeyFromEnv},
+		{name: "key file only", keyFile: "file-key\n", want: "file-key", wantSource: apiKeyFromFile},
+		{name: "key file wins over environment", envKey: "env-key", keyFile: "  file-key \r\n", want: "file-key", wantSource: apiKeyFromFile, wantWarn: true},
+		{name: "flag wins over environment", envKey: "env-key", flagFile: "flag-key\n", want: "flag-key", wantSource: apiKeyFromFlag, wantWarn: true},
+		{name: "flag wins over key file", keyFile: "file-key", flagFile: "flag-key", want: "flag-key", wantSource: apiKeyFromFlag},
+		{name: "environment wins over keychain", envKey: "env-key", keychain: "keychain-key", want: "env-key", wantSource: apiKeyFromEnv},
+		{name: "keychain only", keychain: "keychain-key", want: "keychain-key", wantSource: apiKeyFromKeychain},
+		{name: "none"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clearClientEnv(t)
+			t.Setenv("LLAMA_API_KEY", tc.envKey)
+			if tc.keyFile != "" {
+				t.Setenv(API_KEY_FILE_ENV, writeKeyFile(t, tc.keyFile))
+			}
+			flagFile := ""
+			if tc.flagFile != "" {
+				flagFile = writeKeyFile(t, tc.flagFile)
+			}
+			keychain := NewMemoryKeychain()
+			if tc.keychain != "" {
+				keychain.Set(KEYCHAIN_SERVICE, KEYCHAIN_ACCOUNT, tc.keychain)
+			}
+			logs := captureLogs(t)
+
+			key, source, err := resolveAPIKey(flagFile, keychain)
+			if err != nil {
+				t.Fatalf("resolveAPIKey: %v", err)
+			}
+			if key != tc.want || source != tc.wantSource {
+				t.Errorf("resolveAPIKey got %q from %q, want %q from %q", key, source, tc.want, tc.wantSource)
+			}
+			if warned := strings.Contains(logs.String(), "WARNING: Both LLAMA_API_KEY"); warned != tc.wantWarn {
+				t.Errorf("got warning %v, want %v: %s", warned, tc.wantWarn, logs)
+			}
+
+			//The client knows no keychain, the CLI passes its key on
+			if tc.keychain != "" {
+				return
+			}
+			var opts []Option
+			if flagFile != "" {
+				opts = append(opts, WithAPIKeyFile(flagFile))
+			}
+			client, err := NewClient(opts...)
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			if client.apiKey != tc.want {
+				t.Errorf("NewClient got key %q, want %q", client.apiKey, tc.want)
+			}
+			if n := strings.Count(logs.String(), "WARNING: Both LLAMA_API_KEY"); n > 1 {
+				t.Errorf("got %d warnings, want at most one: %s", n, logs)
+			}
+		})
+	}
+}
+
+func TestAPIKeyFileErrors(t *testing.T) {
+	unreadable := writeKeyFile(t, "secret")
+	if err := os.Chmod(unreadable, 0o000); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+		// Root reads files regardless of their permissions
+		skipAsRoot bool
+	}{
+		{name: "missing file", path: filepath.Join(t.TempDir(), "missing"), wantErr: "does not exist"},
+		{name: "empty file", path: writeKeyFile(t, " \n\t\n"), wantErr: "is empty"},
+		{name: "permission denied", path: unreadable, wantErr: "Permission denied", skipAsRoot: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.skipAsRoot && os.Getuid() == 0 {
+				t.Skip("Running as root")
+			}
+			clearClientEnv(t)
+			t.Setenv(API_KEY_FILE_ENV, tc.path)
+			captureLogs(t)
+
+			if _, _, err := resolveAPIKey("", NewMemoryKeychain()); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("resolveAPIKey got error %v, want %q", err, tc.wantErr)
+			}
+			_, err := NewClient()
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) || !strings.Contains(err.Error(), tc.path) {
+				t.Errorf("NewClient got error %v, want %q naming the file", err, tc.wantErr)
+			}
+		})
+	}
+}
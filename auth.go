@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Timeout of verifying API key on login
+const AUTH_VERIFY_TIMEOUT = 30 * time.Second
+
+// Run auth subcommand: login, logout or status
+func runAuth(args []string, keychain Keychain, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("Usage: go-llama auth login|logout|status [flags]")
+	}
+	flagSet := flag.NewFlagSet("auth "+args[0], flag.ContinueOnError)
+	registerEnvFileFlag(flagSet)
+	if err := flagSet.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flagSet.NArg() > 0 {
+		return errors.New("Usage: go-llama auth login|logout|status [flags]")
+	}
+
+	switch args[0] {
+	case "login":
+		return authLogin(keychain, stdout)
+	case "logout":
+		err := keychain.Delete(KEYCHAIN_SERVICE, KEYCHAIN_ACCOUNT)
+		if errors.Is(err, ErrKeyNotFound) {
+			fmt.Fprintln(stdout, "Not logged in")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, "Logged out, API key removed from keychain")
+		return nil
+	case "status":
+		key, source, err := resolveAPIKey("", keychain)
+		if err != nil {
+			return err
+		}
+		if key == "" {
+			fmt.Fprintf(stdout, "Not logged in: no API key in %s, LLAMA_API_KEY or keychain\n", API_KEY_FILE_ENV)
+			return nil
+		}
+		fmt.Fprintf(stdout, "Using API key %s from %s\n", redactKey(key), source)
+		return nil
+	default:
+		return fmt.Errorf("Unknown auth command %q, must be login, logout or status", args[0])
+	}
+}
+
+// Prompt for API key without echo, verify it against the API and store it in keychain
+func authLogin(keychain Keychain, stdout io.Writer) error {
+	fmt.Fprint(os.Stderr, "API key: ")
+	key, err := readPassword(os.Stdin)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return fmt.Errorf("Failed to read API key: %w", err)
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return errors.New("API key must not be empty")
+	}
+
+	//Listing models is the cheapest call which checks the key
+	client, err := NewClient(WithAPIKey(key))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), AUTH_VERIFY_TIMEOUT)
+	defer cancel()
+	if err := client.Ping(ctx); err != nil {
+		if errors.Is(err, ErrUnauthorized) {
+			return errors.New("API key was rejected by llama API")
+		}
+		return fmt.Errorf("Failed to verify API key: %w", err)
+	}
+
+	if err := keychain.Set(KEYCHAIN_SERVICE, KEYCHAIN_ACCOUNT, key); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Logged in, API key %s stored in keychain\n", redactKey(key))
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Header carrying API key of Azure OpenAI instead of Authorization
+const AZURE_API_KEY_HEADER = "api-key"
+
+// Azure OpenAI deployment which requests are sent to
+type azureDeployment struct {
+	deployment string
+	apiVersion string
+}
+
+// Send requests to Azure OpenAI deployment instead of llama API.
+// resource is the name of the Azure resource, e.g. "myorg" for https://myorg.openai.azure.com,
+// or a full base URL for custom domains. The deployment name takes the place of the model,
+// and the API key is sent in api-key header instead of Authorization: Bearer.
+func WithAzure(resource, deployment, apiVersion string) Option {
+	return func(c *Client) error {
+		if resource == "" || deployment == "" || apiVersion == "" {
+			return errors.New("Azure resource, deployment and api-version must not be empty")
+		}
+		baseURL := resource
+		if !strings.Contains(resource, "://") {
+			baseURL = "https://" + resource + ".openai.azure.com"
+		}
+		if err := WithBaseURL(baseURL)(c); err != nil {
+			return err
+		}
+		c.azure = &azureDeployment{deployment: deployment, apiVersion: apiVersion}
+		c.model = deployment
+		return nil
+	}
+}
+
+// Get chat completions URL of deployment under base URL
+func (a *azureDeployment) chatCompletionsURL(baseURL string) string {
+	return baseURL + "/openai/deployments/" + url.PathEscape(a.deployment) + CHAT_COMPLETIONS_PATH + "?api-version=" + url.QueryEscape(a.apiVersion)
+}
+
+// Get URL listing models of the resource under base URL
+func (a *azureDeployment) modelsURL(baseURL string) string {
+	return baseURL + "/openai" + MODELS_PATH + "?api-version=" + url.QueryEscape(a.apiVersion)
+}
+
+// Get chat completions URL of model under base URL
+func (c *Client) chatCompletionsURL(baseURL, model string) string {
+	if c.azure != nil {
+		return c.azure.chatCompletionsURL(baseURL)
+	}
+	if c.provider.adapter != nil {
+		return baseURL + c.provider.adapter.chatPath(model)
+	}
+	return baseURL + c.chatCompletionsPath()
+}
+
+// Get models URL under base URL
+func (c *Client) modelsURL(baseURL string) string {
+	if c.azure != nil {
+		return c.azure.modelsURL(baseURL)
+	}
+	if c.provider.adapter != nil {
+		return baseURL + c.provider.adapter.modelsPath()
+	}
+	//Models are listed next to chat completions, e.g. /v1/models for /v1/chat/completions
+	prefix, ok := strings.CutSuffix(c.chatCompletionsPath(), CHAT_COMPLETIONS_PATH)
+	if !ok {
+		prefix = ""
+	}
+	return baseURL + prefix + MODELS_PATH
+}
+
+// Get path of chat completions under base URL
+func (c *Client) chatCompletionsPath() string {
+	if c.completionsPath == "" {
+		return CHAT_COMPLETIONS_PATH
+	}
+	return c.completionsPath
+}
+
+// Set API key header, api-key for Azure, the one of the adapter, if any, and Authorization: Bearer otherwise.
+// Nothing is set without a key, which providers such as Ollama accept.
+func (c *Client) setAuthHeader(header http.Header, apiKey string) {
+	if apiKey == "" {
+		return
+	}
+	if c.azure != nil {
+		header.Set(AZURE_API_KEY_HEADER, apiKey)
+		return
+	}
+	if c.provider.adapter != nil {
+		c.provider.adapter.setAuthHeader(header, apiKey)
+		return
+	}
+	header.Set("Authorization", "Bearer "+apiKey)
+}
+
+// Get API key which was sent with request
+func (c *Client) authKeyOf(req *http.Request) string {
+	if c.azure != nil {
+		return req.Header.Get(AZURE_API_KEY_HEADER)
+	}
+	if c.provider.adapter != nil {
+		return c.provider.adapter.apiKeyOf(req.Header)
+	}
+	return strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+}
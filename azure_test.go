@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Transport answering requests itself with handler, recording them as they leave the client.
+// Requests never reach the network, so any host such as Azure's can be used.
+type stubTransport struct {
+	handler  http.HandlerFunc
+	mu       sync.Mutex
+	requests []capturedRequest
+	urls     []string
+}
+
+func (s *stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+		req.Body.Close()
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, capturedRequest{Method: req.Method, Path: req.URL.Path, Header: req.Header.Clone(), Body: body})
+	s.urls = append(s.urls, req.URL.String())
+	s.mu.Unlock()
+
+	rec := httptest.NewRecorder()
+	s.handler(rec, httptest.NewRequest(req.Method, req.URL.String(), strings.NewReader(string(body))))
+	res := rec.Result()
+	res.Request = req
+	return res, nil
+}
+
+// Get requests sent so far along with their full URLs
+func (s *stubTransport) captured() ([]capturedRequest, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]capturedRequest{}, s.requests...), append([]string{}, s.urls...)
+}
+
+// Answer requests of the client with stub instead of the network
+func withStubTransport(stub *stubTransport) Option {
+	return WithTransportWrapper(func(http.RoundTripper) http.RoundTripper {
+		return stub
+	})
+}
+
+func TestAzureRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		resource   string
+		deployment string
+		apiVersion string
+		wantURL    string
+	}{
+		{
+			name:       "resource name",
+			resource:   "myorg",
+			deployment: "gpt-4o-prod",
+			apiVersion: "2024-06-01",
+			wantURL:    "https://myorg.openai.azure.com/openai/deployments/gpt-4o-prod/chat/completions?api-version=2024-06-01",
+		},
+		{
+			name:       "custom domain",
+			resource:   "https://llm.corp.example/",
+			deployment: "gpt-4o-prod",
+			apiVersion: "2024-10-01-preview",
+			wantURL:    "https://llm.corp.example/openai/deployments/gpt-4o-prod/chat/completions?api-version=2024-10-01-preview",
+		},
+		{
+			name:       "escaped deployment",
+			resource:   "myorg",
+			deployment: "team a/gpt",
+			apiVersion: "2024-06-01",
+			wantURL:    "https://myorg.openai.azure.com/openai/deployments/team%20a%2Fgpt/chat/completions?api-version=2024-06-01",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clearClientEnv(t)
+			stub := &stubTransport{handler: respondChat("Hello from Azure")}
+			client, err := NewClient(WithAPIKey(testAPIKey), WithRetryPolicy(NoRetry), withStubTransport(stub), WithAzure(tc.resource, tc.deployment, tc.apiVersion))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := client.Complete(context.Background(), "Say hello")
+			if err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			if result.Content != "Hello from Azure" {
+				t.Errorf("got content %q", result.Content)
+			}
+			if _, err := client.GenerateStream(context.Background(), "Say hello", func(string) error { return nil }); err != nil {
+				t.Fatalf("GenerateStream: %v", err)
+			}
+
+			reqs, urls := stub.captured()
+			if len(reqs) != 2 {
+				t.Fatalf("sent %d requests, want 2", len(reqs))
+			}
+			for i, req := range reqs {
+				if urls[i] != tc.wantURL {
+					t.Errorf("request %d went to %s, want %s", i+1, urls[i], tc.wantURL)
+				}
+				if got := req.Header.Get(AZURE_API_KEY_HEADER); got != testAPIKey {
+					t.Errorf("request %d sent api-key %q, want the API key", i+1, got)
+				}
+				if got := req.Header.Get("Authorization"); got != "" {
+					t.Errorf("request %d sent Authorization %q, want none", i+1, got)
+				}
+				var body chatRequest
+				if err := json.Unmarshal(req.Body, &body); err != nil {
+					t.Fatal(err)
+				}
+				if body.Model != tc.deployment {
+					t.Errorf("request %d sent model %q, want the deployment %q", i+1, body.Model, tc.deployment)
+				}
+			}
+		})
+	}
+}
+
+func TestAzureModels(t *testing.T) {
+	clearClientEnv(t)
+	stub := &stubTransport{handler: respondJSON(http.StatusOK, `{"data":[{"id":"gpt-4o"}]}`)}
+	client, err := NewClient(WithAPIKey(testAPIKey), withStubTransport(stub), WithAzure("myorg", "gpt-4o-prod", "2024-06-01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.ListModels(context.Background()); err != nil {
+		t.Fatalf("ListModels: %v", err)
+	}
+	reqs, urls := stub.captured()
+	if want := "https://myorg.openai.azure.com/openai/models?api-version=2024-06-01"; urls[0] != want {
+		t.Errorf("listed models at %s, want %s", urls[0], want)
+	}
+	if got := reqs[0].Header.Get(AZURE_API_KEY_HEADER); got != testAPIKey {
+		t.Errorf("sent api-key %q, want the API key", got)
+	}
+}
+
+func TestDefaultModeNotAzure(t *testing.T) {
+	clearClientEnv(t)
+	stub := &stubTransport{handler: respondChat("Hello there")}
+	client, err := NewClient(WithAPIKey(testAPIKey), WithRetryPolicy(NoRetry), withStubTransport(stub))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Complete(context.Background(), "Say hello"); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	reqs, urls := stub.captured()
+	if want := BASE_URL + CHAT_COMPLETIONS_PATH; urls[0] != want {
+		t.Errorf("request went to %s, want %s", urls[0], want)
+	}
+	if got := reqs[0].Header.Get("Authorization"); got != "Bearer "+testAPIKey {
+		t.Errorf("sent Authorization %q, want the bearer token", got)
+	}
+	if got := reqs[0].Header.Get(AZURE_API_KEY_HEADER); got != "" {
+		t.Errorf("sent api-key %q, want none", got)
+	}
+}
+
+func TestAzureInvalid(t *testing.T) {
+	tests := []struct {
+		name                             string
+		resource, deployment, apiVersion string
+	}{
+		{name: "resource", deployment: "gpt-4o-prod", apiVersion: "2024-06-01"},
+		{name: "deployment", resource: "myorg", apiVersion: "2024-06-01"},
+		{name: "api-version", resource: "myorg", deployment: "gpt-4o-prod"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clearClientEnv(t)
+			captureLogs(t)
+			if _, err := NewClient(WithAPIKey(testAPIKey), WithAzure(tc.resource, tc.deployment, tc.apiVersion)); err == nil {
+				t.Errorf("NewClient succeeded without %s", tc.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Outcome of one prompt of a batch
+type BatchResult struct {
+	// Position of the prompt in the input, results arrive in completion order
+	Index  int
+	Prompt string
+	Text   string
+	Err    error
+}
+
+// Job of batch worker pool
+type batchJob struct {
+	index  int
+	prompt string
+}
+
+// Generate text for each prompt received from prompts with up to concurrency requests in flight,
+// sending results to the returned channel, which is closed after the last one.
+//
+// Prompts are read only as fast as workers take them: jobs go through a channel buffered to
+// concurrency, so at most concurrency prompts are queued, concurrency are in flight and concurrency
+// results wait to be received, whatever the size of the batch. A slow reader of the results
+// therefore slows down reading of prompts instead of piling up memory.
+//
+// Cancelling ctx stops reading prompts, remaining workers finish with the context error.
+// The producer of prompts should close the channel, or watch ctx since it may no longer be read,
+// and the caller must keep receiving results until the channel is closed so that workers can exit.
+func (c *Client) GenerateBatch(ctx context.Context, prompts <-chan string, concurrency int, opts ...CallOption) (<-chan BatchResult, error) {
+	if concurrency < 1 {
+		err := fmt.Errorf("%w: batch concurrency must be positive, got %d", ErrInvalidRequest, concurrency)
+		log.Printf("Failed to start batch: %v", err)
+		return nil, err
+	}
+
+	jobs := make(chan batchJob, concurrency)
+	results := make(chan BatchResult, concurrency)
+
+	//Feed jobs until prompts run out or ctx is cancelled
+	go func() {
+		defer close(jobs)
+		for index := 0; ; index++ {
+			select {
+			case prompt, ok := <-prompts:
+				if !ok {
+					return
+				}
+				select {
+				case jobs <- batchJob{index: index, prompt: prompt}:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				text, err := c.Generate(ctx, job.prompt, opts...)
+				results <- BatchResult{Index: job.index, Prompt: job.prompt, Text: text, Err: err}
+			}
+		}()
+	}
+
+	//Close results once all workers are done
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Region of Bedrock unless set with WithBedrock or AWS_REGION
+const BEDROCK_DEFAULT_REGION = "us-east-1"
+
+// Adapter which signs requests instead of sending an API key
+type requestSigner interface {
+	signRequest(req *http.Request, body []byte, now time.Time) error
+}
+
+// Bedrock in the region of AWS_REGION or AWS_DEFAULT_REGION, us-east-1 unless set.
+// Use WithBedrock to choose region and model in code.
+var Bedrock = BedrockProvider(bedrockRegionFromEnv())
+
+// Amazon Bedrock through the Converse API in region, with requests signed with SigV4.
+// Credentials are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, otherwise from
+// the profile in AWS_PROFILE of ~/.aws/credentials and ~/.aws/config. Streaming and
+// function calls are not supported.
+func BedrockProvider(region string) Provider {
+	return Provider{
+		Name:           "bedrock",
+		BaseURL:        "https://bedrock-runtime." + region + ".amazonaws.com",
+		DefaultModel:   "meta.llama3-70b-instruct-v1:0",
+		APIKeyOptional: true,
+		Capabilities:   &Capabilities{},
+		adapter:        bedrockAdapter{region: region},
+	}
+}
+
+// Send requests to Bedrock model in region, e.g. "us-west-2" and "meta.llama3-8b-instruct-v1:0"
+func WithBedrock(region, modelID string) Option {
+	return func(c *Client) error {
+		if region == "" || modelID == "" {
+			return errors.New("Bedrock region and model id must not be empty")
+		}
+		if err := WithProvider(BedrockProvider(region))(c); err != nil {
+			return err
+		}
+		c.model = modelID
+		return nil
+	}
+}
+
+func bedrockRegionFromEnv() string {
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(env); region != "" {
+			return region
+		}
+	}
+	return BEDROCK_DEFAULT_REGION
+}
+
+type bedrockAdapter struct {
+	region string
+}
+
+// Request body of Converse API
+type bedrockRequest struct {
+	Messages        []bedrockMessage        `json:"messages"`
+	System          []bedrockBlock          `json:"system,omitempty"`
+	InferenceConfig *bedrockInferenceConfig `json:"inferenceConfig,omitempty"`
+}
+
+type bedrockMessage struct {
+	Role    string         `json:"role"`
+	Content []bedrockBlock `json:"content"`
+}
+
+type bedrockBlock struct {
+	Text string `json:"text"`
+}
+
+// Bedrock passes maxTokens to Llama models as max_gen_len
+type bedrockInferenceConfig struct {
+	MaxTokens     int      `json:"maxTokens,omitempty"`
+	Temperature   *float64 `json:"temperature,omitempty"`
+	StopSequences []string `json:"stopSequences,omitempty"`
+}
+
+// Response body of Converse API
+type bedrockResponse struct {
+	Output struct {
+		Message *bedrockMessage `json:"message"`
+	} `json:"output"`
+	StopReason string `json:"stopReason"`
+	Usage      struct {
+		InputTokens  int `json:"inputTokens"`
+		OutputTokens int `json:"outputTokens"`
+		TotalTokens  int `json:"totalTokens"`
+	} `json:"usage"`
+}
+
+// Stop reasons of Converse API and the finish reasons they stand for
+var bedrockStopReasons = map[string]string{
+	"end_turn":             "stop",
+	"stop_sequence":        "stop",
+	"max_tokens":           "length",
+	"tool_use":             "function_call",
+	"content_filtered":     "content_filter",
+	"guardrail_intervened": "content_filter",
+}
+
+func (bedrockAdapter) chatPath(model string) string {
+	return "/model/" + url.PathEscape(model) + "/converse"
+}
+
+// Listing models is an API of the control plane, not of bedrock-runtime
+func (bedrockAdapter) modelsPath() string {
+	return ""
+}
+
+// Requests are signed instead, see signRequest
+func (bedrockAdapter) setAuthHeader(header http.Header, apiKey string) {}
+
+func (bedrockAdapter) apiKeyOf(header http.Header) string {
+	return ""
+}
+
+func (a bedrockAdapter) signRequest(req *http.Request, body []byte, now time.Time) error {
+	creds, err := loadAWSCredentials()
+	if err != nil {
+		return err
+	}
+	signV4(req, body, creds, a.region, "bedrock", now)
+	return nil
+}
+
+// Map messages to turns of text blocks with system prompt apart
+func (bedrockAdapter) encodeRequest(chatReq *chatRequest) ([]byte, error) {
+	if chatReq.Stream {
+		return nil, fmt.Errorf("%w: streaming is not supported by Bedrock", ErrInvalidRequest)
+	}
+	system, turns, err := splitTurns(chatReq.Messages, "Bedrock")
+	if err != nil {
+		return nil, err
+	}
+
+	req := bedrockRequest{}
+	for _, s := range system {
+		req.System = append(req.System, bedrockBlock{Text: s})
+	}
+	for _, t := range turns {
+		message := bedrockMessage{Role: t.role}
+		for _, m := range t.messages {
+			for _, part := range m.Parts {
+				if part.Type != "text" {
+					return nil, fmt.Errorf("%w: content part of type %q is not supported by Bedrock", ErrInvalidRequest, part.Type)
+				}
+			}
+			message.Content = append(message.Content, bedrockBlock{Text: m.text()})
+		}
+		req.Messages = append(req.Messages, message)
+	}
+	if chatReq.MaxTokens > 0 || chatReq.Temperature != nil || len(chatReq.Stop) > 0 {
+		req.InferenceConfig = &bedrockInferenceConfig{MaxTokens: chatReq.MaxTokens, Temperature: chatReq.Temperature, StopSequences: chatReq.Stop}
+	}
+
+	return json.Marshal(req)
+}
+
+// Map output message to a chat completion with a single choice
+func (bedrockAdapter) decodeResponse(body []byte) ([]byte, error) {
+	res := bedrockResponse{}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	if res.Output.Message == nil {
+		return nil, errors.New("Bedrock response has no output message")
+	}
+
+	var content strings.Builder
+	for _, block := range res.Output.Message.Content {
+		content.WriteString(block.Text)
+	}
+	finishReason, ok := bedrockStopReasons[res.StopReason]
+	if !ok {
+		finishReason = res.StopReason
+	}
+	return json.Marshal(chatResponse{
+		Object: "chat.completion",
+		Choices: []choice{
+			choice{
+				Message:      resMessage{Role: res.Output.Message.Role, Content: content.String()},
+				FinishReason: finishReason,
+			},
+		},
+		Usage: usage{
+			PromptTokens:     res.Usage.InputTokens,
+			CompletionTokens: res.Usage.OutputTokens,
+			TotalTokens:      res.Usage.TotalTokens,
+		},
+	})
+}
+
+// ConverseStream sends AWS event stream frames rather than server-sent events, and encodeRequest refuses streaming
+func (bedrockAdapter) decodeStreamEvent(data []byte) (*chatStreamChunk, bool, error) {
+	return nil, false, errors.New("Streaming is not supported by Bedrock")
+}
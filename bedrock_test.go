@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const BEDROCK_TEST_MODEL = "meta.llama3-8b-instruct-v1:0"
+
+// Start fake Converse API answering with testdata/bedrock/text.json, and create a Bedrock client
+// pointed at it which signs with the credentials of the SigV4 test suite at the time of a fake clock
+func newBedrockServer(t *testing.T, opts ...Option) (*fakeServer, *Client) {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", "bedrock", "text.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, _ := newFakeServer(t, respondJSON(http.StatusOK, string(body)))
+	clearAWSEnv(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", sigV4TestCreds.accessKeyID)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", sigV4TestCreds.secretAccessKey)
+	return f, f.newClient(t, append([]Option{withClock(newFakeClock()), WithBedrock("us-west-2", BEDROCK_TEST_MODEL), WithBaseURL(f.URL), WithRetryPolicy(NoRetry)}, opts...)...)
+}
+
+func TestBedrockRequestGolden(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		messages []reqMessage
+	}{
+		{
+			name:     "prompt",
+			messages: []reqMessage{{Role: "user", Content: "Translate 'cat' into French."}},
+		},
+		{
+			name: "system_messages",
+			messages: []reqMessage{
+				{Role: "system", Content: "You are a concise translator."},
+				{Role: "system", Content: "Answer in one word."},
+				{Role: "user", Content: "Translate 'cat' into French."},
+			},
+		},
+		{
+			name: "conversation",
+			messages: []reqMessage{
+				{Role: "user", Content: "Give the plural of mouse."},
+				{Role: "assistant", Content: "mice"},
+				{Role: "user", Content: "And of goose?"},
+				{Role: "user", Content: "Answer in one word."},
+			},
+		},
+		{
+			name:     "max_gen_len_and_stop",
+			opts:     []Option{WithMaxTokens(256), WithTemperature(0.2), WithStop("###", "\n\n")},
+			messages: []reqMessage{{Role: "user", Content: "Write a haiku about autumn."}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, client := newBedrockServer(t, tc.opts...)
+			if _, err := client.Chat(context.Background(), tc.messages); err != nil {
+				t.Fatalf("Chat: %v", err)
+			}
+			requests := f.captured()
+			if len(requests) != 1 {
+				t.Fatalf("server got %d requests, want 1", len(requests))
+			}
+			req := requests[0]
+			if want := "/model/" + BEDROCK_TEST_MODEL + "/converse"; req.Path != want {
+				t.Errorf("sent to %s, want %s", req.Path, want)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20240301T120000Z" {
+				t.Errorf("got X-Amz-Date %q, want time of the fake clock", got)
+			}
+			wantAuth := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20240301/us-west-2/bedrock/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature="
+			if got := req.Header.Get("Authorization"); !strings.HasPrefix(got, wantAuth) {
+				t.Errorf("got Authorization %q, want prefix %q", got, wantAuth)
+			}
+			checkGolden(t, filepath.Join("testdata", "golden", "bedrock", "request_"+tc.name+".json"), req.Body)
+		})
+	}
+}
+
+func TestBedrockRequestErrors(t *testing.T) {
+	ignore := func(string) error { return nil }
+	tests := []struct {
+		name string
+		send func(c *Client) error
+		want string
+	}{
+		{name: "stream", send: func(c *Client) error {
+			_, err := c.ChatStream(context.Background(), []reqMessage{{Role: "user", Content: "Hi"}}, ignore)
+			return err
+		}, want: "streaming"},
+		{name: "image", send: func(c *Client) error {
+			_, err := c.Chat(context.Background(), []reqMessage{ImageMessage("What is this?", "https://example.com/cat.png")})
+			return err
+		}, want: "image_url"},
+		{name: "function role", send: func(c *Client) error {
+			_, err := c.Chat(context.Background(), []reqMessage{{Role: "user", Content: "Hi"}, FunctionResultMessage("get_weather", "{}")})
+			return err
+		}, want: `role "function"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, client := newBedrockServer(t)
+			captureLogs(t)
+			if err := tc.send(client); !errors.Is(err, ErrInvalidRequest) || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got error %v, want ErrInvalidRequest about %q", err, tc.want)
+			}
+			if n := len(f.captured()); n != 0 {
+				t.Errorf("server got %d requests, want none", n)
+			}
+		})
+	}
+}
+
+func TestBedrockResponseGolden(t *testing.T) {
+	for _, name := range []string{"text", "max_tokens", "guardrail"} {
+		t.Run(name, func(t *testing.T) {
+			body, err := os.ReadFile(filepath.Join("testdata", "bedrock", name+".json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			translated, err := bedrockAdapter{}.decodeResponse(body)
+			if err != nil {
+				t.Fatalf("decodeResponse: %v", err)
+			}
+			checkGolden(t, filepath.Join("testdata", "golden", "bedrock", "response_"+name+".json"), translated)
+		})
+	}
+
+	t.Run("result", func(t *testing.T) {
+		_, client := newBedrockServer(t)
+		result, err := client.Complete(context.Background(), "Say hello in French")
+		if err != nil {
+			t.Fatalf("Complete: %v", err)
+		}
+		if result.Content != "Bonjour !" || result.FinishReason != "stop" || result.Usage.TotalTokens != 23 {
+			t.Errorf("got content %q, finish reason %q and usage %+v", result.Content, result.FinishReason, result.Usage)
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Matches CircuitOpenError with errors.Is
+var ErrCircuitOpen = errors.New("Circuit breaker is open")
+
+// Error returned without sending a request while the circuit breaker is open
+type CircuitOpenError struct {
+	// Time left until the breaker lets a probe request through, zero while a probe is in flight
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("Circuit breaker is open, retry after %v", e.RetryAfter.Round(time.Millisecond))
+	}
+	return "Circuit breaker is open, waiting for probe request"
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// Circuit breaker which opens after consecutive failures and probes with a single request after cool-down
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     circuitState
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	clock     clock
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, clk clock) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, clock: clk}
+}
+
+// Check if a request may be sent now
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if elapsed := b.clock.Now().Sub(b.openedAt); elapsed < b.cooldown {
+			return &CircuitOpenError{RetryAfter: b.cooldown - elapsed}
+		}
+		//Cool-down is over, let a single probe request through
+		b.state = circuitHalfOpen
+		b.probing = true
+		return nil
+	case circuitHalfOpen:
+		if b.probing {
+			return &CircuitOpenError{}
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Record outcome of a request which was allowed by the breaker
+func (b *circuitBreaker) done(res *http.Response, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	//Cancelled requests say nothing about the provider, just free the probe slot
+	if errors.Is(err, context.Canceled) {
+		b.probing = false
+		return
+	}
+
+	if !isProviderFailure(res, err) {
+		b.state = circuitClosed
+		b.failures = 0
+		b.probing = false
+		return
+	}
+
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.threshold {
+		b.state = circuitOpen
+		b.openedAt = b.clock.Now()
+		b.probing = false
+	}
+}
+
+// Get current state of the breaker
+func (b *circuitBreaker) currentState() circuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Get state of the circuit breaker, "closed", "open" or "half-open".
+// It is always "closed" without WithCircuitBreaker.
+func (c *Client) CircuitState() string {
+	if c.breaker == nil {
+		return circuitClosed.String()
+	}
+	return c.breaker.currentState().String()
+}
+
+// Check if outcome of a request indicates the provider is unhealthy.
+// A request which ran out of time counts as well, as a hung provider is down too.
+func isProviderFailure(res *http.Response, err error) bool {
+	if err == nil {
+		return false
+	}
+	if res != nil && res.StatusCode != http.StatusOK {
+		return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	}
+	return IsTransientError(err) || errors.Is(err, context.DeadlineExceeded)
+}
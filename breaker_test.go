@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/takumi616/go-llama/chaos"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	ctx := context.Background()
+	clk := newFakeClock()
+	var healthy atomic.Bool
+	//State seen by the transport while a request is in flight
+	var stateInFlight atomic.Value
+	var client *Client
+	down := chaos.Fault{Name: "down", Do: func(req *http.Request, next http.RoundTripper) (*http.Response, error) {
+		stateInFlight.Store(client.CircuitState())
+		if healthy.Load() {
+			return next.RoundTrip(req)
+		}
+		return chaos.Status(http.StatusInternalServerError).Do(req, next)
+	}}
+	inject, transport := withChaos(chaos.When(chaos.Always(), down))
+	f, client := newFakeServer(t, respondJSON(http.StatusOK, chatCompletionJSON("Hello there")), withClock(clk), inject, WithCircuitBreaker(2, 30*time.Second))
+
+	complete := func() error {
+		_, err := client.Complete(ctx, "Say hello")
+		return err
+	}
+	wantState := func(want string) {
+		t.Helper()
+		if got := client.CircuitState(); got != want {
+			t.Fatalf("circuit is %s, want %s", got, want)
+		}
+	}
+	wantOpenError := func(err error, retryAfter time.Duration) {
+		t.Helper()
+		var openErr *CircuitOpenError
+		if !errors.Is(err, ErrCircuitOpen) || !errors.As(err, &openErr) || openErr.RetryAfter != retryAfter {
+			t.Fatalf("got error %v, want CircuitOpenError retrying after %v", err, retryAfter)
+		}
+	}
+
+	//Closed: failures below threshold keep it closed
+	if err := complete(); err == nil {
+		t.Fatal("want error from failing server")
+	}
+	wantState("closed")
+
+	//Open: threshold reached, requests fail fast without reaching the server
+	complete()
+	wantState("open")
+	wantOpenError(complete(), 30*time.Second)
+	clk.Advance(10 * time.Second)
+	wantOpenError(complete(), 20*time.Second)
+	if n := transport.Requests(); n != 2 {
+		t.Fatalf("sent %d requests, want 2", n)
+	}
+
+	//Half-open: after cool-down a failing probe opens it again for another cool-down
+	clk.Advance(20 * time.Second)
+	if err := complete(); errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("probe was not let through: %v", err)
+	}
+	if got := stateInFlight.Load(); got != "half-open" {
+		t.Errorf("circuit was %v during probe, want half-open", got)
+	}
+	wantState("open")
+	wantOpenError(complete(), 30*time.Second)
+
+	//Closed again: a successful probe closes it
+	clk.Advance(30 * time.Second)
+	healthy.Store(true)
+	if err := complete(); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	if got := stateInFlight.Load(); got != "half-open" {
+		t.Errorf("circuit was %v during probe, want half-open", got)
+	}
+	wantState("closed")
+	if err := complete(); err != nil {
+		t.Fatalf("Complete after recovery: %v", err)
+	}
+	if n := transport.Requests(); n != 5 {
+		t.Errorf("sent %d requests, want 5", n)
+	}
+	if n := len(f.captured()); n != 2 {
+		t.Errorf("server got %d requests, want the 2 after recovery", n)
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	clk := newFakeClock()
+	breaker := newCircuitBreaker(1, time.Minute, clk)
+	breaker.done(nil, ErrAttemptTimeout)
+	clk.Advance(time.Minute)
+
+	if err := breaker.allow(); err != nil {
+		t.Fatalf("probe was not allowed: %v", err)
+	}
+	//Others fail fast while the probe is in flight, without a time to wait
+	var openErr *CircuitOpenError
+	if err := breaker.allow(); !errors.As(err, &openErr) || openErr.RetryAfter != 0 {
+		t.Fatalf("got error %v during probe, want CircuitOpenError", err)
+	}
+	//A cancelled probe says nothing about the provider and frees the slot
+	breaker.done(nil, context.Canceled)
+	if err := breaker.allow(); err != nil {
+		t.Fatalf("probe after cancelled one was not allowed: %v", err)
+	}
+	breaker.done(&http.Response{StatusCode: http.StatusOK}, nil)
+	if state := breaker.currentState(); state != circuitClosed {
+		t.Errorf("circuit is %v, want closed", state)
+	}
+}
+
+func TestCircuitBreakerHungProbe(t *testing.T) {
+	clk := newFakeClock()
+	captureLogs(t)
+	hang, transport := withChaos(chaos.When(chaos.Always(), chaos.Latency(time.Hour)))
+	_, client := newFakeServer(t, respondJSON(http.StatusOK, chatCompletionJSON("Hello there")), withClock(clk), hang,
+		WithCircuitBreaker(1, 30*time.Second), WithAttemptTimeout(0))
+	completeWithin := func(d time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), d)
+		defer cancel()
+		_, err := client.Complete(ctx, "Say hello")
+		return err
+	}
+
+	//A request running out of time opens the circuit like any other failure
+	if err := completeWithin(50 * time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want context.DeadlineExceeded", err)
+	}
+	if state := client.CircuitState(); state != "open" {
+		t.Fatalf("circuit is %s after hung request, want open", state)
+	}
+
+	//The probe after cool-down hangs as well and opens it again instead of leaving it half-open
+	clk.Advance(30 * time.Second)
+	if err := completeWithin(50 * time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v from probe, want context.DeadlineExceeded", err)
+	}
+	if state := client.CircuitState(); state != "open" {
+		t.Fatalf("circuit is %s after hung probe, want open", state)
+	}
+	if err := completeWithin(time.Second); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("got error %v, want ErrCircuitOpen", err)
+	}
+	if n := transport.Requests(); n != 2 {
+		t.Errorf("sent %d requests, want the hung one and the probe", n)
+	}
+}
+
+func TestCircuitBreakerFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		res  *http.Response
+		err  error
+		want bool
+	}{
+		{name: "success", res: &http.Response{StatusCode: http.StatusOK}, want: false},
+		{name: "500", res: &http.Response{StatusCode: http.StatusInternalServerError}, err: errors.New("Internal"), want: true},
+		{name: "429", res: &http.Response{StatusCode: http.StatusTooManyRequests}, err: errors.New("Slow down"), want: true},
+		{name: "400", res: &http.Response{StatusCode: http.StatusBadRequest}, err: errors.New("Bad request"), want: false},
+		{name: "attempt timeout", err: ErrAttemptTimeout, want: true},
+		{name: "deadline exceeded", err: fmt.Errorf("Post: %w", context.DeadlineExceeded), want: true},
+		{name: "connection reset", err: syscall.ECONNRESET, want: true},
+		{name: "canceled", err: fmt.Errorf("Post: %w", context.Canceled), want: false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			breaker := newCircuitBreaker(1, time.Minute, newFakeClock())
+			breaker.done(tc.res, tc.err)
+			if opened := breaker.currentState() == circuitOpen; opened != tc.want {
+				t.Errorf("circuit opened %v, want %v", opened, tc.want)
+			}
+		})
+	}
+}
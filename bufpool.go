@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Buffers larger than this, e.g. of requests with inline images, are dropped instead of pooled
+const MAX_POOLED_BUFFER_BYTES = 1 << 20
+
+// Buffers which request bodies are marshaled into, reused across requests to spare allocations
+var bufferPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// Marshaled request body, shared by all attempts of an operation.
+// The transport may read a body after the request returns, e.g. of a cancelled hedge,
+// so the buffer goes back to the pool only when the operation and every reader are done with it.
+type requestBody struct {
+	data []byte
+	// Pooled buffer holding data, nil when data was allocated otherwise
+	buf  *bytes.Buffer
+	refs atomic.Int32
+}
+
+// Marshal value into a pooled buffer. The body must be released once the operation is done.
+func marshalPooled(v any) (*requestBody, error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		bufferPool.Put(buf)
+		return nil, err
+	}
+	//Encoder ends with a newline which json.Marshal does not write, keep bodies and cache keys the same
+	body := &requestBody{data: bytes.TrimSuffix(buf.Bytes(), []byte("\n")), buf: buf}
+	body.refs.Store(1)
+	return body, nil
+}
+
+// Wrap body which was not marshaled into a pooled buffer
+func newRequestBody(data []byte) *requestBody {
+	body := &requestBody{data: data}
+	body.refs.Store(1)
+	return body
+}
+
+// Get reader of data for one request, released when the transport closes it
+func (b *requestBody) reader() io.ReadCloser {
+	b.refs.Add(1)
+	return &requestBodyReader{Reader: bytes.NewReader(b.data), body: b}
+}
+
+// Drop a reference, returning the buffer to the pool after the last one
+func (b *requestBody) release() {
+	if b.refs.Add(-1) != 0 || b.buf == nil {
+		return
+	}
+	if b.buf.Cap() <= MAX_POOLED_BUFFER_BYTES {
+		bufferPool.Put(b.buf)
+	}
+	b.buf, b.data = nil, nil
+}
+
+type requestBodyReader struct {
+	*bytes.Reader
+	body *requestBody
+	once sync.Once
+}
+
+func (r *requestBodyReader) Close() error {
+	r.once.Do(r.body.release)
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Requests of the sizes the batch path sends, a short prompt and a long conversation calling functions
+func benchmarkRequests(tb testing.TB) map[string]*chatRequest {
+	tb.Helper()
+	short, err := NewRequest().Model(DEFAULT_MODEL).System("You are a concise translator.").User("Translate 'cat' into French.").MaxTokens(64).Build()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	builder := NewRequest().Model(DEFAULT_MODEL).System("You are a helpful assistant.").Temperature(0.7)
+	for i := 0; i < 10; i++ {
+		builder = builder.User(fmt.Sprintf("Question %d: %s", i, strings.Repeat("Tell me more about autumn. ", 20))).
+			Assistant(fmt.Sprintf("Answer %d: %s", i, strings.Repeat("Leaves turn red and fall. ", 20)))
+	}
+	long, err := builder.User("Summarize our conversation.").Build()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	long.Functions = []function{weatherFunction}
+	long.FunctionCall = "auto"
+
+	return map[string]*chatRequest{"short": short, "long": long}
+}
+
+func TestMarshalPooledMatchesMarshal(t *testing.T) {
+	for name, chatReq := range benchmarkRequests(t) {
+		t.Run(name, func(t *testing.T) {
+			want, err := json.Marshal(chatReq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			//Twice, so that the second one reuses the buffer of the first
+			for i := 0; i < 2; i++ {
+				body, err := marshalPooled(chatReq)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(body.data, want) {
+					t.Errorf("pooled body differs from json.Marshal:\n%s\n%s", body.data, want)
+				}
+				body.release()
+			}
+		})
+	}
+}
+
+func TestRequestBodyReleasedAfterLastReader(t *testing.T) {
+	body, err := marshalPooled(map[string]string{"model": DEFAULT_MODEL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := body.reader(), body.reader()
+	body.release()
+	first.Close()
+	first.Close()
+
+	//A reader still open, e.g. of a hedged request, keeps the data
+	data, err := io.ReadAll(second)
+	if err != nil || string(data) != `{"model":"`+DEFAULT_MODEL+`"}` {
+		t.Fatalf("got %q, %v from the last reader", data, err)
+	}
+	if body.buf == nil {
+		t.Fatal("buffer was released while a reader was open")
+	}
+	second.Close()
+	if body.buf != nil || body.data != nil {
+		t.Error("buffer was not released after the last reader")
+	}
+}
+
+// Compare allocations of json.Marshal with marshaling into pooled buffers, run with -benchmem
+func BenchmarkMarshalRequest(b *testing.B) {
+	requests := benchmarkRequests(b)
+	for _, name := range []string{"short", "long"} {
+		chatReq := requests[name]
+		b.Run(name+"/json.Marshal", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				body, err := json.Marshal(chatReq)
+				if err != nil {
+					b.Fatal(err)
+				}
+				newRequestBody(body).release()
+			}
+		})
+		b.Run(name+"/pooled", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				body, err := marshalPooled(chatReq)
+				if err != nil {
+					b.Fatal(err)
+				}
+				body.release()
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Builder of chat requests, e.g.
+//
+//	NewRequest().Model("llama3-8b").System("Be brief.").User("Hello").Temperature(0.2).Build()
+//
+// Problems are collected and returned together by Build.
+type RequestBuilder struct {
+	req  chatRequest
+	errs []error
+}
+
+// Start building a chat request for the default model
+func NewRequest() *RequestBuilder {
+	return &RequestBuilder{req: chatRequest{Model: DEFAULT_MODEL}}
+}
+
+// Set model
+func (b *RequestBuilder) Model(model string) *RequestBuilder {
+	b.req.Model = model
+	return b
+}
+
+// Add system message
+func (b *RequestBuilder) System(content string) *RequestBuilder {
+	return b.Message(reqMessage{Role: "system", Content: content})
+}
+
+// Add user message
+func (b *RequestBuilder) User(content string) *RequestBuilder {
+	return b.Message(reqMessage{Role: "user", Content: content})
+}
+
+// Add assistant message, e.g. an earlier reply in the conversation
+func (b *RequestBuilder) Assistant(content string) *RequestBuilder {
+	return b.Message(reqMessage{Role: "assistant", Content: content})
+}
+
+// Add messages, e.g. history of a conversation
+func (b *RequestBuilder) Message(messages ...reqMessage) *RequestBuilder {
+	b.req.Messages = append(b.req.Messages, messages...)
+	return b
+}
+
+// Attach function definitions
+func (b *RequestBuilder) Functions(functions ...function) *RequestBuilder {
+	b.req.Functions = append(b.req.Functions, functions...)
+	return b
+}
+
+// Set whether the model calls attached functions, "none" or "auto"
+func (b *RequestBuilder) FunctionCall(mode string) *RequestBuilder {
+	b.req.FunctionCall = mode
+	return b
+}
+
+// Set whether the model may call several functions at once
+func (b *RequestBuilder) ParallelToolCalls(enabled bool) *RequestBuilder {
+	b.req.ParallelToolCalls = &enabled
+	return b
+}
+
+// Set sampling temperature, from 0 to 2
+func (b *RequestBuilder) Temperature(temperature float64) *RequestBuilder {
+	if temperature < 0 || temperature > 2 {
+		b.errs = append(b.errs, fmt.Errorf("temperature must be between 0 and 2, got %v", temperature))
+	}
+	b.req.Temperature = &temperature
+	return b
+}
+
+// Set maximum number of tokens to generate
+func (b *RequestBuilder) MaxTokens(n int) *RequestBuilder {
+	if n < 0 {
+		b.errs = append(b.errs, fmt.Errorf("max tokens must not be negative, got %d", n))
+	}
+	b.req.MaxTokens = n
+	return b
+}
+
+// Validate and return built request. Errors wrap ErrInvalidRequest.
+func (b *RequestBuilder) Build() (*chatRequest, error) {
+	errs := append([]error{}, b.errs...)
+	if b.req.Model == "" {
+		errs = append(errs, errors.New("model must not be empty"))
+	}
+	if len(b.req.Messages) == 0 {
+		errs = append(errs, errors.New("at least one message is required"))
+	}
+	for i, m := range b.req.Messages {
+		switch m.Role {
+		case "system", "user", "assistant":
+		case "function":
+			if m.Name == "" {
+				errs = append(errs, fmt.Errorf("function message at index %d has no name", i))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("message at index %d has unknown role %q", i, m.Role))
+		}
+	}
+	switch b.req.FunctionCall {
+	case "":
+	case "none", "auto":
+		if len(b.req.Functions) == 0 {
+			errs = append(errs, errors.New("function call is set without functions"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown function call mode %q", b.req.FunctionCall))
+	}
+	if err := checkFunctions(b.req.Functions); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRequest, errors.Join(errs...))
+	}
+
+	//Copy so that further calls on the builder do not change the returned request
+	req := b.req
+	req.Messages = append([]reqMessage{}, b.req.Messages...)
+	req.Functions = append([]function(nil), b.req.Functions...)
+	return &req, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// On-disk cache of response bodies keyed by hash of request body
+type responseCache struct {
+	dir string
+	ttl time.Duration
+}
+
+// Create cache storing responses under dir, ttl of zero means entries never expire
+func newResponseCache(dir string, ttl time.Duration) (*responseCache, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		log.Printf("Failed to create cache directory: %v", err)
+		return nil, err
+	}
+	return &responseCache{dir: dir, ttl: ttl}, nil
+}
+
+// Get cached response body unless it is missing or expired
+func (rc *responseCache) get(key string) ([]byte, bool) {
+	path := rc.path(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	if rc.ttl > 0 && time.Since(info.ModTime()) > rc.ttl {
+		return nil, false
+	}
+
+	body, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Failed to read cached response: %v", err)
+		return nil, false
+	}
+	return body, true
+}
+
+// Store response body, failures only disable caching of this response
+func (rc *responseCache) put(key string, body []byte) {
+	//Write to temporary file first so that readers never see a partial entry
+	tmp, err := os.CreateTemp(rc.dir, key+".*.tmp")
+	if err != nil {
+		log.Printf("Failed to create cache file: %v", err)
+		return
+	}
+	_, err = tmp.Write(body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Printf("Failed to write cache file: %v", err)
+		os.Remove(tmp.Name())
+		return
+	}
+
+	err = os.Rename(tmp.Name(), rc.path(key))
+	if err != nil {
+		log.Printf("Failed to store cache file: %v", err)
+		os.Remove(tmp.Name())
+	}
+}
+
+func (rc *responseCache) path(key string) string {
+	return filepath.Join(rc.dir, key+".json")
+}
+
+// Get cache key of operation, the hash of provider, completions URL and request body.
+// Identical bodies sent to another provider or base URL get other answers, so those must not share entries.
+// Failover URLs serve the same API, so the first configured base URL stands for all of them.
+func (c *Client) cacheKey(op *operation) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", c.provider.Name, c.chatCompletionsURL(c.endpoints.urls[0], op.model))
+	h.Write(op.body.data)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	handler := respondJSON(http.StatusOK, chatCompletionJSON("Bonjour"))
+
+	t.Run("identical request is answered from cache", func(t *testing.T) {
+		f, client := newFakeServer(t, handler, WithCache(t.TempDir(), 0))
+		for i, wantCached := range []bool{false, true} {
+			result, err := client.Complete(ctx, "Say hello")
+			if err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			if result.Cached != wantCached || result.Content != "Bonjour" {
+				t.Errorf("call %d got cached %v content %q, want cached %v", i+1, result.Cached, result.Content, wantCached)
+			}
+		}
+		if n := len(f.captured()); n != 1 {
+			t.Errorf("server got %d requests, want 1", n)
+		}
+	})
+
+	t.Run("WithoutCache calls the API", func(t *testing.T) {
+		f, client := newFakeServer(t, handler, WithCache(t.TempDir(), 0))
+		for i := 0; i < 2; i++ {
+			if _, err := client.Complete(ctx, "Say hello", WithoutCache()); err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+		}
+		if n := len(f.captured()); n != 2 {
+			t.Errorf("server got %d requests, want 2", n)
+		}
+	})
+
+	t.Run("base URLs do not share entries", func(t *testing.T) {
+		dir := t.TempDir()
+		first, firstClient := newFakeServer(t, handler, WithCache(dir, 0))
+		second, secondClient := newFakeServer(t, handler, WithCache(dir, 0))
+		for _, client := range []*Client{firstClient, secondClient} {
+			if _, err := client.Complete(ctx, "Say hello"); err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+		}
+		if len(first.captured()) != 1 || len(second.captured()) != 1 {
+			t.Errorf("servers got %d and %d requests, want 1 each", len(first.captured()), len(second.captured()))
+		}
+	})
+
+	t.Run("providers do not share entries", func(t *testing.T) {
+		dir := t.TempDir()
+		f, llamaClient := newFakeServer(t, handler, WithCache(dir, 0))
+		//Same URL and body, only the provider differs
+		ollamaClient := f.newClient(t, WithProvider(Ollama), WithBaseURL(f.URL), WithDefaultModel(DEFAULT_MODEL), WithCache(dir, 0))
+		for _, client := range []*Client{llamaClient, ollamaClient} {
+			result, err := client.Complete(ctx, "Say hello")
+			if err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			if result.Cached {
+				t.Errorf("%s got cached response of another provider", client.provider.Name)
+			}
+		}
+		if n := len(f.captured()); n != 2 {
+			t.Errorf("server got %d requests, want 2", n)
+		}
+	})
+}
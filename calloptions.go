@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// CallOption configures a single call, overriding client defaults
+type CallOption func(*callOptions) error
+
+// Settings of a single call
+type callOptions struct {
+	model          string
+	header         http.Header
+	rawResponse    bool
+	noCache        bool
+	timeout        *time.Duration
+	attemptTimeout *time.Duration
+	// Replaces default system prompt of the client when not nil, empty sends none
+	systemPrompt *string
+}
+
+// Send a single call to given model instead of the client's default model
+func WithModel(model string) CallOption {
+	return func(o *callOptions) error {
+		if model == "" {
+			return fmt.Errorf("%w: model must not be empty", ErrInvalidRequest)
+		}
+		o.model = model
+		return nil
+	}
+}
+
+// Keep raw response body of a single call in GenerateResult.RawBody
+func WithRawResponse() CallOption {
+	return func(o *callOptions) error {
+		o.rawResponse = true
+		return nil
+	}
+}
+
+// Send given system prompt in a single call instead of the client's default one, see WithDefaultSystemPrompt.
+// Empty prompt sends no system message in the call.
+func WithSystemPrompt(prompt string) CallOption {
+	return func(o *callOptions) error {
+		o.systemPrompt = &prompt
+		return nil
+	}
+}
+
+// Set overall deadline of a single call across all retries, replacing the client's from WithTimeout
+func WithCallTimeout(d time.Duration) CallOption {
+	return func(o *callOptions) error {
+		if d < 0 {
+			return fmt.Errorf("%w: timeout must not be negative", ErrInvalidRequest)
+		}
+		o.timeout = &d
+		return nil
+	}
+}
+
+// Set timeout of each attempt of a single call, replacing the client's from WithAttemptTimeout.
+// Non-stream attempts are still cut short by the response header timeout of the client.
+func WithCallAttemptTimeout(d time.Duration) CallOption {
+	return func(o *callOptions) error {
+		if d < 0 {
+			return fmt.Errorf("%w: attempt timeout must not be negative", ErrInvalidRequest)
+		}
+		o.attemptTimeout = &d
+		return nil
+	}
+}
+
+// Always call the API in a single call, neither reading nor storing cached responses
+func WithoutCache() CallOption {
+	return func(o *callOptions) error {
+		o.noCache = true
+		return nil
+	}
+}
+
+// Add header to the requests of a single call, e.g. a trace ID.
+// Applied after default and client headers, so it replaces them, except Authorization and Content-Type
+// which require WithUnsafeHeaders(true). Can be repeated for multiple values.
+func WithRequestHeader(key, value string) CallOption {
+	return WithRequestHeaders(http.Header{key: {value}})
+}
+
+// Add all values of header to the requests of a single call, see WithRequestHeader
+func WithRequestHeaders(header http.Header) CallOption {
+	return func(o *callOptions) error {
+		if o.header == nil {
+			o.header = http.Header{}
+		}
+		for key, values := range header {
+			for _, value := range values {
+				o.header.Add(key, value)
+			}
+		}
+		return nil
+	}
+}
+
+// Apply call options and check them against client settings
+func (c *Client) newCallOptions(opts []CallOption) (*callOptions, error) {
+	call := &callOptions{}
+	for _, opt := range opts {
+		if err := opt(call); err != nil {
+			log.Printf("Failed to apply call option: %v", err)
+			return nil, err
+		}
+	}
+	if call.model != "" {
+		if err := c.provider.validateModel(call.model); err != nil {
+			log.Printf("Failed to apply call option: %v", err)
+			return nil, err
+		}
+	}
+	if err := validateHeaders(call.header, c.unsafeHeaders); err != nil {
+		err = fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+		log.Printf("Failed to apply call option: %v", err)
+		return nil, err
+	}
+	return call, nil
+}
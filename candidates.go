@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Number of distinct candidates to generate for a prompt
+type CandidateConstraint struct {
+	// Number of unique candidates wanted, sent as n
+	N int
+	// Number of extra requests asking for the missing candidates when duplicates leave fewer than N, 0 disables backfilling.
+	// Each backfill request is billed for the whole prompt again plus every choice it returns, duplicates included,
+	// so backfilling can cost up to MaxBackfills times the prompt tokens on top of the first request.
+	MaxBackfills int
+}
+
+// Send a prompt asking for N choices and return their contents without duplicates, compared after trimming and lowercasing.
+// When duplicates leave fewer than N candidates, the missing count is requested again up to MaxBackfills times.
+// Fewer than N candidates are returned without error when backfilling does not find enough.
+func (c *Client) GenerateCandidates(ctx context.Context, prompt string, constraint CandidateConstraint, opts ...CallOption) ([]string, error) {
+	if constraint.N < 1 || constraint.MaxBackfills < 0 {
+		err := fmt.Errorf("%w: n must be positive and backfills must not be negative", ErrInvalidRequest)
+		log.Printf("Failed to validate candidate constraint: %v", err)
+		return nil, err
+	}
+	call, err := c.newCallOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []string{}
+	seen := map[string]bool{}
+	for attempt := 0; attempt <= constraint.MaxBackfills && len(candidates) < constraint.N; attempt++ {
+		chatReq := c.newChatRequest([]reqMessage{
+			reqMessage{Role: "user", Content: prompt},
+		}, call)
+		chatReq.N = constraint.N - len(candidates)
+
+		//Backfill must not be answered with the cached duplicates of an identical request
+		attemptCall := call
+		if attempt > 0 {
+			backfillCall := *call
+			backfillCall.noCache = true
+			attemptCall = &backfillCall
+		}
+
+		comp, err := c.createChatCompletion(ctx, chatReq, attemptCall)
+		if err != nil {
+			return nil, err
+		}
+		for _, choice := range comp.response.Choices {
+			//Censored output is no candidate
+			if choice.FinishReason == "content_filter" {
+				continue
+			}
+			key := normalizeCandidate(choice.Message.Content)
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			candidates = append(candidates, choice.Message.Content)
+		}
+	}
+	if len(candidates) > constraint.N {
+		candidates = candidates[:constraint.N]
+	}
+	return candidates, nil
+}
+
+// Normalize content for comparing candidates
+func normalizeCandidate(content string) string {
+	return strings.ToLower(strings.TrimSpace(content))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Optional request fields which an OpenAI compatible backend may not accept
+type Capabilities struct {
+	// functions, function_call and parallel_tool_calls
+	Tools bool
+	// response_format
+	ResponseFormat bool
+	// logprobs and top_logprobs
+	Logprobs bool
+	// logit_bias
+	LogitBias bool
+	// GBNF grammar of llama.cpp server. Unlike other fields, requests with a grammar
+	// are rejected rather than stripped when it is not supported.
+	Grammar bool
+}
+
+// Capabilities of backends which accept every optional field
+var AllCapabilities = Capabilities{Tools: true, ResponseFormat: true, Logprobs: true, LogitBias: true, Grammar: true}
+
+// Names of capabilities as given on the command line and in config files
+var capabilityNames = []string{"tools", "response_format", "logprobs", "logit_bias", "grammar"}
+
+// Parse comma separated capability names, "all" or "none"
+func ParseCapabilities(list string) (Capabilities, error) {
+	caps := Capabilities{}
+	for _, name := range splitList(list) {
+		switch strings.ToLower(name) {
+		case "all":
+			caps = AllCapabilities
+		case "none":
+		case "tools":
+			caps.Tools = true
+		case "response_format":
+			caps.ResponseFormat = true
+		case "logprobs":
+			caps.Logprobs = true
+		case "logit_bias":
+			caps.LogitBias = true
+		case "grammar":
+			caps.Grammar = true
+		default:
+			return Capabilities{}, fmt.Errorf("Unknown capability %q, must be all, none or any of %s", name, strings.Join(capabilityNames, ", "))
+		}
+	}
+	return caps, nil
+}
+
+// Format capabilities as comma separated names
+func (caps Capabilities) String() string {
+	var names []string
+	for i, supported := range []bool{caps.Tools, caps.ResponseFormat, caps.Logprobs, caps.LogitBias, caps.Grammar} {
+		if supported {
+			names = append(names, capabilityNames[i])
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// Generic OpenAI compatible backend such as llama.cpp server, vLLM, Groq or Together.
+// The API key is read from apiKeyEnv when it is set, and caps tell which optional fields the backend accepts.
+func CompatibleProvider(baseURL, apiKeyEnv string, caps Capabilities) Provider {
+	p := Compatible
+	p.BaseURL = baseURL
+	p.APIKeyEnv = apiKeyEnv
+	p.Capabilities = &caps
+	return p
+}
+
+// Reject requests using fields which the backend does not support instead of stripping them with a warning
+func WithStrictCapabilities(strict bool) Option {
+	return func(c *Client) error {
+		c.strictCapabilities = strict
+		return nil
+	}
+}
+
+// Check request against capabilities of the provider. Unsupported fields are stripped from a copy
+// of the request with a warning, or rejected with ErrInvalidRequest in strict mode.
+func (c *Client) applyCapabilities(chatReq *chatRequest) (*chatRequest, error) {
+	caps := c.provider.Capabilities
+
+	//Output without the grammar would not be what the caller relies on, so it is never stripped.
+	//Providers without capabilities are OpenAI compatible APIs which do not know grammars.
+	if chatReq.Grammar != "" && (caps == nil || !caps.Grammar) {
+		err := fmt.Errorf("%w: grammar not supported by provider %s, use a llama.cpp server with the grammar capability", ErrInvalidRequest, c.provider.Name)
+		log.Printf("Failed to check capabilities: %v", err)
+		return nil, err
+	}
+	if caps == nil {
+		return chatReq, nil
+	}
+
+	stripped := *chatReq
+	var unsupported []string
+	if !caps.Tools && (len(chatReq.Functions) > 0 || chatReq.FunctionCall != "" || chatReq.ParallelToolCalls != nil) {
+		unsupported = append(unsupported, "functions")
+		stripped.Functions, stripped.FunctionCall, stripped.ParallelToolCalls = nil, "", nil
+	}
+	if !caps.ResponseFormat && chatReq.ResponseFormat != nil {
+		unsupported = append(unsupported, "response_format")
+		stripped.ResponseFormat = nil
+	}
+	if !caps.Logprobs && (chatReq.Logprobs || chatReq.TopLogprobs > 0) {
+		unsupported = append(unsupported, "logprobs")
+		stripped.Logprobs, stripped.TopLogprobs = false, 0
+	}
+	if !caps.LogitBias && len(chatReq.LogitBias) > 0 {
+		unsupported = append(unsupported, "logit_bias")
+		stripped.LogitBias = nil
+	}
+	if len(unsupported) == 0 {
+		return chatReq, nil
+	}
+
+	if c.strictCapabilities {
+		err := fmt.Errorf("%w: %s not supported by provider %s", ErrInvalidRequest, strings.Join(unsupported, ", "), c.provider.Name)
+		log.Printf("Failed to check capabilities: %v", err)
+		return nil, err
+	}
+	log.Printf("WARNING: Removed %s from request, not supported by provider %s", strings.Join(unsupported, ", "), c.provider.Name)
+	return &stripped, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Pretend OpenAI compatible backends along with the optional fields they accept
+var pretendBackends = []struct {
+	name         string
+	capabilities string
+	apiKeyEnv    string
+	header       http.Header
+	// Fields of a request using every optional field which reach the backend
+	wantFields []string
+}{
+	{
+		name:         "llama.cpp server",
+		capabilities: "tools,logprobs,grammar",
+		wantFields:   []string{"functions", "logprobs", "top_logprobs"},
+	},
+	{
+		name:         "gateway",
+		capabilities: "response_format,logit_bias",
+		apiKeyEnv:    "GATEWAY_API_KEY",
+		header:       http.Header{"X-Gateway-Team": {"search"}},
+		wantFields:   []string{"response_format", "logit_bias"},
+	},
+}
+
+// Optional request fields checked against capabilities
+var optionalFields = []string{"functions", "response_format", "logprobs", "top_logprobs", "logit_bias"}
+
+// Options setting each optional field of requests, top_logprobs comes along with logprobs
+var fieldOptions = map[string]Option{
+	"functions":       WithFunctions(weatherFunction),
+	"response_format": WithResponseFormat("json_object"),
+	"logprobs":        WithLogprobs(2),
+	"logit_bias":      WithLogitBias(map[string]float64{"50256": -100}),
+}
+
+// Options setting the optional fields of names which have an option
+func withOptionalFields(names ...string) []Option {
+	var opts []Option
+	for _, name := range names {
+		if opt, ok := fieldOptions[name]; ok {
+			opts = append(opts, opt)
+		}
+	}
+	return opts
+}
+
+// Names of optional fields set in the JSON body of a request
+func sentOptionalFields(t *testing.T, body []byte) []string {
+	t.Helper()
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		t.Fatal(err)
+	}
+	var sent []string
+	for _, name := range optionalFields {
+		if _, ok := fields[name]; ok {
+			sent = append(sent, name)
+		}
+	}
+	return sent
+}
+
+func newCompatibleClient(t *testing.T, f *fakeServer, capabilities, apiKeyEnv string, opts ...Option) (*Client, error) {
+	t.Helper()
+	caps, err := ParseCapabilities(capabilities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewClient(append([]Option{WithProvider(CompatibleProvider(f.URL, apiKeyEnv, caps)), WithRetryPolicy(NoRetry)}, opts...)...)
+}
+
+func TestCapabilitiesMatrix(t *testing.T) {
+	for _, backend := range pretendBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			f, _ := newFakeServer(t, respondChat("Hello there"))
+			if backend.apiKeyEnv != "" {
+				t.Setenv(backend.apiKeyEnv, "gateway-key")
+			}
+			logs := captureLogs(t)
+			client, err := newCompatibleClient(t, f, backend.capabilities, backend.apiKeyEnv, append(withOptionalFields(optionalFields...), WithHeaders(backend.header))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := client.Complete(context.Background(), "Say hello"); err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			req := f.captured()[0]
+			if got := sentOptionalFields(t, req.Body); !slices.Equal(got, backend.wantFields) {
+				t.Errorf("sent fields %q, want %q", got, backend.wantFields)
+			}
+			for _, name := range []string{"functions", "response_format", "logprobs", "logit_bias"} {
+				removed := !slices.Contains(backend.wantFields, name)
+				if warned := strings.Contains(logs.String(), name); warned != removed {
+					t.Errorf("warned about %s %v, want %v: %s", name, warned, removed, logs)
+				}
+			}
+
+			wantAuthorization := ""
+			if backend.apiKeyEnv != "" {
+				wantAuthorization = "Bearer gateway-key"
+			}
+			if got := req.Header.Get("Authorization"); got != wantAuthorization {
+				t.Errorf("sent Authorization %q, want %q", got, wantAuthorization)
+			}
+			for key := range backend.header {
+				if got := req.Header.Get(key); got != backend.header.Get(key) {
+					t.Errorf("sent %s %q, want %q", key, got, backend.header.Get(key))
+				}
+			}
+		})
+	}
+}
+
+func TestStrictCapabilitiesMatrix(t *testing.T) {
+	for _, backend := range pretendBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			f, _ := newFakeServer(t, respondChat("Hello there"))
+			captureLogs(t)
+
+			client, err := newCompatibleClient(t, f, backend.capabilities, "", append(withOptionalFields(optionalFields...), WithStrictCapabilities(true))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = client.Complete(context.Background(), "Say hello")
+			if !errors.Is(err, ErrInvalidRequest) {
+				t.Fatalf("got error %v, want ErrInvalidRequest", err)
+			}
+			for _, name := range []string{"functions", "response_format", "logprobs", "logit_bias"} {
+				if rejected := strings.Contains(err.Error(), name); rejected == slices.Contains(backend.wantFields, name) {
+					t.Errorf("rejected %s %v in %q", name, rejected, err)
+				}
+			}
+			if n := len(f.captured()); n != 0 {
+				t.Errorf("sent %d requests, want none", n)
+			}
+
+			//Requests using only supported fields go through unchanged
+			client, err = newCompatibleClient(t, f, backend.capabilities, "", append(withOptionalFields(backend.wantFields...), WithStrictCapabilities(true))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.Complete(context.Background(), "Say hello"); err != nil {
+				t.Fatalf("Complete with supported fields: %v", err)
+			}
+			if got := sentOptionalFields(t, f.captured()[0].Body); !slices.Equal(got, backend.wantFields) {
+				t.Errorf("sent fields %q, want %q", got, backend.wantFields)
+			}
+		})
+	}
+}
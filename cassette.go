@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"unicode/utf8"
+)
+
+// Environment variable choosing the cassette mode of CassetteModeFromEnv, "record" records and anything else replays
+const CASSETTE_MODE_ENV = "LLAMA_CASSETTE"
+
+// Whether a cassette records real interactions or replays recorded ones
+type CassetteMode string
+
+const (
+	// Send requests and write them with their responses to the cassette, replacing what it held
+	CassetteRecord CassetteMode = "record"
+	// Answer requests from the cassette without touching the network
+	CassetteReplay CassetteMode = "replay"
+)
+
+// Returned in replay mode for requests which the cassette has no unused recording of
+var ErrCassetteMismatch = errors.New("No recorded interaction matches request")
+
+// Get cassette mode from LLAMA_CASSETTE, so tests replay unless run with LLAMA_CASSETTE=record
+func CassetteModeFromEnv() CassetteMode {
+	if os.Getenv(CASSETTE_MODE_ENV) == string(CassetteRecord) {
+		return CassetteRecord
+	}
+	return CassetteReplay
+}
+
+// Record interactions to, or replay them from, the JSON cassette at path, e.g. in integration tests:
+//
+//	client, err := NewClient(WithCassette("testdata/chat.json", CassetteModeFromEnv()))
+//
+// Credential headers are not recorded. Requests match by method, URL and hash of the body with JSON compacted,
+// and streamed responses replay in the chunks they were read in. Replay needs no API key.
+func WithCassette(path string, mode CassetteMode) Option {
+	return func(c *Client) error {
+		cassette := &cassette{path: path, mode: mode}
+		switch mode {
+		case CassetteRecord:
+		case CassetteReplay:
+			data, err := os.ReadFile(path)
+			if err != nil {
+				log.Printf("Failed to read cassette: %v", err)
+				return err
+			}
+			if err := json.Unmarshal(data, &cassette.interactions); err != nil {
+				return fmt.Errorf("Invalid cassette %s: %w", path, err)
+			}
+		default:
+			return fmt.Errorf("Unknown cassette mode %q, must be record or replay", mode)
+		}
+		c.cassette = cassette
+		return nil
+	}
+}
+
+// RoundTripper recording or replaying interactions, wrapping the transport the client would use otherwise
+type cassette struct {
+	path string
+	mode CassetteMode
+	next http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []*interaction
+}
+
+type interaction struct {
+	Request  cassetteRequest  `json:"request"`
+	Response cassetteResponse `json:"response"`
+
+	//Set once replayed, so identical requests replay the recordings in order
+	used bool
+}
+
+type cassetteRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body"`
+	// SHA-256 of the normalized body, which replay matches on
+	BodyHash string `json:"body_hash"`
+}
+
+type cassetteResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	// Body in the pieces it was read in, e.g. events of a stream
+	Chunks []cassetteChunk `json:"chunks"`
+}
+
+// Part of a response body, written as a string when it is UTF-8 text and as {"base64": ...} otherwise
+type cassetteChunk []byte
+
+func (c cassetteChunk) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(c) {
+		return json.Marshal(string(c))
+	}
+	return json.Marshal(map[string]string{"base64": base64.StdEncoding.EncodeToString(c)})
+}
+
+func (c *cassetteChunk) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*c = cassetteChunk(s)
+		return nil
+	}
+	var encoded struct {
+		Base64 string `json:"base64"`
+	}
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded.Base64)
+	if err != nil {
+		return err
+	}
+	*c = decoded
+	return nil
+}
+
+func (c *cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	recorded := cassetteRequest{
+		Method:   req.Method,
+		URL:      redactURL(req.URL),
+		Header:   stripCredentials(req.Header),
+		Body:     string(body),
+		BodyHash: normalizedBodyHash(body),
+	}
+
+	if c.mode == CassetteReplay {
+		return c.replay(req, recorded)
+	}
+
+	//Send a copy with the body read above
+	sent := req.Clone(req.Context())
+	sent.Body = io.NopCloser(bytes.NewReader(body))
+	res, err := c.next.RoundTrip(sent)
+	if err != nil {
+		return nil, err
+	}
+	entry := &interaction{
+		Request:  recorded,
+		Response: cassetteResponse{StatusCode: res.StatusCode, Header: res.Header.Clone()},
+	}
+	res.Body = &recordingBody{ReadCloser: res.Body, cassette: c, entry: entry}
+	return res, nil
+}
+
+// Answer request with the first unused recording matching it
+func (c *cassette) replay(req *http.Request, recorded cassetteRequest) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range c.interactions {
+		if entry.used || entry.Request.Method != recorded.Method || entry.Request.URL != recorded.URL ||
+			entry.Request.BodyHash != recorded.BodyHash {
+			continue
+		}
+		entry.used = true
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", entry.Response.StatusCode, http.StatusText(entry.Response.StatusCode)),
+			StatusCode:    entry.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        entry.Response.Header.Clone(),
+			Body:          &chunkReader{chunks: entry.Response.Chunks},
+			ContentLength: -1,
+			Request:       req,
+		}, nil
+	}
+	err := fmt.Errorf("%w: %s %s with body hash %s in %s", ErrCassetteMismatch, recorded.Method, recorded.URL, recorded.BodyHash, c.path)
+	log.Printf("Failed to replay request: %v", err)
+	return nil, err
+}
+
+// Add finished interaction and write the cassette, so it is complete whenever the test stops
+func (c *cassette) save(entry *interaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interactions = append(c.interactions, entry)
+
+	data, err := json.MarshalIndent(c.interactions, "", "  ")
+	if err != nil {
+		log.Printf("Failed to Marshal cassette: %v", err)
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		log.Printf("Failed to create cassette directory: %v", err)
+		return err
+	}
+	if err := os.WriteFile(c.path, append(data, '\n'), 0o644); err != nil {
+		log.Printf("Failed to write cassette: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Copy header without credentials, which must not end up in cassettes
+func stripCredentials(header http.Header) http.Header {
+	stripped := header.Clone()
+	for _, key := range sensitiveHeaders {
+		stripped.Del(key)
+	}
+	return stripped
+}
+
+// Hash body with JSON compacted, so formatting does not affect matching
+func normalizedBodyHash(body []byte) string {
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, body); err == nil {
+		body = compacted.Bytes()
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Response body recording each read as a chunk, saving the interaction when the body is done
+type recordingBody struct {
+	io.ReadCloser
+	cassette *cassette
+	entry    *interaction
+	once     sync.Once
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.entry.Response.Chunks = append(b.entry.Response.Chunks, bytes.Clone(p[:n]))
+	}
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *recordingBody) finish() {
+	b.once.Do(func() {
+		b.cassette.save(b.entry)
+	})
+}
+
+// Body of a replayed response, returning no more than one recorded chunk per read
+type chunkReader struct {
+	chunks []cassetteChunk
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	if n == len(r.chunks[0]) {
+		r.chunks = r.chunks[1:]
+	} else {
+		r.chunks[0] = r.chunks[0][n:]
+	}
+	return n, nil
+}
+
+func (r *chunkReader) Close() error {
+	return nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Transport answering every request with body read in chunks
+type chunkedTransport struct {
+	chunks []string
+}
+
+func (tr *chunkedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	chunks := make([]cassetteChunk, len(tr.chunks))
+	for i, chunk := range tr.chunks {
+		chunks[i] = cassetteChunk(chunk)
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/event-stream"}},
+		Body:       &chunkReader{chunks: chunks},
+		Request:    req,
+	}, nil
+}
+
+// Read body with a buffer larger than any chunk, returning what each read got
+func readsOf(t *testing.T, body io.Reader) []string {
+	t.Helper()
+	var reads []string
+	buf := make([]byte, 4096)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			reads = append(reads, string(buf[:n]))
+		}
+		if err == io.EOF {
+			return reads
+		}
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+	}
+}
+
+// Load cassette at path like WithCassette does for a client
+func loadCassette(t *testing.T, path string, mode CassetteMode) *cassette {
+	t.Helper()
+	var c Client
+	if err := WithCassette(path, mode)(&c); err != nil {
+		t.Fatalf("WithCassette: %v", err)
+	}
+	return c.cassette
+}
+
+func TestCassetteRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassettes", "chat.json")
+	f, client := newFakeServer(t, respondChat("Hello there."), WithCassette(path, CassetteRecord))
+
+	recorded, err := client.Complete(context.Background(), "Say hello")
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	var recordedDeltas []string
+	recordedStream, err := client.ChatStream(context.Background(), []reqMessage{{Role: "user", Content: "Say goodbye"}}, func(delta string) error {
+		recordedDeltas = append(recordedDeltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("cassette not written: %v", err)
+	}
+	if strings.Contains(string(data), testAPIKey) || strings.Contains(string(data), "Authorization") {
+		t.Errorf("cassette holds credentials:\n%s", data)
+	}
+	if n := len(loadCassette(t, path, CassetteReplay).interactions); n != 2 {
+		t.Fatalf("recorded %d interactions, want 2", n)
+	}
+
+	//Replay reaches no server and needs no key
+	baseURL := f.URL
+	f.Close()
+	clearClientEnv(t)
+	captureLogs(t)
+	replaying, err := NewClient(WithBaseURL(baseURL), WithRetryPolicy(NoRetry), WithCassette(path, CassetteReplay))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	replayed, err := replaying.Complete(context.Background(), "Say hello")
+	if err != nil {
+		t.Fatalf("replayed Complete: %v", err)
+	}
+	if replayed.Content != recorded.Content || replayed.Usage != recorded.Usage {
+		t.Errorf("replayed %q with %+v, want %q with %+v", replayed.Content, replayed.Usage, recorded.Content, recorded.Usage)
+	}
+	var replayedDeltas []string
+	replayedStream, err := replaying.ChatStream(context.Background(), []reqMessage{{Role: "user", Content: "Say goodbye"}}, func(delta string) error {
+		replayedDeltas = append(replayedDeltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("replayed ChatStream: %v", err)
+	}
+	if replayedStream.Content != recordedStream.Content || !slices.Equal(replayedDeltas, recordedDeltas) {
+		t.Errorf("replayed %q in %q, want %q in %q", replayedStream.Content, replayedDeltas, recordedStream.Content, recordedDeltas)
+	}
+
+	//Each recording replays once
+	if _, err := replaying.Complete(context.Background(), "Say hello"); !errors.Is(err, ErrCassetteMismatch) {
+		t.Errorf("got error %v replaying twice, want ErrCassetteMismatch", err)
+	}
+	if _, err := replaying.Complete(context.Background(), "Say something else"); !errors.Is(err, ErrCassetteMismatch) {
+		t.Errorf("got error %v for an unrecorded prompt, want ErrCassetteMismatch", err)
+	}
+}
+
+func TestCassetteStreamDeltas(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stream.json")
+	f, client := newFakeServer(t, respondDeltas("Hel", "lo", " there"), WithCassette(path, CassetteRecord))
+
+	messages := []reqMessage{{Role: "user", Content: "Say hello"}}
+	if _, err := client.ChatStream(context.Background(), messages, func(string) error { return nil }); err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	if n := len(loadCassette(t, path, CassetteReplay).interactions); n != 1 {
+		t.Fatalf("recorded %d interactions, want 1", n)
+	}
+
+	baseURL := f.URL
+	f.Close()
+	replaying, err := NewClient(WithBaseURL(baseURL), WithRetryPolicy(NoRetry), WithCassette(path, CassetteReplay))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	var deltas []string
+	result, err := replaying.ChatStream(context.Background(), messages, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("replayed ChatStream: %v", err)
+	}
+	if want := []string{"Hel", "lo", " there"}; !slices.Equal(deltas, want) || result.FinishReason != "stop" {
+		t.Errorf("replayed %q finishing %q, want %q finishing stop", deltas, result.FinishReason, want)
+	}
+}
+
+func TestCassetteChunkBoundaries(t *testing.T) {
+	//Events split mid-line, and bytes which are not UTF-8
+	source := []string{"data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n", "data: {\"choi", "ces\":[]}\n\n", "\xff\xfe", "data: [DONE]\n\n"}
+	path := filepath.Join(t.TempDir(), "chunks.json")
+	recorder := &cassette{path: path, mode: CassetteRecord, next: &chunkedTransport{chunks: source}}
+
+	req, _ := http.NewRequest(http.MethodPost, "http://llama.invalid/v1/chat/completions", strings.NewReader(`{"stream":true}`))
+	res, err := recorder.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	if got := readsOf(t, res.Body); !slices.Equal(got, source) {
+		t.Errorf("recording read %q, want %q", got, source)
+	}
+	res.Body.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"base64"`)) {
+		t.Errorf("cassette lacks base64 for bytes which are not UTF-8:\n%s", data)
+	}
+
+	//Formatting of the body does not affect matching
+	player := loadCassette(t, path, CassetteReplay)
+	req, _ = http.NewRequest(http.MethodPost, "http://llama.invalid/v1/chat/completions", strings.NewReader("{\n  \"stream\": true\n}"))
+	res, err = player.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("replayed RoundTrip: %v", err)
+	}
+	if got := readsOf(t, res.Body); !slices.Equal(got, source) {
+		t.Errorf("replay read %q, want the recorded chunks %q", got, source)
+	}
+}
+
+func TestChunkReaderSmallBuffer(t *testing.T) {
+	r := &chunkReader{chunks: []cassetteChunk{cassetteChunk("Hello"), cassetteChunk(" there")}}
+	var reads []string
+	buf := make([]byte, 3)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			reads = append(reads, string(buf[:n]))
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if want := []string{"Hel", "lo", " th", "ere"}; !slices.Equal(reads, want) {
+		t.Errorf("got reads %q, want %q never crossing a chunk", reads, want)
+	}
+}
+
+func TestNormalizedBodyHash(t *testing.T) {
+	compact := normalizedBodyHash([]byte(`{"model":"llama3","stream":true}`))
+	if got := normalizedBodyHash([]byte("{\n\t\"model\": \"llama3\",\n\t\"stream\": true\n}")); got != compact {
+		t.Errorf("got different hashes for formatting alone")
+	}
+	if got := normalizedBodyHash([]byte(`{"model":"llama3","stream":false}`)); got == compact {
+		t.Errorf("got the same hash for different bodies")
+	}
+	if normalizedBodyHash(nil) == "" || normalizedBodyHash([]byte("not JSON")) == "" {
+		t.Errorf("got no hash for a body which is not JSON")
+	}
+}
+
+func TestWithCassetteErrors(t *testing.T) {
+	captureLogs(t)
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalid, []byte(`{"request":`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		mode    CassetteMode
+		wantErr string
+	}{
+		{name: "missing file", path: filepath.Join(dir, "missing.json"), mode: CassetteReplay, wantErr: "no such file"},
+		{name: "invalid file", path: invalid, mode: CassetteReplay, wantErr: "Invalid cassette"},
+		{name: "unknown mode", path: invalid, mode: "rewind", wantErr: `Unknown cassette mode "rewind"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var c Client
+			if err := WithCassette(tc.path, tc.mode)(&c); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("got error %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestCassetteModeFromEnv(t *testing.T) {
+	for value, want := range map[string]CassetteMode{"record": CassetteRecord, "": CassetteReplay, "replay": CassetteReplay, "yes": CassetteReplay} {
+		t.Setenv(CASSETTE_MODE_ENV, value)
+		if got := CassetteModeFromEnv(); got != want {
+			t.Errorf("got %s for %s=%q, want %s", got, CASSETTE_MODE_ENV, value, want)
+		}
+	}
+}
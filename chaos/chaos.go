@@ -0,0 +1,292 @@
+// Package chaos injects faults into HTTP requests, to exercise retry, circuit breaker and failover logic
+// deterministically. Wrap the transport of the client under test and give rules which decide per request
+// which faults to inject:
+//
+//	transport := chaos.NewChaosTransport(http.DefaultTransport,
+//		chaos.When(chaos.OnRequest(1, 2), chaos.TooManyRequests(time.Second)),
+//		chaos.When(chaos.Every(5), chaos.ConnectionReset()),
+//	)
+//	client := &http.Client{Transport: transport}
+//
+// Rules matching the same request compose in the order given, e.g. Latency followed by TruncatedBody
+// delays the request and then cuts its response short. Counts of injected faults tell what happened.
+package chaos
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Decides whether a rule applies to the nth request, counted from 1 across the transport
+type Matcher func(n int, req *http.Request) bool
+
+// Fault applied to a request instead of, or around, sending it with next
+type Fault struct {
+	// Name counted by Injected, e.g. "429"
+	Name string
+	Do   func(req *http.Request, next http.RoundTripper) (*http.Response, error)
+}
+
+// Fault injected into requests which match
+type Rule struct {
+	match    Matcher
+	fault    Fault
+	injected atomic.Int64
+}
+
+// Create rule injecting fault into requests which match
+func When(match Matcher, fault Fault) *Rule {
+	return &Rule{match: match, fault: fault}
+}
+
+// Get name of the rule's fault
+func (r *Rule) Name() string {
+	return r.fault.Name
+}
+
+// Get number of requests the fault was injected into
+func (r *Rule) Injected() int {
+	return int(r.injected.Load())
+}
+
+// RoundTripper injecting faults of rules into requests sent through Next
+type ChaosTransport struct {
+	Next  http.RoundTripper
+	rules []*Rule
+
+	mu       sync.Mutex
+	requests int
+}
+
+// Wrap next, http.DefaultTransport when nil, injecting faults of rules
+func NewChaosTransport(next http.RoundTripper, rules ...*Rule) *ChaosTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &ChaosTransport{Next: next, rules: rules}
+}
+
+func (t *ChaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.requests++
+	n := t.requests
+	t.mu.Unlock()
+
+	//Chain faults of matching rules, the first rule outermost
+	var next http.RoundTripper = t.Next
+	for i := len(t.rules) - 1; i >= 0; i-- {
+		rule := t.rules[i]
+		if !rule.match(n, req) {
+			continue
+		}
+		rule.injected.Add(1)
+		inner := next
+		next = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return rule.fault.Do(req, inner)
+		})
+	}
+	return next.RoundTrip(req)
+}
+
+// Get number of requests sent through the transport
+func (t *ChaosTransport) Requests() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.requests
+}
+
+// Get number of injected faults by name
+func (t *ChaosTransport) Injected() map[string]int {
+	counts := map[string]int{}
+	for _, rule := range t.rules {
+		counts[rule.Name()] += rule.Injected()
+	}
+	return counts
+}
+
+// Get rules of the transport, to inspect them one by one
+func (t *ChaosTransport) Rules() []*Rule {
+	return t.rules
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Match every request
+func Always() Matcher {
+	return func(int, *http.Request) bool { return true }
+}
+
+// Match requests with the given numbers, e.g. OnRequest(1, 3) matches the first and the third
+func OnRequest(numbers ...int) Matcher {
+	return func(n int, _ *http.Request) bool {
+		for _, number := range numbers {
+			if n == number {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Match the first n requests
+func FirstN(n int) Matcher {
+	return func(number int, _ *http.Request) bool { return number <= n }
+}
+
+// Match every kth request, e.g. Every(3) matches the third, sixth and so on
+func Every(k int) Matcher {
+	return func(n int, _ *http.Request) bool { return k > 0 && n%k == 0 }
+}
+
+// Match requests to hosts, e.g. the primary of several base URLs
+func ToHost(host string) Matcher {
+	return func(_ int, req *http.Request) bool { return req.URL.Host == host }
+}
+
+// Match requests which both matchers match
+func And(a, b Matcher) Matcher {
+	return func(n int, req *http.Request) bool { return a(n, req) && b(n, req) }
+}
+
+// Answer with status code and a JSON error body without sending the request
+func Status(code int) Fault {
+	return Fault{
+		Name: strconv.Itoa(code),
+		Do: func(req *http.Request, _ http.RoundTripper) (*http.Response, error) {
+			return newResponse(req, code, http.Header{}, fmt.Sprintf(`{"error":{"message":"chaos: injected %d"}}`, code)), nil
+		},
+	}
+}
+
+// Answer 429 with Retry-After in whole seconds without sending the request
+func TooManyRequests(retryAfter time.Duration) Fault {
+	fault := Status(http.StatusTooManyRequests)
+	do := fault.Do
+	fault.Do = func(req *http.Request, next http.RoundTripper) (*http.Response, error) {
+		res, err := do(req, next)
+		if err == nil && retryAfter > 0 {
+			res.Header.Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+		}
+		return res, err
+	}
+	return fault
+}
+
+// Fail with connection reset by peer without sending the request
+func ConnectionReset() Fault {
+	return Fault{
+		Name: "connection reset",
+		Do: func(req *http.Request, _ http.RoundTripper) (*http.Response, error) {
+			return nil, &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+		},
+	}
+}
+
+// Delay the request by d, giving up when its context ends first
+func Latency(d time.Duration) Fault {
+	return Fault{
+		Name: "latency",
+		Do: func(req *http.Request, next http.RoundTripper) (*http.Response, error) {
+			timer := time.NewTimer(d)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+				return next.RoundTrip(req)
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+		},
+	}
+}
+
+// Send the request, then end the response body with io.ErrUnexpectedEOF after n bytes as a dropped connection does
+func TruncatedBody(n int) Fault {
+	return Fault{
+		Name: "truncated body",
+		Do: func(req *http.Request, next http.RoundTripper) (*http.Response, error) {
+			res, err := next.RoundTrip(req)
+			if err != nil {
+				return nil, err
+			}
+			res.Body = &truncatedBody{body: res.Body, remaining: n}
+			res.ContentLength = -1
+			return res, nil
+		},
+	}
+}
+
+// Send the request, then replace the response body with the first half of it, which is not valid JSON
+func MalformedJSON() Fault {
+	return Fault{
+		Name: "malformed JSON",
+		Do: func(req *http.Request, next http.RoundTripper) (*http.Response, error) {
+			res, err := next.RoundTrip(req)
+			if err != nil {
+				return nil, err
+			}
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			body = append(body[:len(body)/2], []byte(`{"chaos":`)...)
+			res.Body = io.NopCloser(bytes.NewReader(body))
+			res.ContentLength = int64(len(body))
+			res.Header.Del("Content-Length")
+			return res, nil
+		},
+	}
+}
+
+type truncatedBody struct {
+	body      io.ReadCloser
+	remaining int
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if len(p) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.body.Read(p)
+	b.remaining -= n
+	if err == io.EOF && b.remaining > 0 {
+		//Body was shorter than the cut, leave it whole
+		return n, io.EOF
+	}
+	return n, err
+}
+
+func (b *truncatedBody) Close() error {
+	return b.body.Close()
+}
+
+func newResponse(req *http.Request, code int, header http.Header, body string) *http.Response {
+	header.Set("Content-Type", "application/json")
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewBufferString(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
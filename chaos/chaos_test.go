@@ -0,0 +1,147 @@
+package chaos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Body every request to the fake backend is answered with
+const backendBody = `{"choices":[{"message":{"content":"Hello there"}}]}`
+
+// Transport answering every request with 200 and backendBody, counting requests which reach it
+type backend struct {
+	requests int
+}
+
+func (b *backend) RoundTrip(req *http.Request) (*http.Response, error) {
+	b.requests++
+	return newResponse(req, http.StatusOK, http.Header{}, backendBody), nil
+}
+
+func get(t *testing.T, transport http.RoundTripper, url string) (*http.Response, error) {
+	t.Helper()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return transport.RoundTrip(req)
+}
+
+func TestInjectedCounts(t *testing.T) {
+	next := &backend{}
+	unavailable := When(OnRequest(1, 3), Status(http.StatusServiceUnavailable))
+	reset := When(Every(4), ConnectionReset())
+	slow := When(And(FirstN(5), ToHost("slow.test")), Latency(time.Millisecond))
+	transport := NewChaosTransport(next, unavailable, reset, slow)
+
+	statuses := []int{}
+	for i, url := range []string{"http://a.test", "http://a.test", "http://slow.test", "http://slow.test", "http://slow.test", "http://slow.test"} {
+		res, err := get(t, transport, url)
+		if err != nil {
+			if !errors.Is(err, syscall.ECONNRESET) {
+				t.Fatalf("request %d got error %v, want connection reset", i+1, err)
+			}
+			statuses = append(statuses, 0)
+			continue
+		}
+		res.Body.Close()
+		statuses = append(statuses, res.StatusCode)
+	}
+
+	if want := []int{503, 200, 503, 0, 200, 200}; !reflect.DeepEqual(statuses, want) {
+		t.Errorf("got statuses %v, want %v", statuses, want)
+	}
+	if n := transport.Requests(); n != 6 {
+		t.Errorf("got %d requests, want 6", n)
+	}
+	//Faults answering in place of the backend keep requests from reaching it
+	if next.requests != 3 {
+		t.Errorf("backend got %d requests, want 3", next.requests)
+	}
+	want := map[string]int{"503": 2, "connection reset": 1, "latency": 3}
+	if got := transport.Injected(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got injected %v, want %v", got, want)
+	}
+	for _, rule := range transport.Rules() {
+		if rule.Injected() != want[rule.Name()] {
+			t.Errorf("rule %q injected %d, want %d", rule.Name(), rule.Injected(), want[rule.Name()])
+		}
+	}
+}
+
+func TestFaults(t *testing.T) {
+	t.Run("TooManyRequests rounds Retry-After up to seconds", func(t *testing.T) {
+		res, err := get(t, NewChaosTransport(&backend{}, When(Always(), TooManyRequests(1500*time.Millisecond))), "http://a.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusTooManyRequests || res.Header.Get("Retry-After") != "2" {
+			t.Errorf("got status %d with Retry-After %q, want 429 with 2", res.StatusCode, res.Header.Get("Retry-After"))
+		}
+	})
+
+	t.Run("TruncatedBody ends with unexpected EOF", func(t *testing.T) {
+		res, err := get(t, NewChaosTransport(&backend{}, When(Always(), TruncatedBody(10))), "http://a.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		if !errors.Is(err, io.ErrUnexpectedEOF) || string(body) != backendBody[:10] {
+			t.Errorf("got body %q and error %v, want %q and unexpected EOF", body, err, backendBody[:10])
+		}
+	})
+
+	t.Run("TruncatedBody longer than the body leaves it whole", func(t *testing.T) {
+		res, err := get(t, NewChaosTransport(&backend{}, When(Always(), TruncatedBody(1000))), "http://a.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		if err != nil || string(body) != backendBody {
+			t.Errorf("got body %q and error %v, want the whole body", body, err)
+		}
+	})
+
+	t.Run("MalformedJSON", func(t *testing.T) {
+		res, err := get(t, NewChaosTransport(&backend{}, When(Always(), MalformedJSON())), "http://a.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		if json.Valid(body) || res.ContentLength != int64(len(body)) {
+			t.Errorf("got body %q of length %d, want invalid JSON of its declared length", body, res.ContentLength)
+		}
+	})
+
+	t.Run("Latency gives up when the context ends", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, "GET", "http://a.test", nil)
+		next := &backend{}
+		_, err := NewChaosTransport(next, When(Always(), Latency(time.Hour))).RoundTrip(req)
+		if !errors.Is(err, context.DeadlineExceeded) || next.requests != 0 {
+			t.Errorf("got error %v with %d requests sent, want deadline exceeded before sending", err, next.requests)
+		}
+	})
+
+	t.Run("rules compose in order", func(t *testing.T) {
+		transport := NewChaosTransport(&backend{}, When(Always(), Latency(time.Millisecond)), When(Always(), TruncatedBody(5)))
+		res, err := get(t, transport, "http://a.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(res.Body); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("got error %v, want the delayed response truncated", err)
+		}
+		if got := transport.Injected(); got["latency"] != 1 || got["truncated body"] != 1 {
+			t.Errorf("got injected %v, want both faults once", got)
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package chaos_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"time"
+
+	"github.com/takumi616/go-llama/chaos"
+)
+
+func ExampleChaosTransport() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"ok":true}`)
+	}))
+	defer server.Close()
+
+	transport := chaos.NewChaosTransport(http.DefaultTransport,
+		chaos.When(chaos.OnRequest(1), chaos.TooManyRequests(2*time.Second)),
+		chaos.When(chaos.OnRequest(2), chaos.ConnectionReset()),
+	)
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < 3; i++ {
+		res, err := client.Get(server.URL)
+		if err != nil {
+			fmt.Println("reset:", errors.Is(err, syscall.ECONNRESET))
+			continue
+		}
+		res.Body.Close()
+		fmt.Printf("%d Retry-After=%q\n", res.StatusCode, res.Header.Get("Retry-After"))
+	}
+	fmt.Println(transport.Requests(), transport.Injected())
+	// Output:
+	// 429 Retry-After="2"
+	// reset: true
+	// 200 Retry-After=""
+	// 3 map[429:1 connection reset:1]
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Request body to llama API
+type chatRequest struct {
+	Model        string             `json:"model"`
+	Messages     []reqMessage       `json:"messages"`
+	Functions    []function         `json:"functions,omitempty"`
+	Stream       bool               `json:"stream,omitempty"`
+	FunctionCall string             `json:"function_call,omitempty"`
+	MaxTokens    int                `json:"max_tokens,omitempty"`
+	Temperature  *float64           `json:"temperature,omitempty"`
+	LogitBias    map[string]float64 `json:"logit_bias,omitempty"`
+	Logprobs     bool               `json:"logprobs,omitempty"`
+	TopLogprobs  int                `json:"top_logprobs,omitempty"`
+	N            int                `json:"n,omitempty"`
+	Stop         []string           `json:"stop,omitempty"`
+	// GBNF grammar of llama.cpp server
+	Grammar string `json:"grammar,omitempty"`
+	// Omitted unless set, leaving parallel calls to the server's default
+	ParallelToolCalls *bool           `json:"parallel_tool_calls,omitempty"`
+	ResponseFormat    *responseFormat `json:"response_format,omitempty"`
+	// Routing preferences of OpenRouter
+	Provider *ProviderRouting `json:"provider,omitempty"`
+}
+
+// Preferences of OpenRouter for choosing the upstream provider which serves a request
+type ProviderRouting struct {
+	// Upstream providers to try in order, e.g. "Together", "DeepInfra"
+	Order []string `json:"order,omitempty"`
+	// Whether other providers may serve the request when those in Order are unavailable, OpenRouter allows it unless set
+	AllowFallbacks *bool `json:"allow_fallbacks,omitempty"`
+}
+
+// Format the model must answer in
+type responseFormat struct {
+	// "text", "json_object" or "json_schema"
+	Type       string            `json:"type"`
+	JSONSchema *jsonSchemaFormat `json:"json_schema,omitempty"`
+}
+
+// Schema which structured output must follow
+type jsonSchemaFormat struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+	Strict bool            `json:"strict,omitempty"`
+
+	//Parsed Schema, to validate responses against
+	schema *jsonSchema
+}
+
+// Message with plain string content, or multimodal content when Parts is set
+type reqMessage struct {
+	Role    string        `json:"role"`
+	Content string        `json:"content"`
+	Parts   []contentPart `json:"-"`
+	// Name of the function whose result a "function" message carries
+	Name string `json:"name,omitempty"`
+	// Function call of an assistant message, kept so that the next request has the whole conversation
+	FunctionCall *functionCall `json:"function_call,omitempty"`
+}
+
+type function struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Parameters  parameters `json:"parameters"`
+	Required    []string   `json:"required"`
+}
+
+type parameters struct {
+	Type       string              `json:"type"`
+	Properties map[string]property `json:"properties"`
+}
+
+type property struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	// Type of elements when Type is "array"
+	Items *property `json:"items,omitempty"`
+}
+
+// Response body from llama API
+type chatResponse struct {
+	ID      string   `json:"id"`
+	Object  string   `json:"object"`
+	Created int64    `json:"created"`
+	Model   string   `json:"model"`
+	Choices []choice `json:"choices"`
+	Usage   usage    `json:"usage"`
+	// Upstream provider which served the request, reported by OpenRouter
+	Provider string `json:"provider,omitempty"`
+}
+
+type usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type choice struct {
+	Index        int        `json:"index"`
+	Message      resMessage `json:"message"`
+	FinishReason string     `json:"finish_reason"`
+	Logprobs     *logprobs  `json:"logprobs,omitempty"`
+}
+
+// Log probabilities of generated tokens, only returned when requested
+type logprobs struct {
+	Content []tokenLogprob `json:"content"`
+}
+
+type tokenLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	// UTF-8 bytes of the token, nil when it has no byte representation
+	Bytes []int `json:"bytes"`
+	// Most likely tokens at this position, as many as requested with top_logprobs
+	TopLogprobs []topLogprob `json:"top_logprobs"`
+}
+
+type topLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes"`
+}
+
+type resMessage struct {
+	Role         string       `json:"role"`
+	Content      string       `json:"content"`
+	FunctionCall functionCall `json:"function_call"`
+}
+
+type functionCall struct {
+	Name string `json:"name"`
+	// JSON encoded arguments chosen by the model
+	Arguments functionArguments `json:"arguments"`
+}
+
+// JSON text of function arguments, sent as a string by most providers and as an object by some
+type functionArguments string
+
+func (a *functionArguments) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*a = functionArguments(s)
+		return nil
+	}
+	if string(data) == "null" {
+		*a = ""
+		return nil
+	}
+	*a = functionArguments(data)
+	return nil
+}
+
+// Set a prompt and other values to create chat request
+func createChatRequest(prompt string) *chatRequest {
+	return createChatRequestWithMessages([]reqMessage{
+		reqMessage{Role: "user", Content: prompt},
+	})
+}
+
+// Model used unless configured otherwise
+const DEFAULT_MODEL = "llama3-70b"
+
+// Set messages and other values to create chat request.
+// Functions are not attached unless configured, see WithFunctions.
+func createChatRequestWithMessages(messages []reqMessage) *chatRequest {
+	return &chatRequest{
+		Model:    DEFAULT_MODEL,
+		Messages: messages,
+	}
+}
+
+// Decode JSON arguments of function call into caller's type, e.g. a struct with fields of the function's parameters
+func DecodeArguments[T any](fc functionCall) (T, error) {
+	var args T
+	if strings.TrimSpace(string(fc.Arguments)) == "" {
+		return args, fmt.Errorf("%w: function call %q has no arguments", ErrUnexpectedResponse, fc.Name)
+	}
+	if err := json.Unmarshal([]byte(fc.Arguments), &args); err != nil {
+		return args, fmt.Errorf("%w: invalid arguments of function call %q: %w", ErrUnexpectedResponse, fc.Name, err)
+	}
+	return args, nil
+}
+
+// Finish reasons of OpenAI compatible servers which mean the same as "stop" or "length"
+var finishReasonAliases = map[string]string{
+	"eos":           "stop",
+	"end_turn":      "stop",
+	"stop_sequence": "stop",
+	"max_tokens":    "length",
+	"model_length":  "length",
+}
+
+// Map finish reason of servers such as Ollama to the OpenAI one
+func normalizeFinishReason(reason string) string {
+	if alias, ok := finishReasonAliases[reason]; ok {
+		return alias
+	}
+	return reason
+}
+
+// Prepend system message with prompt unless prompt is empty or messages already start with a system message
+func withSystemPrompt(messages []reqMessage, prompt string) []reqMessage {
+	if prompt == "" || (len(messages) > 0 && messages[0].Role == "system") {
+		return messages
+	}
+	return append([]reqMessage{reqMessage{Role: "system", Content: prompt}}, messages...)
+}
+
+// Keep leading system messages and the last max of the other messages, max of 0 keeps all of them
+func trimHistory(messages []reqMessage, max int) []reqMessage {
+	system := 0
+	for system < len(messages) && messages[system].Role == "system" {
+		system++
+	}
+	if max <= 0 || len(messages)-system <= max {
+		return messages
+	}
+	trimmed := make([]reqMessage, 0, system+max)
+	trimmed = append(trimmed, messages[:system]...)
+	return append(trimmed, messages[len(messages)-max:]...)
+}
+
+// Function asking the model for an English example sentence using given words
+var exampleSentenceFunction = function{
+	Name:        "Get_English_Exmple_Sentence",
+	Description: "Get the English example sentence generated with given words.",
+	Parameters: parameters{
+		Type: "object",
+		Properties: map[string]property{
+			"sentence": property{
+				Type:        "string",
+				Description: "English example sentence using all the given words",
+			},
+			"words": property{
+				Type:        "array",
+				Description: "Words of the vocabulary list used in the sentence, e.g. nonchalant, reckon, appalled",
+				Items:       &property{Type: "string"},
+			},
+		},
+	},
+	Required: []string{"sentence", "words"},
+}
+
+// Check function definitions before sending them, since malformed schemas cause confusing 400 errors
+func validateFunctions(functions []function) error {
+	if err := checkFunctions(functions); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	return nil
+}
+
+// Find first malformed function definition
+func checkFunctions(functions []function) error {
+	for i, fn := range functions {
+		if fn.Name == "" {
+			return fmt.Errorf("function at index %d has no name", i)
+		}
+		if fn.Parameters.Type != "object" {
+			return fmt.Errorf("parameters type of function %q must be \"object\", got %q", fn.Name, fn.Parameters.Type)
+		}
+		for _, name := range fn.Required {
+			if _, ok := fn.Parameters.Properties[name]; !ok {
+				return fmt.Errorf("required property %q of function %q is not defined in properties", name, fn.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestDecodeArguments(t *testing.T) {
+	type wordsArguments struct{ Words []string }
+
+	tests := []struct {
+		name    string
+		message string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "string arguments",
+			message: `{"role":"assistant","function_call":{"name":"split","arguments":"{\"words\":[\"Hello\",\"llama\"]}"}}`,
+			want:    []string{"Hello", "llama"},
+		},
+		{
+			name:    "object arguments",
+			message: `{"role":"assistant","function_call":{"name":"split","arguments":{"words":["Hello","llama"]}}}`,
+			want:    []string{"Hello", "llama"},
+		},
+		{
+			name:    "empty list",
+			message: `{"role":"assistant","function_call":{"name":"split","arguments":"{\"words\":[]}"}}`,
+			want:    []string{},
+		},
+		{
+			name:    "no arguments",
+			message: `{"role":"assistant","function_call":{"name":"split","arguments":""}}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid JSON",
+			message: `{"role":"assistant","function_call":{"name":"split","arguments":"{\"words\":[\"Hel"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "wrong type",
+			message: `{"role":"assistant","function_call":{"name":"split","arguments":"{\"words\":\"Hello llama\"}"}}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var message resMessage
+			if err := json.Unmarshal([]byte(tc.message), &message); err != nil {
+				t.Fatal(err)
+			}
+
+			args, err := DecodeArguments[wordsArguments](message.FunctionCall)
+			if tc.wantErr {
+				if !errors.Is(err, ErrUnexpectedResponse) {
+					t.Errorf("got %+v, %v, want ErrUnexpectedResponse", args, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeArguments: %v", err)
+			}
+			if args.Words == nil || !slices.Equal(args.Words, tc.want) {
+				t.Errorf("got words %q, want %q", args.Words, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeArgumentsOfChat(t *testing.T) {
+	body := `{"model":"llama3-70b","choices":[{"index":0,"message":{"role":"assistant","content":null,` +
+		`"function_call":{"name":"split","arguments":"{\"words\":[\"Hello\",\"llama\"]}"}},"finish_reason":"function_call"}]}`
+	_, client := newFakeServer(t, respondJSON(http.StatusOK, body))
+
+	result, err := client.Chat(context.Background(), []reqMessage{{Role: "user", Content: "Split: Hello llama"}})
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	args, err := DecodeArguments[struct{ Words []string }](result.FunctionCall)
+	if err != nil {
+		t.Fatalf("DecodeArguments: %v", err)
+	}
+	if want := []string{"Hello", "llama"}; !slices.Equal(args.Words, want) {
+		t.Errorf("got words %q, want %q", args.Words, want)
+	}
+}
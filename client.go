@@ -0,0 +1,789 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoints
+const (
+	BASE_URL              = "https://api.llama-api.com"
+	CHAT_COMPLETIONS_PATH = "/chat/completions"
+	MODELS_PATH           = "/models"
+	UNIX_SOCKET_BASE_URL  = "http://unix"
+)
+
+// Default maximum size of response bodies
+const DEFAULT_MAX_RESPONSE_BYTES = 10 << 20
+
+// Default header carrying idempotency key of an operation
+const DEFAULT_IDEMPOTENCY_HEADER = "Idempotency-Key"
+
+// Logical request to llama API shared by all of its attempts
+type operation struct {
+	requestID      string
+	idempotencyKey string
+	model          string
+	body           *requestBody
+	header         http.Header
+	secrets        []string
+	stream         bool
+	noCache        bool
+	debug          bool
+	// Overall deadline across retries and timeout of each attempt, zero means no limit
+	timeout        time.Duration
+	attemptTimeout time.Duration
+}
+
+// Print log tagged with request ID, with the API key redacted in case an error quotes it
+func (op *operation) logf(format string, v ...any) {
+	msg := fmt.Sprintf(format, v...)
+	for _, secret := range op.secrets {
+		msg = redactSecret(msg, secret)
+	}
+	log.Printf("[%s] %s", op.requestID, msg)
+}
+
+// Print log tagged with request ID only when debug logging is enabled
+func (op *operation) debugf(format string, v ...any) {
+	if op.debug {
+		op.logf("[debug] "+format, v...)
+	}
+}
+
+// Parsed response along with how it was obtained
+type completion struct {
+	response *chatResponse
+	// Raw response body, dropped along with completion unless requested with WithRawResponse
+	body   []byte
+	header http.Header
+	// Time header was received, which relative rate limit resets count from
+	receivedAt time.Time
+	endpoint   string
+	keyIndex   int
+	cached     bool
+	// Model named in the request, the response may name another one which served it
+	requestedModel string
+}
+
+// Client for llama API
+type Client struct {
+	httpClient         *http.Client
+	transport          *http.Transport
+	dialer             *net.Dialer
+	endpoints          *endpointPool
+	apiKey             string
+	apiKeys            []string
+	keys               *keyPool
+	keyCooldown        time.Duration
+	model              string
+	functions          []function
+	functionCall       string
+	header             http.Header
+	unsafeHeaders      bool
+	org                string
+	retryPolicy        RetryPolicy
+	maxRetryAfter      time.Duration
+	timeout            time.Duration
+	attemptTimeout     time.Duration
+	breaker            *circuitBreaker
+	limiter            *rateLimiter
+	tokenLimiter       *tokenWindow
+	maxTokens          int
+	temperature        *float64
+	stop               []string
+	completionsPath    string
+	grammar            string
+	promptPreprocessor func(string) string
+	postprocessor      func(string) string
+	cassette           *cassette
+	transportWrapper   func(http.RoundTripper) http.RoundTripper
+	maxHistory         int
+	maxPromptTokens    int
+	clock              clock
+	systemPrompt       string
+	azure              *azureDeployment
+	parallelToolCalls  *bool
+	provider           Provider
+	responseFormat     *responseFormat
+	strictCapabilities bool
+	routing            *ProviderRouting
+	logitBias          map[string]float64
+	logprobs           bool
+	topLogprobs        int
+	cache              *responseCache
+	rateLimits         *rateLimitState
+	metrics            *Metrics
+	hedgeDelay         time.Duration
+	flights            *flightGroup
+	fallbackModels     []string
+	idempotencyHeader  string
+	maxResponseBytes   int64
+	userAgent          string
+	userAgentSuffix    string
+	strictDecoding     bool
+	compression        Compression
+	debug              bool
+}
+
+// Get environment variable, or fallback when it is unset or empty
+func getenvDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Create a client configured from environment variables and given options
+func NewClient(opts ...Option) (*Client, error) {
+	dialer := newDialer()
+	transport := newTransport(dialer)
+	c := &Client{
+		httpClient:        &http.Client{Transport: transport},
+		transport:         transport,
+		dialer:            dialer,
+		endpoints:         newEndpointPool(strings.TrimRight(getenvDefault("LLAMA_API_URL", BASE_URL), "/")),
+		apiKey:            os.Getenv("LLAMA_API_KEY"),
+		apiKeys:           splitList(os.Getenv(API_KEYS_ENV)),
+		keyCooldown:       DEFAULT_KEY_COOLDOWN,
+		model:             getenvDefault("LLAMA_MODEL", DEFAULT_MODEL),
+		org:               os.Getenv("LLAMA_ORG"),
+		retryPolicy:       DefaultRetryPolicy(),
+		maxRetryAfter:     DEFAULT_MAX_RETRY_AFTER,
+		timeout:           DEFAULT_TIMEOUT,
+		attemptTimeout:    DEFAULT_ATTEMPT_TIMEOUT,
+		debug:             os.Getenv("LLAMA_DEBUG") != "",
+		rateLimits:        &rateLimitState{},
+		idempotencyHeader: DEFAULT_IDEMPOTENCY_HEADER,
+		maxResponseBytes:  DEFAULT_MAX_RESPONSE_BYTES,
+		userAgent:         defaultUserAgent,
+		provider:          Llama,
+		clock:             realClock{},
+	}
+
+	//Key file from environment wins over LLAMA_API_KEY, options can override it
+	if path := os.Getenv(API_KEY_FILE_ENV); path != "" {
+		opts = append([]Option{WithAPIKeyFile(path)}, opts...)
+	}
+
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			log.Printf("Failed to apply client option: %v", err)
+			return nil, err
+		}
+	}
+
+	//Rotate keys when more than one is given, the first one serves single requests
+	if len(c.apiKeys) > 0 {
+		c.apiKey = c.apiKeys[0]
+	}
+	if len(c.apiKeys) > 1 {
+		c.keys = newKeyPool(c.apiKeys, c.keyCooldown, c.clock)
+	}
+	//Pool exists before options, so it learns the clock once they are applied
+	c.endpoints.clock = c.clock
+
+	//Catch model names of another provider before the first request
+	if err := c.provider.validateModel(c.model); err != nil {
+		log.Printf("Failed to apply client option: %v", err)
+		return nil, err
+	}
+
+	//Wrapped last so that the cassette sees requests of whichever transport the options installed
+	if c.cassette != nil {
+		c.cassette.next = c.httpClient.Transport
+		c.httpClient.Transport = c.cassette
+		//Replayed requests reach no server which could check a key
+		if c.cassette.mode == CassetteReplay {
+			c.provider.APIKeyOptional = true
+		}
+	}
+
+	if c.transportWrapper != nil {
+		c.httpClient.Transport = c.transportWrapper(c.httpClient.Transport)
+	}
+
+	//Checked after all options since WithUnsafeHeaders may come after WithHeader
+	if err := validateHeaders(c.header, c.unsafeHeaders); err != nil {
+		log.Printf("Failed to apply client option: %v", err)
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Get generated response from Llama API
+func getGeneratedResponse(prompt string) (string, error) {
+	c, err := getDefaultClient()
+	if err != nil {
+		return "", err
+	}
+	return c.Generate(context.Background(), prompt)
+}
+
+// Client shared by getGeneratedResponse so keep-alive connections are reused across calls
+var (
+	defaultClientOnce sync.Once
+	defaultClient     *Client
+	defaultClientErr  error
+)
+
+// Get shared client, creating it from environment on first use
+func getDefaultClient() (*Client, error) {
+	defaultClientOnce.Do(func() {
+		defaultClient, defaultClientErr = NewClient()
+	})
+	return defaultClient, defaultClientErr
+}
+
+// Send a prompt to llama API and return generated text
+func (c *Client) Generate(ctx context.Context, prompt string, opts ...CallOption) (string, error) {
+	result, err := c.Complete(ctx, prompt, opts...)
+	if err != nil {
+		return "", err
+	}
+
+	//Return generated text from llama
+	return result.Content, nil
+}
+
+// Send chat request to llama API and return parsed response having at least one choice.
+// When the model is overloaded the request is re-issued with each fallback model in order.
+func (c *Client) createChatCompletion(ctx context.Context, chatReq *chatRequest, call *callOptions) (*completion, error) {
+	ctx, _ = ensureRequestID(ctx)
+
+	//Catch schema mistakes locally before the network call
+	if err := validateFunctions(chatReq.Functions); err != nil {
+		log.Printf("Failed to validate functions: %v", err)
+		return nil, err
+	}
+	//Validate against the schema asked for, even when it is stripped for the provider
+	format := chatReq.ResponseFormat
+	chatReq, err := c.applyCapabilities(chatReq)
+	if err != nil {
+		return nil, err
+	}
+
+	primary := chatReq.Model
+	if call.model != "" {
+		primary = call.model
+	}
+	models := append([]string{primary}, c.fallbackModels...)
+	for i, model := range models {
+		modelReq := *chatReq
+		modelReq.Model = model
+
+		//Request with fallback model has a different body, so it must not reuse the caller's key
+		modelCtx := ctx
+		if key, ok := IdempotencyKeyFromContext(ctx); ok && i > 0 {
+			modelCtx = ContextWithIdempotencyKey(ctx, key+"-"+model)
+		}
+
+		comp, err := c.completeWithModel(modelCtx, &modelReq, call)
+		if err == nil && chatReq.N <= 1 {
+			err = checkContentFilter(comp.response)
+		}
+		if err == nil {
+			return comp, validateResponseSchema(format, comp.response)
+		}
+		if i == len(models)-1 || !shouldFallback(err) {
+			return comp, err
+		}
+		log.Printf("Model %s failed, falling back to %s: %v", model, models[i+1], err)
+	}
+	return nil, errors.New("No model to send request to")
+}
+
+// Send chat request for a single model, retrying as decided by retry policy
+func (c *Client) completeWithModel(ctx context.Context, chatReq *chatRequest, call *callOptions) (comp *completion, err error) {
+	//Record outcome and latency of the whole operation including retries
+	outcome := "success"
+	if c.metrics != nil {
+		start := time.Now()
+		defer func() {
+			if err != nil {
+				outcome = "error"
+			}
+			c.metrics.observeRequest(chatReq.Model, outcome, time.Since(start))
+		}()
+	}
+
+	//Tag the operation with request ID for tracing
+	ctx, requestID := ensureRequestID(ctx)
+	op := &operation{requestID: requestID, idempotencyKey: idempotencyKeyFor(ctx), model: chatReq.Model, header: call.header, secrets: c.secrets(), noCache: call.noCache, debug: c.debug}
+	op.timeout, op.attemptTimeout = c.timeoutsFor(call)
+
+	//Marshal Go struct into Json
+	op.body, err = c.encodeChatRequest(chatReq)
+	if err != nil {
+		op.logf("Failed to Marshal: %v", err)
+		return nil, err
+	}
+	defer op.body.release()
+
+	//Share a single call among concurrent identical requests
+	if c.flights != nil {
+		comp, err = c.flights.do(hashRequest(op.body.data), func() (*completion, error) {
+			return c.executeOperation(ctx, op, chatReq)
+		})
+	} else {
+		comp, err = c.executeOperation(ctx, op, chatReq)
+	}
+	if err == nil && comp.cached {
+		outcome = "cached"
+	}
+	return comp, err
+}
+
+// Get response for operation from cache or llama API
+func (c *Client) executeOperation(ctx context.Context, op *operation, chatReq *chatRequest) (*completion, error) {
+	//Return cached response of identical request
+	var cacheKey string
+	if c.cache != nil && !op.noCache {
+		cacheKey = c.cacheKey(op)
+		if body, ok := c.cache.get(cacheKey); ok {
+			op.debugf("Using cached response %s", cacheKey)
+			chatRes, err := c.parseChatResponse(nil, body)
+			if err != nil {
+				return nil, err
+			}
+			if chatRes.Model == "" {
+				chatRes.Model = op.model
+			}
+			return &completion{response: chatRes, body: body, keyIndex: -1, cached: true, requestedModel: op.model}, nil
+		}
+	}
+
+	if c.missingAPIKey() {
+		op.logf("Failed to get API KEY: %v", ErrMissingAPIKey)
+		return nil, ErrMissingAPIKey
+	}
+
+	//Wait until estimated tokens fit into tokens per minute budget
+	var reservation *tokenEntry
+	if c.tokenLimiter != nil {
+		var err error
+		reservation, err = c.tokenLimiter.reserve(ctx, estimateRequestTokens(chatReq))
+		if err != nil {
+			err = fmt.Errorf("Interrupted while waiting for token budget: %w", err)
+			op.logf("Failed to send request: %v", err)
+			return nil, err
+		}
+	}
+
+	//Send request and read response body, retrying on transient failures
+	res, body, err := c.sendWithRetry(ctx, op)
+	if err != nil {
+		if reservation != nil {
+			c.tokenLimiter.reconcile(reservation, 0)
+		}
+		return nil, err
+	}
+
+	chatRes, err := c.parseChatResponse(res, body)
+	if err != nil {
+		return nil, err
+	}
+	if chatRes.Model == "" {
+		chatRes.Model = op.model
+	}
+
+	//Replace estimate with actual usage when the API reports it
+	if reservation != nil && chatRes.Usage.TotalTokens > 0 {
+		c.tokenLimiter.reconcile(reservation, chatRes.Usage.TotalTokens)
+	}
+
+	if c.cache != nil && !op.noCache {
+		c.cache.put(cacheKey, body)
+	}
+
+	return &completion{response: chatRes, body: body, header: res.Header, receivedAt: c.clock.Now(), endpoint: c.endpointOf(res), keyIndex: c.keyIndexOf(res), requestedModel: op.model}, nil
+}
+
+// Unmarshal response body and check it has at least one choice.
+// res is nil for bodies which did not come from the network, e.g. cached ones.
+func (c *Client) parseChatResponse(res *http.Response, body []byte) (*chatResponse, error) {
+	//Translate body of an API with another shape
+	if adapter := c.provider.adapter; adapter != nil {
+		translated, err := adapter.decodeResponse(body)
+		if err != nil {
+			err := newDecodeError(res, body, err)
+			log.Printf("Failed to unmarshal: %v", err)
+			return nil, err
+		}
+		body = translated
+	}
+
+	//Unmarshal json response into Go struct
+	chatRes := &chatResponse{}
+	err := c.decodeJSON(body, chatRes)
+	if err != nil {
+		err := newDecodeError(res, body, err)
+		log.Printf("Failed to unmarshal: %v", err)
+		return nil, err
+	}
+
+	if len(chatRes.Choices) == 0 {
+		err := errors.New("No choices returned from llama")
+		log.Printf("Failed to get expected length of choices: %v", err)
+		return nil, err
+	}
+	for i := range chatRes.Choices {
+		chatRes.Choices[i].FinishReason = normalizeFinishReason(chatRes.Choices[i].FinishReason)
+	}
+
+	//Zero values hide missing fields, so check them on the raw JSON
+	if err := checkChatResponseShape(body); err != nil {
+		err := newDecodeError(res, body, err)
+		log.Printf("Failed to get expected response shape: %v", err)
+		return nil, err
+	}
+
+	return chatRes, nil
+}
+
+// Check the content filter did not stop generation of the first choice, returning ContentFilteredError with the partial content
+func checkContentFilter(chatRes *chatResponse) error {
+	choice := chatRes.Choices[0]
+	if choice.FinishReason != "content_filter" {
+		return nil
+	}
+	err := &ContentFilteredError{Content: choice.Message.Content, Model: chatRes.Model}
+	log.Printf("Failed to get complete response: %v", err)
+	return err
+}
+
+// Check that every choice has a message object with content or a function call
+func checkChatResponseShape(body []byte) error {
+	var shape struct {
+		Choices []map[string]json.RawMessage `json:"choices"`
+	}
+	if err := json.Unmarshal(body, &shape); err != nil {
+		return err
+	}
+	for i, choice := range shape.Choices {
+		raw, ok := choice["message"]
+		if !ok || string(raw) == "null" {
+			return fmt.Errorf("%w: choices[%d].message is missing", ErrUnexpectedResponse, i)
+		}
+		var message map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &message); err != nil {
+			return fmt.Errorf("%w: choices[%d].message is not an object", ErrUnexpectedResponse, i)
+		}
+		_, hasContent := message["content"]
+		_, hasFunctionCall := message["function_call"]
+		if !hasContent && !hasFunctionCall {
+			return fmt.Errorf("%w: choices[%d].message has neither content nor function_call", ErrUnexpectedResponse, i)
+		}
+	}
+	return nil
+}
+
+// Marshal request body, in the shape of the API of the provider.
+// The body must be released once the operation is done.
+func (c *Client) encodeChatRequest(chatReq *chatRequest) (*requestBody, error) {
+	if adapter := c.provider.adapter; adapter != nil {
+		data, err := adapter.encodeRequest(chatReq)
+		if err != nil {
+			return nil, err
+		}
+		return newRequestBody(data), nil
+	}
+	return marshalPooled(chatReq)
+}
+
+// Create chat request for messages with settings of the client and call
+func (c *Client) newChatRequest(messages []reqMessage, call *callOptions) *chatRequest {
+	if c.promptPreprocessor != nil {
+		messages = preprocessMessages(messages, c.promptPreprocessor)
+	}
+	messages = trimHistory(withSystemPrompt(messages, c.systemPromptFor(call)), c.maxHistory)
+	messages = trimToTokens(messages, c.maxPromptTokens)
+	chatReq := createChatRequestWithMessages(messages)
+	chatReq.Model = c.model
+	if len(c.functions) > 0 {
+		chatReq.Functions = c.functions
+		chatReq.FunctionCall = c.functionCall
+		chatReq.ParallelToolCalls = c.parallelToolCalls
+	}
+	chatReq.MaxTokens = c.maxTokens
+	chatReq.Temperature = c.temperature
+	chatReq.Stop = c.stop
+	chatReq.Grammar = c.grammar
+	chatReq.LogitBias = c.logitBias
+	chatReq.Logprobs = c.logprobs
+	chatReq.TopLogprobs = c.topLogprobs
+	chatReq.ResponseFormat = c.responseFormat
+	chatReq.Provider = c.routing
+	return chatReq
+}
+
+// Rewrite text of user messages with preprocess, copying them so that the caller's messages are kept
+func preprocessMessages(messages []reqMessage, preprocess func(string) string) []reqMessage {
+	processed := make([]reqMessage, len(messages))
+	for i, m := range messages {
+		if m.Role == "user" {
+			if len(m.Parts) == 0 {
+				m.Content = preprocess(m.Content)
+			} else {
+				m.Parts = append([]contentPart{}, m.Parts...)
+				for j, part := range m.Parts {
+					if part.Type == "text" {
+						m.Parts[j].Text = preprocess(part.Text)
+					}
+				}
+			}
+		}
+		processed[i] = m
+	}
+	return processed
+}
+
+// Get tokens spent within the last minute and the tokens per minute limit, zeros when unlimited
+func (c *Client) TokenUsage() (int, int) {
+	if c.tokenLimiter == nil {
+		return 0, 0
+	}
+	return c.tokenLimiter.usage()
+}
+
+// Execute a single http request to llama API and return response body.
+// Connection errors, 502 and 503 fail over to the next base URL, putting the failed one in cool-down.
+// With multiple API keys, 401 and 429 switch to the next key likewise.
+// Response is returned along with APIError for non-200 status codes, its body is already closed.
+func (c *Client) send(ctx context.Context, op *operation) (*http.Response, []byte, error) {
+	if c.keys == nil {
+		return c.sendWithKey(ctx, op, c.apiKey)
+	}
+
+	//Switch to the next key when the API rejects or rate limits the current one
+	var res *http.Response
+	var body []byte
+	var err error
+	for i := 0; i < len(c.keys.keys); i++ {
+		index, key := c.keys.pick()
+		res, body, err = c.sendWithKey(ctx, op, key)
+		if !isKeyRejected(res, err) || ctx.Err() != nil {
+			break
+		}
+		c.keys.markUnhealthy(index)
+		if i < len(c.keys.keys)-1 {
+			op.logf("API key #%d rejected with status %d, switching to next key", index, res.StatusCode)
+		}
+	}
+	return res, body, err
+}
+
+// Send request with given API key, failing over to the next base URL when an endpoint looks dead
+func (c *Client) sendWithKey(ctx context.Context, op *operation, apiKey string) (*http.Response, []byte, error) {
+	var res *http.Response
+	var body []byte
+	var err error
+	endpoints := c.endpoints.candidates()
+	for i, baseURL := range endpoints {
+		res, body, err = c.sendTo(ctx, op, baseURL, apiKey)
+		if !isFailoverError(res, err) || ctx.Err() != nil {
+			break
+		}
+		c.endpoints.markUnhealthy(baseURL)
+		if i < len(endpoints)-1 {
+			op.logf("Endpoint %s failed, failing over to %s: %v", baseURL, endpoints[i+1], err)
+		}
+	}
+	if err == nil {
+		op.debugf("Response served by %s", c.endpointOf(res))
+	}
+	return res, body, err
+}
+
+// Execute http request to chat completions endpoint under given base URL
+func (c *Client) sendTo(ctx context.Context, op *operation, baseURL, apiKey string) (*http.Response, []byte, error) {
+	//Create Http request struct with request method, endpoint and request body
+	reqBody := op.body.reader()
+	req, err := http.NewRequestWithContext(ctx, "POST", c.chatCompletionsURL(baseURL, op.model), reqBody)
+	if err != nil {
+		reqBody.Close()
+		op.logf("Failed to create http request struct: %v", err)
+		return nil, nil, err
+	}
+	//Pooled body is not a type whose length and replay http.NewRequest know
+	req.ContentLength = int64(len(op.body.data))
+	req.GetBody = func() (io.ReadCloser, error) { return op.body.reader(), nil }
+
+	//Add necessary headers, including the API key for authorization
+	req.Header.Set("Content-Type", "application/json")
+	if c.idempotencyHeader != "" {
+		//Same key across retries and failover lets the provider deduplicate the operation
+		req.Header.Set(c.idempotencyHeader, op.idempotencyKey)
+	}
+	c.setAcceptEncoding(req, op.stream)
+	c.setHeaders(req, op, apiKey)
+	if signer, ok := c.provider.adapter.(requestSigner); ok {
+		//Sign last, the signature covers the final headers
+		if err := signer.signRequest(req, op.body.data, c.clock.Now()); err != nil {
+			op.logf("Failed to sign request: %v", err)
+			return nil, nil, err
+		}
+	}
+	if op.debug {
+		op.debugf("Sending %s %s with headers %v", req.Method, redactURL(req.URL), redactHeader(req.Header))
+	}
+
+	//Execute http request to llama and get response
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		op.logf("Failed to get http response: %v", err)
+		return nil, nil, err
+	}
+	c.rateLimits.update(res.Header, c.clock.Now())
+
+	//Hand over body of successful stream to the caller, who closes it
+	if op.stream && res.StatusCode == http.StatusOK {
+		return res, nil, nil
+	}
+	defer closeBody(res.Body)
+
+	//Read http response body, both success and error bodies are bounded
+	reader, err := c.responseReader(res, op)
+	if err != nil {
+		return res, nil, err
+	}
+	body, err := readBody(reader, c.maxResponseBytes)
+	if err != nil {
+		op.logf("Failed to read body: %v", err)
+		return res, nil, err
+	}
+
+	//Check if http status code and content type are as expected
+	if err := c.checkResponse(res, body); err != nil {
+		op.logf("Failed to get expected response: %v", err)
+		return res, nil, err
+	}
+
+	return res, body, nil
+}
+
+// Check response status and Content-Type before decoding.
+// Error bodies which are not JSON, such as HTML pages from gateways, are quoted as a sanitized excerpt.
+func (c *Client) checkResponse(res *http.Response, body []byte) error {
+	contentType := res.Header.Get("Content-Type")
+	if res.StatusCode != http.StatusOK {
+		apiErr := &APIError{StatusCode: res.StatusCode, Header: res.Header, Body: c.redact(string(body))}
+		apiErr.RequestID, apiErr.CFRay = providerRequestIDs(res.Header)
+		if !isJSONContentType(contentType) {
+			apiErr.Excerpt = c.redact(bodySnippet(body, ERROR_SNIPPET_BYTES))
+		}
+		return apiErr
+	}
+	if !isJSONContentType(contentType) {
+		return fmt.Errorf("%w: %q: %s", ErrUnexpectedContentType, contentType, c.redact(bodySnippet(body, ERROR_SNIPPET_BYTES)))
+	}
+	return nil
+}
+
+// Check if Content-Type is JSON. A missing Content-Type is given the benefit of the doubt.
+func isJSONContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// Wrap decoding error with status, Content-Type and the beginning of the body, never any request headers
+func newDecodeError(res *http.Response, body []byte, err error) *DecodeError {
+	decodeErr := &DecodeError{Snippet: bodySnippet(body, ERROR_SNIPPET_BYTES), Err: err}
+	if res != nil {
+		decodeErr.StatusCode = res.StatusCode
+		decodeErr.ContentType = res.Header.Get("Content-Type")
+	}
+	return decodeErr
+}
+
+// Unmarshal JSON into v. Unknown fields are ignored unless strict decoding is enabled,
+// in which case the first unknown field is reported as an error.
+func (c *Client) decodeJSON(data []byte, v any) error {
+	if !c.strictDecoding {
+		return json.Unmarshal(data, v)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("Strict decoding failed: %w", err)
+	}
+	return nil
+}
+
+// Read body up to limit bytes, failing with ResponseTooLargeError beyond that
+func readBody(r io.Reader, limit int64) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, &ResponseTooLargeError{Read: int64(len(body)), Limit: limit}
+	}
+	return body, nil
+}
+
+// Set headers common to all requests, including the API key for authorization
+func (c *Client) setHeaders(req *http.Request, op *operation, apiKey string) {
+	userAgent := c.userAgent
+	if c.userAgentSuffix != "" {
+		userAgent += " " + c.userAgentSuffix
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("X-Request-ID", op.requestID)
+	applyHeaders(req, c.provider.Header)
+	c.setAuthHeader(req.Header, apiKey)
+	if c.org != "" {
+		req.Header.Set("OpenAI-Organization", c.org)
+	}
+
+	//Extra headers replace defaults, per-call ones replace client ones
+	applyHeaders(req, c.header)
+	applyHeaders(req, op.header)
+}
+
+// Print log only when debug logging is enabled
+func (c *Client) debugf(format string, v ...any) {
+	if c.debug {
+		log.Printf("[debug] %s", c.redact(fmt.Sprintf(format, v...)))
+	}
+}
+
+// Get system prompt of a call, the client's default unless replaced with WithSystemPrompt
+func (c *Client) systemPromptFor(call *callOptions) string {
+	if call.systemPrompt != nil {
+		return *call.systemPrompt
+	}
+	return c.systemPrompt
+}
+
+// Get overall and per-attempt timeouts of a call, the client's unless replaced with call options
+func (c *Client) timeoutsFor(call *callOptions) (time.Duration, time.Duration) {
+	timeout, attemptTimeout := c.timeout, c.attemptTimeout
+	if call.timeout != nil {
+		timeout = *call.timeout
+	}
+	if call.attemptTimeout != nil {
+		attemptTimeout = *call.attemptTimeout
+	}
+	return timeout, attemptTimeout
+}
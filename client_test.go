@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// API key clients of fake servers authenticate with
+const testAPIKey = "test-key"
+
+// Environment variables read by NewClient, cleared so that tests do not depend on the shell
+var clientEnv = []string{"LLAMA_API_KEY", API_KEYS_ENV, API_KEY_FILE_ENV, "LLAMA_API_URL", "LLAMA_MODEL", "LLAMA_ORG", "LLAMA_DEBUG"}
+
+// Clear environment variables read by NewClient for the rest of the test
+func clearClientEnv(t testing.TB) {
+	for _, name := range clientEnv {
+		t.Setenv(name, "")
+	}
+}
+
+// Fake llama API recording the requests it receives
+type fakeServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []capturedRequest
+}
+
+// Request received by a fake server
+type capturedRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// Start fake server answering with handler, and create a client pointed at it with WithBaseURL.
+// The client has a test API key and does not retry, opts are applied after those.
+func newFakeServer(t *testing.T, handler http.HandlerFunc, opts ...Option) (*fakeServer, *Client) {
+	t.Helper()
+	clearClientEnv(t)
+
+	f := &fakeServer{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.requests = append(f.requests, capturedRequest{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone(), Body: body})
+		f.mu.Unlock()
+
+		//Let the handler read the body as well
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		handler(w, r)
+	}))
+	t.Cleanup(f.Close)
+
+	return f, f.newClient(t, append([]Option{WithAPIKey(testAPIKey), WithRetryPolicy(NoRetry)}, opts...)...)
+}
+
+// Create another client pointed at the server, with opts only
+func (f *fakeServer) newClient(t *testing.T, opts ...Option) *Client {
+	t.Helper()
+	client, err := NewClient(append([]Option{WithBaseURL(f.URL)}, opts...)...)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return client
+}
+
+// Get requests received so far
+func (f *fakeServer) captured() []capturedRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]capturedRequest{}, f.requests...)
+}
+
+// Handler answering every request with status and JSON body
+func respondJSON(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}
+}
+
+// Body of a chat completion with a single choice of content
+func chatCompletionJSON(content string) string {
+	data, _ := json.Marshal(chatResponse{
+		ID:      "chatcmpl-test",
+		Object:  "chat.completion",
+		Model:   DEFAULT_MODEL,
+		Choices: []choice{{Message: resMessage{Role: "assistant", Content: content}, FinishReason: "stop"}},
+		Usage:   usage{PromptTokens: 5, CompletionTokens: 2, TotalTokens: 7},
+	})
+	return string(data)
+}
+
+// Handler answering streamed requests with a single chunk of content and others with a completion
+func respondChat(content string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Stream bool `json:"stream"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			respondJSON(http.StatusOK, chatCompletionJSON(content))(w, r)
+			return
+		}
+		chunk, _ := json.Marshal(chatStreamChunk{Choices: []streamChoice{{Delta: chunkDelta{Role: "assistant", Content: content}, FinishReason: "stop"}}})
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: "+string(chunk)+"\n\ndata: [DONE]\n\n")
+	}
+}
+
+func TestClientComplete(t *testing.T) {
+	//Closed once the handler holds the request, so that the test can cancel it mid-flight
+	started := make(chan struct{})
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		opts     []Option
+		noAPIKey bool
+		// Cancel the context once the server received the request
+		cancel bool
+		// Golden request body, not compared when empty
+		golden string
+		// Number of requests reaching the server
+		wantRequests int
+		wantContent  string
+		checkErr     func(t *testing.T, err error)
+	}{
+		{
+			name:         "success",
+			handler:      respondJSON(http.StatusOK, chatCompletionJSON("Hello there")),
+			golden:       "testdata/chat_request.json",
+			wantRequests: 1,
+			wantContent:  "Hello there",
+		},
+		{
+			name:         "success with client defaults",
+			handler:      respondJSON(http.StatusOK, chatCompletionJSON("Hello there")),
+			opts:         []Option{WithDefaultSystemPrompt("Answer briefly."), WithMaxTokens(64), WithTemperature(0.2), WithStop("\n\n")},
+			golden:       "testdata/chat_request_defaults.json",
+			wantRequests: 1,
+			wantContent:  "Hello there",
+		},
+		{
+			name:     "missing API key",
+			handler:  respondJSON(http.StatusOK, chatCompletionJSON("Hello there")),
+			noAPIKey: true,
+			checkErr: func(t *testing.T, err error) {
+				if !errors.Is(err, ErrMissingAPIKey) {
+					t.Errorf("got error %v, want ErrMissingAPIKey", err)
+				}
+			},
+		},
+		{
+			name:         "non-200 with JSON error body",
+			handler:      respondJSON(http.StatusBadRequest, `{"error":{"message":"Unknown model","type":"invalid_request_error"}}`),
+			golden:       "testdata/chat_request.json",
+			wantRequests: 1,
+			checkErr: func(t *testing.T, err error) {
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) {
+					t.Fatalf("got error %v, want APIError", err)
+				}
+				if apiErr.StatusCode != http.StatusBadRequest || !strings.Contains(apiErr.Body, "Unknown model") {
+					t.Errorf("got status %d body %q, want 400 with the error body", apiErr.StatusCode, apiErr.Body)
+				}
+			},
+		},
+		{
+			name:         "empty choices",
+			handler:      respondJSON(http.StatusOK, `{"id":"chatcmpl-test","object":"chat.completion","choices":[]}`),
+			wantRequests: 1,
+			checkErr: func(t *testing.T, err error) {
+				if err == nil || !strings.Contains(err.Error(), "No choices") {
+					t.Errorf("got error %v, want no choices error", err)
+				}
+			},
+		},
+		{
+			name:         "malformed JSON",
+			handler:      respondJSON(http.StatusOK, `{"id":"chatcmpl-test","choices":[{"message":`),
+			wantRequests: 1,
+			checkErr: func(t *testing.T, err error) {
+				var decodeErr *DecodeError
+				if !errors.As(err, &decodeErr) {
+					t.Fatalf("got error %v, want DecodeError", err)
+				}
+				if decodeErr.StatusCode != http.StatusOK || !strings.Contains(decodeErr.Snippet, `"choices"`) {
+					t.Errorf("got status %d snippet %q, want 200 with the body quoted", decodeErr.StatusCode, decodeErr.Snippet)
+				}
+			},
+		},
+		{
+			name: "context cancelled mid-request",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				<-r.Context().Done()
+			},
+			cancel:       true,
+			wantRequests: 1,
+			checkErr: func(t *testing.T, err error) {
+				if !errors.Is(err, context.Canceled) {
+					t.Errorf("got error %v, want context.Canceled", err)
+				}
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, client := newFakeServer(t, tc.handler, tc.opts...)
+			if tc.noAPIKey {
+				client = f.newClient(t, tc.opts...)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tc.cancel {
+				go func() {
+					<-started
+					cancel()
+				}()
+			}
+
+			result, err := client.Complete(ctx, "Say hello")
+			if tc.checkErr != nil {
+				if err == nil {
+					t.Fatalf("got content %q, want error", result.Content)
+				}
+				tc.checkErr(t, err)
+			} else if err != nil {
+				t.Fatalf("Complete: %v", err)
+			} else if result.Content != tc.wantContent {
+				t.Errorf("got content %q, want %q", result.Content, tc.wantContent)
+			}
+
+			requests := f.captured()
+			if len(requests) != tc.wantRequests {
+				t.Fatalf("server got %d requests, want %d", len(requests), tc.wantRequests)
+			}
+			if len(requests) == 0 {
+				return
+			}
+			req := requests[0]
+			if req.Method != "POST" || req.Path != CHAT_COMPLETIONS_PATH {
+				t.Errorf("got %s %s, want POST %s", req.Method, req.Path, CHAT_COMPLETIONS_PATH)
+			}
+			if got := req.Header.Get("Authorization"); got != "Bearer "+testAPIKey {
+				t.Errorf("got Authorization %q, want the test key", got)
+			}
+			if tc.golden != "" {
+				checkGolden(t, tc.golden, req.Body)
+			}
+		})
+	}
+}
+
+// Silence logs of failed decoding for the duration of a fuzz target, they would flood its output
+func discardLogs(f *testing.F) {
+	log.SetOutput(io.Discard)
+	f.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+// Decoding arbitrary bodies must not panic, and bodies which decode must have a choice to build the result from.
+// Seeds are captured responses of the providers in testdata/fuzz/FuzzChatResponse.
+func FuzzChatResponse(f *testing.F) {
+	clearClientEnv(f)
+	discardLogs(f)
+	var clients []*Client
+	for _, opts := range [][]Option{
+		{WithAPIKey(testAPIKey)},
+		{WithAPIKey(testAPIKey), WithStrictDecoding(true)},
+		{WithProvider(Anthropic), WithAPIKey(testAPIKey)},
+	} {
+		client, err := NewClient(opts...)
+		if err != nil {
+			f.Fatal(err)
+		}
+		clients = append(clients, client)
+	}
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		for _, client := range clients {
+			chatRes, err := client.parseChatResponse(nil, body)
+			if err != nil {
+				continue
+			}
+			if len(chatRes.Choices) == 0 {
+				t.Fatalf("%s: decoded response without choices from %q", client.provider.Name, body)
+			}
+			checkContentFilter(chatRes)
+			newGenerateResult(&completion{response: chatRes, body: body}, &callOptions{rawResponse: true})
+		}
+	})
+}
+
+// Error bodies, whatever their status, Content-Type and bytes, must give an error which quotes no API key,
+// is valid UTF-8 and keeps the whole body. Seeds are captured error responses in testdata/fuzz/FuzzErrorBody.
+func FuzzErrorBody(f *testing.F) {
+	const key = "sk-fuzz-0123456789abcdef"
+	clearClientEnv(f)
+	client, err := NewClient(WithAPIKey(key))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Fuzz(func(t *testing.T, status int, contentType string, body []byte) {
+		res := &http.Response{StatusCode: status, Header: http.Header{"Content-Type": {contentType}}}
+		err := client.checkResponse(res, body)
+		if status == http.StatusOK && isJSONContentType(contentType) {
+			if err != nil {
+				t.Fatalf("got error %v for JSON response", err)
+			}
+			return
+		}
+		if err == nil {
+			t.Fatalf("got no error for status %d with Content-Type %q", status, contentType)
+		}
+
+		msg := err.Error()
+		if !utf8.ValidString(msg) {
+			t.Errorf("error is not valid UTF-8: %q", msg)
+		}
+		if strings.Contains(msg, key) && !strings.Contains(contentType, key) {
+			t.Errorf("error quotes the API key: %s", msg)
+		}
+
+		var apiErr *APIError
+		if status == http.StatusOK {
+			if !errors.Is(err, ErrUnexpectedContentType) {
+				t.Errorf("got error %v, want ErrUnexpectedContentType", err)
+			}
+			return
+		}
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("got error %v, want APIError", err)
+		}
+		if want := redactSecret(string(body), key); apiErr.StatusCode != status || apiErr.Body != want {
+			t.Errorf("got status %d body %q, want %d %q", apiErr.StatusCode, apiErr.Body, status, want)
+		}
+		for _, r := range apiErr.Excerpt {
+			if r < 0x20 || r == 0x7f {
+				t.Fatalf("excerpt has control character %q: %q", r, apiErr.Excerpt)
+			}
+		}
+		shouldFallback(err)
+		classifyHealth(err)
+	})
+}
+
+func TestPromptPreprocessorAppliesToEveryMethod(t *testing.T) {
+	ctx := context.Background()
+	ignore := func(string) error { return nil }
+	history := []reqMessage{
+		{Role: "user", Content: "hello"},
+		{Role: "assistant", Content: "hi"},
+		{Role: "user", Content: "again"},
+	}
+	tests := []struct {
+		name string
+		send func(c *Client) error
+		want []string
+	}{
+		{name: "Complete", send: func(c *Client) error { _, err := c.Complete(ctx, "hello"); return err }, want: []string{"HELLO"}},
+		{name: "Chat", send: func(c *Client) error { _, err := c.Chat(ctx, history); return err }, want: []string{"HELLO", "hi", "AGAIN"}},
+		{name: "GenerateStream", send: func(c *Client) error { _, err := c.GenerateStream(ctx, "hello", ignore); return err }, want: []string{"HELLO"}},
+		{name: "ChatStream", send: func(c *Client) error { _, err := c.ChatStream(ctx, history, ignore); return err }, want: []string{"HELLO", "hi", "AGAIN"}},
+		{name: "image", send: func(c *Client) error {
+			_, err := c.Chat(ctx, []reqMessage{ImageMessage("what is this", "https://example.com/cat.png")})
+			return err
+		}, want: []string{""}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, client := newFakeServer(t, respondChat("Hi"), WithPromptPreprocessor(strings.ToUpper), WithDefaultSystemPrompt("be brief"))
+			if err := tc.send(client); err != nil {
+				t.Fatal(err)
+			}
+
+			var sent struct {
+				Messages []struct {
+					Role    string          `json:"role"`
+					Content json.RawMessage `json:"content"`
+				} `json:"messages"`
+			}
+			if err := json.Unmarshal(f.captured()[0].Body, &sent); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, m := range sent.Messages {
+				var content string
+				if json.Unmarshal(m.Content, &content) != nil {
+					//Text of multimodal content is checked below
+					content = ""
+				}
+				got = append(got, content)
+			}
+			want := append([]string{"be brief"}, tc.want...)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got contents %q, want %q", got, want)
+			}
+			if tc.name == "image" && !strings.Contains(string(sent.Messages[1].Content), `"WHAT IS THIS"`) {
+				t.Errorf("text part not preprocessed: %s", sent.Messages[1].Content)
+			}
+		})
+	}
+	if history[0].Content != "hello" {
+		t.Errorf("caller's message was modified to %q", history[0].Content)
+	}
+}
+
+// Handler streaming status and a body of size bytes in flushed chunks without Content-Length,
+// as a misbehaving proxy would, counting the bytes it managed to write
+func oversizedBody(status int, contentType string, size int, written *atomic.Int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		chunk := []byte(strings.Repeat("a", 4<<10))
+		if contentType == "text/event-stream" {
+			//A single event which never ends
+			chunk[0], chunk[1], chunk[2], chunk[3], chunk[4], chunk[5] = 'd', 'a', 't', 'a', ':', ' '
+		}
+		rc := http.NewResponseController(w)
+		for n := 0; n < size; n += len(chunk) {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			written.Add(int64(len(chunk)))
+			rc.Flush()
+		}
+	}
+}
+
+func TestOversizedResponseBody(t *testing.T) {
+	const limit = 64 << 10
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		stream      bool
+	}{
+		{name: "success body", status: http.StatusOK, contentType: "application/json"},
+		{name: "error body", status: http.StatusBadRequest, contentType: "text/html"},
+		{name: "stream event", status: http.StatusOK, contentType: "text/event-stream", stream: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var written atomic.Int64
+			captureLogs(t)
+			policy := ExponentialBackoff{MaxRetries: 3, BaseDelay: time.Second}
+			f, client := newFakeServer(t, oversizedBody(tc.status, tc.contentType, 64<<20, &written), withClock(newFakeClock()), WithRetryPolicy(policy), WithMaxResponseBytes(limit))
+
+			var err error
+			if tc.stream {
+				_, err = client.GenerateStream(context.Background(), "Say hello", func(string) error { return nil })
+			} else {
+				_, err = client.Complete(context.Background(), "Say hello")
+			}
+			var tooLarge *ResponseTooLargeError
+			if !errors.Is(err, ErrResponseTooLarge) || !errors.As(err, &tooLarge) {
+				t.Fatalf("got error %v, want ResponseTooLargeError", err)
+			}
+			if tooLarge.Limit != limit || tooLarge.Read <= limit || tooLarge.Read > 2*limit {
+				t.Errorf("got %d bytes read with limit %d, want just over the limit of %d", tooLarge.Read, tooLarge.Limit, limit)
+			}
+			//Reading stops at the cap and is not retried
+			if n := len(f.captured()); n != 1 {
+				t.Errorf("server got %d requests, want 1", n)
+			}
+			f.Close()
+			if n := written.Load(); n >= 64<<20 {
+				t.Errorf("server wrote all %d bytes, want the client to stop reading", n)
+			}
+		})
+	}
+}
+
+func TestResponseBodyWithinLimit(t *testing.T) {
+	body := chatCompletionJSON(strings.Repeat("a", 1000))
+	_, client := newFakeServer(t, respondJSON(http.StatusOK, body), WithMaxResponseBytes(int64(len(body))))
+	if _, err := client.Complete(context.Background(), "Say hello"); err != nil {
+		t.Errorf("body of exactly the limit: %v", err)
+	}
+
+	clearClientEnv(t)
+	client, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.maxResponseBytes != 10<<20 {
+		t.Errorf("got default limit %d, want 10 MiB", client.maxResponseBytes)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Source of time for retries, rate limiting, the circuit breaker, cool-downs and request signing, replaced in tests
+type clock interface {
+	Now() time.Time
+
+	// Wait for given duration unless context is done first
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// Clock of the system
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	return sleep(ctx, d)
+}
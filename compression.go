@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// How response compression is negotiated with llama API
+type Compression int
+
+const (
+	// Let net/http request gzip and decompress transparently
+	CompressionAuto Compression = iota
+	// Request gzip explicitly and decompress the body ourselves
+	CompressionGzip
+	// Ask for uncompressed responses, for proxies which mangle compression
+	CompressionNone
+)
+
+func (m Compression) String() string {
+	switch m {
+	case CompressionGzip:
+		return "gzip"
+	case CompressionNone:
+		return "none"
+	default:
+		return "auto"
+	}
+}
+
+// Set Accept-Encoding header for configured compression.
+// Streaming requests always ask for identity so events are not held back by the compressor.
+func (c *Client) setAcceptEncoding(req *http.Request, stream bool) {
+	switch {
+	case stream || c.compression == CompressionNone:
+		req.Header.Set("Accept-Encoding", "identity")
+	case c.compression == CompressionGzip:
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+}
+
+// Get reader of response body, decompressing it when gzip was requested explicitly.
+// A body labelled gzip without the gzip magic number is read as is.
+func (c *Client) responseReader(res *http.Response, op *operation) (io.Reader, error) {
+	if c.compression != CompressionGzip || !strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+		return res.Body, nil
+	}
+
+	//Check magic number since some proxies decompress without fixing the header
+	br := bufio.NewReader(res.Body)
+	magic, err := br.Peek(2)
+	if err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		op.debugf("Content-Encoding is gzip but body is not gzipped, reading it as is")
+		return br, nil
+	}
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		op.logf("Failed to create gzip reader: %v", err)
+		return nil, err
+	}
+	return zr, nil
+}
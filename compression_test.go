@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"testing"
+)
+
+// Compress data with gzip
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Handler answering with body labelled by Content-Encoding encoding, left out when empty
+func respondEncoded(encoding string, body []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if encoding != "" {
+			w.Header().Set("Content-Encoding", encoding)
+		}
+		w.Write(body)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	completion := []byte(chatCompletionJSON("Hello there"))
+	gzipped := gzipBytes(t, completion)
+	corrupt := append(append([]byte{}, gzipped[:10]...), bytes.Repeat([]byte{0xff}, 32)...)
+
+	tests := []struct {
+		name    string
+		mode    Compression
+		handler http.HandlerFunc
+		// Accept-Encoding the server should receive
+		wantAccept string
+		wantErr    bool
+	}{
+		{name: "gzip decoded", mode: CompressionGzip, handler: respondEncoded("gzip", gzipped), wantAccept: "gzip"},
+		{name: "gzip label in other case", mode: CompressionGzip, handler: respondEncoded("GZIP", gzipped), wantAccept: "gzip"},
+		{name: "plain body claiming gzip", mode: CompressionGzip, handler: respondEncoded("gzip", completion), wantAccept: "gzip"},
+		{name: "uncompressed answer", mode: CompressionGzip, handler: respondEncoded("", completion), wantAccept: "gzip"},
+		{name: "gzip body claiming identity", mode: CompressionGzip, handler: respondEncoded("identity", gzipped), wantAccept: "gzip", wantErr: true},
+		{name: "corrupt gzip", mode: CompressionGzip, handler: respondEncoded("gzip", corrupt), wantAccept: "gzip", wantErr: true},
+		{name: "none asks for identity", mode: CompressionNone, handler: respondEncoded("", completion), wantAccept: "identity"},
+		{name: "auto decoded by net/http", mode: CompressionAuto, handler: respondEncoded("gzip", gzipped), wantAccept: "gzip"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			captureLogs(t)
+			f, client := newFakeServer(t, tc.handler, WithCompression(tc.mode))
+
+			result, err := client.Complete(context.Background(), "Say hello")
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("got content %q, want error", result.Content)
+				}
+			} else if err != nil {
+				t.Fatalf("Complete: %v", err)
+			} else if result.Content != "Hello there" {
+				t.Errorf("got content %q", result.Content)
+			}
+			if got := f.captured()[0].Header.Get("Accept-Encoding"); got != tc.wantAccept {
+				t.Errorf("server got Accept-Encoding %q, want %q", got, tc.wantAccept)
+			}
+		})
+	}
+}
+
+func TestCompressionBypassedForStreams(t *testing.T) {
+	for _, mode := range []Compression{CompressionAuto, CompressionGzip, CompressionNone} {
+		t.Run(mode.String(), func(t *testing.T) {
+			f, client := newFakeServer(t, respondChat("Hello there"), WithCompression(mode))
+
+			var chunks []string
+			content, err := client.GenerateStream(context.Background(), "Say hello", func(chunk string) error {
+				chunks = append(chunks, chunk)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("GenerateStream: %v", err)
+			}
+			if content != "Hello there" || len(chunks) != 1 {
+				t.Errorf("got content %q in chunks %q", content, chunks)
+			}
+			if got := f.captured()[0].Header.Get("Accept-Encoding"); got != "identity" {
+				t.Errorf("server got Accept-Encoding %q, want identity", got)
+			}
+		})
+	}
+}
+
+func TestCompressionUnknownMode(t *testing.T) {
+	clearClientEnv(t)
+	captureLogs(t)
+	if _, err := NewClient(WithCompression(Compression(42))); err == nil {
+		t.Error("want error for unknown compression mode")
+	}
+}
@@ -0,0 +1,704 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Directory under the user config directory holding config.yaml or config.json
+const CONFIG_DIR_NAME = "go-llama"
+
+// Settings of the command resolved from flags, environment, config file and built-in defaults
+type Config struct {
+	Provider           Provider
+	Model              string
+	BaseURL            string
+	CompletionsPath    string
+	Fixtures           string
+	APIKey             string
+	Organization       string
+	Proxy              string
+	CACert             string
+	ClientCert         string
+	ClientKey          string
+	SystemPrompt       string
+	Temperature        *float64
+	StrictCapabilities bool
+	MaxTokens          int
+	MaxRetries         int
+	Timeout            time.Duration
+	AttemptTimeout     time.Duration
+
+	// Config file which was read, empty when there was none
+	Path string
+	// Profile of config file which was applied, empty when none was selected
+	Profile string
+	// Where each key was resolved from, e.g. "flag -model" or "env LLAMA_MODEL"
+	sources map[string]string
+}
+
+// Key of config file along with the environment variable and flag overriding it
+type configKey struct {
+	name string
+	env  string
+	flag string
+}
+
+// Keys allowed in config file, in the order they are shown
+var configKeys = []configKey{
+	{name: "provider", env: PROVIDER_ENV, flag: "provider"},
+	{name: "api_key_env", flag: "api-key-env"},
+	{name: "capabilities", flag: "capabilities"},
+	{name: "strict_capabilities", flag: "strict-capabilities"},
+	{name: "model", env: "LLAMA_MODEL", flag: "model"},
+	{name: "base_url", env: "LLAMA_API_URL", flag: "base-url"},
+	{name: "completions_path", env: "LLAMA_API_PATH", flag: "completions-path"},
+	{name: "fixtures", flag: "fixtures"},
+	{name: "api_key", env: "LLAMA_API_KEY"},
+	{name: "organization", env: "LLAMA_ORG"},
+	{name: "proxy", flag: "proxy"},
+	{name: "ca_cert"},
+	{name: "client_cert"},
+	{name: "client_key"},
+	{name: "system_prompt", flag: "system"},
+	{name: "temperature", flag: "temperature"},
+	{name: "max_tokens", flag: "max-tokens"},
+	{name: "max_retries", flag: "max-retries"},
+	{name: "timeout", flag: "timeout"},
+	{name: "attempt_timeout", flag: "attempt-timeout"},
+}
+
+// Values used when neither flags, environment nor config file set a key.
+// Defaults of model and base URL come from the provider.
+var configDefaults = map[string]string{
+	"provider":            Llama.Name,
+	"strict_capabilities": "false",
+	"max_retries":         strconv.Itoa(DEFAULT_MAX_RETRIES),
+	"timeout":             DEFAULT_TIMEOUT.String(),
+	"attempt_timeout":     DEFAULT_ATTEMPT_TIMEOUT.String(),
+}
+
+// Flags selecting config file and profile
+type configSelection struct {
+	path    *string
+	profile *string
+}
+
+// Register flags overriding config file and environment.
+// Returns flags selecting config file and profile, and a function collecting values of flags set explicitly.
+func registerConfigFlags(flagSet *flag.FlagSet) (configSelection, func() map[string]string) {
+	selection := configSelection{
+		path:    flagSet.String("config", "", "Config file, defaults to config.yaml or config.json in "+filepath.Join("~", ".config", CONFIG_DIR_NAME)),
+		profile: flagSet.String("profile", "", "Profile of config file to apply, defaults to "+PROFILE_ENV),
+	}
+	flagSet.String("provider", Llama.Name, "Provider preset setting base URL, API key variable and default model: "+providerNames())
+	flagSet.String("api-key-env", "", "Environment variable holding the API key, defaults to the provider's one")
+	flagSet.String("capabilities", "", "Comma separated optional fields the backend accepts, e.g. tools,logprobs, defaults to all")
+	flagSet.Bool("strict-capabilities", false, "Reject requests using fields the backend does not accept instead of removing them")
+	flagSet.String("model", "", "Model to send requests to, defaults to the provider's default model")
+	flagSet.String("base-url", "", "Base URL of the API, defaults to the provider's base URL")
+	flagSet.String("completions-path", "", "Path of chat completions under base URL, e.g. /v1/chat/completions, defaults to "+CHAT_COMPLETIONS_PATH)
+	flagSet.String("fixtures", "", "Directory of JSON fixtures which the mock provider answers from")
+	flagSet.String("proxy", "", "Proxy URL (http, https or socks5), defaults to HTTPS_PROXY")
+	flagSet.String("system", "", "System prompt sent before the prompt")
+	flagSet.Float64("temperature", 0, "Sampling temperature from 0 to 2, left to the API unless set")
+	flagSet.Int("max-tokens", 0, "Maximum number of tokens to generate, 0 leaves it to the API")
+	flagSet.Int("max-retries", DEFAULT_MAX_RETRIES, "Maximum number of retries of failed requests")
+	flagSet.Duration("timeout", DEFAULT_TIMEOUT, "Overall deadline across all retries, 0 means no limit")
+	flagSet.Duration("attempt-timeout", DEFAULT_ATTEMPT_TIMEOUT, "Timeout of each single attempt, 0 means no limit")
+
+	return selection, func() map[string]string {
+		values := map[string]string{}
+		flagSet.Visit(func(f *flag.Flag) {
+			for _, key := range configKeys {
+				if key.flag == f.Name {
+					values[key.name] = f.Value.String()
+				}
+			}
+		})
+		return values
+	}
+}
+
+// Read config file and resolve configuration with flags and environment.
+// A missing default config file is ignored, while a missing file given with -config is an error.
+func loadConfig(selection configSelection, flags map[string]string) (*Config, error) {
+	path := *selection.path
+	required := path != ""
+	if !required {
+		path = findConfigFile()
+	}
+	profile := *selection.profile
+	if profile == "" {
+		profile = os.Getenv(PROFILE_ENV)
+	}
+
+	var file *configFile
+	if path != "" {
+		var err error
+		file, err = readConfigFile(path)
+		if errors.Is(err, fs.ErrNotExist) && !required {
+			path, err = "", nil
+		}
+		if err != nil {
+			log.Printf("Failed to read config file: %v", err)
+			return nil, err
+		}
+	}
+
+	cfg, err := resolveConfig(flags, os.Getenv, file, profile)
+	if err != nil {
+		log.Printf("Failed to resolve config: %v", err)
+		return nil, err
+	}
+	cfg.Path = path
+	return cfg, nil
+}
+
+// Find config.yaml, config.yml or config.json in the user config directory, empty when there is none
+func findConfigFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	for _, name := range []string{"config.yaml", "config.yml", "config.json"} {
+		path := filepath.Join(dir, CONFIG_DIR_NAME, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// Resolve configuration with precedence flags > environment > profile > config file > built-in defaults.
+// flags maps keys of configKeys to raw values, environment variables are looked up with getenv.
+// file may be nil, profile selects one of its profiles unless empty.
+func resolveConfig(flags map[string]string, getenv func(string) string, file *configFile, profile string) (*Config, error) {
+	if file == nil {
+		file = &configFile{}
+	}
+	profileValues, ok := file.profiles[profile]
+	if profile != "" && !ok {
+		names := file.profileNames()
+		if len(names) == 0 {
+			return nil, fmt.Errorf("Unknown profile %q, no profiles are defined", profile)
+		}
+		return nil, fmt.Errorf("Unknown profile %q, available profiles: %s", profile, strings.Join(names, ", "))
+	}
+
+	values := map[string]string{}
+	sources := map[string]string{}
+	var provider Provider
+	for _, key := range configKeys {
+		env := key.env
+		if key.name == "api_key" {
+			//Key of the chosen provider, e.g. OPENAI_API_KEY
+			env = provider.APIKeyEnv
+		}
+		if value, ok := flags[key.name]; ok {
+			values[key.name], sources[key.name] = value, "flag -"+key.flag
+		} else if value := getenvFor(getenv, env); value != "" {
+			values[key.name], sources[key.name] = value, "env "+env
+		} else if value, ok := profileValues[key.name]; ok {
+			values[key.name], sources[key.name] = value, "profile "+profile
+		} else if value, ok := file.values[key.name]; ok {
+			values[key.name], sources[key.name] = value, "config file"
+		} else if value, ok := configDefault(key.name, provider); ok {
+			values[key.name], sources[key.name] = value, "default"
+		}
+
+		//Provider comes first since defaults of other keys depend on it
+		switch key.name {
+		case "provider":
+			var err error
+			if provider, err = ParseProvider(values[key.name]); err != nil {
+				return nil, err
+			}
+		case "api_key_env":
+			if value := values[key.name]; value != "" {
+				provider.APIKeyEnv = value
+			}
+		case "capabilities":
+			if value, ok := values[key.name]; ok {
+				caps, err := ParseCapabilities(value)
+				if err != nil {
+					return nil, err
+				}
+				provider.Capabilities = &caps
+			}
+		}
+	}
+
+	//Compatible backends are defined by their base URL alone
+	if provider.Name == Compatible.Name {
+		if values["base_url"] == "" {
+			return nil, errors.New("Provider compatible needs a base URL, set base_url or -base-url")
+		}
+		provider.BaseURL = values["base_url"]
+	}
+
+	//Mock provider answers from fixtures instead of the network
+	if provider.Name == Mock.Name {
+		provider.transport = &mockTransport{dir: values["fixtures"]}
+	}
+
+	cfg, err := parseConfig(values)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Provider = provider
+	cfg.Profile = profile
+	cfg.sources = sources
+	return cfg, nil
+}
+
+// Get built-in default of key, model and base URL depending on provider
+func configDefault(name string, provider Provider) (string, bool) {
+	switch name {
+	case "model":
+		return provider.DefaultModel, true
+	case "base_url":
+		return provider.BaseURL, true
+	}
+	value, ok := configDefaults[name]
+	return value, ok
+}
+
+// Look up environment variable, none when name is empty
+func getenvFor(getenv func(string) string, name string) string {
+	if name == "" {
+		return ""
+	}
+	return getenv(name)
+}
+
+// Convert raw values into typed configuration, naming the key of an invalid value
+func parseConfig(values map[string]string) (*Config, error) {
+	cfg := &Config{
+		Model:           values["model"],
+		BaseURL:         values["base_url"],
+		CompletionsPath: values["completions_path"],
+		Fixtures:        values["fixtures"],
+		APIKey:          values["api_key"],
+		Organization:    values["organization"],
+		Proxy:           values["proxy"],
+		CACert:          values["ca_cert"],
+		ClientCert:      values["client_cert"],
+		ClientKey:       values["client_key"],
+		SystemPrompt:    values["system_prompt"],
+	}
+	if (cfg.ClientCert == "") != (cfg.ClientKey == "") {
+		return nil, errors.New("Invalid client certificate, client_cert and client_key must be set together")
+	}
+
+	var err error
+	if value, ok := values["temperature"]; ok {
+		temperature, parseErr := strconv.ParseFloat(value, 64)
+		if parseErr != nil || temperature < 0 || temperature > 2 {
+			return nil, fmt.Errorf("Invalid temperature %q, must be a number between 0 and 2", value)
+		}
+		cfg.Temperature = &temperature
+	}
+	if value, ok := values["strict_capabilities"]; ok {
+		if cfg.StrictCapabilities, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("Invalid strict_capabilities %q, must be true or false", value)
+		}
+	}
+	if cfg.MaxTokens, err = parseConfigInt(values, "max_tokens"); err != nil {
+		return nil, err
+	}
+	if cfg.MaxRetries, err = parseConfigInt(values, "max_retries"); err != nil {
+		return nil, err
+	}
+	if cfg.Timeout, err = parseConfigDuration(values, "timeout"); err != nil {
+		return nil, err
+	}
+	if cfg.AttemptTimeout, err = parseConfigDuration(values, "attempt_timeout"); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Parse non-negative integer value of key, zero when unset
+func parseConfigInt(values map[string]string, key string) (int, error) {
+	value, ok := values[key]
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid %s %q, must be a non-negative integer", key, value)
+	}
+	return n, nil
+}
+
+// Parse non-negative duration value of key such as "30s", zero when unset
+func parseConfigDuration(values map[string]string, key string) (time.Duration, error) {
+	value, ok := values[key]
+	if !ok {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("Invalid %s %q, must be a non-negative duration such as 30s", key, value)
+	}
+	return d, nil
+}
+
+// Environment variable selecting profile when -profile is not given
+const PROFILE_ENV = "GO_LLAMA_PROFILE"
+
+// Key of config file holding named profiles
+const PROFILES_KEY = "profiles"
+
+// Settings read from config file, with named profiles overriding the base settings
+type configFile struct {
+	values   map[string]string
+	profiles map[string]map[string]string
+}
+
+// Get names of profiles in alphabetical order
+func (f *configFile) profileNames() []string {
+	names := make([]string, 0, len(f.profiles))
+	for name := range f.profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Read config file as JSON or YAML depending on extension, warning about unknown keys
+func readConfigFile(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree map[string]any
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		tree, err = parseJSONConfig(data)
+	} else {
+		tree, err = parseYAMLConfig(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	file := &configFile{profiles: map[string]map[string]string{}}
+	if file.values, err = configValues(path, "", tree); err != nil {
+		return nil, err
+	}
+	if raw, ok := tree[PROFILES_KEY]; ok {
+		profiles, ok := raw.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: %s must map profile names to settings", path, PROFILES_KEY)
+		}
+		for name, raw := range profiles {
+			settings, ok := raw.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%s: profile %q must map keys to values", path, name)
+			}
+			if file.profiles[name], err = configValues(path, PROFILES_KEY+"."+name+".", settings); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return file, nil
+}
+
+// Get values of known keys in a section of config file, warning about unknown keys.
+// prefix names the section in messages, e.g. "profiles.work.".
+func configValues(path, prefix string, section map[string]any) (map[string]string, error) {
+	values := map[string]string{}
+	for name, raw := range section {
+		if prefix == "" && name == PROFILES_KEY {
+			continue
+		}
+		if !isConfigKey(name) {
+			log.Printf("WARNING: %s: unknown key %q is ignored", path, prefix+name)
+			continue
+		}
+		value, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: value of %q must be a string, number or boolean", path, prefix+name)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// Check if name is one of configKeys
+func isConfigKey(name string) bool {
+	for _, key := range configKeys {
+		if key.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse JSON object into nested maps whose leaves are strings, numbers and booleans converted to strings
+func parseJSONConfig(data []byte) (map[string]any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var object map[string]any
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+	return stringifyJSON(object)
+}
+
+// Convert leaves of decoded JSON object to strings, dropping nulls
+func stringifyJSON(object map[string]any) (map[string]any, error) {
+	tree := map[string]any{}
+	for name, value := range object {
+		switch v := value.(type) {
+		case string:
+			tree[name] = v
+		case json.Number:
+			tree[name] = v.String()
+		case bool:
+			tree[name] = strconv.FormatBool(v)
+		case map[string]any:
+			child, err := stringifyJSON(v)
+			if err != nil {
+				return nil, err
+			}
+			tree[name] = child
+		case nil:
+		default:
+			return nil, fmt.Errorf("value of %q must be a string, number, boolean or object", name)
+		}
+	}
+	return tree, nil
+}
+
+// Section of YAML being parsed along with indentation of its keys, -1 until its first key is seen
+type yamlSection struct {
+	indent int
+	values map[string]any
+}
+
+// Parse "key: value" lines, the subset of YAML used by config files, into nested maps.
+// Comments, blank lines, quoted values and sections nested by indenting with spaces are supported, lists are not.
+func parseYAMLConfig(r io.Reader) (map[string]any, error) {
+	root := map[string]any{}
+	stack := []*yamlSection{&yamlSection{indent: 0, values: root}}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: indent with spaces, not tabs", n)
+		}
+		indent := len(line) - len(trimmed)
+
+		//Indentation of a new section is set by its first key
+		top := stack[len(stack)-1]
+		if top.indent < 0 {
+			parentIndent := stack[len(stack)-2].indent
+			if indent > parentIndent {
+				top.indent = indent
+			} else {
+				stack = stack[:len(stack)-1]
+			}
+		}
+		for len(stack) > 1 && indent < stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+		section := stack[len(stack)-1]
+		if indent != section.indent {
+			return nil, fmt.Errorf("line %d: inconsistent indentation", n)
+		}
+
+		name, rawValue, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", n)
+		}
+		name = strings.TrimSpace(name)
+		if _, dup := section.values[name]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", n, name)
+		}
+
+		//Key without value starts a nested section
+		rawValue = strings.TrimSpace(rawValue)
+		if rawValue == "" || strings.HasPrefix(rawValue, "#") {
+			child := map[string]any{}
+			section.values[name] = child
+			stack = append(stack, &yamlSection{indent: -1, values: child})
+			continue
+		}
+		value, err := parseDotEnvValue(rawValue)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		section.values[name] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// Create client options applying configuration
+func (cfg *Config) options() []Option {
+	opts := []Option{
+		WithProvider(cfg.Provider),
+		WithBaseURL(cfg.BaseURL),
+		WithMaxRetries(cfg.MaxRetries),
+		WithTimeout(cfg.Timeout),
+		WithAttemptTimeout(cfg.AttemptTimeout),
+		WithMaxTokens(cfg.MaxTokens),
+	}
+	if cfg.Model != "" {
+		opts = append(opts, WithDefaultModel(cfg.Model))
+	}
+	if cfg.CompletionsPath != "" {
+		opts = append(opts, WithCompletionsPath(cfg.CompletionsPath))
+	}
+	if cfg.Organization != "" {
+		opts = append(opts, WithOrganization(cfg.Organization))
+	}
+	if cfg.Proxy != "" {
+		opts = append(opts, WithProxy(cfg.Proxy))
+	}
+	if cfg.CACert != "" {
+		opts = append(opts, WithCACertFile(cfg.CACert))
+	}
+	if cfg.ClientCert != "" {
+		opts = append(opts, WithClientCert(cfg.ClientCert, cfg.ClientKey))
+	}
+	if cfg.StrictCapabilities {
+		opts = append(opts, WithStrictCapabilities(true))
+	}
+	if cfg.SystemPrompt != "" {
+		opts = append(opts, WithDefaultSystemPrompt(cfg.SystemPrompt))
+	}
+	if cfg.Temperature != nil {
+		opts = append(opts, WithTemperature(*cfg.Temperature))
+	}
+	return opts
+}
+
+// Get value of key for display, with secrets masked
+func (cfg *Config) display(key configKey) string {
+	var value string
+	switch key.name {
+	case "provider":
+		value = cfg.Provider.Name
+	case "api_key_env":
+		value = cfg.Provider.APIKeyEnv
+	case "capabilities":
+		value = "all"
+		if cfg.Provider.Capabilities != nil {
+			value = cfg.Provider.Capabilities.String()
+		}
+	case "strict_capabilities":
+		value = strconv.FormatBool(cfg.StrictCapabilities)
+	case "model":
+		value = cfg.Model
+	case "base_url":
+		value = redactURLString(cfg.BaseURL)
+	case "completions_path":
+		value = cfg.CompletionsPath
+		if value == "" {
+			value = CHAT_COMPLETIONS_PATH
+		}
+	case "fixtures":
+		value = cfg.Fixtures
+	case "api_key":
+		if cfg.APIKey != "" {
+			value = redactKey(cfg.APIKey)
+		}
+	case "organization":
+		value = cfg.Organization
+	case "proxy":
+		value = redactURLString(cfg.Proxy)
+	case "ca_cert":
+		value = cfg.CACert
+	case "client_cert":
+		value = cfg.ClientCert
+	case "client_key":
+		value = cfg.ClientKey
+	case "system_prompt":
+		value = strconv.Quote(cfg.SystemPrompt)
+	case "temperature":
+		if cfg.Temperature != nil {
+			value = strconv.FormatFloat(*cfg.Temperature, 'g', -1, 64)
+		}
+	case "max_tokens":
+		value = strconv.Itoa(cfg.MaxTokens)
+	case "max_retries":
+		value = strconv.Itoa(cfg.MaxRetries)
+	case "timeout":
+		value = cfg.Timeout.String()
+	case "attempt_timeout":
+		value = cfg.AttemptTimeout.String()
+	}
+	return value
+}
+
+// Redact credentials in URL, keeping unparsable values as is
+func redactURLString(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	return redactURL(u)
+}
+
+// Run config subcommand, "config show" prints the effective configuration with secrets masked
+func runConfig(args []string, stdout io.Writer) error {
+	if len(args) == 0 || args[0] != "show" {
+		return errors.New("Usage: go-llama config show [flags]")
+	}
+
+	flagSet := flag.NewFlagSet("config show", flag.ContinueOnError)
+	registerEnvFileFlag(flagSet)
+	selection, configFlags := registerConfigFlags(flagSet)
+	if err := flagSet.Parse(args[1:]); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(selection, configFlags())
+	if err != nil {
+		return err
+	}
+
+	if cfg.Path != "" {
+		fmt.Fprintf(stdout, "# config file: %s\n", cfg.Path)
+	} else {
+		fmt.Fprintln(stdout, "# config file: none")
+	}
+	if cfg.Profile != "" {
+		fmt.Fprintf(stdout, "# profile: %s\n", cfg.Profile)
+	}
+	for _, key := range configKeys {
+		source, ok := cfg.sources[key.name]
+		if !ok {
+			source = "unset"
+		}
+		fmt.Fprintf(stdout, "%-21s %-40s # %s\n", key.name+":", cfg.display(key), source)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Part of multimodal message content
+type contentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *imageURL `json:"image_url,omitempty"`
+}
+
+type imageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Build user message asking a text question about an image
+func ImageMessage(text, url string) reqMessage {
+	return reqMessage{
+		Role: "user",
+		Parts: []contentPart{
+			contentPart{Type: "text", Text: text},
+			contentPart{Type: "image_url", ImageURL: &imageURL{URL: url}},
+		},
+	}
+}
+
+// Convert reply of the model into a message for the history of the next request,
+// keeping its function call so that the follow-up knows what was called
+func AssistantMessage(message resMessage) reqMessage {
+	req := reqMessage{Role: message.Role, Content: message.Content}
+	if req.Role == "" {
+		req.Role = "assistant"
+	}
+	if message.FunctionCall.Name != "" {
+		fc := message.FunctionCall
+		req.FunctionCall = &fc
+	}
+	return req
+}
+
+// Build message returning result of a function call to the model
+func FunctionResultMessage(name, content string) reqMessage {
+	return reqMessage{Role: "function", Name: name, Content: content}
+}
+
+// Marshal content as an array of parts when the message has parts, otherwise as a string
+func (m reqMessage) MarshalJSON() ([]byte, error) {
+	type plainMessage reqMessage
+	if len(m.Parts) == 0 && m.FunctionCall != nil && m.Content == "" {
+		//Assistant message calling a function has null content
+		return json.Marshal(struct {
+			Role         string        `json:"role"`
+			Content      *string       `json:"content"`
+			FunctionCall *functionCall `json:"function_call"`
+		}{Role: m.Role, FunctionCall: m.FunctionCall})
+	}
+	if len(m.Parts) == 0 {
+		return json.Marshal(plainMessage(m))
+	}
+
+	return json.Marshal(struct {
+		Role    string        `json:"role"`
+		Content []contentPart `json:"content"`
+	}{Role: m.Role, Content: m.Parts})
+}
+
+// Unmarshal content given either as a string or as an array of parts
+func (m *reqMessage) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Role         string          `json:"role"`
+		Content      json.RawMessage `json:"content"`
+		Name         string          `json:"name"`
+		FunctionCall *functionCall   `json:"function_call"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*m = reqMessage{Role: raw.Role, Name: raw.Name, FunctionCall: raw.FunctionCall}
+	if len(raw.Content) == 0 || string(raw.Content) == "null" {
+		return nil
+	}
+	switch raw.Content[0] {
+	case '"':
+		return json.Unmarshal(raw.Content, &m.Content)
+	case '[':
+		return json.Unmarshal(raw.Content, &m.Parts)
+	default:
+		return errors.New("Message content must be a string or an array of parts")
+	}
+}
+
+// Get text of message, joining text parts of multimodal content
+func (m reqMessage) text() string {
+	if len(m.Parts) == 0 {
+		return m.Content
+	}
+	text := ""
+	for _, part := range m.Parts {
+		if part.Type == "text" {
+			text += part.Text
+		}
+	}
+	return text
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Outcome of a health check
+type HealthStatus string
+
+const (
+	// API answered and accepted the API key
+	HealthReachable HealthStatus = "reachable"
+	// API key is missing or was rejected
+	HealthUnauthorized HealthStatus = "unauthorized"
+	// API key was accepted but requests are throttled
+	HealthRateLimited HealthStatus = "rate-limited"
+	// No response from the API, e.g. DNS, TLS, proxy or connection failures
+	HealthUnreachable HealthStatus = "unreachable"
+	// API answered with another error, e.g. 404 of a wrong base URL or 5xx
+	HealthError HealthStatus = "error"
+)
+
+// Result of a health check
+type Health struct {
+	Status HealthStatus
+	// Time until the API answered, zero when it did not
+	Latency time.Duration
+	// Error of the check, nil when reachable
+	Err error
+}
+
+// Check connectivity and API key with a minimal authenticated request and classify the outcome.
+// The models endpoint is used so no chat tokens are spent, providers without one get a 1 token completion.
+func (c *Client) CheckHealth(ctx context.Context) Health {
+	start := time.Now()
+	var err error
+	if c.provider.adapter != nil && c.provider.adapter.modelsPath() == "" {
+		err = c.pingCompletion(ctx)
+	} else {
+		_, _, err = c.getModels(ctx)
+	}
+
+	health := Health{Status: classifyHealth(err), Err: err}
+	if health.Status != HealthUnreachable && !errors.Is(err, ErrMissingAPIKey) {
+		health.Latency = time.Since(start)
+	}
+	return health
+}
+
+// Send completion of a single token, bypassing the cache so the API is really asked
+func (c *Client) pingCompletion(ctx context.Context) error {
+	call, err := c.newCallOptions([]CallOption{WithoutCache(), WithSystemPrompt("")})
+	if err != nil {
+		return err
+	}
+	chatReq := c.newChatRequest([]reqMessage{reqMessage{Role: "user", Content: "ping"}}, call)
+	chatReq.MaxTokens = 1
+	_, err = c.createChatCompletion(ctx, chatReq, call)
+	return err
+}
+
+// Classify error of a health check
+func classifyHealth(err error) HealthStatus {
+	if err == nil {
+		return HealthReachable
+	}
+	if errors.Is(err, ErrMissingAPIKey) || errors.Is(err, ErrUnauthorized) {
+		return HealthUnauthorized
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusForbidden:
+			return HealthUnauthorized
+		case http.StatusTooManyRequests:
+			return HealthRateLimited
+		}
+		return HealthError
+	}
+
+	var urlErr *url.Error
+	var netErr net.Error
+	if errors.Is(err, ErrCircuitOpen) || errors.As(err, &urlErr) || errors.As(err, &netErr) || IsTransientError(err) {
+		return HealthUnreachable
+	}
+	return HealthError
+}
+
+// Run "doctor" subcommand printing the resolved settings and a diagnosis of connectivity
+func runDoctor(args []string, stdout io.Writer) error {
+	flagSet := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	registerEnvFileFlag(flagSet)
+	selection, configFlags := registerConfigFlags(flagSet)
+	apiKeyFile := flagSet.String("api-key-file", "", "File containing the API key, takes precedence over LLAMA_API_KEY")
+	timeout := flagSet.Duration("ping-timeout", 10*time.Second, "Time to wait for the API to answer")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(selection, configFlags())
+	if err != nil {
+		return err
+	}
+	opts := cfg.options()
+	apiKey, source, err := commandAPIKey(cfg, *apiKeyFile)
+	if err != nil {
+		return err
+	}
+	if apiKey != "" {
+		opts = append(opts, WithAPIKey(apiKey))
+	}
+	client, err := NewClient(opts...)
+	if err != nil {
+		return err
+	}
+
+	baseURL := client.endpoints.primary()
+	fmt.Fprintf(stdout, "%-10s %s\n", "provider:", client.provider.Name)
+	fmt.Fprintf(stdout, "%-10s %s\n", "base URL:", redactURLString(baseURL))
+	fmt.Fprintf(stdout, "%-10s %s\n", "proxy:", client.proxyFor(baseURL))
+	switch {
+	case apiKey != "":
+		fmt.Fprintf(stdout, "%-10s %s (from %s)\n", "API key:", redactKey(apiKey), source)
+	case client.provider.APIKeyOptional:
+		fmt.Fprintf(stdout, "%-10s none, not required by %s\n", "API key:", client.provider.Name)
+	default:
+		fmt.Fprintf(stdout, "%-10s none\n", "API key:")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	health := client.CheckHealth(ctx)
+	status := string(health.Status)
+	if health.Latency > 0 {
+		status += fmt.Sprintf(" (%s)", health.Latency.Round(time.Millisecond))
+	}
+	fmt.Fprintf(stdout, "%-10s %s\n", "status:", status)
+	fmt.Fprintf(stdout, "%-10s %s\n", "diagnosis:", diagnose(health, client.provider))
+
+	if health.Status != HealthReachable {
+		return fmt.Errorf("Health check failed: %w", health.Err)
+	}
+	return nil
+}
+
+// Get proxy which requests to target go through, "none" when they connect directly
+func (c *Client) proxyFor(target string) string {
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil || c.httpClient.Transport != c.transport || c.transport.Proxy == nil {
+		return "none"
+	}
+	proxyURL, err := c.transport.Proxy(req)
+	if err != nil {
+		return "invalid: " + err.Error()
+	}
+	if proxyURL == nil {
+		return "none"
+	}
+	return redactURL(proxyURL)
+}
+
+// Explain health in terms of what to check next
+func diagnose(health Health, provider Provider) string {
+	switch health.Status {
+	case HealthReachable:
+		return "API is reachable and accepted the API key"
+	case HealthUnauthorized:
+		if errors.Is(health.Err, ErrMissingAPIKey) {
+			return fmt.Sprintf("No API key is configured, set %s or run \"go-llama auth login\"", provider.APIKeyEnv)
+		}
+		return fmt.Sprintf("API key was rejected, check it is a valid key of %s: %v", provider.Name, health.Err)
+	case HealthRateLimited:
+		return "API key works but requests are rate limited, retry later or lower -rpm"
+	case HealthUnreachable:
+		return fmt.Sprintf("Could not reach the API, check the base URL, DNS, proxy and TLS settings: %v", health.Err)
+	default:
+		return fmt.Sprintf("API answered with an error, check the base URL and completions path: %v", health.Err)
+	}
+}
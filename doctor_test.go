@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDoctor(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("sk-from-file-1234\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		args    []string
+		env     map[string]string
+		// Whether base URL is left to the args rather than pointing at the fake server
+		ownBaseURL bool
+		wantStatus HealthStatus
+		want       []string
+		// Requests reaching the fake server
+		wantRequests int
+	}{
+		{
+			name:         "reachable",
+			handler:      respondJSON(http.StatusOK, modelsJSON),
+			env:          map[string]string{"LLAMA_API_KEY": "sk-live-doctor-5678"},
+			wantStatus:   HealthReachable,
+			want:         []string{"provider:  llama", "proxy:     none", "API key:   " + redactKey("sk-live-doctor-5678") + " (from LLAMA_API_KEY)", "accepted the API key"},
+			wantRequests: 1,
+		},
+		{
+			name:         "key from file",
+			handler:      respondJSON(http.StatusOK, modelsJSON),
+			args:         []string{"-api-key-file", keyFile},
+			wantStatus:   HealthReachable,
+			want:         []string{"API key:   " + redactKey("sk-from-file-1234") + " (from -api-key-file flag)"},
+			wantRequests: 1,
+		},
+		{
+			name:       "missing key",
+			handler:    respondJSON(http.StatusOK, modelsJSON),
+			args:       []string{"-provider", "openai"},
+			wantStatus: HealthUnauthorized,
+			want:       []string{"provider:  openai", "API key:   none\n", "No API key is configured, set OPENAI_API_KEY"},
+		},
+		{
+			name:         "key not required",
+			handler:      respondJSON(http.StatusOK, `{"data":[{"id":"llama3"}]}`),
+			args:         []string{"-provider", "ollama"},
+			env:          map[string]string{"OLLAMA_API_KEY": ""},
+			wantStatus:   HealthReachable,
+			want:         []string{"API key:   none, not required by ollama"},
+			wantRequests: 1,
+		},
+		{
+			name:         "rejected key",
+			handler:      respondJSON(http.StatusUnauthorized, `{"error":{"message":"Invalid API key"}}`),
+			env:          map[string]string{"LLAMA_API_KEY": "sk-revoked-0000"},
+			wantStatus:   HealthUnauthorized,
+			want:         []string{"API key was rejected, check it is a valid key of llama"},
+			wantRequests: 1,
+		},
+		{
+			name:         "forbidden key",
+			handler:      respondJSON(http.StatusForbidden, `{"error":{"message":"Project has no access to models"}}`),
+			env:          map[string]string{"LLAMA_API_KEY": "sk-project-0000"},
+			wantStatus:   HealthUnauthorized,
+			want:         []string{"API key was rejected"},
+			wantRequests: 1,
+		},
+		{
+			name:         "rate limited",
+			handler:      respondJSON(http.StatusTooManyRequests, `{"error":{"message":"Rate limit reached"}}`),
+			args:         []string{"-max-retries", "0"},
+			env:          map[string]string{"LLAMA_API_KEY": "sk-live-doctor-5678"},
+			wantStatus:   HealthRateLimited,
+			want:         []string{"requests are rate limited"},
+			wantRequests: 1,
+		},
+		{
+			name:         "wrong base URL",
+			handler:      http.NotFound,
+			env:          map[string]string{"LLAMA_API_KEY": "sk-live-doctor-5678"},
+			wantStatus:   HealthError,
+			want:         []string{"API answered with an error, check the base URL"},
+			wantRequests: 1,
+		},
+		{
+			name:         "server error",
+			handler:      respondJSON(http.StatusInternalServerError, `{"error":{"message":"Internal error"}}`),
+			args:         []string{"-max-retries", "0"},
+			env:          map[string]string{"LLAMA_API_KEY": "sk-live-doctor-5678"},
+			wantStatus:   HealthError,
+			want:         []string{"API answered with an error"},
+			wantRequests: 1,
+		},
+		{
+			name:       "unreachable",
+			handler:    respondJSON(http.StatusOK, modelsJSON),
+			args:       []string{"-base-url", "REFUSED", "-max-retries", "0"},
+			env:        map[string]string{"LLAMA_API_KEY": "sk-live-doctor-5678"},
+			ownBaseURL: true,
+			wantStatus: HealthUnreachable,
+			want:       []string{"Could not reach the API, check the base URL, DNS, proxy and TLS settings"},
+		},
+		{
+			name:         "through proxy",
+			handler:      respondJSON(http.StatusOK, modelsJSON),
+			args:         []string{"-base-url", "http://llama.invalid/v1", "-proxy", "PROXY"},
+			env:          map[string]string{"LLAMA_API_KEY": "sk-live-doctor-5678"},
+			ownBaseURL:   true,
+			wantStatus:   HealthReachable,
+			want:         []string{"base URL:  http://llama.invalid/v1", "proxy:     http://127.0.0.1:"},
+			wantRequests: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, _ := newFakeServer(t, tc.handler)
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			for name, value := range tc.env {
+				t.Setenv(name, value)
+			}
+			captureLogs(t)
+
+			args := []string{"-ping-timeout", "5s"}
+			if !tc.ownBaseURL {
+				args = append(args, "-base-url", f.URL)
+			}
+			for _, arg := range tc.args {
+				switch arg {
+				case "REFUSED":
+					arg = refusedURL(t)
+				case "PROXY":
+					arg = f.URL
+				}
+				args = append(args, arg)
+			}
+
+			var stdout bytes.Buffer
+			err := runDoctor(args, &stdout)
+			output := stdout.String()
+			if (err == nil) != (tc.wantStatus == HealthReachable) {
+				t.Errorf("got error %v for status %s", err, tc.wantStatus)
+			}
+			//Latency is shown whenever the API answered
+			want := "status:    " + string(tc.wantStatus) + "\n"
+			if tc.wantRequests > 0 {
+				want = "status:    " + string(tc.wantStatus) + " ("
+			}
+			if !strings.Contains(output, want) {
+				t.Errorf("output lacks %q:\n%s", want, output)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(output, want) {
+					t.Errorf("output lacks %q:\n%s", want, output)
+				}
+			}
+			if n := len(f.captured()); n != tc.wantRequests {
+				t.Errorf("server got %d requests, want %d", n, tc.wantRequests)
+			}
+		})
+	}
+}
+
+func TestClassifyHealth(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want HealthStatus
+	}{
+		{name: "none", err: nil, want: HealthReachable},
+		{name: "missing key", err: ErrMissingAPIKey, want: HealthUnauthorized},
+		{name: "unauthorized", err: &APIError{StatusCode: http.StatusUnauthorized}, want: HealthUnauthorized},
+		{name: "forbidden", err: &APIError{StatusCode: http.StatusForbidden}, want: HealthUnauthorized},
+		{name: "rate limited", err: &APIError{StatusCode: http.StatusTooManyRequests}, want: HealthRateLimited},
+		{name: "not found", err: &APIError{StatusCode: http.StatusNotFound}, want: HealthError},
+		{name: "circuit open", err: ErrCircuitOpen, want: HealthUnreachable},
+		{name: "other", err: errors.New("Unexpected content type"), want: HealthError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := classifyHealth(tc.err); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
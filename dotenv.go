@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Environment variables which may be set from .env file
+var dotEnvKeys = []string{"LLAMA_API_KEY", "LLAMA_API_KEYS", "LLAMA_API_KEY_FILE", "LLAMA_ORG", "LLAMA_MODEL", "LLAMA_API_URL"}
+
+// Valid names of environment variables
+var dotEnvKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Variable defined in .env file
+type dotEnvEntry struct {
+	key   string
+	value string
+}
+
+// Load .env file into environment variables listed in keys, all of them when keys is nil.
+// Variables already set in the environment take precedence, a missing file is not an error.
+// Malformed lines are skipped with a warning.
+func loadDotEnv(path string, keys []string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Failed to open %s: %v", path, err)
+		return err
+	}
+	defer f.Close()
+
+	entries, warnings, err := parseDotEnv(f)
+	if err != nil {
+		log.Printf("Failed to read %s: %v", path, err)
+		return err
+	}
+	for _, warning := range warnings {
+		log.Printf("WARNING: %s:%s", path, warning)
+	}
+
+	//Look up variables before setting any, so that a later line for the same key replaces an earlier one
+	preset := map[string]bool{}
+	for _, entry := range entries {
+		_, preset[entry.key] = os.LookupEnv(entry.key)
+	}
+	for _, entry := range entries {
+		if keys != nil && !slices.Contains(keys, entry.key) {
+			continue
+		}
+		if preset[entry.key] {
+			continue
+		}
+		if err := os.Setenv(entry.key, entry.value); err != nil {
+			log.Printf("Failed to set %s: %v", entry.key, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Parse KEY=VALUE lines of .env file, supporting comments, export prefixes,
+// single quoted literal values and double quoted values with escapes.
+// Warnings are returned for malformed lines, prefixed with their line numbers.
+func parseDotEnv(r io.Reader) ([]dotEnvEntry, []string, error) {
+	var entries []dotEnvEntry
+	var warnings []string
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if n == 1 {
+			line = strings.TrimPrefix(line, "\uFEFF")
+		}
+
+		//Skip blank lines and comments
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		key, rawValue, ok := strings.Cut(line, "=")
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("%d: missing '=', line is skipped", n))
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if !dotEnvKeyPattern.MatchString(key) {
+			warnings = append(warnings, fmt.Sprintf("%d: invalid variable name %q, line is skipped", n, key))
+			continue
+		}
+		value, err := parseDotEnvValue(strings.TrimSpace(rawValue))
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%d: %v, line is skipped", n, err))
+			continue
+		}
+		entries = append(entries, dotEnvEntry{key: key, value: value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return entries, warnings, nil
+}
+
+// Parse value part of a .env line
+func parseDotEnvValue(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, "'"):
+		//Single quoted values are taken literally
+		end := strings.IndexByte(raw[1:], '\'')
+		if end < 0 {
+			return "", errors.New("unterminated single quoted value")
+		}
+		return raw[1 : end+1], checkDotEnvTrailer(raw[end+2:])
+
+	case strings.HasPrefix(raw, "\""):
+		var sb strings.Builder
+		for i := 1; i < len(raw); i++ {
+			switch ch := raw[i]; {
+			case ch == '"':
+				return sb.String(), checkDotEnvTrailer(raw[i+1:])
+			case ch == '\\' && i+1 < len(raw):
+				i++
+				switch raw[i] {
+				case 'n':
+					sb.WriteByte('\n')
+				case 'r':
+					sb.WriteByte('\r')
+				case 't':
+					sb.WriteByte('\t')
+				default:
+					sb.WriteByte(raw[i])
+				}
+			default:
+				sb.WriteByte(ch)
+			}
+		}
+		return "", errors.New("unterminated double quoted value")
+
+	default:
+		//Unquoted values end at an inline comment
+		if i := strings.Index(raw, " #"); i >= 0 {
+			raw = raw[:i]
+		}
+		return strings.TrimSpace(raw), nil
+	}
+}
+
+// Check only an optional comment follows a closing quote
+func checkDotEnvTrailer(rest string) error {
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return fmt.Errorf("unexpected %q after closing quote", rest)
+	}
+	return nil
+}
+
+// Find value of -env-file flag before flags are parsed, since .env is loaded before subcommands run
+func envFileFromArgs(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "env-file" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ".env"
+}
+
+// Skip -env-file flags in front of args, so that a subcommand may follow them
+func skipEnvFileFlags(args []string) []string {
+	for len(args) > 0 {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(args[0], "-"), "=")
+		if !strings.HasPrefix(args[0], "-") || name != "env-file" {
+			break
+		}
+		if hasValue || len(args) == 1 {
+			args = args[1:]
+		} else {
+			args = args[2:]
+		}
+	}
+	return args
+}
+
+// Register -env-file flag, which main reads before parsing, so that every command accepts it
+func registerEnvFileFlag(flagSet *flag.FlagSet) {
+	flagSet.String("env-file", ".env", "File to load LLAMA_* environment variables from, already set variables win")
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseDotEnv(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "dotenv", "nasty.env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	entries, warnings, err := parseDotEnv(f)
+	if err != nil {
+		t.Fatalf("parseDotEnv: %v", err)
+	}
+
+	wantEntries := []dotEnvEntry{
+		{key: "LLAMA_API_KEY", value: "sk-plain"},
+		{key: "LLAMA_MODEL", value: "llama3-70b"},
+		{key: "LLAMA_ORG", value: `org-#1 literal \n $HOME`},
+		{key: "LLAMA_API_URL", value: "http://llama.local/v1?a=1#frag"},
+		{key: "ESCAPED", value: "line1\nline2\t\"quoted\" \\ back"},
+		{key: "EMPTY", value: ""},
+		{key: "EMPTY_QUOTED", value: ""},
+		{key: "HASH_IN_VALUE", value: "abc#def"},
+		{key: "INDENTED", value: "spaced value"},
+		{key: "EQUALS", value: "a=b=c"},
+		{key: "WINDOWS", value: "crlf"},
+		{key: "EXPORT_SPACES", value: "yes"},
+		{key: "DUP", value: "first"},
+		{key: "DUP", value: "second"},
+		{key: "exportNOSPACE", value: "x"},
+		{key: "UNICODE", value: "こんにちは 🦙"},
+	}
+	if !slices.Equal(entries, wantEntries) {
+		t.Errorf("got entries\n%q\nwant\n%q", entries, wantEntries)
+	}
+
+	wantWarnings := []string{
+		"15: missing '='",
+		`16: invalid variable name "1BAD"`,
+		`17: invalid variable name "BAD-NAME"`,
+		"18: unterminated double quoted value",
+		"19: unterminated single quoted value",
+		`20: unexpected "junk" after closing quote`,
+		`21: invalid variable name ""`,
+	}
+	if len(warnings) != len(wantWarnings) {
+		t.Fatalf("got warnings %q, want %d", warnings, len(wantWarnings))
+	}
+	for i, want := range wantWarnings {
+		if !strings.HasPrefix(warnings[i], want) || !strings.HasSuffix(warnings[i], "line is skipped") {
+			t.Errorf("got warning %q, want %q", warnings[i], want)
+		}
+	}
+}
+
+func TestParseDotEnvValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", raw: "value", want: "value"},
+		{name: "inline comment", raw: "value # comment", want: "value"},
+		{name: "hash without space", raw: "a#b", want: "a#b"},
+		{name: "single quoted", raw: `'a "b" \n'`, want: `a "b" \n`},
+		{name: "double quoted escapes", raw: `"a\"b\\c\rd"`, want: "a\"b\\c\rd"},
+		{name: "unknown escape", raw: `"\q"`, want: "q"},
+		{name: "quoted with comment", raw: `"a # b" # c`, want: "a # b"},
+		{name: "trailing backslash", raw: `"abc\`, wantErr: true},
+		{name: "text after quote", raw: `'a'b`, wantErr: true},
+		{name: "lone quote", raw: `"`, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseDotEnvValue(tc.raw)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("got %q, want error", got)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Errorf("got %q, %v, want %q", got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadDotEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	data := "LLAMA_API_KEY=from-file\nLLAMA_MODEL=llama3-8b\nLLAMA_MODEL=llama3-70b\nLLAMA_ORG=org-file\nOTHER=ignored\nbroken line\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	clearClientEnv(t)
+	t.Setenv("OTHER", "")
+	os.Unsetenv("LLAMA_API_KEY")
+	os.Unsetenv("LLAMA_MODEL")
+	os.Unsetenv("OTHER")
+	//Set in the environment, even if empty, so the file must not override it
+	t.Setenv("LLAMA_ORG", "")
+	logs := captureLogs(t)
+
+	if err := loadDotEnv(path, dotEnvKeys); err != nil {
+		t.Fatalf("loadDotEnv: %v", err)
+	}
+	tests := []struct {
+		name  string
+		want  string
+		isSet bool
+	}{
+		{name: "LLAMA_API_KEY", want: "from-file", isSet: true},
+		{name: "LLAMA_MODEL", want: "llama3-70b", isSet: true},
+		{name: "LLAMA_ORG", want: "", isSet: true},
+		{name: "OTHER", isSet: false},
+	}
+	for _, tc := range tests {
+		value, isSet := os.LookupEnv(tc.name)
+		if value != tc.want || isSet != tc.isSet {
+			t.Errorf("got %s=%q set %v, want %q set %v", tc.name, value, isSet, tc.want, tc.isSet)
+		}
+	}
+	if want := "WARNING: " + path + ":6: missing '='"; !strings.Contains(logs.String(), want) {
+		t.Errorf("log lacks %q: %s", want, logs)
+	}
+
+	if err := loadDotEnv(filepath.Join(t.TempDir(), "missing.env"), dotEnvKeys); err != nil {
+		t.Errorf("got error %v for missing file, want none", err)
+	}
+}
+
+func TestEnvFileFromArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "default", args: []string{"-model", "llama3"}, want: ".env"},
+		{name: "separate value", args: []string{"-env-file", "team.env"}, want: "team.env"},
+		{name: "equals", args: []string{"--env-file=team.env"}, want: "team.env"},
+		{name: "after subcommand", args: []string{"models", "-env-file", "team.env"}, want: "team.env"},
+		{name: "after terminator", args: []string{"--", "-env-file", "team.env"}, want: ".env"},
+		{name: "missing value", args: []string{"-env-file"}, want: ".env"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := envFileFromArgs(tc.args); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSkipEnvFileFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "none", args: []string{"models", "-provider", "ollama"}, want: []string{"models", "-provider", "ollama"}},
+		{name: "separate value", args: []string{"-env-file", "team.env", "models"}, want: []string{"models"}},
+		{name: "equals", args: []string{"--env-file=team.env", "doctor"}, want: []string{"doctor"}},
+		{name: "repeated", args: []string{"-env-file", "a.env", "-env-file=b.env", "config", "show"}, want: []string{"config", "show"}},
+		{name: "other flag first", args: []string{"-v", "-env-file", "team.env"}, want: []string{"-v", "-env-file", "team.env"}},
+		{name: "missing value", args: []string{"-env-file"}, want: []string{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := skipEnvFileFlags(tc.args); !slices.Equal(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSubcommandsAcceptEnvFile(t *testing.T) {
+	clearClientEnv(t)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("LLAMA_API_KEY", testAPIKey)
+	captureLogs(t)
+
+	var stdout bytes.Buffer
+	if err := runConfig([]string{"show", "-env-file", "team.env"}, &stdout); err != nil {
+		t.Errorf("config show: %v", err)
+	}
+	if err := runAuth([]string{"status", "-env-file", "team.env"}, nil, &stdout); err != nil {
+		t.Errorf("auth status: %v", err)
+	}
+	if err := runAuth([]string{"status", "extra"}, nil, &stdout); err == nil {
+		t.Error("auth status succeeded with an extra argument")
+	}
+	//Unreachable API fails the commands, but only after their flags parsed
+	t.Setenv("LLAMA_API_URL", refusedURL(t))
+	if err := runModels([]string{"-env-file", "team.env"}, &stdout); err == nil || strings.Contains(err.Error(), "flag provided but not defined") {
+		t.Errorf("models: %v", err)
+	}
+	if err := runDoctor([]string{"-env-file", "team.env", "-ping-timeout", "5s"}, &stdout); err == nil || strings.Contains(err.Error(), "flag provided but not defined") {
+		t.Errorf("doctor: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Default time a failed endpoint is skipped before it is tried again
+const DEFAULT_ENDPOINT_COOLDOWN = 30 * time.Second
+
+// Ordered base URLs of the same API with health tracking for failover
+type endpointPool struct {
+	mu             sync.Mutex
+	urls           []string
+	cooldown       time.Duration
+	unhealthyUntil map[string]time.Time
+	clock          clock
+}
+
+func newEndpointPool(urls ...string) *endpointPool {
+	return &endpointPool{
+		urls:           urls,
+		cooldown:       DEFAULT_ENDPOINT_COOLDOWN,
+		unhealthyUntil: map[string]time.Time{},
+		clock:          realClock{},
+	}
+}
+
+// Get base URLs to try in order, skipping those in cool-down unless all of them are
+func (p *endpointPool) candidates() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.clock.Now()
+	healthy := make([]string, 0, len(p.urls))
+	for _, url := range p.urls {
+		if now.After(p.unhealthyUntil[url]) {
+			healthy = append(healthy, url)
+		}
+	}
+	if len(healthy) == 0 {
+		return append([]string{}, p.urls...)
+	}
+	return healthy
+}
+
+// Get base URL to use for single requests which do not fail over
+func (p *endpointPool) primary() string {
+	return p.candidates()[0]
+}
+
+// Skip endpoint until cool-down has passed
+func (p *endpointPool) markUnhealthy(url string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.unhealthyUntil[url] = p.clock.Now().Add(p.cooldown)
+}
+
+// Check if the endpoint looks dead so that the next one should be tried
+func isFailoverError(res *http.Response, err error) bool {
+	if err == nil {
+		return false
+	}
+	if res != nil {
+		return res.StatusCode == http.StatusBadGateway || res.StatusCode == http.StatusServiceUnavailable
+	}
+
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	if errors.As(err, &dnsErr) || (errors.As(err, &opErr) && opErr.Op == "dial") ||
+		errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	return IsTransientError(err)
+}
+
+// Get base URL which served the response
+func (c *Client) endpointOf(res *http.Response) string {
+	if res == nil || res.Request == nil {
+		return ""
+	}
+	requestURL := res.Request.URL.String()
+	for _, baseURL := range c.endpoints.urls {
+		if strings.HasPrefix(requestURL, baseURL) {
+			return baseURL
+		}
+	}
+	return strings.TrimSuffix(requestURL, c.chatCompletionsPath())
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/takumi616/go-llama/chaos"
+)
+
+// Listen on a local port, closing every connection at once and counting them
+func deadListener(t *testing.T) (net.Listener, *atomic.Int64) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var accepted atomic.Int64
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			conn.Close()
+		}
+	}()
+	return ln, &accepted
+}
+
+// Get URL of a local port nobody listens on, so that connections are refused
+func refusedURL(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + ln.Addr().String()
+	ln.Close()
+	return url
+}
+
+// Start a server answering 503 to every request, returning its URL
+func newFakeServer503(t *testing.T) string {
+	f, _ := newFakeServer(t, respondJSON(http.StatusServiceUnavailable, `{"error":{"message":"Unavailable"}}`))
+	return f.URL
+}
+
+// Host of a primary endpoint whose requests chaos rules answer, it never resolves
+const chaosPrimaryHost = "primary.invalid"
+
+func TestFailoverToLiveEndpoint(t *testing.T) {
+	dead, _ := deadListener(t)
+	tests := []struct {
+		name    string
+		deadURL string
+		// Fault injected into requests to chaosPrimaryHost, which replaces deadURL
+		fault chaos.Fault
+	}{
+		{name: "connection refused", deadURL: refusedURL(t)},
+		{name: "connection closed", deadURL: "http://" + dead.Addr().String()},
+		{name: "connection reset", fault: chaos.ConnectionReset()},
+		{name: "503", fault: chaos.Status(http.StatusServiceUnavailable)},
+		{name: "502", fault: chaos.Status(http.StatusBadGateway)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, _ := newFakeServer(t, respondJSON(http.StatusOK, chatCompletionJSON("Hello there")))
+			logs := captureLogs(t)
+			opts := []Option{WithAPIKey(testAPIKey), WithRetryPolicy(NoRetry), WithDebug(true)}
+			var rule *chaos.Rule
+			if tc.fault.Do != nil {
+				tc.deadURL = "http://" + chaosPrimaryHost
+				rule = chaos.When(chaos.ToHost(chaosPrimaryHost), tc.fault)
+				inject, _ := withChaos(rule)
+				opts = append(opts, inject)
+			}
+			client := f.newClient(t, append(opts, WithBaseURLs(tc.deadURL, f.URL))...)
+
+			result, err := client.Complete(context.Background(), "Say hello")
+			if err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			if result.Endpoint != f.URL {
+				t.Errorf("served by %q, want %q", result.Endpoint, f.URL)
+			}
+			for _, want := range []string{"Endpoint " + tc.deadURL + " failed, failing over to " + f.URL, "Response served by " + f.URL} {
+				if !strings.Contains(logs.String(), want) {
+					t.Errorf("log lacks %q: %s", want, logs)
+				}
+			}
+			if rule != nil && rule.Injected() != 1 {
+				t.Errorf("injected %d faults into the primary, want 1", rule.Injected())
+			}
+		})
+	}
+}
+
+func TestFailedEndpointCooldown(t *testing.T) {
+	ctx := context.Background()
+	reset := chaos.When(chaos.ToHost(chaosPrimaryHost), chaos.ConnectionReset())
+	inject, _ := withChaos(reset)
+	f, _ := newFakeServer(t, respondJSON(http.StatusOK, chatCompletionJSON("Hello there")))
+	captureLogs(t)
+	clk := newFakeClock()
+	client := f.newClient(t, withClock(clk), WithAPIKey(testAPIKey), WithRetryPolicy(NoRetry), inject,
+		WithBaseURLs("http://"+chaosPrimaryHost, f.URL), WithEndpointCooldown(30*time.Second))
+
+	complete := func() {
+		t.Helper()
+		result, err := client.Complete(ctx, "Say hello")
+		if err != nil {
+			t.Fatalf("Complete: %v", err)
+		}
+		if result.Endpoint != f.URL {
+			t.Errorf("served by %q, want %q", result.Endpoint, f.URL)
+		}
+	}
+
+	complete()
+	if reset.Injected() != 1 {
+		t.Fatal("dead endpoint was not tried first")
+	}
+
+	//Within cool-down the dead endpoint is skipped
+	for i := 0; i < 3; i++ {
+		clk.Advance(5 * time.Second)
+		complete()
+	}
+	if n := reset.Injected(); n != 1 {
+		t.Errorf("dead endpoint got %d more requests during cool-down, want none", n-1)
+	}
+
+	//Once cool-down has passed it is tried again
+	clk.Advance(16 * time.Second)
+	complete()
+	if n := reset.Injected(); n != 2 {
+		t.Errorf("dead endpoint got %d requests, want it tried again after cool-down", n)
+	}
+	if n := len(f.captured()); n != 5 {
+		t.Errorf("live server got %d requests, want 5", n)
+	}
+}
+
+func TestAllEndpointsInCooldownAreTried(t *testing.T) {
+	first, firstAccepted := deadListener(t)
+	second, secondAccepted := deadListener(t)
+	captureLogs(t)
+	clearClientEnv(t)
+	client, err := NewClient(WithAPIKey(testAPIKey), WithRetryPolicy(NoRetry),
+		WithBaseURLs("http://"+first.Addr().String(), "http://"+second.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Complete(context.Background(), "Say hello"); err == nil {
+			t.Fatal("want error when every endpoint is dead")
+		}
+	}
+	//Rather than failing without a request, all endpoints are tried when none is healthy
+	if firstAccepted.Load() < 2 || secondAccepted.Load() < 2 {
+		t.Errorf("endpoints got %d and %d connections, want both tried on each call", firstAccepted.Load(), secondAccepted.Load())
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// Maximum bytes of response body quoted in error messages
+const ERROR_SNIPPET_BYTES = 300
+
+var (
+	// Returned when no API key is configured
+	ErrMissingAPIKey = errors.New("LLAMA_API_KEY environment variable is not set")
+
+	// Returned when llama API rejects the API key
+	ErrUnauthorized = errors.New("Unauthorized: API key was rejected")
+
+	// Returned when a request is found invalid before sending it
+	ErrInvalidRequest = errors.New("Invalid request")
+
+	// Matches ResponseTooLargeError with errors.Is
+	ErrResponseTooLarge = errors.New("Response body too large")
+
+	// Returned when a response lacks fields every response must have
+	ErrUnexpectedResponse = errors.New("Unexpected response shape")
+
+	// Returned when a successful response is not JSON
+	ErrUnexpectedContentType = errors.New("Unexpected content type")
+)
+
+// Matches ContentFilteredError with errors.Is
+var ErrContentFiltered = errors.New("Generation stopped by content filter")
+
+// Error returned when the provider's content filter stopped generation, finish reason "content_filter".
+// The output was censored, so Content is not a complete answer.
+type ContentFilteredError struct {
+	// Text generated before the filter stopped it, possibly empty
+	Content string
+	Model   string
+}
+
+func (e *ContentFilteredError) Error() string {
+	return fmt.Sprintf("Generation by model %s stopped by content filter after %d characters", e.Model, len(e.Content))
+}
+
+func (e *ContentFilteredError) Is(target error) bool {
+	return target == ErrContentFiltered
+}
+
+// Error returned when llama API responds with unexpected status code
+type APIError struct {
+	StatusCode int
+	Header     http.Header
+	// Response body with API keys redacted
+	Body string
+	// Sanitized beginning of Body, set when the body is not JSON
+	Excerpt string
+	// Request ID assigned by the provider, to be quoted in support tickets
+	RequestID string
+	// Cloudflare ray ID, set when the provider is behind Cloudflare
+	CFRay string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("Unexpected status code: %d", e.StatusCode)
+	if e.Excerpt != "" {
+		msg += ": " + e.Excerpt
+	}
+	if ids := formatProviderIDs(e.RequestID, e.CFRay); ids != "" {
+		msg += " (" + ids + ")"
+	}
+	return msg
+}
+
+// Get request ID and Cloudflare ray ID which the provider sent in response headers
+func providerRequestIDs(header http.Header) (requestID, cfRay string) {
+	return header.Get("X-Request-Id"), header.Get("Cf-Ray")
+}
+
+// Format provider IDs for messages, empty when there are none
+func formatProviderIDs(requestID, cfRay string) string {
+	var ids []string
+	if requestID != "" {
+		ids = append(ids, "request id: "+requestID)
+	}
+	if cfRay != "" {
+		ids = append(ids, "cf-ray: "+cfRay)
+	}
+	return strings.Join(ids, ", ")
+}
+
+// Error returned when a response body exceeds the configured maximum size
+type ResponseTooLargeError struct {
+	// Bytes read before giving up
+	Read int64
+	// Configured maximum size
+	Limit int64
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("Response body too large: read %d bytes, limit is %d bytes", e.Read, e.Limit)
+}
+
+func (e *ResponseTooLargeError) Is(target error) bool {
+	return target == ErrResponseTooLarge
+}
+
+// Allow errors.Is(err, ErrUnauthorized) for 401 responses
+func (e *APIError) Unwrap() error {
+	if e.StatusCode == http.StatusUnauthorized {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// Check if error means the model is overloaded so that a fallback model should be tried.
+// Client errors other than 429 are not fixed by switching models.
+func shouldFallback(err error) bool {
+	if errors.Is(err, ErrCircuitOpen) {
+		return true
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500 {
+		return true
+	}
+	return strings.Contains(strings.ToLower(apiErr.Body), "overloaded")
+}
+
+// Error returned when a response body cannot be decoded, quoting the beginning of the body
+type DecodeError struct {
+	StatusCode  int
+	ContentType string
+	Snippet     string
+	Err         error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("Failed to decode response (status %d, Content-Type %q): %v: body: %s",
+		e.StatusCode, e.ContentType, e.Err, e.Snippet)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Get the first n bytes of body for error messages, cut on a UTF-8 boundary
+// with control characters and invalid bytes escaped
+func bodySnippet(body []byte, n int) string {
+	truncated := len(body) > n
+	if truncated {
+		//Back off to the start of the rune crossing the limit
+		cut := n
+		for cut > 0 && cut > n-utf8.UTFMax && !utf8.RuneStart(body[cut]) {
+			cut--
+		}
+		if cut == n-utf8.UTFMax || cut == 0 {
+			cut = n
+		}
+		body = body[:cut]
+	}
+
+	var sb strings.Builder
+	for len(body) > 0 {
+		r, size := utf8.DecodeRune(body)
+		switch {
+		case r == utf8.RuneError && size <= 1:
+			fmt.Fprintf(&sb, "\\x%02x", body[0])
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\r':
+			sb.WriteString(`\r`)
+		case r == '\t':
+			sb.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f || (r >= 0x80 && r < 0xa0):
+			fmt.Fprintf(&sb, "\\u%04x", r)
+		default:
+			sb.WriteRune(r)
+		}
+		body = body[size:]
+	}
+	if truncated {
+		sb.WriteString("...")
+	}
+	return sb.String()
+}
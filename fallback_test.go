@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Handler answering requests for each model with its scripted status, 200 with a completion naming the model
+// when the model is not in statuses, recording the model of every request
+func scriptedModels(statuses map[string]int, body string) (http.HandlerFunc, func() []string) {
+	var mu sync.Mutex
+	var models []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var req chatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		models = append(models, req.Model)
+		mu.Unlock()
+
+		if status, ok := statuses[req.Model]; ok {
+			respondJSON(status, body)(w, r)
+			return
+		}
+		data, _ := json.Marshal(chatResponse{
+			Model:   req.Model,
+			Choices: []choice{{Message: resMessage{Role: "assistant", Content: "Answered by " + req.Model}, FinishReason: "stop"}},
+		})
+		respondJSON(http.StatusOK, string(data))(w, r)
+	}
+	sent := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, models...)
+	}
+	return handler, sent
+}
+
+func TestFallbackModels(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  map[string]int
+		body      string
+		wantSent  []string
+		wantModel string
+		// Status of the APIError returned when all models fail
+		wantStatus int
+	}{
+		{
+			name:     "primary overloaded",
+			statuses: map[string]int{"llama3-70b": http.StatusServiceUnavailable},
+			wantSent: []string{"llama3-70b", "llama3-70b", "llama3-8b"}, wantModel: "llama3-8b",
+		},
+		{
+			name:     "primary rate limited and first fallback failing",
+			statuses: map[string]int{"llama3-70b": http.StatusTooManyRequests, "llama3-8b": http.StatusInternalServerError},
+			wantSent: []string{"llama3-70b", "llama3-70b", "llama3-8b", "llama3-8b", "llama3-3b"}, wantModel: "llama3-3b",
+		},
+		{
+			name:     "overloaded body on another status",
+			statuses: map[string]int{"llama3-70b": http.StatusBadRequest},
+			body:     `{"error":{"message":"Model is overloaded, try again later"}}`,
+			wantSent: []string{"llama3-70b", "llama3-8b"}, wantModel: "llama3-8b",
+		},
+		{
+			name:     "client error does not fall back",
+			statuses: map[string]int{"llama3-70b": http.StatusBadRequest},
+			wantSent: []string{"llama3-70b"}, wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:     "all models failing",
+			statuses: map[string]int{"llama3-70b": http.StatusBadGateway, "llama3-8b": http.StatusBadGateway, "llama3-3b": http.StatusServiceUnavailable},
+			wantSent: []string{"llama3-70b", "llama3-70b", "llama3-8b", "llama3-8b", "llama3-3b", "llama3-3b"}, wantStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			body := tc.body
+			if body == "" {
+				body = `{"error":{"message":"Failed"}}`
+			}
+			handler, sent := scriptedModels(tc.statuses, body)
+			logs := captureLogs(t)
+			policy := fixedBackoff{ExponentialBackoff: ExponentialBackoff{MaxRetries: 1}, delay: time.Second}
+			_, client := newFakeServer(t, handler, withClock(newFakeClock()), WithRetryPolicy(policy), WithFallbackModels("llama3-8b", "llama3-3b"))
+
+			result, err := client.Complete(context.Background(), "Say hello")
+			if !slices.Equal(sent(), tc.wantSent) {
+				t.Errorf("sent requests for %v, want %v", sent(), tc.wantSent)
+			}
+			if tc.wantStatus != 0 {
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) || apiErr.StatusCode != tc.wantStatus {
+					t.Fatalf("got error %v, want APIError %d", err, tc.wantStatus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			if result.Model != tc.wantModel || result.RequestedModel != tc.wantModel || result.Content != "Answered by "+tc.wantModel {
+				t.Errorf("got answer %q by %s for %s, want it by %s", result.Content, result.Model, result.RequestedModel, tc.wantModel)
+			}
+			if want := "falling back to " + tc.wantModel; !strings.Contains(logs.String(), want) {
+				t.Errorf("log lacks %q: %s", want, logs)
+			}
+		})
+	}
+}
+
+func TestFallbackModelGetsOwnIdempotencyKey(t *testing.T) {
+	handler, _ := scriptedModels(map[string]int{"llama3-70b": http.StatusServiceUnavailable}, `{"error":{"message":"Overloaded"}}`)
+	f, client := newFakeServer(t, handler, WithFallbackModels("llama3-8b"))
+	captureLogs(t)
+
+	ctx := ContextWithIdempotencyKey(context.Background(), "key-1")
+	if _, err := client.Complete(ctx, "Say hello"); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	reqs := f.captured()
+	if len(reqs) != 2 {
+		t.Fatalf("server got %d requests, want 2", len(reqs))
+	}
+	if got := reqs[0].Header.Get(DEFAULT_IDEMPOTENCY_HEADER); got != "key-1" {
+		t.Errorf("primary got key %q, want key-1", got)
+	}
+	if got := reqs[1].Header.Get(DEFAULT_IDEMPOTENCY_HEADER); got != "key-1-llama3-8b" {
+		t.Errorf("fallback got key %q, want key-1-llama3-8b", got)
+	}
+}
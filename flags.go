@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Flag value which can be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Split comma separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Parse "Name: value" header flags
+func parseHeaderFlags(values []string) (http.Header, error) {
+	header := http.Header{}
+	for _, value := range values {
+		name, v, ok := strings.Cut(value, ":")
+		if !ok {
+			return nil, fmt.Errorf("Invalid header %q, expected \"Name: value\"", value)
+		}
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(v))
+	}
+	return header, nil
+}
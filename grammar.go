@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// GBNF grammar constraining output to a single line ending in punctuation, e.g. one example sentence
+const SINGLE_SENTENCE_GRAMMAR = `root ::= [^\r\n]* [.!?]
+`
+
+// Matches rule definitions of GBNF such as "root ::= ..."
+var grammarRulePattern = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9-]*)\s*::=`)
+
+// Constrain output with GBNF grammar, as llama.cpp server accepts in its "grammar" field.
+// Requests fail with ErrInvalidRequest unless the provider has the grammar capability,
+// e.g. CompatibleProvider with Capabilities{Grammar: true}.
+func WithGrammar(grammar string) Option {
+	return func(c *Client) error {
+		if err := checkGrammar(grammar); err != nil {
+			return err
+		}
+		c.grammar = grammar
+		return nil
+	}
+}
+
+// Read GBNF grammar from file, e.g. a .gbnf file
+func readGrammarFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Failed to read grammar file: %v", err)
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Check grammar is roughly well formed: it defines root, each rule starts with "name ::=",
+// quotes and character classes are closed and parentheses are balanced. The server does the full parsing.
+func checkGrammar(grammar string) error {
+	if strings.TrimSpace(grammar) == "" {
+		return errors.New("Grammar must not be empty")
+	}
+
+	hasRoot := false
+	depth := 0
+	for i, line := range strings.Split(grammar, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		//Indented lines and lines inside a group continue the previous rule
+		if depth == 0 && line[0] != ' ' && line[0] != '\t' {
+			match := grammarRulePattern.FindStringSubmatch(trimmed)
+			if match == nil {
+				return fmt.Errorf("Invalid grammar at line %d: expected a rule such as \"name ::= ...\"", i+1)
+			}
+			hasRoot = hasRoot || match[1] == "root"
+		}
+		var err error
+		if depth, err = scanGrammarLine(trimmed, depth); err != nil {
+			return fmt.Errorf("Invalid grammar at line %d: %w", i+1, err)
+		}
+	}
+	if depth != 0 {
+		return errors.New("Invalid grammar: unbalanced (")
+	}
+	if !hasRoot {
+		return errors.New("Invalid grammar: no root rule")
+	}
+	return nil
+}
+
+// Check quotes and character classes of a line are closed, returning depth of parentheses after it
+func scanGrammarLine(line string, depth int) (int, error) {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '#':
+			return depth, nil
+		case '"', '[':
+			closing := byte('"')
+			if line[i] == '[' {
+				closing = ']'
+			}
+			end := closingIndex(line, i+1, closing)
+			if end < 0 {
+				return depth, fmt.Errorf("unclosed %c", line[i])
+			}
+			i = end
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return depth, errors.New("unbalanced )")
+			}
+		}
+	}
+	return depth, nil
+}
+
+// Find index of closing character from start, skipping escaped characters
+func closingIndex(line string, start int, closing byte) int {
+	for i := start; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case closing:
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGrammarPerProvider(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider func(baseURL string) Provider
+		// Whether requests with a grammar are sent, rather than rejected
+		supported bool
+	}{
+		{name: "llama", provider: func(string) Provider { return Llama }},
+		{name: "openai", provider: func(string) Provider { return OpenAI }},
+		{name: "ollama", provider: func(string) Provider { return Ollama }},
+		{
+			name:     "compatible without grammar",
+			provider: func(baseURL string) Provider { return CompatibleProvider(baseURL, "", Capabilities{Tools: true}) },
+		},
+		{
+			name:      "llama.cpp server",
+			provider:  func(baseURL string) Provider { return CompatibleProvider(baseURL, "", Capabilities{Grammar: true}) },
+			supported: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, _ := newFakeServer(t, respondChat("Hello there."))
+			captureLogs(t)
+			newClient := func(opts ...Option) *Client {
+				return f.newClient(t, append([]Option{WithProvider(tc.provider(f.URL)), WithBaseURL(f.URL), WithAPIKey(testAPIKey)}, opts...)...)
+			}
+
+			//Without a grammar, no provider gets the field
+			if _, err := newClient().Complete(context.Background(), "Say hello"); err != nil {
+				t.Fatalf("Complete without grammar: %v", err)
+			}
+			if sentGrammar(t, f.captured()[0].Body) {
+				t.Error("sent grammar without one configured")
+			}
+
+			_, err := newClient(WithGrammar(SINGLE_SENTENCE_GRAMMAR)).Complete(context.Background(), "Say hello")
+			requests := f.captured()
+			if !tc.supported {
+				if !errors.Is(err, ErrInvalidRequest) {
+					t.Errorf("got error %v, want ErrInvalidRequest", err)
+				}
+				if len(requests) != 1 {
+					t.Errorf("sent %d requests, want none with the grammar", len(requests)-1)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Complete with grammar: %v", err)
+			}
+			var body struct {
+				Grammar string `json:"grammar"`
+			}
+			if err := json.Unmarshal(requests[1].Body, &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Grammar != SINGLE_SENTENCE_GRAMMAR {
+				t.Errorf("sent grammar %q, want the single sentence grammar", body.Grammar)
+			}
+		})
+	}
+}
+
+// Whether the JSON body of a request has the grammar field
+func sentGrammar(t *testing.T, body []byte) bool {
+	t.Helper()
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		t.Fatal(err)
+	}
+	_, ok := fields["grammar"]
+	return ok
+}
+
+func TestSingleSentenceGrammar(t *testing.T) {
+	if err := checkGrammar(SINGLE_SENTENCE_GRAMMAR); err != nil {
+		t.Fatalf("checkGrammar: %v", err)
+	}
+
+	//The grammar is a single rule of character classes, which read the same as a regular expression
+	rule, found := strings.CutPrefix(strings.TrimSpace(SINGLE_SENTENCE_GRAMMAR), "root ::= ")
+	if !found || strings.Contains(rule, "\n") {
+		t.Fatalf("got grammar %q, want a single root rule", SINGLE_SENTENCE_GRAMMAR)
+	}
+	pattern, err := regexp.Compile("^" + strings.ReplaceAll(rule, " ", "") + "$")
+	if err != nil {
+		t.Fatalf("root rule %q is not made of character classes: %v", rule, err)
+	}
+
+	tests := []struct {
+		output string
+		want   bool
+	}{
+		{output: "The llama ate grass.", want: true},
+		{output: "Does the llama eat grass?", want: true},
+		{output: "!", want: true},
+		{output: "The llama ate grass", want: false},
+		{output: "The llama ate grass.\nThen it slept.", want: false},
+		{output: "The llama ate grass.\r", want: false},
+		{output: "", want: false},
+	}
+	for _, tc := range tests {
+		if got := pattern.MatchString(tc.output); got != tc.want {
+			t.Errorf("grammar matches %q: %v, want %v", tc.output, got, tc.want)
+		}
+	}
+}
+
+func TestCheckGrammar(t *testing.T) {
+	tests := []struct {
+		name    string
+		grammar string
+		wantErr string
+	}{
+		{name: "multiple rules", grammar: "root ::= answer \".\"\n# Words of the answer\nanswer ::= (\"yes\" | \"no\")\n"},
+		{name: "continued rule", grammar: "root ::= (\n  \"a\" |\n  \"b\"\n)\n"},
+		{name: "escaped quote", grammar: `root ::= "say \"hi\"" [\]]`},
+		{name: "empty", grammar: " \n", wantErr: "must not be empty"},
+		{name: "no root", grammar: `answer ::= "yes"`, wantErr: "no root rule"},
+		{name: "not a rule", grammar: "root = \"yes\"", wantErr: "line 1"},
+		{name: "unclosed quote", grammar: "root ::= \"yes", wantErr: "unclosed \""},
+		{name: "unclosed class", grammar: "root ::= [a-z", wantErr: "unclosed ["},
+		{name: "unbalanced open", grammar: "root ::= (\"a\"", wantErr: "unbalanced ("},
+		{name: "unbalanced close", grammar: "root ::= \"a\")", wantErr: "unbalanced )"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkGrammar(tc.grammar)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("got error %v, want none", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("got error %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadGrammarFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sentence.gbnf")
+	if err := os.WriteFile(path, []byte(SINGLE_SENTENCE_GRAMMAR), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	grammar, err := readGrammarFile(path)
+	if err != nil || grammar != SINGLE_SENTENCE_GRAMMAR {
+		t.Errorf("got %q, %v, want the file content", grammar, err)
+	}
+
+	captureLogs(t)
+	if _, err := readGrammarFile(filepath.Join(t.TempDir(), "missing.gbnf")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got error %v for a missing file, want os.ErrNotExist", err)
+	}
+}
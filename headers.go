@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Hop-by-hop headers are managed by net/http and never set by callers
+var hopByHopHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Host":                true,
+	"Content-Length":      true,
+}
+
+// Headers which extra headers replace only with WithUnsafeHeaders(true),
+// so that a stray header does not replace the API key or break the request body
+var protectedHeaders = map[string]bool{
+	"Authorization": true,
+	"Api-Key":       true,
+	"X-Api-Key":     true,
+	"Content-Type":  true,
+	"X-Amz-Date":    true,
+}
+
+// Check extra header can be set on requests. Protected headers are refused unless allowUnsafe is set.
+func validateHeader(key, value string, allowUnsafe bool) error {
+	if key == "" || strings.ContainsAny(key, " \t\r\n:") {
+		return fmt.Errorf("Invalid header name %q", key)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("Value of header %s must not contain line breaks", key)
+	}
+	canonical := http.CanonicalHeaderKey(key)
+	if hopByHopHeaders[canonical] {
+		return fmt.Errorf("Header %s is managed by the transport and cannot be set", canonical)
+	}
+	if protectedHeaders[canonical] && !allowUnsafe {
+		return fmt.Errorf("Overriding %s header requires WithUnsafeHeaders(true)", canonical)
+	}
+	return nil
+}
+
+// Check all values of extra headers
+func validateHeaders(header http.Header, allowUnsafe bool) error {
+	for key, values := range header {
+		for _, value := range values {
+			if err := validateHeader(key, value, allowUnsafe); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Set extra headers on request, replacing values already set for the same keys
+func applyHeaders(req *http.Request, header http.Header) {
+	for key, values := range header {
+		req.Header[key] = append([]string{}, values...)
+	}
+}
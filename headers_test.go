@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// Round tripper recording headers of every request as the client hands it to the transport
+type requestCapture struct {
+	next    http.RoundTripper
+	mu      sync.Mutex
+	headers []http.Header
+}
+
+func (c *requestCapture) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.headers = append(c.headers, req.Header.Clone())
+	c.mu.Unlock()
+	return c.next.RoundTrip(req)
+}
+
+func (c *requestCapture) captured() []http.Header {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]http.Header{}, c.headers...)
+}
+
+// Capture headers of requests the client sends
+func withRequestCapture(capture *requestCapture) Option {
+	return WithTransportWrapper(func(next http.RoundTripper) http.RoundTripper {
+		capture.next = next
+		return capture
+	})
+}
+
+func TestHeaderPrecedence(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		callOpts []CallOption
+		header   string
+		want     []string
+	}{
+		{name: "client header", opts: []Option{WithHeader("X-Org-Id", "org-1")}, header: "X-Org-Id", want: []string{"org-1"}},
+		{name: "repeated client header", opts: []Option{WithHeader("X-Org-Id", "org-1"), WithHeader("x-org-id", "org-2")}, header: "X-Org-Id", want: []string{"org-1", "org-2"}},
+		{name: "call header", callOpts: []CallOption{WithRequestHeader("X-Trace-Id", "trace-1")}, header: "X-Trace-Id", want: []string{"trace-1"}},
+		{
+			name:     "call header replaces client header",
+			opts:     []Option{WithHeader("X-Trace-Id", "client")},
+			callOpts: []CallOption{WithRequestHeader("X-Trace-Id", "call")},
+			header:   "X-Trace-Id", want: []string{"call"},
+		},
+		{name: "client header replaces default", opts: []Option{WithHeader("User-Agent", "gateway-bot")}, header: "User-Agent", want: []string{"gateway-bot"}},
+		{name: "call header replaces default", callOpts: []CallOption{WithRequestHeader("X-Request-ID", "req-1")}, header: "X-Request-ID", want: []string{"req-1"}},
+		{name: "client header replaces organization", opts: []Option{WithOrganization("org-default"), WithHeader("OpenAI-Organization", "org-1")}, header: "OpenAI-Organization", want: []string{"org-1"}},
+		{name: "unsafe Content-Type", opts: []Option{WithUnsafeHeaders(true), WithHeader("Content-Type", "application/vnd.gateway+json")}, header: "Content-Type", want: []string{"application/vnd.gateway+json"}},
+		{name: "unsafe Authorization per call", opts: []Option{WithUnsafeHeaders(true)}, callOpts: []CallOption{WithRequestHeader("Authorization", "Bearer other")}, header: "Authorization", want: []string{"Bearer other"}},
+		{name: "Authorization kept", opts: []Option{WithHeader("X-Org-Id", "org-1")}, header: "Authorization", want: []string{"Bearer " + testAPIKey}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			capture := &requestCapture{}
+			_, client := newFakeServer(t, respondJSON(http.StatusOK, chatCompletionJSON("Hello there")), append([]Option{withRequestCapture(capture)}, tc.opts...)...)
+
+			if _, err := client.Complete(context.Background(), "Say hello", tc.callOpts...); err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			//Per-call headers are gone on the next call
+			if _, err := client.Complete(context.Background(), "Say hello"); err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			headers := capture.captured()
+			if got := headers[0].Values(tc.header); !slices.Equal(got, tc.want) {
+				t.Errorf("got %s %q, want %q", tc.header, got, tc.want)
+			}
+			if len(tc.callOpts) > 0 && slices.Equal(headers[1].Values(tc.header), tc.want) {
+				t.Errorf("%s of the call was sent on the next call as well", tc.header)
+			}
+		})
+	}
+}
+
+func TestHeaderRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		callOpts []CallOption
+	}{
+		{name: "Authorization", opts: []Option{WithHeader("Authorization", "Bearer other")}},
+		{name: "Content-Type in other case", opts: []Option{WithHeader("content-type", "text/plain")}},
+		{name: "API key header", opts: []Option{WithHeader("X-Api-Key", "other")}},
+		{name: "Connection", opts: []Option{WithUnsafeHeaders(true), WithHeader("Connection", "close")}},
+		{name: "Transfer-Encoding", opts: []Option{WithUnsafeHeaders(true), WithHeader("Transfer-Encoding", "chunked")}},
+		{name: "Host", opts: []Option{WithUnsafeHeaders(true), WithHeader("Host", "other.test")}},
+		{name: "Content-Length", opts: []Option{WithUnsafeHeaders(true), WithHeader("Content-Length", "0")}},
+		{name: "Proxy-Authorization", opts: []Option{WithUnsafeHeaders(true), WithHeader("Proxy-Authorization", "Basic eA==")}},
+		{name: "invalid name", opts: []Option{WithHeader("X Org", "org-1")}},
+		{name: "line break in value", opts: []Option{WithHeader("X-Org-Id", "org-1\r\nX-Injected: 1")}},
+		{name: "Authorization per call", callOpts: []CallOption{WithRequestHeader("Authorization", "Bearer other")}},
+		{name: "Keep-Alive per call", opts: []Option{WithUnsafeHeaders(true)}, callOpts: []CallOption{WithRequestHeader("Keep-Alive", "timeout=5")}},
+		{name: "line break per call", callOpts: []CallOption{WithRequestHeader("X-Trace-Id", "a\nb")}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clearClientEnv(t)
+			captureLogs(t)
+			capture := &requestCapture{}
+			client, err := NewClient(append([]Option{WithAPIKey(testAPIKey), withRequestCapture(capture)}, tc.opts...)...)
+			if len(tc.callOpts) == 0 {
+				if err == nil {
+					t.Fatal("NewClient succeeded, want the header rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			if _, err := client.Complete(context.Background(), "Say hello", tc.callOpts...); err == nil {
+				t.Fatal("Complete succeeded, want the header rejected")
+			}
+			if n := len(capture.captured()); n != 0 {
+				t.Errorf("sent %d requests, want none", n)
+			}
+		})
+	}
+}
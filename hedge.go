@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Outcome of one of the hedged requests
+type hedgeResult struct {
+	res   *http.Response
+	body  []byte
+	err   error
+	hedge bool
+}
+
+// Send request and fire an identical second one when no response headers arrive within hedge delay.
+// Whichever succeeds first is returned and the other is cancelled.
+// Only meant for non-streaming requests, the hedge counts against the rate limiter.
+func (c *Client) sendHedged(ctx context.Context, op *operation) (*http.Response, []byte, error) {
+	//Cancelling on return aborts the request which lost the race
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, 2)
+	gotHeaders := make(chan struct{})
+	var once sync.Once
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { once.Do(func() { close(gotHeaders) }) },
+	}
+
+	go func() {
+		res, body, err := c.send(httptrace.WithClientTrace(ctx, trace), op)
+		results <- hedgeResult{res: res, body: body, err: err}
+	}()
+
+	timer := time.NewTimer(c.hedgeDelay)
+	defer timer.Stop()
+
+	select {
+	case r := <-results:
+		return r.res, r.body, r.err
+	case <-gotHeaders:
+		r := <-results
+		return r.res, r.body, r.err
+	case <-timer.C:
+	}
+
+	//Primary request stalls, fire the hedge once the rate limiter allows it
+	if c.limiter != nil {
+		if err := c.limiter.wait(ctx); err != nil {
+			err = fmt.Errorf("Interrupted while waiting for rate limiter: %w", err)
+			op.logf("Failed to send hedged request: %v", err)
+			r := <-results
+			return r.res, r.body, r.err
+		}
+	}
+	op.debugf("No response headers within %v, sending hedged request", c.hedgeDelay)
+	go func() {
+		res, body, err := c.send(ctx, op)
+		results <- hedgeResult{res: res, body: body, err: err, hedge: true}
+	}()
+
+	//Take the first success, or the last failure when both fail
+	first := <-results
+	if first.err == nil {
+		op.debugf("Hedged race won by hedge: %v", first.hedge)
+		return first.res, first.body, nil
+	}
+	second := <-results
+	if second.err == nil {
+		op.debugf("Hedged race won by hedge: %v", second.hedge)
+	}
+	return second.res, second.body, second.err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHedgeWinsOverSlowPrimary(t *testing.T) {
+	var requests atomic.Int64
+	//Closed when the server sees the primary request cancelled
+	primaryCancelled := make(chan struct{})
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+				close(primaryCancelled)
+			case <-time.After(10 * time.Second):
+				respondJSON(http.StatusOK, chatCompletionJSON("Too late"))(w, r)
+			}
+			return
+		}
+		respondJSON(http.StatusOK, chatCompletionJSON("Hedged"))(w, r)
+	}
+	logs := captureLogs(t)
+	f, client := newFakeServer(t, handler, WithHedging(50*time.Millisecond), WithDebug(true))
+
+	start := time.Now()
+	result, err := client.Complete(context.Background(), "Say hello")
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if result.Content != "Hedged" {
+		t.Errorf("got content %q, want the hedge's", result.Content)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("returned after %v, want soon after the hedge delay", elapsed)
+	}
+	select {
+	case <-primaryCancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow primary was not cancelled after the hedge won")
+	}
+	if n := len(f.captured()); n != 2 {
+		t.Errorf("server got %d requests, want the primary and the hedge", n)
+	}
+	if !strings.Contains(logs.String(), "Hedged race won by hedge: true") {
+		t.Errorf("log lacks the winner: %s", logs)
+	}
+}
+
+func TestHedgeNotSentForFastPrimary(t *testing.T) {
+	f, client := newFakeServer(t, respondJSON(http.StatusOK, chatCompletionJSON("Hello there")), WithHedging(time.Second))
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.Complete(context.Background(), "Say hello"); err != nil {
+			t.Fatalf("Complete: %v", err)
+		}
+	}
+	if n := len(f.captured()); n != 3 {
+		t.Errorf("server got %d requests, want no hedges", n)
+	}
+}
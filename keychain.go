@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Service and account under which the API key is stored in the OS keychain
+const (
+	KEYCHAIN_SERVICE = "go-llama"
+	KEYCHAIN_ACCOUNT = "api-key"
+)
+
+// Returned by Keychain when no secret is stored
+var ErrKeyNotFound = errors.New("API key not found in keychain")
+
+// Store of secrets, backed by the OS keychain, see defaultKeychain
+type Keychain interface {
+	// Get secret, ErrKeyNotFound when none is stored
+	Get(service, account string) (string, error)
+	// Store secret, replacing an existing one
+	Set(service, account, secret string) error
+	// Delete secret, ErrKeyNotFound when none is stored
+	Delete(service, account string) error
+}
+
+// Keychain keeping secrets in memory, for tests and environments without an OS keychain
+type MemoryKeychain struct {
+	mu      sync.Mutex
+	secrets map[string]string
+}
+
+func NewMemoryKeychain() *MemoryKeychain {
+	return &MemoryKeychain{secrets: map[string]string{}}
+}
+
+func (k *MemoryKeychain) Get(service, account string) (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	secret, ok := k.secrets[service+"/"+account]
+	if !ok {
+		return "", ErrKeyNotFound
+	}
+	return secret, nil
+}
+
+func (k *MemoryKeychain) Set(service, account, secret string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.secrets[service+"/"+account] = secret
+	return nil
+}
+
+func (k *MemoryKeychain) Delete(service, account string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.secrets[service+"/"+account]; !ok {
+		return ErrKeyNotFound
+	}
+	delete(k.secrets, service+"/"+account)
+	return nil
+}
+
+// Where the API key was found
+type apiKeySource string
+
+const (
+	apiKeyFromFlag     apiKeySource = "-api-key-file flag"
+	apiKeyFromEnv      apiKeySource = "LLAMA_API_KEY"
+	apiKeyFromFile     apiKeySource = API_KEY_FILE_ENV
+	apiKeyFromKeychain apiKeySource = "keychain"
+)
+
+// Resolve API key in order of -api-key-file flag, key file from environment, environment variable, then keychain.
+// Key files win over LLAMA_API_KEY when both are set, a warning is logged.
+// Returns an empty key without error when none is configured.
+func resolveAPIKey(keyFileFlag string, keychain Keychain) (string, apiKeySource, error) {
+	if keyFileFlag != "" {
+		warnAPIKeyEnvIgnored(keyFileFlag)
+		key, err := readAPIKeyFile(keyFileFlag)
+		return key, apiKeyFromFlag, err
+	}
+	if path := os.Getenv(API_KEY_FILE_ENV); path != "" {
+		warnAPIKeyEnvIgnored(path)
+		key, err := readAPIKeyFile(path)
+		return key, apiKeyFromFile, err
+	}
+	if key := os.Getenv("LLAMA_API_KEY"); key != "" {
+		return key, apiKeyFromEnv, nil
+	}
+	key, err := keychain.Get(KEYCHAIN_SERVICE, KEYCHAIN_ACCOUNT)
+	if errors.Is(err, ErrKeyNotFound) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", apiKeyFromKeychain, fmt.Errorf("Failed to read API key from keychain: %w", err)
+	}
+	return key, apiKeyFromKeychain, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Exit status of security command when the item does not exist
+const SECURITY_ERR_ITEM_NOT_FOUND = 44
+
+// macOS Keychain accessed through the security command
+type macKeychain struct{}
+
+func defaultKeychain() Keychain {
+	return macKeychain{}
+}
+
+func (macKeychain) Get(service, account string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w").Output()
+	if err != nil {
+		return "", securityError(err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (macKeychain) Set(service, account, secret string) error {
+	//Pass the secret on stdin in interactive mode, command line arguments are visible to other users
+	if strings.ContainsAny(secret, "\"\\\r\n") {
+		return errors.New("Secret must not contain quotes, backslashes or line breaks")
+	}
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %q -a %q -w \"%s\"\n", service, account, secret))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to store secret in keychain: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (macKeychain) Delete(service, account string) error {
+	if err := exec.Command("security", "delete-generic-password", "-s", service, "-a", account).Run(); err != nil {
+		return securityError(err)
+	}
+	return nil
+}
+
+// Map exit status of security command to ErrKeyNotFound
+func securityError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == SECURITY_ERR_ITEM_NOT_FOUND {
+		return ErrKeyNotFound
+	}
+	return fmt.Errorf("Failed to access keychain: %w", err)
+}
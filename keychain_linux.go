@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Secret Service, e.g. GNOME Keyring or KWallet, accessed through secret-tool of libsecret
+type secretServiceKeychain struct{}
+
+func defaultKeychain() Keychain {
+	return secretServiceKeychain{}
+}
+
+func (secretServiceKeychain) Get(service, account string) (string, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", service, "account", account).Output()
+	//secret-tool exits with 1 and prints nothing when the item does not exist
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(out) == 0 && len(exitErr.Stderr) == 0 {
+		return "", ErrKeyNotFound
+	}
+	if err != nil {
+		return "", secretToolError(err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (secretServiceKeychain) Set(service, account, secret string) error {
+	//secret-tool reads the secret from stdin, keeping it off the command line
+	cmd := exec.Command("secret-tool", "store", "--label=go-llama API key", "service", service, "account", account)
+	cmd.Stdin = strings.NewReader(secret)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", secretToolError(err), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (k secretServiceKeychain) Delete(service, account string) error {
+	if _, err := k.Get(service, account); err != nil {
+		return err
+	}
+	if err := exec.Command("secret-tool", "clear", "service", service, "account", account).Run(); err != nil {
+		return secretToolError(err)
+	}
+	return nil
+}
+
+// Explain missing secret-tool command
+func secretToolError(err error) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("secret-tool not found, install libsecret-tools to use the keychain: %w", err)
+	}
+	return fmt.Errorf("Failed to access keychain: %w", err)
+}
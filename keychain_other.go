@@ -0,0 +1,27 @@
+//go:build !darwin && !linux && !windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Placeholder for platforms without a supported keychain
+type unsupportedKeychain struct{}
+
+func defaultKeychain() Keychain {
+	return unsupportedKeychain{}
+}
+
+func (unsupportedKeychain) Get(service, account string) (string, error) {
+	return "", ErrKeyNotFound
+}
+
+func (unsupportedKeychain) Set(service, account, secret string) error {
+	return fmt.Errorf("Keychain is not supported on %s", runtime.GOOS)
+}
+
+func (unsupportedKeychain) Delete(service, account string) error {
+	return ErrKeyNotFound
+}
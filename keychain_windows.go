@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// Constants of Windows Credential Manager API
+const (
+	CRED_TYPE_GENERIC          = 1
+	CRED_PERSIST_LOCAL_MACHINE = 2
+	ERROR_NOT_FOUND            = syscall.Errno(1168)
+)
+
+var (
+	advapi32       = syscall.NewLazyDLL("advapi32.dll")
+	procCredReadW  = advapi32.NewProc("CredReadW")
+	procCredWriteW = advapi32.NewProc("CredWriteW")
+	procCredDelete = advapi32.NewProc("CredDeleteW")
+	procCredFree   = advapi32.NewProc("CredFree")
+)
+
+// CREDENTIALW structure
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// Windows Credential Manager
+type credentialManager struct{}
+
+func defaultKeychain() Keychain {
+	return credentialManager{}
+}
+
+func (credentialManager) Get(service, account string) (string, error) {
+	target, err := syscall.UTF16PtrFromString(service + ":" + account)
+	if err != nil {
+		return "", err
+	}
+	var cred *credential
+	ret, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), CRED_TYPE_GENERIC, 0, uintptr(unsafe.Pointer(&cred)))
+	if ret == 0 {
+		return "", credentialError(err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+func (credentialManager) Set(service, account, secret string) error {
+	target, err := syscall.UTF16PtrFromString(service + ":" + account)
+	if err != nil {
+		return err
+	}
+	user, err := syscall.UTF16PtrFromString(account)
+	if err != nil {
+		return err
+	}
+	blob := []byte(secret)
+	if len(blob) == 0 {
+		return errors.New("Secret must not be empty")
+	}
+	cred := credential{
+		Type:               CRED_TYPE_GENERIC,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		CredentialBlob:     &blob[0],
+		Persist:            CRED_PERSIST_LOCAL_MACHINE,
+		UserName:           user,
+	}
+	ret, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0)
+	if ret == 0 {
+		return credentialError(err)
+	}
+	return nil
+}
+
+func (credentialManager) Delete(service, account string) error {
+	target, err := syscall.UTF16PtrFromString(service + ":" + account)
+	if err != nil {
+		return err
+	}
+	ret, _, err := procCredDelete.Call(uintptr(unsafe.Pointer(target)), CRED_TYPE_GENERIC, 0)
+	if ret == 0 {
+		return credentialError(err)
+	}
+	return nil
+}
+
+// Map ERROR_NOT_FOUND to ErrKeyNotFound
+func credentialError(err error) error {
+	if errors.Is(err, ERROR_NOT_FOUND) {
+		return ErrKeyNotFound
+	}
+	return fmt.Errorf("Failed to access Credential Manager: %w", err)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Environment variable holding comma separated API keys used in turn
+const API_KEYS_ENV = "LLAMA_API_KEYS"
+
+// Default time a key rejected with 401 or 429 is skipped before it is tried again
+const DEFAULT_KEY_COOLDOWN = 60 * time.Second
+
+// API keys used round-robin, skipping keys in cool-down after the API rejected them
+type keyPool struct {
+	mu             sync.Mutex
+	keys           []string
+	next           int
+	cooldown       time.Duration
+	unhealthyUntil []time.Time
+	clock          clock
+}
+
+func newKeyPool(keys []string, cooldown time.Duration, clk clock) *keyPool {
+	return &keyPool{
+		keys:           keys,
+		cooldown:       cooldown,
+		unhealthyUntil: make([]time.Time, len(keys)),
+		clock:          clk,
+	}
+}
+
+// Pick next key in turn, skipping keys in cool-down unless all of them are
+func (p *keyPool) pick() (int, string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.clock.Now()
+	soonest := -1
+	for i := 0; i < len(p.keys); i++ {
+		index := (p.next + i) % len(p.keys)
+		if now.After(p.unhealthyUntil[index]) {
+			p.next = index + 1
+			return index, p.keys[index]
+		}
+		if soonest < 0 || p.unhealthyUntil[index].Before(p.unhealthyUntil[soonest]) {
+			soonest = index
+		}
+	}
+
+	//All keys are cooling down, use the one which recovers first
+	p.next = soonest + 1
+	return soonest, p.keys[soonest]
+}
+
+// Skip key until cool-down has passed
+func (p *keyPool) markUnhealthy(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.unhealthyUntil[index] = p.clock.Now().Add(p.cooldown)
+}
+
+// Get index of key, -1 when it is not in the pool
+func (p *keyPool) indexOf(key string) int {
+	for i, k := range p.keys {
+		if k == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// Check if the API rejected the key itself, so that the next key should be tried
+func isKeyRejected(res *http.Response, err error) bool {
+	return err != nil && res != nil &&
+		(res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusTooManyRequests)
+}
+
+// Get index of the API key which was sent with the response's request, -1 when unknown
+func (c *Client) keyIndexOf(res *http.Response) int {
+	if res == nil || res.Request == nil {
+		return -1
+	}
+	key := c.authKeyOf(res.Request)
+	if c.keys == nil {
+		if key == c.apiKey {
+			return 0
+		}
+		return -1
+	}
+	return c.keys.indexOf(key)
+}
+
+// Get all API keys which must never appear in logs
+func (c *Client) secrets() []string {
+	if len(c.apiKeys) > 0 {
+		return c.apiKeys
+	}
+	return []string{c.apiKey}
+}
+
+// Redact all API keys in s
+func (c *Client) redact(s string) string {
+	for _, secret := range c.secrets() {
+		s = redactSecret(s, secret)
+	}
+	return s
+}
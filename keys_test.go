@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Handler answering requests sent with a key in statuses with its status and others with a completion,
+// recording the key of every request
+func scriptedKeys(statuses map[string]int) (http.HandlerFunc, func() []string) {
+	var mu sync.Mutex
+	var keys []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		mu.Lock()
+		keys = append(keys, key)
+		mu.Unlock()
+
+		if status, ok := statuses[key]; ok {
+			respondJSON(status, `{"error":{"message":"Rejected"}}`)(w, r)
+			return
+		}
+		respondJSON(http.StatusOK, chatCompletionJSON("Hello there"))(w, r)
+	}
+	sent := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, keys...)
+	}
+	return handler, sent
+}
+
+func TestKeyRotation(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusUnauthorized} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			handler, sent := scriptedKeys(map[string]int{"key-a": status})
+			logs := captureLogs(t)
+			clk := newFakeClock()
+			_, client := newFakeServer(t, handler, withClock(clk), WithAPIKeys("key-a", "key-b"), WithKeyCooldown(time.Minute))
+
+			complete := func(wantSent ...string) {
+				t.Helper()
+				before := len(sent())
+				result, err := client.Complete(context.Background(), "Say hello")
+				if err != nil {
+					t.Fatalf("Complete: %v", err)
+				}
+				if result.KeyIndex != 1 {
+					t.Errorf("served with key #%d, want #1", result.KeyIndex)
+				}
+				if got := sent()[before:]; !slices.Equal(got, wantSent) {
+					t.Errorf("sent keys %v, want %v", got, wantSent)
+				}
+			}
+
+			complete("key-a", "key-b")
+			if want := fmt.Sprintf("API key #0 rejected with status %d", status); !strings.Contains(logs.String(), want) {
+				t.Errorf("log lacks %q: %s", want, logs)
+			}
+
+			//Key A is skipped during its cool-down
+			clk.Advance(30 * time.Second)
+			complete("key-b")
+			complete("key-b")
+
+			//Once cool-down has passed key A is tried again
+			clk.Advance(31 * time.Second)
+			complete("key-a", "key-b")
+		})
+	}
+}
+
+func TestKeyRoundRobin(t *testing.T) {
+	handler, sent := scriptedKeys(nil)
+	_, client := newFakeServer(t, handler, WithAPIKeys("key-a", "key-b", "key-c"))
+
+	var indexes []int
+	for i := 0; i < 4; i++ {
+		result, err := client.Complete(context.Background(), "Say hello")
+		if err != nil {
+			t.Fatalf("Complete: %v", err)
+		}
+		indexes = append(indexes, result.KeyIndex)
+	}
+	if want := []string{"key-a", "key-b", "key-c", "key-a"}; !slices.Equal(sent(), want) {
+		t.Errorf("sent keys %v, want %v", sent(), want)
+	}
+	if want := []int{0, 1, 2, 0}; !slices.Equal(indexes, want) {
+		t.Errorf("served with keys %v, want %v", indexes, want)
+	}
+}
+
+func TestAllKeysRejected(t *testing.T) {
+	handler, sent := scriptedKeys(map[string]int{"key-a": http.StatusTooManyRequests, "key-b": http.StatusUnauthorized})
+	captureLogs(t)
+	_, client := newFakeServer(t, handler, withClock(newFakeClock()), WithAPIKeys("key-a", "key-b"))
+
+	_, err := client.Complete(context.Background(), "Say hello")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("got error %v, want the last key's 401", err)
+	}
+	if want := []string{"key-a", "key-b"}; !slices.Equal(sent(), want) {
+		t.Errorf("sent keys %v, want each key once", sent())
+	}
+
+	//With all keys cooling down the one recovering first is used
+	if _, err := client.Complete(context.Background(), "Say hello"); err == nil {
+		t.Fatal("Complete succeeded with all keys rejected")
+	}
+	if got := sent()[2]; got != "key-a" {
+		t.Errorf("sent %s first, want key-a which recovers first", got)
+	}
+}
+
+func TestKeyPoolCooldown(t *testing.T) {
+	clk := newFakeClock()
+	pool := newKeyPool([]string{"key-a", "key-b"}, time.Minute, clk)
+
+	index, _ := pool.pick()
+	pool.markUnhealthy(index)
+	for i := 0; i < 3; i++ {
+		if index, key := pool.pick(); index != 1 {
+			t.Errorf("picked %s during cool-down of key-a", key)
+		}
+	}
+	clk.Advance(time.Minute + time.Second)
+	if _, key := pool.pick(); key != "key-a" {
+		t.Errorf("picked %s, want key-a back after its cool-down", key)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Matches LengthError with errors.Is
+var ErrLengthNotSatisfied = errors.New("Generated text is outside the word count range")
+
+// Word count range which generated text must fall into
+type LengthConstraint struct {
+	// Minimum number of words, 0 means no minimum
+	MinWords int
+	// Maximum number of words, 0 means no maximum
+	MaxWords int
+	// Number of times to re-generate with a strengthened prompt when the text is out of range
+	MaxRetries int
+}
+
+// Warning returned along with the best attempt when no attempt satisfied LengthConstraint
+type LengthError struct {
+	// Words of the returned best attempt
+	Words    int
+	MinWords int
+	MaxWords int
+	Attempts int
+}
+
+func (e *LengthError) Error() string {
+	return fmt.Sprintf("Generated text has %d words after %d attempts, expected %s",
+		e.Words, e.Attempts, describeWordRange(e.MinWords, e.MaxWords))
+}
+
+func (e *LengthError) Is(target error) bool {
+	return target == ErrLengthNotSatisfied
+}
+
+// Get generated response having a word count within constraint, using the shared client
+func getGeneratedResponseWithLength(prompt string, constraint LengthConstraint) (string, error) {
+	return generateWithLength(prompt, constraint, getGeneratedResponse)
+}
+
+// Send a prompt to llama API and re-generate with a strengthened prompt while the word count is out of range.
+// When all attempts fail, the attempt closest to the range is returned with LengthError.
+func (c *Client) GenerateWithLength(ctx context.Context, prompt string, constraint LengthConstraint, opts ...CallOption) (string, error) {
+	return generateWithLength(prompt, constraint, func(prompt string) (string, error) {
+		return c.Generate(ctx, prompt, opts...)
+	})
+}
+
+// Call generate until its text satisfies constraint or retries run out
+func generateWithLength(prompt string, constraint LengthConstraint, generate func(string) (string, error)) (string, error) {
+	if err := constraint.validate(); err != nil {
+		log.Printf("Failed to validate length constraint: %v", err)
+		return "", err
+	}
+
+	best := ""
+	bestDistance := -1
+	bestWords := 0
+	attemptPrompt := prompt
+	for attempt := 0; attempt <= constraint.MaxRetries; attempt++ {
+		text, err := generate(attemptPrompt)
+		if err != nil {
+			return "", err
+		}
+
+		words := len(strings.Fields(text))
+		distance := constraint.distance(words)
+		if distance == 0 {
+			return text, nil
+		}
+		if bestDistance < 0 || distance < bestDistance {
+			best, bestDistance, bestWords = text, distance, words
+		}
+
+		//Tell the model what went wrong with the previous answer
+		attemptPrompt = fmt.Sprintf("%s\n\nYour previous answer had %d words. Answer with %s.",
+			prompt, words, describeWordRange(constraint.MinWords, constraint.MaxWords))
+	}
+
+	return best, &LengthError{
+		Words:    bestWords,
+		MinWords: constraint.MinWords,
+		MaxWords: constraint.MaxWords,
+		Attempts: constraint.MaxRetries + 1,
+	}
+}
+
+// Check constraint has a non-empty range
+func (l LengthConstraint) validate() error {
+	if l.MinWords < 0 || l.MaxWords < 0 || l.MaxRetries < 0 {
+		return fmt.Errorf("%w: word counts and retries must not be negative", ErrInvalidRequest)
+	}
+	if l.MaxWords > 0 && l.MinWords > l.MaxWords {
+		return fmt.Errorf("%w: minimum words %d exceeds maximum words %d", ErrInvalidRequest, l.MinWords, l.MaxWords)
+	}
+	return nil
+}
+
+// Get how many words the count is away from the range, 0 when within it
+func (l LengthConstraint) distance(words int) int {
+	if words < l.MinWords {
+		return l.MinWords - words
+	}
+	if l.MaxWords > 0 && words > l.MaxWords {
+		return words - l.MaxWords
+	}
+	return 0
+}
+
+// Describe word range for prompts and error messages
+func describeWordRange(min, max int) string {
+	switch {
+	case max == 0:
+		return fmt.Sprintf("at least %d words", min)
+	case min == 0:
+		return fmt.Sprintf("at most %d words", max)
+	default:
+		return fmt.Sprintf("between %d and %d words", min, max)
+	}
+}
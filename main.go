@@ -1,184 +1,164 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
 )
 
-// Request body to llama API
-type chatRequest struct {
-	Model        string       `json:"model"`
-	Messages     []reqMessage `json:"messages"`
-	Functions    []function   `json:"functions"`
-	Stream       bool         `json:"stream"`
-	FunctionCall string       `json:"function_call"`
-}
-
-type reqMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
-
-type function struct {
-	Name        string     `json:"name"`
-	Description string     `json:"description"`
-	Parameters  parameters `json:"parameters"`
-	Required    []string   `json:"required"`
-}
-
-type parameters struct {
-	Type       string     `json:"type"`
-	Properties properties `json:"properties"`
-}
-
-type properties struct {
-	Words words `json:"words"`
-}
-
-type words struct {
-	Type        string `json:"type"`
-	Description string `json:"description"`
-}
-
-// Response body from llama API
-type chatResponse struct {
-	Choices []choice `json:"choices"`
-}
-
-type choice struct {
-	Index        int        `json:"index"`
-	Message      resMessage `json:"message"`
-	FinishReason string     `json:"finish_reason"`
-}
-
-type resMessage struct {
-	Role         string       `json:"role"`
-	Content      string       `json:"content"`
-	FunctionCall functionCall `json:"function_call"`
-}
-
-type functionCall struct {
-	Name      string    `json:"name"`
-	Arguments arguments `json:"arguments"`
-}
-
-type arguments struct {
-	Words []string `json:"words"`
-}
+func main() {
+	//Fill unset environment variables from .env in working directory or -env-file
+	envFile := envFileFromArgs(os.Args[1:])
+	if err := loadDotEnv(envFile, dotEnvKeys); err != nil {
+		log.Fatalf("Failed to load %s: %v", envFile, err)
+	}
 
-// Endpoint
-const API_URL = "https://api.llama-api.com/chat/completions"
+	//Subcommands have their own arguments, they may follow -env-file
+	args := skipEnvFileFlags(os.Args[1:])
+	if len(args) > 0 && args[0] == "auth" {
+		if err := runAuth(args[1:], defaultKeychain(), os.Stdout); err != nil {
+			log.Fatalf("Failed to run auth: %v", err)
+		}
+		return
+	}
+	if len(args) > 0 && args[0] == "models" {
+		if err := runModels(args[1:], os.Stdout); err != nil {
+			log.Fatalf("Failed to run models: %v", err)
+		}
+		return
+	}
+	if len(args) > 0 && args[0] == "doctor" {
+		if err := runDoctor(args[1:], os.Stdout); err != nil {
+			log.Fatalf("Failed to run doctor: %v", err)
+		}
+		return
+	}
+	if len(args) > 0 && args[0] == "config" {
+		if err := runConfig(args[1:], os.Stdout); err != nil {
+			log.Fatalf("Failed to run config: %v", err)
+		}
+		return
+	}
 
-// Get generated response from Llama API
-func getGeneratedResponse(prompt string) (string, error) {
-	//Create request body
-	chatReq := createChatRequest(prompt)
+	registerEnvFileFlag(flag.CommandLine)
+	configSelection, configFlags := registerConfigFlags(flag.CommandLine)
+	listModels := flag.Bool("list-models", false, "List available model IDs and exit")
+	pullIfMissing := flag.Bool("pull-if-missing", false, "With the ollama provider, pull the model when Ollama does not have it and retry")
+	count := flag.Int("n", 1, "Number of independent generations to run, each printed numbered")
+	checkModel := flag.Bool("check-model", false, "Check the model is listed by the API before sending the prompt, suggesting close matches")
+	rpm := flag.Int("rpm", 0, "Maximum requests per minute, 0 means unlimited")
+	tpm := flag.Int("tpm", 0, "Maximum tokens per minute, 0 means unlimited")
+	verbose := flag.Bool("v", false, "Print debug logs and token usage")
+	useCache := flag.Bool("cache", false, "Cache responses of identical requests in the user cache directory")
+	noCache := flag.Bool("no-cache", false, "Always call the API, even with -cache or -cache-dir")
+	cacheDir := flag.String("cache-dir", "", "Directory of cached responses, enables caching")
+	cacheTTL := flag.Duration("cache-ttl", 24*time.Hour, "Lifetime of cached responses, 0 means forever")
+	var fallbackModels stringList
+	flag.Var(&fallbackModels, "fallback-model", "Model to use when the primary model is overloaded, can be repeated")
+	var wordFlags stringList
+	flag.Var(&wordFlags, "word", "Vocabulary word to use in the sentence, can be repeated")
+	wordList := flag.String("words", "", "Comma separated vocabulary words to use in the sentence")
+	image := flag.String("image", "", "URL of an image to send along with the prompt")
+	var headerFlags stringList
+	flag.Var(&headerFlags, "header", "Extra request header as \"Name: value\", can be repeated")
+	grammarFile := flag.String("grammar-file", "", "GBNF grammar file constraining output, needs a provider with the grammar capability")
+	apiKeyFile := flag.String("api-key-file", "", "File containing the API key, takes precedence over LLAMA_API_KEY")
+	flag.Parse()
 
-	//Marshal Go struct into Json
-	jsonData, err := json.Marshal(chatReq)
+	//Resolve settings from flags, environment, config file and defaults
+	cfg, err := loadConfig(configSelection, configFlags())
 	if err != nil {
-		log.Printf("Failed to Marshal: %v", err)
-		return "", err
+		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	//Create Http request struct with request method, endpoint and request body
-	ctx := context.Background()
-	req, err := http.NewRequestWithContext(ctx, "POST", API_URL, bytes.NewReader(jsonData))
-	if err != nil {
-		log.Printf("Failed to create http request struct: %v", err)
-		return "", err
+	opts := append(cfg.options(),
+		WithRateLimit(*rpm, 1),
+		WithTokenRateLimit(*tpm),
+		WithFallbackModels(fallbackModels...),
+	)
+	if *verbose {
+		opts = append(opts, WithDebug(true))
+	}
+	if len(headerFlags) > 0 {
+		header, err := parseHeaderFlags(headerFlags)
+		if err != nil {
+			log.Fatalf("Failed to parse headers: %v", err)
+		}
+		opts = append(opts, WithHeaders(header))
 	}
 
-	//Add necessary headers, including the API key for authorization
-	apiKey := os.Getenv("LLAMA_API_KEY")
-	if apiKey == "" {
-		err := errors.New("LLAMA_API_KEY environment variable is not set")
-		log.Printf("Failed to get API KEY: %v", err)
-		return "", err
+	if *grammarFile != "" {
+		grammar, err := readGrammarFile(*grammarFile)
+		if err != nil {
+			log.Fatalf("Failed to load grammar: %v", err)
+		}
+		opts = append(opts, WithGrammar(grammar))
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
 
-	//Execute http request to llama and get response
-	client := &http.Client{}
-	res, err := client.Do(req)
+	apiKey, _, err := commandAPIKey(cfg, *apiKeyFile)
 	if err != nil {
-		log.Printf("Failed to get http response: %v", err)
-		return "", err
+		log.Fatalf("Failed to resolve API key: %v", err)
 	}
-
-	//Check if http status code is ok
-	if res.StatusCode != http.StatusOK {
-		err := errors.New("Unexpected status code")
-		log.Printf("Failed to get expected status code: %v :%d", err, res.StatusCode)
-		return "", err
+	if apiKey != "" {
+		opts = append(opts, WithAPIKey(apiKey))
 	}
-
-	//Read http response body
-	defer res.Body.Close()
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		log.Printf("Failed to read body: %v", err)
-		return "", err
+	//Caching is opt-in, repeated prompts are expected to give fresh answers
+	if (*useCache || *cacheDir != "") && !*noCache {
+		dir := *cacheDir
+		if dir == "" {
+			dir = defaultCacheDir()
+		}
+		if dir == "" {
+			log.Fatalf("Failed to enable cache: user cache directory is unknown, set -cache-dir")
+		}
+		opts = append(opts, WithCache(dir, *cacheTTL))
 	}
 
-	//Unmarshal json response into Go struct
-	chatRes := &chatResponse{}
-	err = json.Unmarshal(body, chatRes)
+	client, err := NewClient(opts...)
 	if err != nil {
-		log.Printf("Failed to unmarshal: %v", err)
-		return "", err
+		log.Fatalf("Failed to create client: %v", err)
 	}
+	//Cancel in-flight request on Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-	if len(chatRes.Choices) == 0 {
-		err := errors.New("No choices returned from llama")
-		log.Printf("Failed to get expected length of choices: %v", err)
-		return "", err
+	if *listModels {
+		models, err := client.ListModels(ctx)
+		exitIfCancelled(ctx, err)
+		if err != nil {
+			log.Fatalf("Failed to list models from Llama API: %v", err)
+		}
+		for _, model := range models {
+			fmt.Println(model)
+		}
+		return
 	}
 
-	//Return generated text from llama
-	return chatRes.Choices[0].Message.Content, nil
-}
-
-// Set a prompt and other values to create chat request
-func createChatRequest(prompt string) *chatRequest {
-	return &chatRequest{
-		Model: "llama3-70b",
-		Messages: []reqMessage{
-			reqMessage{Role: "user", Content: prompt},
-		},
-		Functions: []function{
-			function{
-				Name:        "Get_English_Exmple_Sentence",
-				Description: "Get the English example sentence generated with given words.",
-				Parameters: parameters{
-					Type: "object",
-					Properties: properties{
-						Words: words{
-							Type:        "string",
-							Description: "English vocabulary list, e.g. nonchalant, reckon, appalled",
-						},
-					},
-				},
-				Required: []string{"words"},
-			},
-		},
-		Stream:       false,
-		FunctionCall: "none",
+	if *checkModel {
+		err := client.CheckModel(ctx, client.model)
+		exitIfCancelled(ctx, err)
+		if err != nil {
+			log.Fatalf("Failed to check model: %v", err)
+		}
 	}
-}
 
-func main() {
-	words := [3]string{"nonchalant", "reckon", "appalled"}
-	prompt := fmt.Sprintf("Please create an English example sentence using following words: %s, %s, %s",
-		words[0], words[1], words[2])
+	//Collect vocabulary words from flags, falling back to the demo words
+	words := append([]string{}, wordFlags...)
+	words = append(words, splitList(*wordList)...)
+	if len(words) == 0 {
+		words = []string{"nonchalant", "reckon", "appalled"}
+	}
+	prompt, err := RenderPrompt(EXAMPLE_SENTENCE_PROMPT, struct{ Words []string }{Words: words})
+	if err != nil {
+		log.Fatalf("Failed to create prompt: %v", err)
+	}
 
 	fmt.Println("")
 	fmt.Println("")
@@ -190,12 +170,132 @@ func main() {
 	fmt.Println("")
 
 	fmt.Println("++++++ Generated response ++++++")
-	response, err := getGeneratedResponse(prompt)
-	if err != nil {
-		log.Fatalf("Failed to get generated response from Llama API: %v", err)
+	message := reqMessage{Role: "user", Content: prompt}
+	if *image != "" {
+		message = ImageMessage(prompt, *image)
+	}
+	if *count < 1 {
+		log.Fatalf("Invalid -n %d: must be at least 1", *count)
+	}
+	var callOpts []CallOption
+	if *count > 1 {
+		//Identical requests would otherwise be answered from the cache
+		callOpts = append(callOpts, WithoutCache())
 	}
-	fmt.Println(response)
 
-	fmt.Println("")
-	fmt.Println("")
+	//Run generations one after another so the rate limit applies between them
+	failed := 0
+	for i := 1; i <= *count; i++ {
+		if *count > 1 {
+			fmt.Printf("------ %d/%d ------\n", i, *count)
+		}
+		var result *GenerateResult
+		if *pullIfMissing && cfg.Provider.Name == Ollama.Name {
+			result, err = chatPullingIfMissing(ctx, client, []reqMessage{message}, os.Stderr, callOpts...)
+		} else {
+			result, err = client.Chat(ctx, []reqMessage{message}, callOpts...)
+		}
+		exitIfCancelled(ctx, err)
+		if err != nil && *count == 1 {
+			log.Fatalf("Failed to get generated response from Llama API: %v", err)
+		}
+		if err != nil {
+			//Report and go on with the remaining generations
+			log.Printf("Failed to get generated response %d/%d from Llama API: %v", i, *count, err)
+			failed++
+			continue
+		}
+		fmt.Println(result.Content)
+
+		fmt.Println("")
+		fmt.Println("")
+
+		if *verbose {
+			if ids := formatProviderIDs(result.ProviderRequestID, result.CFRay); ids != "" {
+				fmt.Printf("Provider %s\n", ids)
+			}
+			if result.UpstreamProvider != "" || (result.RequestedModel != "" && result.Model != result.RequestedModel) {
+				fmt.Printf("Served by model %s (requested %s) %s\n", result.Model, result.RequestedModel, result.UpstreamProvider)
+			}
+			if used, limit := client.TokenUsage(); limit > 0 {
+				fmt.Printf("Tokens used in the last minute: %d/%d\n", used, limit)
+			}
+		}
+	}
+	if failed > 0 {
+		log.Fatalf("Failed %d of %d generations", failed, *count)
+	}
+}
+
+// Resolve API key from flag, environment or keychain, then config file.
+// Keychain and key file variable hold llama keys, other providers take the key from their variable.
+// Also returns where the key came from, e.g. "keychain" or "env OPENAI_API_KEY".
+func commandAPIKey(cfg *Config, apiKeyFile string) (string, apiKeySource, error) {
+	apiKey := ""
+	var source apiKeySource
+	if cfg.Provider.Name == Llama.Name && cfg.Provider.APIKeyEnv == Llama.APIKeyEnv {
+		var err error
+		apiKey, source, err = resolveAPIKey(apiKeyFile, defaultKeychain())
+		if err != nil && source != apiKeyFromKeychain {
+			return "", source, err
+		}
+		if err != nil {
+			log.Printf("WARNING: %v", err)
+		}
+	} else if apiKeyFile != "" {
+		var err error
+		source = apiKeyFromFlag
+		apiKey, err = readAPIKeyFile(apiKeyFile)
+		if err != nil {
+			return "", source, err
+		}
+	}
+	if apiKey == "" {
+		//e.g. "env OPENAI_API_KEY" or "config file"
+		apiKey, source = cfg.APIKey, apiKeySource(cfg.sources["api_key"])
+	}
+	return apiKey, source, nil
+}
+
+// Pull model to Ollama, writing progress to progress such as stderr
+func pullModel(ctx context.Context, client *Client, model string, progress io.Writer) error {
+	fmt.Fprintf(progress, "Pulling %s\n", model)
+	err := client.PullModel(ctx, model, func(p PullProgress) {
+		if p.Total > 0 {
+			fmt.Fprintf(progress, "\r%-35s %3d%%", p.Status, p.Completed*100/p.Total)
+		} else {
+			fmt.Fprintf(progress, "\r%-40s", p.Status)
+		}
+	})
+	fmt.Fprintln(progress)
+	return err
+}
+
+// Chat with Ollama, pulling the model and retrying once when Ollama has not pulled it yet
+func chatPullingIfMissing(ctx context.Context, client *Client, messages []reqMessage, progress io.Writer, opts ...CallOption) (*GenerateResult, error) {
+	result, err := client.Chat(ctx, messages, opts...)
+	if err == nil || !IsModelNotFound(err) {
+		return result, err
+	}
+	if err := pullModel(ctx, client, client.model, progress); err != nil {
+		return nil, err
+	}
+	return client.Chat(ctx, messages, opts...)
+}
+
+// Exit with non-zero status when the operation was interrupted by a signal
+func exitIfCancelled(ctx context.Context, err error) {
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		fmt.Fprintln(os.Stderr, "cancelled")
+		os.Exit(130)
+	}
+}
+
+// Get default directory of cached responses, empty when user cache directory is unknown
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "go-llama")
 }
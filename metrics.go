@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upper bounds in seconds of request latency histogram buckets
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// Metrics collects generation statistics and exposes them in Prometheus text format.
+// It implements http.Handler so it can be served as a scrape endpoint.
+type Metrics struct {
+	mu       sync.Mutex
+	requests map[[2]string]float64
+	errors   map[[2]string]float64
+	retries  map[string]float64
+	latency  map[[2]string]*histogram
+}
+
+type histogram struct {
+	counts []float64
+	sum    float64
+	count  float64
+}
+
+// Create empty metrics registry
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: map[[2]string]float64{},
+		errors:   map[[2]string]float64{},
+		retries:  map[string]float64{},
+		latency:  map[[2]string]*histogram{},
+	}
+}
+
+// Record a finished generation with its model, outcome and latency
+func (m *Metrics) observeRequest(model, outcome string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := [2]string{model, outcome}
+	m.requests[key]++
+
+	h, ok := m.latency[key]
+	if !ok {
+		h = &histogram{counts: make([]float64, len(latencyBuckets))}
+		m.latency[key] = h
+	}
+	seconds := d.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// Record a failed attempt by status class such as 4xx, 5xx or network
+func (m *Metrics) observeError(model, statusClass string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors[[2]string{model, statusClass}]++
+}
+
+// Record a retry
+func (m *Metrics) observeRetry(model string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries[model]++
+}
+
+// Write metrics in Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sb strings.Builder
+
+	sb.WriteString("# HELP llama_requests_total Total number of generation requests.\n")
+	sb.WriteString("# TYPE llama_requests_total counter\n")
+	for _, key := range sortedKeys(m.requests) {
+		fmt.Fprintf(&sb, "llama_requests_total{model=%s,outcome=%s} %s\n",
+			quoteLabel(key[0]), quoteLabel(key[1]), formatFloat(m.requests[key]))
+	}
+
+	sb.WriteString("# HELP llama_request_errors_total Total number of failed attempts by status class.\n")
+	sb.WriteString("# TYPE llama_request_errors_total counter\n")
+	for _, key := range sortedKeys(m.errors) {
+		fmt.Fprintf(&sb, "llama_request_errors_total{model=%s,status_class=%s} %s\n",
+			quoteLabel(key[0]), quoteLabel(key[1]), formatFloat(m.errors[key]))
+	}
+
+	sb.WriteString("# HELP llama_retries_total Total number of retried attempts.\n")
+	sb.WriteString("# TYPE llama_retries_total counter\n")
+	models := make([]string, 0, len(m.retries))
+	for model := range m.retries {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for _, model := range models {
+		fmt.Fprintf(&sb, "llama_retries_total{model=%s} %s\n", quoteLabel(model), formatFloat(m.retries[model]))
+	}
+
+	sb.WriteString("# HELP llama_request_duration_seconds Latency of generation requests including retries.\n")
+	sb.WriteString("# TYPE llama_request_duration_seconds histogram\n")
+	for _, key := range sortedKeys(m.latency) {
+		h := m.latency[key]
+		labels := fmt.Sprintf("model=%s,outcome=%s", quoteLabel(key[0]), quoteLabel(key[1]))
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(&sb, "llama_request_duration_seconds_bucket{%s,le=\"%s\"} %s\n", labels, formatFloat(bound), formatFloat(h.counts[i]))
+		}
+		fmt.Fprintf(&sb, "llama_request_duration_seconds_bucket{%s,le=\"+Inf\"} %s\n", labels, formatFloat(h.count))
+		fmt.Fprintf(&sb, "llama_request_duration_seconds_sum{%s} %s\n", labels, formatFloat(h.sum))
+		fmt.Fprintf(&sb, "llama_request_duration_seconds_count{%s} %s\n", labels, formatFloat(h.count))
+	}
+
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+// Serve metrics to Prometheus scraper
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// Get status class label of a failed attempt
+func statusClass(res *http.Response) string {
+	if res == nil || res.StatusCode == http.StatusOK {
+		return "network"
+	}
+	return strconv.Itoa(res.StatusCode/100) + "xx"
+}
+
+func sortedKeys[V any](m map[[2]string]V) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
+
+// Quote label value escaping backslash, double quote and newline
+func quoteLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return `"` + value + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
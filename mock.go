@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fixture answering requests whose messages have no fixture of their own
+const MOCK_DEFAULT_FIXTURE = "default"
+
+// Reply of the mock provider when the fixture directory has no default fixture
+const MOCK_DEFAULT_CONTENT = "This is a mock response."
+
+// Offline provider answering from fixtures, see MockProvider
+var Mock = Provider{
+	Name:           "mock",
+	BaseURL:        "http://mock.invalid/v1",
+	DefaultModel:   "mock",
+	APIKeyOptional: true,
+	transport:      &mockTransport{},
+}
+
+// Provider answering requests from JSON fixtures in dir instead of the network, for offline development.
+// Requests go through the client as usual, so retries, caching and rate limits behave as with a real provider.
+//
+// A fixture is picked by the key of the request's messages, see MockFixtureKey, falling back to "default".
+// Its file name is the key followed by optional settings and ".json":
+//
+//	KEY[.delay-DURATION][.error-STATUS].json
+//
+// e.g. "default.json", "3f2a9c0d1e4b5a6f.delay-2s.json" or "default.error-429.json".
+// Delay postpones the response, error answers with the status code and the file content as body.
+// Other fixtures hold a mockFixture, an empty dir replies with MOCK_DEFAULT_CONTENT.
+func MockProvider(dir string) Provider {
+	p := Mock
+	p.transport = &mockTransport{dir: dir}
+	return p
+}
+
+// Get key naming the fixture which answers messages, the first 16 hex digits of the SHA-256 of their JSON
+func MockFixtureKey(messages []reqMessage) (string, error) {
+	data, err := json.Marshal(messages)
+	if err != nil {
+		return "", err
+	}
+	return mockFixtureKey(data), nil
+}
+
+func mockFixtureKey(messagesJSON []byte) string {
+	sum := sha256.Sum256(messagesJSON)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// Content of a fixture which does not inject an error
+type mockFixture struct {
+	Content      string        `json:"content"`
+	FunctionCall *functionCall `json:"function_call"`
+	// Defaults to "stop"
+	FinishReason string `json:"finish_reason"`
+	// Whole response body sent as is, replacing the fields above
+	Response json.RawMessage `json:"response"`
+}
+
+// Fixture file along with the settings of its name
+type mockFixtureFile struct {
+	path   string
+	delay  time.Duration
+	status int
+}
+
+// Serves requests to the mock provider from fixtures
+type mockTransport struct {
+	dir string
+}
+
+func (t *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if req.Method == "GET" && strings.HasSuffix(req.URL.Path, "/models") {
+		return mockResponse(req, http.StatusOK, "application/json", []byte(`{"object":"list","data":[{"id":"`+Mock.DefaultModel+`","object":"model","owned_by":"mock"}]}`)), nil
+	}
+
+	var chatReq struct {
+		Model    string          `json:"model"`
+		Messages json.RawMessage `json:"messages"`
+		Stream   bool            `json:"stream"`
+	}
+	if err := json.Unmarshal(body, &chatReq); err != nil {
+		return mockResponse(req, http.StatusBadRequest, "application/json", []byte(`{"error":{"message":"Mock provider could not decode request"}}`)), nil
+	}
+
+	key := mockFixtureKey(chatReq.Messages)
+	file, err := t.findFixture(key)
+	if err != nil {
+		return nil, err
+	}
+	if file.delay > 0 {
+		timer := time.NewTimer(file.delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+
+	fixture := mockFixture{Content: MOCK_DEFAULT_CONTENT}
+	if file.path != "" {
+		data, err := os.ReadFile(file.path)
+		if err != nil {
+			log.Printf("Failed to read mock fixture: %v", err)
+			return nil, err
+		}
+		if file.status != 0 {
+			return mockResponse(req, file.status, "application/json", data), nil
+		}
+		fixture = mockFixture{}
+		if err := json.Unmarshal(data, &fixture); err != nil {
+			log.Printf("Failed to unmarshal mock fixture %s: %v", file.path, err)
+			return nil, fmt.Errorf("Invalid mock fixture %s: %w", file.path, err)
+		}
+	}
+
+	if len(fixture.Response) > 0 {
+		return mockResponse(req, http.StatusOK, "application/json", fixture.Response), nil
+	}
+	return fixture.respond(req, "mock-"+key, chatReq.Model, chatReq.Stream)
+}
+
+// Find fixture of key, then the default one. Neither existing is no error, the built-in reply is used.
+func (t *mockTransport) findFixture(key string) (mockFixtureFile, error) {
+	if t.dir == "" {
+		return mockFixtureFile{}, nil
+	}
+	for _, name := range []string{key, MOCK_DEFAULT_FIXTURE} {
+		matches, err := filepath.Glob(filepath.Join(t.dir, name+"*.json"))
+		if err != nil {
+			return mockFixtureFile{}, err
+		}
+		var files []mockFixtureFile
+		for _, match := range matches {
+			file, ok, err := parseMockFixtureName(match, name)
+			if err != nil {
+				return mockFixtureFile{}, err
+			}
+			if ok {
+				files = append(files, file)
+			}
+		}
+		if len(files) > 1 {
+			return mockFixtureFile{}, fmt.Errorf("Several mock fixtures for %s in %s", name, t.dir)
+		}
+		if len(files) == 1 {
+			return files[0], nil
+		}
+		if name == key {
+			log.Printf("No mock fixture %s.json in %s, using default", key, t.dir)
+		}
+	}
+	return mockFixtureFile{}, nil
+}
+
+// Parse settings from name of fixture file, reporting whether it belongs to key at all
+func parseMockFixtureName(path, key string) (mockFixtureFile, bool, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSuffix(filepath.Base(path), ".json"), key)
+	if !ok || (rest != "" && rest[0] != '.') {
+		return mockFixtureFile{}, false, nil
+	}
+
+	file := mockFixtureFile{path: path}
+	for _, setting := range strings.Split(rest, ".")[1:] {
+		name, value, _ := strings.Cut(setting, "-")
+		switch name {
+		case "delay":
+			delay, err := time.ParseDuration(value)
+			if err != nil {
+				return mockFixtureFile{}, false, fmt.Errorf("Invalid delay of mock fixture %s: %w", path, err)
+			}
+			file.delay = delay
+		case "error":
+			status, err := strconv.Atoi(value)
+			if err != nil || status < 400 || status > 599 {
+				return mockFixtureFile{}, false, fmt.Errorf("Invalid error status of mock fixture %s, must be 400 to 599", path)
+			}
+			file.status = status
+		default:
+			return mockFixtureFile{}, false, fmt.Errorf("Unknown setting %q of mock fixture %s", setting, path)
+		}
+	}
+	return file, true, nil
+}
+
+// Build chat completion, or a stream of a single chunk, from fixture
+func (f mockFixture) respond(req *http.Request, id, model string, stream bool) (*http.Response, error) {
+	finishReason := f.FinishReason
+	if finishReason == "" {
+		finishReason = "stop"
+	}
+	message := resMessage{Role: "assistant", Content: f.Content}
+	if f.FunctionCall != nil {
+		message.FunctionCall = *f.FunctionCall
+	}
+
+	if stream {
+		delta := chunkDelta{Role: "assistant", Content: f.Content}
+		if f.FunctionCall != nil {
+			delta.FunctionCall = &functionCallDelta{Name: f.FunctionCall.Name, Arguments: f.FunctionCall.Arguments}
+		}
+		chunk, err := json.Marshal(chatStreamChunk{
+			ID:      id,
+			Model:   model,
+			Choices: []streamChoice{{Delta: delta, FinishReason: finishReason}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		body := "data: " + string(chunk) + "\n\ndata: [DONE]\n\n"
+		return mockResponse(req, http.StatusOK, "text/event-stream", []byte(body)), nil
+	}
+
+	completionTokens := EstimateTokens(f.Content)
+	data, err := json.Marshal(chatResponse{
+		ID:      id,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []choice{{Message: message, FinishReason: finishReason}},
+		Usage:   usage{CompletionTokens: completionTokens, TotalTokens: completionTokens},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mockResponse(req, http.StatusOK, "application/json", data), nil
+}
+
+func mockResponse(req *http.Request, status int, contentType string, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {contentType}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
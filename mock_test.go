@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Write fixtures to a new directory, each named by file name without .json.
+// Names may contain KEY, replaced with the fixture key of a user message saying "Say hello".
+func writeMockFixtures(t *testing.T, fixtures map[string]string) string {
+	t.Helper()
+	key, err := MockFixtureKey([]reqMessage{{Role: "user", Content: "Say hello"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for name, content := range fixtures {
+		path := filepath.Join(dir, strings.ReplaceAll(name, "KEY", key)+".json")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// Client of the mock provider answering from fixtures in dir, chosen like "-provider mock -fixtures dir"
+func newMockClient(t *testing.T, dir string, opts ...Option) *Client {
+	t.Helper()
+	clearClientEnv(t)
+	cfg, err := resolveConfig(map[string]string{"provider": "mock", "fixtures": dir}, fakeEnv(nil), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(append(cfg.options(), append([]Option{WithRetryPolicy(NoRetry)}, opts...)...)...)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return client
+}
+
+func TestMockFixtureMatching(t *testing.T) {
+	dir := writeMockFixtures(t, map[string]string{
+		"KEY":     `{"content":"Hello from the fixture"}`,
+		"default": `{"content":"Hello from the default","finish_reason":"length"}`,
+	})
+	client := newMockClient(t, dir)
+	logs := captureLogs(t)
+
+	tests := []struct {
+		name       string
+		prompt     string
+		stream     bool
+		want       string
+		wantFinish string
+	}{
+		{name: "matched", prompt: "Say hello", want: "Hello from the fixture", wantFinish: "stop"},
+		{name: "matched stream", prompt: "Say hello", stream: true, want: "Hello from the fixture", wantFinish: "stop"},
+		{name: "fallback", prompt: "Say goodbye", want: "Hello from the default", wantFinish: "length"},
+		{name: "fallback stream", prompt: "Say goodbye", stream: true, want: "Hello from the default", wantFinish: "length"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			messages := []reqMessage{{Role: "user", Content: tc.prompt}}
+			var result *GenerateResult
+			var err error
+			if tc.stream {
+				result, err = client.ChatStream(context.Background(), messages, func(string) error { return nil })
+			} else {
+				result, err = client.Chat(context.Background(), messages)
+			}
+			if err != nil {
+				t.Fatalf("Chat: %v", err)
+			}
+			if result.Content != tc.want || result.FinishReason != tc.wantFinish {
+				t.Errorf("got %q finishing %s, want %q finishing %s", result.Content, result.FinishReason, tc.want, tc.wantFinish)
+			}
+			if result.Model != Mock.DefaultModel {
+				t.Errorf("got model %q, want %q", result.Model, Mock.DefaultModel)
+			}
+		})
+	}
+
+	key, _ := MockFixtureKey([]reqMessage{{Role: "user", Content: "Say goodbye"}})
+	if want := "No mock fixture " + key + ".json"; !strings.Contains(logs.String(), want) {
+		t.Errorf("log lacks %q: %s", want, logs)
+	}
+}
+
+func TestMockBuiltInReply(t *testing.T) {
+	for name, dir := range map[string]string{"no directory": "", "empty directory": t.TempDir()} {
+		t.Run(name, func(t *testing.T) {
+			client := newMockClient(t, dir)
+			captureLogs(t)
+
+			result, err := client.Complete(context.Background(), "Say hello")
+			if err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			if result.Content != MOCK_DEFAULT_CONTENT {
+				t.Errorf("got %q, want %q", result.Content, MOCK_DEFAULT_CONTENT)
+			}
+		})
+	}
+}
+
+func TestMockFixtureContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		fixture  string
+		want     string
+		wantCall functionCall
+	}{
+		{
+			name:     "function call",
+			fixture:  `{"function_call":{"name":"get_weather","arguments":"{\"city\":\"Tokyo\"}"},"finish_reason":"function_call"}`,
+			wantCall: functionCall{Name: "get_weather", Arguments: `{"city":"Tokyo"}`},
+		},
+		{
+			name:    "whole response",
+			fixture: `{"response":{"model":"mock-large","choices":[{"index":0,"message":{"role":"assistant","content":"Raw reply"},"finish_reason":"stop"}]}}`,
+			want:    "Raw reply",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newMockClient(t, writeMockFixtures(t, map[string]string{"KEY": tc.fixture}), WithFunctions(weatherFunction))
+
+			result, err := client.Complete(context.Background(), "Say hello")
+			if err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			if result.Content != tc.want || result.FunctionCall != tc.wantCall {
+				t.Errorf("got %q calling %+v, want %q calling %+v", result.Content, result.FunctionCall, tc.want, tc.wantCall)
+			}
+		})
+	}
+}
+
+func TestMockInjectedError(t *testing.T) {
+	tests := []struct {
+		name     string
+		fixtures map[string]string
+		prompt   string
+		want     int
+	}{
+		{
+			name:     "matched",
+			fixtures: map[string]string{"KEY.error-429": `{"error":{"message":"Rate limit reached"}}`, "default": `{"content":"unused"}`},
+			prompt:   "Say hello",
+			want:     http.StatusTooManyRequests,
+		},
+		{
+			name:     "default",
+			fixtures: map[string]string{"default.error-503": `{"error":{"message":"Service unavailable"}}`},
+			prompt:   "Say goodbye",
+			want:     http.StatusServiceUnavailable,
+		},
+		{
+			name:     "with delay",
+			fixtures: map[string]string{"KEY.delay-1ms.error-500": `{"error":{"message":"Internal error"}}`},
+			prompt:   "Say hello",
+			want:     http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newMockClient(t, writeMockFixtures(t, tc.fixtures))
+			captureLogs(t)
+
+			_, err := client.Complete(context.Background(), tc.prompt)
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tc.want {
+				t.Fatalf("got error %v, want status %d", err, tc.want)
+			}
+			if !strings.Contains(apiErr.Body, `"error"`) {
+				t.Errorf("got body %q, want the fixture", apiErr.Body)
+			}
+		})
+	}
+}
+
+func TestMockDelay(t *testing.T) {
+	client := newMockClient(t, writeMockFixtures(t, map[string]string{"KEY.delay-50ms": `{"content":"Hello late"}`}))
+	captureLogs(t)
+
+	start := time.Now()
+	result, err := client.Complete(context.Background(), "Say hello")
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || result.Content != "Hello late" {
+		t.Errorf("got %q after %v, want Hello late after 50ms", result.Content, elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := client.Complete(ctx, "Say hello", WithoutCache()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want the deadline to cut the delay short", err)
+	}
+}
+
+func TestMockInvalidFixtures(t *testing.T) {
+	tests := []struct {
+		name     string
+		fixtures map[string]string
+		wantErr  string
+	}{
+		{name: "several", fixtures: map[string]string{"KEY": `{}`, "KEY.delay-1s": `{}`}, wantErr: "Several mock fixtures"},
+		{name: "unknown setting", fixtures: map[string]string{"KEY.retry-3": `{}`}, wantErr: `Unknown setting "retry-3"`},
+		{name: "bad delay", fixtures: map[string]string{"KEY.delay-soon": `{}`}, wantErr: "Invalid delay"},
+		{name: "bad status", fixtures: map[string]string{"default.error-200": `{}`}, wantErr: "must be 400 to 599"},
+		{name: "bad JSON", fixtures: map[string]string{"KEY": `{"content":`}, wantErr: "Invalid mock fixture"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newMockClient(t, writeMockFixtures(t, tc.fixtures))
+			captureLogs(t)
+
+			if _, err := client.Complete(context.Background(), "Say hello"); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("got error %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseMockFixtureName(t *testing.T) {
+	tests := []struct {
+		path   string
+		want   mockFixtureFile
+		wantOK bool
+	}{
+		{path: "fixtures/default.json", want: mockFixtureFile{path: "fixtures/default.json"}, wantOK: true},
+		{path: "fixtures/default.delay-2s.error-429.json", want: mockFixtureFile{path: "fixtures/default.delay-2s.error-429.json", delay: 2 * time.Second, status: 429}, wantOK: true},
+		{path: "fixtures/defaults.json"},
+		{path: "fixtures/default-old.json"},
+	}
+
+	for _, tc := range tests {
+		got, ok, err := parseMockFixtureName(tc.path, MOCK_DEFAULT_FIXTURE)
+		if err != nil || ok != tc.wantOK || got != tc.want {
+			t.Errorf("parseMockFixtureName(%q) = %+v, %v, %v, want %+v, %v", tc.path, got, ok, err, tc.want, tc.wantOK)
+		}
+	}
+}
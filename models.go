@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Response body from models endpoint
+type modelsResponse struct {
+	Data []ModelInfo `json:"data"`
+}
+
+// Model served by the API
+type ModelInfo struct {
+	ID      string `json:"id"`
+	OwnedBy string `json:"owned_by"`
+	// Unix time the model was created, zero when the API does not tell
+	Created int64 `json:"created"`
+}
+
+// Check connectivity and API key without spending chat tokens where the provider has a models endpoint,
+// CheckHealth tells how a failure is classified
+func (c *Client) Ping(ctx context.Context) error {
+	return c.CheckHealth(ctx).Err
+}
+
+// Get IDs of models available from llama API
+func (c *Client) ListModels(ctx context.Context) ([]string, error) {
+	models, err := c.Models(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(models))
+	for _, m := range models {
+		ids = append(ids, m.ID)
+	}
+	return ids, nil
+}
+
+// Get models available from llama API with their owner and creation time, in the order the API lists them
+func (c *Client) Models(ctx context.Context) ([]ModelInfo, error) {
+	res, body, err := c.getModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	//Unmarshal json response into Go struct
+	modelsRes := &modelsResponse{}
+	err = json.Unmarshal(body, modelsRes)
+	if err != nil {
+		err := newDecodeError(res, body, err)
+		log.Printf("Failed to unmarshal: %v", err)
+		return nil, err
+	}
+	return modelsRes.Data, nil
+}
+
+// Execute GET request to models endpoint and return response body
+func (c *Client) getModels(ctx context.Context) (*http.Response, []byte, error) {
+	ctx, requestID := ensureRequestID(ctx)
+	op := &operation{requestID: requestID, secrets: c.secrets(), debug: c.debug}
+
+	if c.missingAPIKey() {
+		op.logf("Failed to get API KEY: %v", ErrMissingAPIKey)
+		return nil, nil, ErrMissingAPIKey
+	}
+
+	if c.attemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.attemptTimeout)
+		defer cancel()
+	}
+
+	if c.provider.adapter != nil && c.provider.adapter.modelsPath() == "" {
+		err := fmt.Errorf("Provider %s has no models endpoint", c.provider.Name)
+		op.logf("Failed to get models: %v", err)
+		return nil, nil, err
+	}
+
+	//Create Http request struct for models endpoint derived from base URL
+	req, err := http.NewRequestWithContext(ctx, "GET", c.modelsURL(c.endpoints.primary()), nil)
+	if err != nil {
+		op.logf("Failed to create http request struct: %v", err)
+		return nil, nil, err
+	}
+	c.setAcceptEncoding(req, false)
+	c.setHeaders(req, op, c.apiKey)
+	if op.debug {
+		op.debugf("Sending %s %s with headers %v", req.Method, redactURL(req.URL), redactHeader(req.Header))
+	}
+
+	//Execute http request, network failures are wrapped to tell them apart from API errors
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		op.logf("Failed to get http response: %v", err)
+		return nil, nil, fmt.Errorf("Failed to connect to llama API: %w", err)
+	}
+	defer closeBody(res.Body)
+
+	//Read http response body
+	reader, err := c.responseReader(res, op)
+	if err != nil {
+		return res, nil, err
+	}
+	body, err := readBody(reader, c.maxResponseBytes)
+	if err != nil {
+		op.logf("Failed to read body: %v", err)
+		return res, nil, err
+	}
+
+	//Check if http status code and content type are as expected, 401 unwraps to ErrUnauthorized
+	if err := c.checkResponse(res, body); err != nil {
+		op.logf("Failed to get expected response: %v", err)
+		return res, nil, err
+	}
+
+	return res, body, nil
+}
+
+// Matches ModelNotFoundError with errors.Is
+var ErrModelNotFound = errors.New("Model not found")
+
+// Error returned when a model is not among those the API lists
+type ModelNotFoundError struct {
+	Model string
+	// Listed models closest to Model, best first
+	Suggestions []string
+}
+
+func (e *ModelNotFoundError) Error() string {
+	msg := fmt.Sprintf("Model %q not found", e.Model)
+	if len(e.Suggestions) > 0 {
+		msg += ", did you mean " + strings.Join(e.Suggestions, ", ") + "?"
+	}
+	return msg
+}
+
+func (e *ModelNotFoundError) Is(target error) bool {
+	return target == ErrModelNotFound
+}
+
+// Maximum number of models suggested for a mistyped name
+const MAX_MODEL_SUGGESTIONS = 3
+
+// Check model is listed by the models endpoint before spending a request on it,
+// failing with ModelNotFoundError which suggests close matches
+func (c *Client) CheckModel(ctx context.Context, model string) error {
+	ids, err := c.ListModels(ctx)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(ids, model) {
+		return nil
+	}
+	err = &ModelNotFoundError{Model: model, Suggestions: suggestModels(model, ids)}
+	log.Printf("Failed to check model: %v", err)
+	return err
+}
+
+// Find up to MAX_MODEL_SUGGESTIONS ids within edit distance of a third of the name, at least 2,
+// ignoring case. Ids containing the name, e.g. a missing prefix, are suggested as well.
+func suggestModels(model string, ids []string) []string {
+	name := strings.ToLower(model)
+	maxDistance := max(2, utf8.RuneCountInString(name)/3)
+
+	type candidate struct {
+		id       string
+		distance int
+	}
+	var candidates []candidate
+	for _, id := range ids {
+		lower := strings.ToLower(id)
+		distance := editDistance(name, lower)
+		if distance > maxDistance && !(name != "" && strings.Contains(lower, name)) {
+			continue
+		}
+		candidates = append(candidates, candidate{id: id, distance: distance})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	suggestions := make([]string, 0, MAX_MODEL_SUGGESTIONS)
+	for i := 0; i < len(candidates) && i < MAX_MODEL_SUGGESTIONS; i++ {
+		suggestions = append(suggestions, candidates[i].id)
+	}
+	return suggestions
+}
+
+// Get Levenshtein distance between a and b in runes
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// Run "models" subcommand printing models of the configured provider sorted by id
+func runModels(args []string, stdout io.Writer) error {
+	flagSet := flag.NewFlagSet("models", flag.ContinueOnError)
+	registerEnvFileFlag(flagSet)
+	selection, configFlags := registerConfigFlags(flagSet)
+	apiKeyFile := flagSet.String("api-key-file", "", "File containing the API key, takes precedence over LLAMA_API_KEY")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(selection, configFlags())
+	if err != nil {
+		return err
+	}
+	opts := cfg.options()
+	apiKey, _, err := commandAPIKey(cfg, *apiKeyFile)
+	if err != nil {
+		return err
+	}
+	if apiKey != "" {
+		opts = append(opts, WithAPIKey(apiKey))
+	}
+	client, err := NewClient(opts...)
+	if err != nil {
+		return err
+	}
+
+	models, err := client.Models(context.Background())
+	if err != nil {
+		return err
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].ID < models[j].ID })
+	for _, m := range models {
+		created := ""
+		if m.Created > 0 {
+			created = time.Unix(m.Created, 0).UTC().Format(time.DateOnly)
+		}
+		fmt.Fprintln(stdout, strings.TrimRight(fmt.Sprintf("%-40s %-20s %s", m.ID, m.OwnedBy, created), " "))
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Models listing of a fake API, unsorted like real ones
+const modelsJSON = `{"object":"list","data":[
+	{"id":"llama3-70b","object":"model","owned_by":"meta","created":1713398400},
+	{"id":"meta-llama/llama-3-70b-instruct","object":"model","owned_by":"meta"},
+	{"id":"llama3-8b","object":"model","owned_by":"meta","created":1713398400},
+	{"id":"codellama-34b","object":"model","owned_by":"meta","created":1693180800},
+	{"id":"mixtral-8x7b","object":"model","owned_by":"mistralai","created":1702339200}
+]}`
+
+func TestModels(t *testing.T) {
+	f, client := newFakeServer(t, respondJSON(http.StatusOK, modelsJSON))
+
+	models, err := client.Models(context.Background())
+	if err != nil {
+		t.Fatalf("Models: %v", err)
+	}
+	if len(models) != 5 {
+		t.Fatalf("got %d models, want 5", len(models))
+	}
+	if want := (ModelInfo{ID: "llama3-70b", OwnedBy: "meta", Created: 1713398400}); models[0] != want {
+		t.Errorf("got first model %+v, want %+v", models[0], want)
+	}
+	if models[1].Created != 0 {
+		t.Errorf("got created %d for a model without it, want 0", models[1].Created)
+	}
+
+	ids, err := client.ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("ListModels: %v", err)
+	}
+	want := []string{"llama3-70b", "meta-llama/llama-3-70b-instruct", "llama3-8b", "codellama-34b", "mixtral-8x7b"}
+	if !slices.Equal(ids, want) {
+		t.Errorf("got ids %q, want %q in the order of the API", ids, want)
+	}
+
+	req := f.captured()[0]
+	if req.Method != http.MethodGet || req.Path != "/models" {
+		t.Errorf("sent %s %s, want GET /models", req.Method, req.Path)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer "+testAPIKey {
+		t.Errorf("sent Authorization %q", got)
+	}
+}
+
+func TestModelsErrors(t *testing.T) {
+	captureLogs(t)
+
+	_, client := newFakeServer(t, respondJSON(http.StatusUnauthorized, `{"error":{"message":"Invalid API key"}}`))
+	if _, err := client.Models(context.Background()); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("got error %v, want ErrUnauthorized", err)
+	}
+
+	_, client = newFakeServer(t, respondJSON(http.StatusOK, `{"data":"llama3-70b"}`))
+	var decodeErr *DecodeError
+	if _, err := client.Models(context.Background()); !errors.As(err, &decodeErr) {
+		t.Errorf("got error %v, want DecodeError", err)
+	}
+}
+
+func TestRunModels(t *testing.T) {
+	f, _ := newFakeServer(t, respondJSON(http.StatusOK, modelsJSON))
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("LLAMA_API_KEY", testAPIKey)
+
+	var stdout bytes.Buffer
+	if err := runModels([]string{"-base-url", f.URL}, &stdout); err != nil {
+		t.Fatalf("models: %v", err)
+	}
+	want := []string{
+		"codellama-34b                            meta                 2023-08-28",
+		"llama3-70b                               meta                 2024-04-18",
+		"llama3-8b                                meta                 2024-04-18",
+		"meta-llama/llama-3-70b-instruct          meta",
+		"mixtral-8x7b                             mistralai            2023-12-12",
+	}
+	if got := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n"); !slices.Equal(got, want) {
+		t.Errorf("got output\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCheckModel(t *testing.T) {
+	tests := []struct {
+		name            string
+		model           string
+		wantSuggestions []string
+		wantFound       bool
+	}{
+		{name: "listed", model: "llama3-8b", wantFound: true},
+		{name: "typo", model: "llama3-7b", wantSuggestions: []string{"llama3-70b", "llama3-8b"}},
+		{name: "missing character", model: "lama3-70b", wantSuggestions: []string{"llama3-70b", "llama3-8b"}},
+		{name: "case", model: "Mixtral-8x7B", wantSuggestions: []string{"mixtral-8x7b"}},
+		{name: "missing prefix", model: "llama-3-70b-instruct", wantSuggestions: []string{"meta-llama/llama-3-70b-instruct"}},
+		{name: "unrelated", model: "gpt-4o"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, client := newFakeServer(t, respondJSON(http.StatusOK, modelsJSON))
+			captureLogs(t)
+
+			err := client.CheckModel(context.Background(), tc.model)
+			if tc.wantFound {
+				if err != nil {
+					t.Errorf("got error %v, want none", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrModelNotFound) || !IsModelNotFound(err) {
+				t.Fatalf("got error %v, want ErrModelNotFound", err)
+			}
+			var notFound *ModelNotFoundError
+			errors.As(err, &notFound)
+			if notFound.Model != tc.model || !slices.Equal(notFound.Suggestions, tc.wantSuggestions) {
+				t.Errorf("got %q with suggestions %q, want %q", notFound.Model, notFound.Suggestions, tc.wantSuggestions)
+			}
+			if len(tc.wantSuggestions) > 0 && !strings.Contains(err.Error(), "did you mean "+tc.wantSuggestions[0]) {
+				t.Errorf("got message %q, want the suggestions", err)
+			}
+		})
+	}
+}
+
+func TestSuggestModelsLimit(t *testing.T) {
+	ids := []string{"llama3-8b", "llama3-8b-q4", "llama3-8b-q8", "llama3-8b-fp16", "llama3-8c"}
+	got := suggestModels("llama3-8b-", ids)
+	if want := []string{"llama3-8b", "llama3-8b-q4", "llama3-8b-q8"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want the %d closest %q", got, MAX_MODEL_SUGGESTIONS, want)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "", b: "", want: 0},
+		{a: "llama", b: "", want: 5},
+		{a: "llama", b: "llama", want: 0},
+		{a: "llama", b: "lama", want: 1},
+		{a: "llama3-8b", b: "llama3-70b", want: 2},
+		{a: "kitten", b: "sitting", want: 3},
+		{a: "ラマ", b: "ラクダ", want: 2},
+	}
+
+	for _, tc := range tests {
+		if got := editDistance(tc.a, tc.b); got != tc.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+		if got := editDistance(tc.b, tc.a); got != tc.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tc.b, tc.a, got, tc.want)
+		}
+	}
+}
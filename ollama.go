@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Paths of Ollama's native API, next to its OpenAI compatible /v1
+const (
+	OLLAMA_TAGS_PATH = "/api/tags"
+	OLLAMA_PULL_PATH = "/api/pull"
+)
+
+// Model which Ollama has downloaded
+type LocalModel struct {
+	// Name with tag, e.g. "llama3:latest"
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	Digest     string    `json:"digest"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// Progress event of a model pull
+type PullProgress struct {
+	// e.g. "pulling manifest", "downloading" or "success"
+	Status string `json:"status"`
+	Digest string `json:"digest"`
+	// Bytes of the layer being downloaded, zero outside downloads
+	Total     int64  `json:"total"`
+	Completed int64  `json:"completed"`
+	Error     string `json:"error"`
+}
+
+// Get models downloaded by the Ollama server at base URL
+func (c *Client) ListLocalModels(ctx context.Context) ([]LocalModel, error) {
+	ctx, requestID := ensureRequestID(ctx)
+	op := &operation{requestID: requestID, secrets: c.secrets(), debug: c.debug}
+
+	res, err := c.sendOllama(ctx, op, "GET", OLLAMA_TAGS_PATH, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody(res.Body)
+
+	body, err := readBody(res.Body, c.maxResponseBytes)
+	if err != nil {
+		op.logf("Failed to read body: %v", err)
+		return nil, err
+	}
+	if err := c.checkResponse(res, body); err != nil {
+		op.logf("Failed to get expected response: %v", err)
+		return nil, err
+	}
+
+	var tags struct {
+		Models []LocalModel `json:"models"`
+	}
+	if err := json.Unmarshal(body, &tags); err != nil {
+		err := newDecodeError(res, body, err)
+		op.logf("Failed to unmarshal: %v", err)
+		return nil, err
+	}
+	return tags.Models, nil
+}
+
+// Download model to the Ollama server at base URL, passing each progress event to progress, which may be nil.
+// Events are read one line at a time as the server sends them, and the pull is done after the "success" event.
+func (c *Client) PullModel(ctx context.Context, name string, progress func(PullProgress)) error {
+	ctx, requestID := ensureRequestID(ctx)
+	op := &operation{requestID: requestID, secrets: c.secrets(), debug: c.debug}
+	if name == "" {
+		err := fmt.Errorf("%w: model name must not be empty", ErrInvalidRequest)
+		op.logf("Failed to pull model: %v", err)
+		return err
+	}
+
+	reqBody, err := json.Marshal(map[string]any{"model": name, "stream": true})
+	if err != nil {
+		op.logf("Failed to Marshal: %v", err)
+		return err
+	}
+	res, err := c.sendOllama(ctx, op, "POST", OLLAMA_PULL_PATH, reqBody)
+	if err != nil {
+		return err
+	}
+	defer closeBody(res.Body)
+
+	//Error responses are a single JSON object rather than a stream
+	if res.StatusCode != http.StatusOK {
+		body, err := readBody(res.Body, c.maxResponseBytes)
+		if err != nil {
+			op.logf("Failed to read body: %v", err)
+			return err
+		}
+		err = c.checkResponse(res, body)
+		op.logf("Failed to pull model: %v", err)
+		return err
+	}
+
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		event := PullProgress{}
+		if err := json.Unmarshal(line, &event); err != nil {
+			err := newDecodeError(res, line, err)
+			op.logf("Failed to unmarshal: %v", err)
+			return err
+		}
+		if event.Error != "" {
+			err := fmt.Errorf("Ollama failed to pull %s: %s", name, c.redact(event.Error))
+			op.logf("%v", err)
+			return err
+		}
+		if progress != nil {
+			progress(event)
+		}
+		if event.Status == "success" {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		err = fmt.Errorf("Failed to read pull progress: %w", err)
+		op.logf("%v", err)
+		return err
+	}
+	err = fmt.Errorf("Pull of %s ended before success: %w", name, io.ErrUnexpectedEOF)
+	op.logf("%v", err)
+	return err
+}
+
+// Send request to path of Ollama's native API, which lives at the root of the server rather than under /v1
+func (c *Client) sendOllama(ctx context.Context, op *operation, method, path string, body []byte) (*http.Response, error) {
+	baseURL := strings.TrimSuffix(c.endpoints.primary(), "/v1")
+	req, err := http.NewRequestWithContext(ctx, method, baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		op.logf("Failed to create http request struct: %v", err)
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	c.setHeaders(req, op, c.apiKey)
+	if op.debug {
+		op.debugf("Sending %s %s with headers %v", req.Method, redactURL(req.URL), redactHeader(req.Header))
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		op.logf("Failed to get http response: %v", err)
+		return nil, fmt.Errorf("Failed to connect to Ollama: %w", err)
+	}
+	return res, nil
+}
+
+// Check if err tells that the model does not exist, either from CheckModel
+// or from Ollama, which answers 404 for models it has not pulled
+func IsModelNotFound(err error) bool {
+	if errors.Is(err, ErrModelNotFound) {
+		return true
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		return false
+	}
+	body := strings.ToLower(apiErr.Body)
+	return strings.Contains(body, "model") && strings.Contains(body, "not found")
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Client configured like "-provider ollama -base-url <fake Ollama>", without any API key
+func newOllamaClient(t *testing.T, fixture string) (*fakeServer, *Client) {
+	t.Helper()
+	f, _ := newFakeServer(t, respondFixture(t, "ollama", fixture))
+	t.Setenv(Ollama.APIKeyEnv, "")
+	cfg, err := resolveConfig(map[string]string{"provider": "ollama", "base_url": f.URL + "/v1"}, fakeEnv(nil), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(append(cfg.options(), WithRetryPolicy(NoRetry))...)
+	if err != nil {
+		t.Fatalf("NewClient without API key: %v", err)
+	}
+	return f, client
+}
+
+func TestOllamaRecordedResponses(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		stream  bool
+		want    GenerateResult
+	}{
+		{
+			name:    "completion",
+			fixture: "completion.json",
+			want:    GenerateResult{Content: "Hello! How can I assist you today?", FinishReason: "stop", Usage: usage{PromptTokens: 12, CompletionTokens: 10, TotalTokens: 22}},
+		},
+		{
+			name:    "missing usage",
+			fixture: "no_usage.json",
+			want:    GenerateResult{Content: "Hello! How can I assist you today?", FinishReason: "stop"},
+		},
+		{
+			name:    "model length",
+			fixture: "length.json",
+			want:    GenerateResult{Content: "Hello! How can", FinishReason: "length", Usage: usage{PromptTokens: 12, CompletionTokens: 4, TotalTokens: 16}},
+		},
+		{
+			name:    "stream",
+			fixture: "stream.txt",
+			stream:  true,
+			want:    GenerateResult{Content: "Hello! How can I assist you today?", FinishReason: "stop"},
+		},
+		{
+			name:    "stream ending without done",
+			fixture: "stream_eos.txt",
+			stream:  true,
+			want:    GenerateResult{Content: "Hello! How can I assist you today?", FinishReason: "stop"},
+		},
+	}
+
+	messages := []reqMessage{{Role: "user", Content: "Say hello"}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, client := newOllamaClient(t, tc.fixture)
+
+			var result *GenerateResult
+			var err error
+			var deltas string
+			if tc.stream {
+				result, err = client.ChatStream(context.Background(), messages, func(delta string) error {
+					deltas += delta
+					return nil
+				})
+			} else {
+				result, err = client.Chat(context.Background(), messages)
+			}
+			if err != nil {
+				t.Fatalf("Chat: %v", err)
+			}
+			if result.Content != tc.want.Content || result.FinishReason != tc.want.FinishReason {
+				t.Errorf("got %q finishing %s, want %q finishing %s", result.Content, result.FinishReason, tc.want.Content, tc.want.FinishReason)
+			}
+			if tc.stream && deltas != tc.want.Content {
+				t.Errorf("streamed %q, want %q", deltas, tc.want.Content)
+			}
+			if !tc.stream && result.Usage != tc.want.Usage {
+				t.Errorf("got usage %+v, want %+v", result.Usage, tc.want.Usage)
+			}
+			if result.Model != "llama3" {
+				t.Errorf("got model %q, want llama3", result.Model)
+			}
+
+			req := f.captured()[0]
+			if req.Path != "/v1/chat/completions" {
+				t.Errorf("sent request to %s", req.Path)
+			}
+			if got, ok := req.Header["Authorization"]; ok {
+				t.Errorf("sent Authorization %q, want none without an API key", got)
+			}
+			var body chatRequest
+			if err := json.Unmarshal(req.Body, &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Model != Ollama.DefaultModel || body.Stream != tc.stream {
+				t.Errorf("sent model %q stream %v, want %q stream %v", body.Model, body.Stream, Ollama.DefaultModel, tc.stream)
+			}
+		})
+	}
+}
+
+func TestOllamaAPIKeyOptional(t *testing.T) {
+	f, _ := newFakeServer(t, respondFixture(t, "ollama", "completion.json"))
+	t.Setenv(Ollama.APIKeyEnv, "ollama-key")
+	client, err := NewClient(WithProvider(Ollama), WithBaseURL(f.URL+"/v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Complete(context.Background(), "Say hello"); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if got := f.captured()[0].Header.Get("Authorization"); got != "Bearer ollama-key" {
+		t.Errorf("sent Authorization %q, want the configured key", got)
+	}
+}
+
+// Error of Ollama's OpenAI compatible endpoint for a model it has not pulled
+const ollamaModelNotFound = `{"error":{"message":"model \\"llama3\\" not found, try pulling it first","type":"api_error","param":null,"code":null}}`
+
+// Progress events of a successful pull, one JSON object per line
+var ollamaPullEvents = []string{
+	`{"status":"pulling manifest"}`,
+	`{"status":"pulling 6a0746a1ec1a","digest":"sha256:6a0746a1ec1a","total":4661211424,"completed":0}`,
+	`{"status":"pulling 6a0746a1ec1a","digest":"sha256:6a0746a1ec1a","total":4661211424,"completed":2330605712}`,
+	`{"status":"pulling 6a0746a1ec1a","digest":"sha256:6a0746a1ec1a","total":4661211424,"completed":4661211424}`,
+	`{"status":"verifying sha256 digest"}`,
+	`{"status":"writing manifest"}`,
+	`{"status":"success"}`,
+}
+
+// Fake Ollama answering chat with 404 until a pull streaming events succeeds, or with chatStatus when set
+func scriptedOllama(pulled *atomic.Bool, chatStatus int, events ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case OLLAMA_PULL_PATH:
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			for _, event := range events {
+				io.WriteString(w, event+"\n")
+				w.(http.Flusher).Flush()
+				if strings.Contains(event, `"success"`) {
+					pulled.Store(true)
+				}
+			}
+		case "/v1" + CHAT_COMPLETIONS_PATH:
+			switch {
+			case chatStatus != 0:
+				respondJSON(chatStatus, `{"error":{"message":"llama runner process has terminated"}}`)(w, r)
+			case !pulled.Load():
+				respondJSON(http.StatusNotFound, ollamaModelNotFound)(w, r)
+			default:
+				respondChat("Hello from llama3")(w, r)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+func TestChatPullingIfMissing(t *testing.T) {
+	chatPath := "/v1" + CHAT_COMPLETIONS_PATH
+	tests := []struct {
+		name       string
+		pulled     bool
+		chatStatus int
+		events     []string
+		wantPaths  []string
+		wantErr    string
+	}{
+		{
+			name:      "not found then pulled",
+			events:    ollamaPullEvents,
+			wantPaths: []string{chatPath, OLLAMA_PULL_PATH, chatPath},
+		},
+		{
+			name:      "already pulled",
+			pulled:    true,
+			wantPaths: []string{chatPath},
+		},
+		{
+			name:      "pull fails",
+			events:    []string{`{"status":"pulling manifest"}`, `{"error":"pull model manifest: file does not exist"}`},
+			wantPaths: []string{chatPath, OLLAMA_PULL_PATH},
+			wantErr:   "Ollama failed to pull llama3: pull model manifest: file does not exist",
+		},
+		{
+			name:      "pull ends early",
+			events:    ollamaPullEvents[:3],
+			wantPaths: []string{chatPath, OLLAMA_PULL_PATH},
+			wantErr:   "ended before success",
+		},
+		{
+			name:       "other error",
+			chatStatus: http.StatusInternalServerError,
+			wantPaths:  []string{chatPath},
+			wantErr:    "status code: 500",
+		},
+	}
+
+	messages := []reqMessage{{Role: "user", Content: "Say hello"}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var pulled atomic.Bool
+			pulled.Store(tc.pulled)
+			f, _ := newFakeServer(t, scriptedOllama(&pulled, tc.chatStatus, tc.events...))
+			t.Setenv(Ollama.APIKeyEnv, "")
+			client := f.newClient(t, WithProvider(Ollama), WithBaseURL(f.URL+"/v1"), WithRetryPolicy(NoRetry))
+			captureLogs(t)
+
+			var progress bytes.Buffer
+			result, err := chatPullingIfMissing(context.Background(), client, messages, &progress)
+			var paths []string
+			for _, req := range f.captured() {
+				paths = append(paths, req.Path)
+			}
+			if !slices.Equal(paths, tc.wantPaths) {
+				t.Errorf("sent requests to %q, want %q", paths, tc.wantPaths)
+			}
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("got error %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("chatPullingIfMissing: %v", err)
+			}
+			if result.Content != "Hello from llama3" {
+				t.Errorf("got content %q", result.Content)
+			}
+			if tc.pulled {
+				if progress.Len() != 0 {
+					t.Errorf("showed progress %q without pulling", progress.String())
+				}
+				return
+			}
+
+			var pull struct {
+				Model  string `json:"model"`
+				Stream bool   `json:"stream"`
+			}
+			if err := json.Unmarshal(f.captured()[1].Body, &pull); err != nil {
+				t.Fatal(err)
+			}
+			if pull.Model != Ollama.DefaultModel || !pull.Stream {
+				t.Errorf("pulled %+v, want %s streaming", pull, Ollama.DefaultModel)
+			}
+			for _, want := range []string{"Pulling llama3\n", "pulling manifest", " 50%", "100%", "success"} {
+				if !strings.Contains(progress.String(), want) {
+					t.Errorf("progress %q lacks %q", progress.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestPullModelIncremental(t *testing.T) {
+	//The server holds back the rest of the events until the client reported the first one
+	firstSeen := make(chan struct{})
+	f, _ := newFakeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		io.WriteString(w, ollamaPullEvents[0]+"\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-firstSeen:
+		case <-time.After(5 * time.Second):
+			return
+		}
+		for _, event := range ollamaPullEvents[1:] {
+			io.WriteString(w, event+"\n")
+		}
+	})
+	client := f.newClient(t, WithProvider(Ollama), WithBaseURL(f.URL+"/v1"))
+
+	var statuses []string
+	err := client.PullModel(context.Background(), "llama3", func(p PullProgress) {
+		if len(statuses) == 0 {
+			close(firstSeen)
+		}
+		statuses = append(statuses, p.Status)
+	})
+	if err != nil {
+		t.Fatalf("PullModel: %v", err)
+	}
+	if len(statuses) != len(ollamaPullEvents) || statuses[0] != "pulling manifest" || statuses[len(statuses)-1] != "success" {
+		t.Errorf("got statuses %q, want one per event", statuses)
+	}
+	if f.captured()[0].Path != OLLAMA_PULL_PATH {
+		t.Errorf("pulled at %s, want %s outside /v1", f.captured()[0].Path, OLLAMA_PULL_PATH)
+	}
+}
+
+func TestPullModelErrors(t *testing.T) {
+	captureLogs(t)
+
+	f, _ := newFakeServer(t, respondJSON(http.StatusOK, "{}"))
+	client := f.newClient(t, WithProvider(Ollama), WithBaseURL(f.URL+"/v1"))
+	if err := client.PullModel(context.Background(), "", nil); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("got error %v for an empty name, want ErrInvalidRequest", err)
+	}
+
+	f, _ = newFakeServer(t, respondJSON(http.StatusInternalServerError, `{"error":"disk full"}`))
+	client = f.newClient(t, WithProvider(Ollama), WithBaseURL(f.URL+"/v1"))
+	var apiErr *APIError
+	if err := client.PullModel(context.Background(), "llama3", nil); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("got error %v, want the API error", err)
+	}
+
+	f, _ = newFakeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, ollamaPullEvents[0]+"\nnot json\n")
+	})
+	client = f.newClient(t, WithProvider(Ollama), WithBaseURL(f.URL+"/v1"))
+	var decodeErr *DecodeError
+	if err := client.PullModel(context.Background(), "llama3", nil); !errors.As(err, &decodeErr) {
+		t.Errorf("got error %v for a malformed event, want DecodeError", err)
+	}
+}
+
+func TestListLocalModels(t *testing.T) {
+	f, _ := newFakeServer(t, respondJSON(http.StatusOK, `{"models":[
+		{"name":"llama3:latest","model":"llama3:latest","modified_at":"2024-05-01T10:20:30.5+09:00","size":4661224676,"digest":"365c0bd3c000"},
+		{"name":"mistral:7b","model":"mistral:7b","modified_at":"2024-04-02T08:00:00Z","size":4109865159,"digest":"61e88e884507"}
+	]}`))
+	client := f.newClient(t, WithProvider(Ollama), WithBaseURL(f.URL+"/v1"))
+
+	models, err := client.ListLocalModels(context.Background())
+	if err != nil {
+		t.Fatalf("ListLocalModels: %v", err)
+	}
+	if len(models) != 2 || models[0].Name != "llama3:latest" || models[0].Size != 4661224676 || models[1].Digest != "61e88e884507" {
+		t.Errorf("got models %+v", models)
+	}
+	if want := time.Date(2024, 5, 1, 1, 20, 30, 5e8, time.UTC); !models[0].ModifiedAt.Equal(want) {
+		t.Errorf("got modified at %v, want %v", models[0].ModifiedAt, want)
+	}
+	if req := f.captured()[0]; req.Method != http.MethodGet || req.Path != OLLAMA_TAGS_PATH {
+		t.Errorf("sent %s %s, want GET %s", req.Method, req.Path, OLLAMA_TAGS_PATH)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Handler answering with a fixture of the testdata directory dir, as an event stream when name ends in .txt
+func respondFixture(t *testing.T, dir, name string) http.HandlerFunc {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(name, ".txt") {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write(data)
+			return
+		}
+		respondJSON(http.StatusOK, string(data))(w, r)
+	}
+}
+
+func TestOpenAIFixtures(t *testing.T) {
+	ignore := func(string) error { return nil }
+	messages := []reqMessage{{Role: "user", Content: "What is the weather in Tokyo?"}}
+	weatherCall := functionCall{Name: "get_weather", Arguments: `{"city":"Tokyo","unit":"celsius"}`}
+
+	tests := []struct {
+		name      string
+		fixture   string
+		functions bool
+		stream    bool
+		want      GenerateResult
+	}{
+		{
+			name:    "completion",
+			fixture: "completion.json",
+			want:    GenerateResult{Content: "Hello! How can I help you today?", FinishReason: "stop", Usage: usage{PromptTokens: 9, CompletionTokens: 9, TotalTokens: 18}},
+		},
+		{
+			name:      "function call",
+			fixture:   "function_call.json",
+			functions: true,
+			want:      GenerateResult{FinishReason: "function_call", FunctionCall: weatherCall, Usage: usage{PromptTokens: 74, CompletionTokens: 20, TotalTokens: 94}},
+		},
+		{
+			name:    "stream",
+			fixture: "stream.txt",
+			stream:  true,
+			want:    GenerateResult{Content: "Hello! How can I help you today?", FinishReason: "stop"},
+		},
+		{
+			name:      "stream function call",
+			fixture:   "stream_function_call.txt",
+			functions: true,
+			stream:    true,
+			want:      GenerateResult{FinishReason: "function_call", FunctionCall: weatherCall},
+		},
+	}
+
+	for _, provider := range []Provider{Llama, OpenAI} {
+		for _, tc := range tests {
+			t.Run(provider.Name+"/"+tc.name, func(t *testing.T) {
+				f, _ := newFakeServer(t, respondFixture(t, "openai", tc.fixture))
+				t.Setenv(provider.APIKeyEnv, "sk-"+provider.Name+"-test")
+				opts := []Option{WithProvider(provider), WithBaseURL(f.URL + "/v1"), WithRetryPolicy(NoRetry)}
+				if tc.functions {
+					opts = append(opts, WithFunctions(weatherFunction))
+				}
+				client, err := NewClient(opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var result *GenerateResult
+				if tc.stream {
+					result, err = client.ChatStream(context.Background(), messages, ignore)
+				} else {
+					result, err = client.Chat(context.Background(), messages)
+				}
+				if err != nil {
+					t.Fatalf("Chat: %v", err)
+				}
+				if result.Content != tc.want.Content || result.FinishReason != tc.want.FinishReason || result.FunctionCall != tc.want.FunctionCall {
+					t.Errorf("got %q finishing %s with call %+v, want %q finishing %s with call %+v",
+						result.Content, result.FinishReason, result.FunctionCall, tc.want.Content, tc.want.FinishReason, tc.want.FunctionCall)
+				}
+				if !tc.stream && result.Usage != tc.want.Usage {
+					t.Errorf("got usage %+v, want %+v", result.Usage, tc.want.Usage)
+				}
+				if result.Model != "gpt-4o-mini-2024-07-18" || result.RequestedModel != provider.DefaultModel {
+					t.Errorf("got model %s for %s, want gpt-4o-mini-2024-07-18 for %s", result.Model, result.RequestedModel, provider.DefaultModel)
+				}
+
+				req := f.captured()[0]
+				if req.Path != "/v1/chat/completions" {
+					t.Errorf("sent request to %s", req.Path)
+				}
+				if got := req.Header.Get("Authorization"); got != "Bearer sk-"+provider.Name+"-test" {
+					t.Errorf("sent Authorization %q, want the key of %s", got, provider.APIKeyEnv)
+				}
+				var body chatRequest
+				if err := json.Unmarshal(req.Body, &body); err != nil {
+					t.Fatal(err)
+				}
+				if body.Model != provider.DefaultModel || body.Stream != tc.stream {
+					t.Errorf("sent model %q stream %v, want %q stream %v", body.Model, body.Stream, provider.DefaultModel, tc.stream)
+				}
+				if tc.functions && (len(body.Functions) != 1 || body.Functions[0].Name != "get_weather") {
+					t.Errorf("sent functions %+v, want get_weather", body.Functions)
+				}
+			})
+		}
+	}
+}
+
+func TestOpenAIModelValidation(t *testing.T) {
+	clearClientEnv(t)
+	captureLogs(t)
+
+	if _, err := NewClient(WithProvider(OpenAI), WithAPIKey(testAPIKey), WithDefaultModel("llama3-70b")); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("got error %v for a llama model on OpenAI, want ErrInvalidRequest", err)
+	}
+
+	f, _ := newFakeServer(t, respondFixture(t, "openai", "completion.json"))
+	client := f.newClient(t, WithProvider(OpenAI), WithBaseURL(f.URL), WithAPIKey(testAPIKey))
+	for _, model := range []string{"gpt-4o", "o1-mini", "ft:gpt-4o-mini:acme::abc123"} {
+		if _, err := client.Complete(context.Background(), "Say hello", WithModel(model)); err != nil {
+			t.Errorf("Complete with %s: %v", model, err)
+		}
+	}
+	if _, err := client.Complete(context.Background(), "Say hello", WithModel("claude-3-opus")); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("got error %v for claude-3-opus on OpenAI, want ErrInvalidRequest", err)
+	}
+	if n := len(f.captured()); n != 3 {
+		t.Errorf("server got %d requests, want none for the invalid model", n)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestOpenRouterServedModel(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		stream  bool
+	}{
+		{name: "completion", fixture: "completion.json"},
+		{name: "stream", fixture: "stream.txt", stream: true},
+	}
+
+	allowFallbacks := true
+	routing := ProviderRouting{Order: []string{"Together", "DeepInfra"}, AllowFallbacks: &allowFallbacks}
+	messages := []reqMessage{{Role: "user", Content: "Say hello"}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, _ := newFakeServer(t, respondFixture(t, "openrouter", tc.fixture))
+			client := f.newClient(t, WithProvider(OpenRouter), WithBaseURL(f.URL), WithAPIKey(testAPIKey), WithProviderRouting(routing))
+
+			var result *GenerateResult
+			var err error
+			if tc.stream {
+				result, err = client.ChatStream(context.Background(), messages, func(string) error { return nil })
+			} else {
+				result, err = client.Chat(context.Background(), messages)
+			}
+			if err != nil {
+				t.Fatalf("Chat: %v", err)
+			}
+			if result.Content != "Hello! How can I help you today?" {
+				t.Errorf("got content %q", result.Content)
+			}
+			if result.Model != "meta-llama/llama-3.1-70b-instruct" || result.RequestedModel != OpenRouter.DefaultModel {
+				t.Errorf("got model %s for %s, want meta-llama/llama-3.1-70b-instruct for %s", result.Model, result.RequestedModel, OpenRouter.DefaultModel)
+			}
+			if result.UpstreamProvider != "DeepInfra" {
+				t.Errorf("got upstream provider %q, want DeepInfra", result.UpstreamProvider)
+			}
+
+			req := f.captured()[0]
+			for key, want := range map[string]string{"Http-Referer": "https://" + MODULE_PATH, "X-Title": "go-llama"} {
+				if got := req.Header.Get(key); got != want {
+					t.Errorf("sent %s %q, want %q", key, got, want)
+				}
+			}
+			var body chatRequest
+			if err := json.Unmarshal(req.Body, &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Provider == nil || !slices.Equal(body.Provider.Order, routing.Order) || body.Provider.AllowFallbacks == nil || !*body.Provider.AllowFallbacks {
+				t.Errorf("sent routing %+v, want %+v", body.Provider, routing)
+			}
+		})
+	}
+}
+
+func TestOpenRouterWithoutRouting(t *testing.T) {
+	f, _ := newFakeServer(t, respondFixture(t, "openrouter", "completion.json"))
+	client := f.newClient(t, WithProvider(OpenRouter), WithBaseURL(f.URL), WithAPIKey(testAPIKey), WithHeader("X-Title", "my-app"))
+
+	if _, err := client.Complete(context.Background(), "Say hello"); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	req := f.captured()[0]
+	if got := req.Header.Get("X-Title"); got != "my-app" {
+		t.Errorf("sent X-Title %q, want the one of WithHeader", got)
+	}
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(req.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if provider, ok := body["provider"]; ok {
+		t.Errorf("sent routing %s, want none", provider)
+	}
+}
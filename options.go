@@ -0,0 +1,804 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Option configures a Client
+type Option func(*Client) error
+
+// Set organization ID sent as OpenAI-Organization header
+func WithOrganization(org string) Option {
+	return func(c *Client) error {
+		c.org = org
+		return nil
+	}
+}
+
+// Set maximum number of retries of ExponentialBackoff retry policy
+func WithMaxRetries(n int) Option {
+	return func(c *Client) error {
+		if n < 0 {
+			return errors.New("Max retries must not be negative")
+		}
+		p, ok := c.retryPolicy.(ExponentialBackoff)
+		if !ok {
+			return errors.New("Max retries can only be set on ExponentialBackoff retry policy")
+		}
+		p.MaxRetries = n
+		c.retryPolicy = p
+		return nil
+	}
+}
+
+// Set policy deciding whether and when failed requests are retried
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) error {
+		if p == nil {
+			p = NoRetry
+		}
+		c.retryPolicy = p
+		return nil
+	}
+}
+
+// Set upper limit of the wait time requested by Retry-After header
+func WithMaxRetryAfter(d time.Duration) Option {
+	return func(c *Client) error {
+		c.maxRetryAfter = d
+		return nil
+	}
+}
+
+// Enable debug logging
+func WithDebug(debug bool) Option {
+	return func(c *Client) error {
+		c.debug = debug
+		return nil
+	}
+}
+
+// Set base URL of llama API, e.g. https://api.llama-api.com
+func WithBaseURL(baseURL string) Option {
+	return WithBaseURLs(baseURL)
+}
+
+// Set base URL along with the path of chat completions under it, for servers mounting the API
+// under a prefix, e.g. "https://host" and "/v1/chat/completions" or "https://host/v1" and "chat/completions".
+// Slashes between them are joined into one. The models endpoint is expected next to chat completions.
+func WithBaseURLPath(baseURL, completionsPath string) Option {
+	return func(c *Client) error {
+		if err := WithBaseURL(baseURL)(c); err != nil {
+			return err
+		}
+		return WithCompletionsPath(completionsPath)(c)
+	}
+}
+
+// Set path of chat completions under base URL, "/chat/completions" by default
+func WithCompletionsPath(completionsPath string) Option {
+	return func(c *Client) error {
+		path := strings.Trim(completionsPath, "/")
+		if path == "" {
+			return errors.New("Completions path must not be empty")
+		}
+		if strings.ContainsAny(path, "?#") || strings.Contains(path, "://") {
+			return fmt.Errorf("Completions path must be a path only, got %q", completionsPath)
+		}
+		c.completionsPath = "/" + path
+		return nil
+	}
+}
+
+// Set base URLs of the same API in order of preference.
+// Requests fail over to the next one on connection errors, 502 and 503.
+func WithBaseURLs(baseURLs ...string) Option {
+	return func(c *Client) error {
+		if len(baseURLs) == 0 {
+			return errors.New("Base URL must not be empty")
+		}
+		urls := make([]string, 0, len(baseURLs))
+		for _, baseURL := range baseURLs {
+			if baseURL == "" {
+				return errors.New("Base URL must not be empty")
+			}
+			urls = append(urls, strings.TrimRight(baseURL, "/"))
+		}
+		c.endpoints.urls = urls
+		return nil
+	}
+}
+
+// Set how long a failed base URL is skipped before it is tried again
+func WithEndpointCooldown(d time.Duration) Option {
+	return func(c *Client) error {
+		if d < 0 {
+			return errors.New("Endpoint cool-down must not be negative")
+		}
+		c.endpoints.cooldown = d
+		return nil
+	}
+}
+
+// Set overall deadline of an operation across all retries and backoff waits, zero means no limit.
+// It bounds every attempt as well: an attempt is cut short when the deadline comes first,
+// and a retry whose backoff would end after the deadline is not started. A deadline of
+// the caller's context applies in the same way, whichever is earlier wins.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) error {
+		if d < 0 {
+			return errors.New("Timeout must not be negative")
+		}
+		c.timeout = d
+		return nil
+	}
+}
+
+// Set timeout of each single attempt, zero means no limit.
+// An attempt exceeding it fails with ErrAttemptTimeout and is retried like a network timeout
+// while the overall deadline of WithTimeout leaves time, so one slow attempt does not use up the budget.
+// For streams it bounds the time until response headers arrive.
+func WithAttemptTimeout(d time.Duration) Option {
+	return func(c *Client) error {
+		if d < 0 {
+			return errors.New("Attempt timeout must not be negative")
+		}
+		c.attemptTimeout = d
+		return nil
+	}
+}
+
+// Send requests through given proxy instead of the one from HTTP_PROXY and HTTPS_PROXY.
+// Supports http, https and socks5 proxies, credentials can be given in the URL as user:password@.
+func WithProxy(proxyURL string) Option {
+	return func(c *Client) error {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			//url.Error quotes the raw URL, which may contain the password
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+			return fmt.Errorf("Invalid proxy URL: %w", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("Unsupported proxy scheme %q, must be http, https or socks5", u.Scheme)
+		}
+		if u.Host == "" {
+			return fmt.Errorf("Proxy URL %s has no host", redactURL(u))
+		}
+		c.transport.Proxy = http.ProxyURL(u)
+		c.debugf("Using proxy %s", redactURL(u))
+		return nil
+	}
+}
+
+// Connect to a local server listening on unix socket at path instead of over TCP.
+// Unless a base URL is configured, requests go to a dummy host, UNIX_SOCKET_BASE_URL.
+// Proxies are not used for socket connections.
+func WithUnixSocket(path string) Option {
+	return func(c *Client) error {
+		if path == "" {
+			return errors.New("Unix socket path must not be empty")
+		}
+		dialer := c.dialer
+		c.transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", path)
+		}
+		c.transport.Proxy = nil
+		if slices.Equal(c.endpoints.urls, []string{BASE_URL}) {
+			c.endpoints.urls = []string{UNIX_SOCKET_BASE_URL}
+		}
+		return nil
+	}
+}
+
+// Environment variable which must be set to 1 to allow WithInsecureSkipVerify
+const INSECURE_TLS_ENV = "LLAMA_ALLOW_INSECURE_TLS"
+
+// Use given TLS configuration for connections to llama API
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *Client) error {
+		if cfg == nil {
+			return errors.New("TLS config must not be nil")
+		}
+		c.transport.TLSClientConfig = cfg.Clone()
+		return nil
+	}
+}
+
+// Trust server certificates signed by given CA pool, e.g. a private CA of self-hosted server
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(c *Client) error {
+		if pool == nil {
+			return errors.New("CA cert pool must not be nil")
+		}
+		c.tlsConfig().RootCAs = pool
+		return nil
+	}
+}
+
+// Trust server certificates signed by CA certificates in given PEM, in addition to system CAs
+func WithCACert(pemBytes []byte) Option {
+	return func(c *Client) error {
+		return c.addCACert(pemBytes, "CA PEM")
+	}
+}
+
+// Trust server certificates signed by CA certificates in given PEM file, in addition to system CAs
+func WithCACertFile(path string) Option {
+	return func(c *Client) error {
+		pemBytes, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("Failed to read CA certificate %s: %w", path, err)
+		}
+		return c.addCACert(pemBytes, path)
+	}
+}
+
+// Authenticate to server with client certificate and key in PEM files, for servers requiring mTLS
+func WithClientCert(certFile, keyFile string) Option {
+	return func(c *Client) error {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("Failed to load client certificate %s with key %s: %w", certFile, keyFile, err)
+		}
+		cfg := c.tlsConfig()
+		cfg.Certificates = append(cfg.Certificates, cert)
+		return nil
+	}
+}
+
+// DANGEROUS: Skip verification of server certificates.
+// This makes connections open to man-in-the-middle attacks and is only meant for testing
+// against servers with self-signed certificates. It is refused unless LLAMA_ALLOW_INSECURE_TLS=1 is set.
+func WithInsecureSkipVerify(skip bool) Option {
+	return func(c *Client) error {
+		if skip && os.Getenv(INSECURE_TLS_ENV) != "1" {
+			return fmt.Errorf("Skipping TLS verification requires %s=1", INSECURE_TLS_ENV)
+		}
+		if skip {
+			log.Printf("WARNING: TLS certificate verification is disabled")
+		}
+		c.tlsConfig().InsecureSkipVerify = skip
+		return nil
+	}
+}
+
+// Wrap the transport of every request, e.g. with chaos.ChaosTransport to inject faults.
+// It wraps whatever the other options configured, the cassette of WithCassette included.
+func WithTransportWrapper(wrap func(http.RoundTripper) http.RoundTripper) Option {
+	return func(c *Client) error {
+		c.transportWrapper = wrap
+		return nil
+	}
+}
+
+// Set timeout of establishing connections, zero means no limit
+func WithDialTimeout(d time.Duration) Option {
+	return func(c *Client) error {
+		if d < 0 {
+			return errors.New("Dial timeout must not be negative")
+		}
+		c.dialer.Timeout = d
+		return nil
+	}
+}
+
+// Set maximum number of idle keep-alive connections across all hosts, zero means no limit
+func WithMaxIdleConns(n int) Option {
+	return func(c *Client) error {
+		if n < 0 {
+			return errors.New("Maximum idle connections must not be negative")
+		}
+		c.transport.MaxIdleConns = n
+		return nil
+	}
+}
+
+// Set maximum number of idle keep-alive connections per host.
+// Raise it to the number of concurrent requests so connections are not closed between batches.
+func WithMaxIdleConnsPerHost(n int) Option {
+	return func(c *Client) error {
+		if n < 0 {
+			return errors.New("Maximum idle connections per host must not be negative")
+		}
+		c.transport.MaxIdleConnsPerHost = n
+		return nil
+	}
+}
+
+// Set maximum number of connections per host including active ones, zero means no limit
+func WithMaxConnsPerHost(n int) Option {
+	return func(c *Client) error {
+		if n < 0 {
+			return errors.New("Maximum connections per host must not be negative")
+		}
+		c.transport.MaxConnsPerHost = n
+		return nil
+	}
+}
+
+// Set how long an idle keep-alive connection is kept open, zero means no limit
+func WithIdleConnTimeout(d time.Duration) Option {
+	return func(c *Client) error {
+		if d < 0 {
+			return errors.New("Idle connection timeout must not be negative")
+		}
+		c.transport.IdleConnTimeout = d
+		return nil
+	}
+}
+
+// Set timeout of TLS handshakes, zero means no limit
+func WithTLSHandshakeTimeout(d time.Duration) Option {
+	return func(c *Client) error {
+		if d < 0 {
+			return errors.New("TLS handshake timeout must not be negative")
+		}
+		c.transport.TLSHandshakeTimeout = d
+		return nil
+	}
+}
+
+// Set timeout of waiting for response headers after the request is written, zero means no limit.
+// Non-stream responses send headers only once generation is done, so long generations need a longer timeout or streaming.
+func WithResponseHeaderTimeout(d time.Duration) Option {
+	return func(c *Client) error {
+		if d < 0 {
+			return errors.New("Response header timeout must not be negative")
+		}
+		c.transport.ResponseHeaderTimeout = d
+		return nil
+	}
+}
+
+// Set timeout of waiting for 100-continue response, zero means sending the body immediately
+func WithExpectContinueTimeout(d time.Duration) Option {
+	return func(c *Client) error {
+		if d < 0 {
+			return errors.New("Expect continue timeout must not be negative")
+		}
+		c.transport.ExpectContinueTimeout = d
+		return nil
+	}
+}
+
+// Open circuit after given number of consecutive failures and fail fast with CircuitOpenError,
+// which matches ErrCircuitOpen and tells the time left, until cool-down has passed.
+// Then a single probe request decides whether to close it again. See Client.CircuitState.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(c *Client) error {
+		if threshold <= 0 {
+			return errors.New("Circuit breaker threshold must be positive")
+		}
+		if cooldown <= 0 {
+			return errors.New("Circuit breaker cool-down must be positive")
+		}
+		c.breaker = newCircuitBreaker(threshold, cooldown, c.clock)
+		return nil
+	}
+}
+
+// Limit requests per minute with a token bucket allowing given burst, zero rpm means unlimited
+func WithRateLimit(rpm, burst int) Option {
+	return func(c *Client) error {
+		if rpm < 0 || burst < 0 {
+			return errors.New("Rate limit must not be negative")
+		}
+		if rpm == 0 {
+			c.limiter = nil
+			return nil
+		}
+		c.limiter = newRateLimiter(rpm, burst, c.clock)
+		return nil
+	}
+}
+
+// Limit tokens per minute, counting estimated prompt tokens plus max_tokens per request, zero means unlimited
+func WithTokenRateLimit(tpm int) Option {
+	return func(c *Client) error {
+		if tpm < 0 {
+			return errors.New("Token rate limit must not be negative")
+		}
+		if tpm == 0 {
+			c.tokenLimiter = nil
+			return nil
+		}
+		c.tokenLimiter = newTokenWindow(tpm, c.clock)
+		return nil
+	}
+}
+
+// Set maximum number of tokens to generate, zero leaves it to the API
+func WithMaxTokens(n int) Option {
+	return func(c *Client) error {
+		if n < 0 {
+			return errors.New("Max tokens must not be negative")
+		}
+		c.maxTokens = n
+		return nil
+	}
+}
+
+// Set sampling temperature from 0 to 2, left to the API unless set
+func WithTemperature(temperature float64) Option {
+	return func(c *Client) error {
+		if temperature < 0 || temperature > 2 {
+			return fmt.Errorf("Temperature must be between 0 and 2, got %v", temperature)
+		}
+		c.temperature = &temperature
+		return nil
+	}
+}
+
+// Set sequences which stop generation when the model produces one of them, OpenAI allows up to 4
+func WithStop(sequences ...string) Option {
+	return func(c *Client) error {
+		for _, s := range sequences {
+			if s == "" {
+				return errors.New("Stop sequence must not be empty")
+			}
+		}
+		c.stop = sequences
+		return nil
+	}
+}
+
+// Rewrite text of user messages before the request is built, e.g. to strip control characters or append a suffix.
+// It applies to every method taking a prompt or messages, streams included. Requests passed to Send are sent as they are.
+func WithPromptPreprocessor(preprocess func(string) string) Option {
+	return func(c *Client) error {
+		c.promptPreprocessor = preprocess
+		return nil
+	}
+}
+
+// Rewrite generated text which Complete, Generate and getGeneratedResponse return, e.g. to trim
+// markdown fences or collapse whitespace. It only runs on success, and Chat results are returned as they are.
+func WithResponsePostprocessor(postprocess func(string) string) Option {
+	return func(c *Client) error {
+		c.postprocessor = postprocess
+		return nil
+	}
+}
+
+// Send only the last k messages of long conversations, besides the system messages they start with,
+// to stay within the context window. Trimming counts messages, not tokens, so k must be chosen to fit
+// the longest messages expected. Zero sends all messages.
+func WithMaxHistoryMessages(k int) Option {
+	return func(c *Client) error {
+		if k < 0 {
+			return errors.New("Maximum history messages must not be negative")
+		}
+		c.maxHistory = k
+		return nil
+	}
+}
+
+// Drop the oldest messages of long conversations until the prompt fits n tokens as EstimateTokens counts them.
+// System messages at the start and the latest user message are always sent, with a warning when they alone exceed n.
+// Applied after WithMaxHistoryMessages, zero sends all messages.
+func WithMaxPromptTokens(n int) Option {
+	return func(c *Client) error {
+		if n < 0 {
+			return errors.New("Maximum prompt tokens must not be negative")
+		}
+		c.maxPromptTokens = n
+		return nil
+	}
+}
+
+// Prepend system message with prompt to the messages of every request, e.g. to set a persona.
+// WithSystemPrompt replaces it in a single call, and messages which already start with
+// a system message are sent as they are.
+func WithDefaultSystemPrompt(prompt string) Option {
+	return func(c *Client) error {
+		c.systemPrompt = prompt
+		return nil
+	}
+}
+
+// Cache responses of identical requests under dir for ttl, zero ttl means entries never expire
+func WithCache(dir string, ttl time.Duration) Option {
+	return func(c *Client) error {
+		if dir == "" {
+			return errors.New("Cache directory must not be empty")
+		}
+		cache, err := newResponseCache(dir, ttl)
+		if err != nil {
+			return err
+		}
+		c.cache = cache
+		return nil
+	}
+}
+
+// Wait for reset time before sending a request when x-ratelimit-remaining-requests or
+// x-ratelimit-remaining-tokens reported by the API drops below threshold, zero disables each check
+func WithRateLimitThreshold(requests, tokens int) Option {
+	return func(c *Client) error {
+		if requests < 0 || tokens < 0 {
+			return errors.New("Rate limit threshold must not be negative")
+		}
+		c.rateLimits.requestsThreshold = requests
+		c.rateLimits.tokensThreshold = tokens
+		return nil
+	}
+}
+
+// Record request counts, errors, retries and latency into given metrics registry
+func WithMetrics(m *Metrics) Option {
+	return func(c *Client) error {
+		c.metrics = m
+		return nil
+	}
+}
+
+// Send an identical second request when no response headers arrive within delay and use
+// whichever completes first, zero disables hedging. Applies only to non-streaming requests.
+func WithHedging(delay time.Duration) Option {
+	return func(c *Client) error {
+		if delay < 0 {
+			return errors.New("Hedging delay must not be negative")
+		}
+		c.hedgeDelay = delay
+		return nil
+	}
+}
+
+// Make only one API call for concurrent identical requests and share its result among callers.
+// Leave it off when sampling the same prompt several times on purpose.
+func WithSingleflight() Option {
+	return func(c *Client) error {
+		c.flights = newFlightGroup()
+		return nil
+	}
+}
+
+// Attach function definitions to chat requests, which are sent without any by default
+func WithFunctions(functions ...function) Option {
+	return func(c *Client) error {
+		if err := validateFunctions(functions); err != nil {
+			return err
+		}
+		c.functions = append([]function{}, functions...)
+		return nil
+	}
+}
+
+// Control whether the model calls attached functions, "none" or "auto".
+// Omitted from requests when empty, leaving the choice to the API.
+func WithFunctionCall(mode string) Option {
+	return func(c *Client) error {
+		switch mode {
+		case "", "none", "auto":
+			c.functionCall = mode
+			return nil
+		default:
+			return fmt.Errorf("Unknown function call mode %q, must be none or auto", mode)
+		}
+	}
+}
+
+// Set whether the model may call several functions at once, false makes it call one at a time.
+// Omitted from requests unless set, leaving it to the server's default.
+func WithParallelToolCalls(enabled bool) Option {
+	return func(c *Client) error {
+		c.parallelToolCalls = &enabled
+		return nil
+	}
+}
+
+// Send routing preferences of OpenRouter with every request, see ProviderRouting
+func WithProviderRouting(routing ProviderRouting) Option {
+	return func(c *Client) error {
+		c.routing = &routing
+		return nil
+	}
+}
+
+// Make the model answer in given format, "text" or "json_object", see WithJSONSchema for "json_schema".
+// Omitted from requests when empty, leaving it to the API.
+func WithResponseFormat(formatType string) Option {
+	return func(c *Client) error {
+		switch formatType {
+		case "":
+			c.responseFormat = nil
+		case "text", "json_object":
+			c.responseFormat = &responseFormat{Type: formatType}
+		default:
+			return fmt.Errorf("Unknown response format %q, must be text or json_object", formatType)
+		}
+		return nil
+	}
+}
+
+// Make the model answer in JSON matching schema, with response_format "json_schema".
+// Content of responses is validated against the schema as well, failing with SchemaError
+// on mismatch, since not every server enforces it. strict asks the server to enforce it exactly.
+func WithJSONSchema(name string, schema json.RawMessage, strict bool) Option {
+	return func(c *Client) error {
+		if name == "" {
+			return errors.New("JSON schema name must not be empty")
+		}
+		parsed, err := parseJSONSchema(schema)
+		if err != nil {
+			return err
+		}
+		c.responseFormat = &responseFormat{
+			Type:       "json_schema",
+			JSONSchema: &jsonSchemaFormat{Name: name, Schema: schema, Strict: strict, schema: parsed},
+		}
+		return nil
+	}
+}
+
+// Request log probabilities of generated tokens, along with up to top most likely alternatives
+// at each position, from 0 to 20
+func WithLogprobs(top int) Option {
+	return func(c *Client) error {
+		if top < 0 || top > 20 {
+			return fmt.Errorf("Top logprobs must be between 0 and 20, got %d", top)
+		}
+		c.logprobs = true
+		c.topLogprobs = top
+		return nil
+	}
+}
+
+// Add header sent with every request, e.g. a key required by a gateway.
+// Applied after default headers, so it replaces them, except Authorization and Content-Type
+// which require WithUnsafeHeaders(true). Can be repeated for multiple values.
+func WithHeader(key, value string) Option {
+	return WithHeaders(http.Header{key: {value}})
+}
+
+// Add all values of header to every request, see WithHeader
+func WithHeaders(header http.Header) Option {
+	return func(c *Client) error {
+		//Protected headers are checked once all options are applied
+		if err := validateHeaders(header, true); err != nil {
+			return err
+		}
+		if c.header == nil {
+			c.header = http.Header{}
+		}
+		for key, values := range header {
+			for _, value := range values {
+				c.header.Add(key, value)
+			}
+		}
+		return nil
+	}
+}
+
+// DANGEROUS: Allow WithHeader and WithRequestHeader to replace Authorization and Content-Type headers
+func WithUnsafeHeaders(allow bool) Option {
+	return func(c *Client) error {
+		c.unsafeHeaders = allow
+		return nil
+	}
+}
+
+// Choose how responses are compressed, see Compression
+func WithCompression(mode Compression) Option {
+	return func(c *Client) error {
+		switch mode {
+		case CompressionAuto:
+			c.transport.DisableCompression = false
+		case CompressionGzip, CompressionNone:
+			//Keep net/http from negotiating or decompressing on its own
+			c.transport.DisableCompression = true
+		default:
+			return fmt.Errorf("Unknown compression mode: %d", mode)
+		}
+		c.compression = mode
+		return nil
+	}
+}
+
+// Set model used for requests, overriding LLAMA_MODEL environment variable
+func WithDefaultModel(model string) Option {
+	return func(c *Client) error {
+		if model == "" {
+			return errors.New("Model must not be empty")
+		}
+		c.model = model
+		return nil
+	}
+}
+
+// Re-issue requests with given models in order when the primary model exhausts retries
+// with 429, 5xx or overloaded errors
+func WithFallbackModels(models ...string) Option {
+	return func(c *Client) error {
+		for _, model := range models {
+			if model == "" {
+				return errors.New("Fallback model must not be empty")
+			}
+		}
+		c.fallbackModels = append([]string{}, models...)
+		return nil
+	}
+}
+
+// Bias likelihood of token IDs appearing in the output, from -100 (ban) to 100 (force)
+func WithLogitBias(bias map[string]float64) Option {
+	return func(c *Client) error {
+		for token, value := range bias {
+			if value < -100 || value > 100 {
+				return fmt.Errorf("Logit bias of token %s must be between -100 and 100, got %v", token, value)
+			}
+		}
+		c.logitBias = maps.Clone(bias)
+		return nil
+	}
+}
+
+// Set header name carrying idempotency key, which is a UUID shared by all retries of an operation.
+// Empty name disables the header.
+func WithIdempotencyKeyHeader(name string) Option {
+	return func(c *Client) error {
+		c.idempotencyHeader = name
+		return nil
+	}
+}
+
+// Set maximum size of response bodies, larger ones fail with ResponseTooLargeError
+func WithMaxResponseBytes(n int64) Option {
+	return func(c *Client) error {
+		if n <= 0 {
+			return errors.New("Max response bytes must be positive")
+		}
+		c.maxResponseBytes = n
+		return nil
+	}
+}
+
+// Set User-Agent header identifying requests in provider logs
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) error {
+		if userAgent == "" {
+			return errors.New("User agent must not be empty")
+		}
+		c.userAgent = userAgent
+		return nil
+	}
+}
+
+// Append suffix to User-Agent header, e.g. "my-app/1.2" to identify the application using this client
+func WithUserAgentSuffix(suffix string) Option {
+	return func(c *Client) error {
+		if strings.ContainsAny(suffix, "\r\n") {
+			return errors.New("User agent suffix must not contain line breaks")
+		}
+		c.userAgentSuffix = strings.TrimSpace(suffix)
+		return nil
+	}
+}
+
+// Reject responses having fields unknown to this client instead of silently ignoring them.
+// Lenient decoding is the default, strict decoding helps to notice provider schema drift.
+func WithStrictDecoding(strict bool) Option {
+	return func(c *Client) error {
+		c.strictDecoding = strict
+		return nil
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Read a line from terminal without echoing it, or a plain line when f is not a terminal
+func readPassword(f *os.File) (string, error) {
+	if !isTerminal(f) {
+		return readLine(f)
+	}
+
+	echoOff := exec.Command("stty", "-echo")
+	echoOff.Stdin = f
+	if err := echoOff.Run(); err != nil {
+		return "", err
+	}
+	defer func() {
+		echoOn := exec.Command("stty", "echo")
+		echoOn.Stdin = f
+		echoOn.Run()
+	}()
+	return readLine(f)
+}
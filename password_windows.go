@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Console mode flag echoing typed characters
+const ENABLE_ECHO_INPUT = 0x0004
+
+var procSetConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+
+// Read a line from console without echoing it, or a plain line when f is not a console
+func readPassword(f *os.File) (string, error) {
+	handle := syscall.Handle(f.Fd())
+	var mode uint32
+	if err := syscall.GetConsoleMode(handle, &mode); err != nil {
+		return readLine(f)
+	}
+
+	ret, _, err := procSetConsoleMode.Call(uintptr(handle), uintptr(mode&^ENABLE_ECHO_INPUT))
+	if ret == 0 {
+		return "", err
+	}
+	defer procSetConsoleMode.Call(uintptr(handle), uintptr(mode))
+	return readLine(f)
+}
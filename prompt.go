@@ -0,0 +1,35 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"text/template"
+)
+
+// Template of prompt asking for an example sentence, rendered with a struct having Words field
+const EXAMPLE_SENTENCE_PROMPT = "Please create an English example sentence using following words: {{join .Words \", \"}}"
+
+// Functions available in prompt templates
+var promptFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// Render prompt template such as "Create a sentence using {{.Words}}" with given data
+func RenderPrompt(tmpl string, data any) (string, error) {
+	//Parse template, referring to a missing key is reported as an error
+	t, err := template.New("prompt").Funcs(promptFuncs).Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		log.Printf("Failed to parse prompt template: %v", err)
+		return "", err
+	}
+
+	//Execute template with data
+	var sb strings.Builder
+	err = t.Execute(&sb, data)
+	if err != nil {
+		log.Printf("Failed to render prompt template: %v", err)
+		return "", err
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Environment variable selecting provider preset of the command
+const PROVIDER_ENV = "GO_LLAMA_PROVIDER"
+
+// Preset of an OpenAI compatible API: where it is, which key it takes and which models it serves
+type Provider struct {
+	Name         string
+	BaseURL      string
+	APIKeyEnv    string
+	DefaultModel string
+	// Prefixes of plausible model names, any model is accepted when empty
+	ModelPrefixes []string
+	// Whether requests are sent without Authorization header when no key is configured, e.g. to a local server
+	APIKeyOptional bool
+	// Optional request fields the provider accepts, nil when it accepts all of them
+	Capabilities *Capabilities
+	// Headers sent with every request, client and per-call headers replace them
+	Header http.Header
+	// Translation of requests and responses for APIs which are not OpenAI compatible, nil otherwise
+	adapter apiAdapter
+	// Serves requests in place of the network, e.g. fixtures of MockProvider, nil otherwise
+	transport http.RoundTripper
+}
+
+var (
+	// llama API, used unless another provider is chosen
+	Llama = Provider{
+		Name:         "llama",
+		BaseURL:      BASE_URL,
+		APIKeyEnv:    "LLAMA_API_KEY",
+		DefaultModel: DEFAULT_MODEL,
+	}
+
+	// OpenAI API at api.openai.com
+	OpenAI = Provider{
+		Name:          "openai",
+		BaseURL:       "https://api.openai.com/v1",
+		APIKeyEnv:     "OPENAI_API_KEY",
+		DefaultModel:  "gpt-4o-mini",
+		ModelPrefixes: []string{"gpt-", "chatgpt-", "o1", "o3", "o4", "ft:"},
+	}
+
+	// Ollama running locally, through its OpenAI compatible endpoint which needs no API key
+	Ollama = Provider{
+		Name:           "ollama",
+		BaseURL:        "http://localhost:11434/v1",
+		APIKeyEnv:      "OLLAMA_API_KEY",
+		DefaultModel:   "llama3",
+		APIKeyOptional: true,
+	}
+
+	// OpenRouter, routing requests to upstream providers of many models
+	OpenRouter = Provider{
+		Name:         "openrouter",
+		BaseURL:      "https://openrouter.ai/api/v1",
+		APIKeyEnv:    "OPENROUTER_API_KEY",
+		DefaultModel: "meta-llama/llama-3-70b-instruct",
+		//Identify the app on OpenRouter rankings, WithHeader can replace them
+		Header: http.Header{
+			"Http-Referer": {"https://" + MODULE_PATH},
+			"X-Title":      {"go-llama"},
+		},
+	}
+
+	// OpenAI compatible backend configured by base URL, see CompatibleProvider
+	Compatible = Provider{
+		Name:           "compatible",
+		APIKeyOptional: true,
+	}
+)
+
+// Provider presets selectable by name
+var providers = []Provider{Llama, OpenAI, Ollama, OpenRouter, Anthropic, Bedrock, Mock, Compatible}
+
+// Find provider preset by name, e.g. "openai"
+func ParseProvider(name string) (Provider, error) {
+	for _, p := range providers {
+		if strings.EqualFold(p.Name, name) {
+			return p, nil
+		}
+	}
+	return Provider{}, fmt.Errorf("Unknown provider %q, must be one of %s", name, providerNames())
+}
+
+// Check model name looks like one served by the provider
+func (p Provider) validateModel(model string) error {
+	if len(p.ModelPrefixes) == 0 {
+		return nil
+	}
+	for _, prefix := range p.ModelPrefixes {
+		if strings.HasPrefix(model, prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: model %q does not look like a model of %s, expected a name starting with %s",
+		ErrInvalidRequest, model, p.Name, strings.Join(p.ModelPrefixes, ", "))
+}
+
+// Send requests to provider, using its base URL, default model and API key from its environment variable.
+// Choosing Llama, the default provider, keeps the settings from LLAMA_* environment variables.
+// Later options such as WithBaseURL, WithDefaultModel and WithAPIKey override the preset.
+func WithProvider(p Provider) Option {
+	return func(c *Client) error {
+		if p.Name == "" || p.BaseURL == "" {
+			return errors.New("Provider must have a name and base URL")
+		}
+		if p.Name != c.provider.Name {
+			if err := WithBaseURL(p.BaseURL)(c); err != nil {
+				return err
+			}
+			c.model = p.DefaultModel
+
+			//Keys of another provider must not be sent to this one
+			c.apiKey = os.Getenv(p.APIKeyEnv)
+			c.apiKeys = nil
+		}
+		c.provider = p
+		c.httpClient.Transport = c.transport
+		if p.transport != nil {
+			c.httpClient.Transport = p.transport
+		}
+		return nil
+	}
+}
+
+// Get names of provider presets for usage messages
+func providerNames() string {
+	names := make([]string, 0, len(providers))
+	for _, p := range providers {
+		names = append(names, p.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Check if requests cannot be sent for lack of an API key
+func (c *Client) missingAPIKey() bool {
+	return c.apiKey == "" && !c.provider.APIKeyOptional
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token bucket limiting number of requests per minute, shared by all goroutines using a Client
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+	clock    clock
+}
+
+func newRateLimiter(rpm, burst int, clk clock) *rateLimiter {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &rateLimiter{
+		interval: time.Minute / time.Duration(rpm),
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     clk.Now(),
+		clock:    clk,
+	}
+}
+
+// Block until a request may be sent or context is done
+func (l *rateLimiter) wait(ctx context.Context) error {
+	//Reserve a token, going into debt if the bucket is empty so that waiters are spaced evenly
+	l.mu.Lock()
+	now := l.clock.Now()
+	l.tokens += float64(now.Sub(l.last)) / float64(l.interval)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens * float64(l.interval))
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	//Give reservation back when caller stops waiting
+	if err := l.clock.Sleep(ctx, delay); err != nil {
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Rate limits reported by x-ratelimit-* response headers.
+// Counts are -1 and reset times are zero when the header was missing or malformed.
+type RateLimits struct {
+	LimitRequests     int
+	LimitTokens       int
+	RemainingRequests int
+	RemainingTokens   int
+	ResetRequests     time.Time
+	ResetTokens       time.Time
+}
+
+// Last rate limits seen in responses and thresholds below which requests wait for reset
+type rateLimitState struct {
+	mu                sync.Mutex
+	limits            RateLimits
+	seen              bool
+	requestsThreshold int
+	tokensThreshold   int
+}
+
+// Parse x-ratelimit-* headers, reporting false when none of them is present
+func parseRateLimitHeaders(h http.Header, now time.Time) (RateLimits, bool) {
+	limits := RateLimits{
+		LimitRequests:     parseRateLimitCount(h.Get("x-ratelimit-limit-requests")),
+		LimitTokens:       parseRateLimitCount(h.Get("x-ratelimit-limit-tokens")),
+		RemainingRequests: parseRateLimitCount(h.Get("x-ratelimit-remaining-requests")),
+		RemainingTokens:   parseRateLimitCount(h.Get("x-ratelimit-remaining-tokens")),
+		ResetRequests:     parseRateLimitReset(h.Get("x-ratelimit-reset-requests"), now),
+		ResetTokens:       parseRateLimitReset(h.Get("x-ratelimit-reset-tokens"), now),
+	}
+
+	found := limits.LimitRequests >= 0 || limits.LimitTokens >= 0 ||
+		limits.RemainingRequests >= 0 || limits.RemainingTokens >= 0 ||
+		!limits.ResetRequests.IsZero() || !limits.ResetTokens.IsZero()
+	return limits, found
+}
+
+// Parse count header, -1 when missing or malformed
+func parseRateLimitCount(value string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// Unix time of the end of year 9999, later reset timestamps are malformed
+const MAX_RESET_TIMESTAMP = 253402300799
+
+// Parse reset header given as duration ("1m30s", "20ms"), seconds ("30", "0.5")
+// or unix timestamp, zero time when missing or malformed
+func parseRateLimitReset(value string, now time.Time) time.Time {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}
+	}
+
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(d)
+	}
+
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+		return time.Time{}
+	}
+
+	//Values as large as a timestamp are taken as unix time rather than remaining seconds
+	if seconds > 1e9 {
+		//Beyond year 9999 the value is garbage, and conversion of larger floats to int64 is undefined
+		if seconds > MAX_RESET_TIMESTAMP {
+			return time.Time{}
+		}
+		return time.Unix(int64(seconds), 0)
+	}
+	return now.Add(time.Duration(seconds * float64(time.Second)))
+}
+
+// Store rate limits from response headers received at now
+func (s *rateLimitState) update(h http.Header, now time.Time) {
+	limits, ok := parseRateLimitHeaders(h, now)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits = limits
+	s.seen = true
+}
+
+// Get last seen rate limits, false when no response had x-ratelimit-* headers yet
+func (s *rateLimitState) get() (RateLimits, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limits, s.seen
+}
+
+// Get time until which requests should wait because remaining quota dropped below threshold
+func (s *rateLimitState) waitUntil() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var until time.Time
+	if !s.seen {
+		return until
+	}
+	if s.requestsThreshold > 0 && s.limits.RemainingRequests >= 0 && s.limits.RemainingRequests < s.requestsThreshold {
+		until = s.limits.ResetRequests
+	}
+	if s.tokensThreshold > 0 && s.limits.RemainingTokens >= 0 && s.limits.RemainingTokens < s.tokensThreshold &&
+		s.limits.ResetTokens.After(until) {
+		until = s.limits.ResetTokens
+	}
+	return until
+}
+
+// Get rate limits reported by the last response which had x-ratelimit-* headers
+func (c *Client) RateLimits() (RateLimits, bool) {
+	return c.rateLimits.get()
+}
+
+// Sleep until quota resets when remaining quota reported by the API is below threshold
+func (c *Client) waitForRateLimitReset(ctx context.Context) error {
+	wait := c.rateLimits.waitUntil().Sub(c.clock.Now())
+	if wait <= 0 {
+		return nil
+	}
+
+	c.debugf("Remaining rate limit is below threshold, waiting %v for reset", wait)
+	return c.clock.Sleep(ctx, wait)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Fake clock whose time stands still while sleeping, so that concurrent waiters reserve at the same time
+type frozenClock struct {
+	*fakeClock
+}
+
+func (c frozenClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sleeps = append(c.sleeps, d)
+	return nil
+}
+
+func TestRateLimitSpacesRequests(t *testing.T) {
+	tests := []struct {
+		name  string
+		burst int
+		// Seconds after the first request at which each request is sent
+		want []int
+	}{
+		{name: "no burst", burst: 1, want: []int{0, 30, 60, 90, 120, 150, 180, 210, 240, 270}},
+		{name: "burst of 3", burst: 3, want: []int{0, 0, 0, 30, 60, 90, 120, 150, 180, 210}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clk := newFakeClock()
+			start := clk.Now()
+			var mu sync.Mutex
+			var sent []int
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				sent = append(sent, int(clk.Now().Sub(start)/time.Second))
+				mu.Unlock()
+				respondJSON(http.StatusOK, chatCompletionJSON("Hello there"))(w, r)
+			}
+			_, client := newFakeServer(t, handler, withClock(clk), WithRateLimit(2, tc.burst))
+
+			for i := 0; i < 10; i++ {
+				if _, err := client.Complete(context.Background(), "Say hello"); err != nil {
+					t.Fatalf("Complete %d: %v", i+1, err)
+				}
+			}
+			if !slices.Equal(sent, tc.want) {
+				t.Errorf("requests sent at %v seconds, want %v", sent, tc.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterSharedByGoroutines(t *testing.T) {
+	clk := frozenClock{newFakeClock()}
+	limiter := newRateLimiter(2, 1, clk)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := limiter.wait(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	//The first request goes at once, the others queue up 30s apart
+	slept := clk.slept()
+	slices.Sort(slept)
+	want := []time.Duration{}
+	for i := 1; i < 10; i++ {
+		want = append(want, time.Duration(i)*30*time.Second)
+	}
+	if !slices.Equal(slept, want) {
+		t.Errorf("waited %v, want %v", slept, want)
+	}
+}
+
+func TestRateLimiterCancelledWaitGivesReservationBack(t *testing.T) {
+	clk := frozenClock{newFakeClock()}
+	limiter := newRateLimiter(2, 1, clk)
+	if err := limiter.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	//The next waiter takes the slot of the cancelled one instead of queueing behind it
+	if err := limiter.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if slept := clk.slept(); !slices.Equal(slept, []time.Duration{30 * time.Second}) {
+		t.Errorf("waited %v, want [30s]", slept)
+	}
+}
+
+func TestParseRateLimitReset(t *testing.T) {
+	now := newFakeClock().Now()
+	tests := []struct {
+		name  string
+		value string
+		want  time.Time
+	}{
+		{name: "duration", value: "1m30s", want: now.Add(90 * time.Second)},
+		{name: "milliseconds", value: "20ms", want: now.Add(20 * time.Millisecond)},
+		{name: "seconds", value: "30", want: now.Add(30 * time.Second)},
+		{name: "fractional seconds", value: " 0.5 ", want: now.Add(500 * time.Millisecond)},
+		{name: "zero", value: "0", want: now},
+		{name: "unix timestamp", value: "1709301600", want: time.Unix(1709301600, 0)},
+		{name: "fractional unix timestamp", value: "1709301600.75", want: time.Unix(1709301600, 0)},
+		{name: "empty", value: "", want: time.Time{}},
+		{name: "garbage", value: "soon", want: time.Time{}},
+		{name: "negative seconds", value: "-5", want: time.Time{}},
+		{name: "negative duration", value: "-5s", want: time.Time{}},
+		{name: "infinity", value: "Inf", want: time.Time{}},
+		{name: "NaN", value: "NaN", want: time.Time{}},
+		{name: "timestamp past year 9999", value: "1e300", want: time.Time{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := parseRateLimitReset(tc.value, now); !got.Equal(tc.want) {
+				t.Errorf("parseRateLimitReset(%q) = %v, want %v", tc.value, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseRateLimitHeaders(t *testing.T) {
+	now := newFakeClock().Now()
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   RateLimits
+		wantOK bool
+	}{
+		{
+			name: "all headers",
+			header: map[string]string{
+				"x-ratelimit-limit-requests": "60", "x-ratelimit-limit-tokens": "150000",
+				"x-ratelimit-remaining-requests": "59", "x-ratelimit-remaining-tokens": "149984",
+				"x-ratelimit-reset-requests": "1s", "x-ratelimit-reset-tokens": "6m0s",
+			},
+			want: RateLimits{
+				LimitRequests: 60, LimitTokens: 150000, RemainingRequests: 59, RemainingTokens: 149984,
+				ResetRequests: now.Add(time.Second), ResetTokens: now.Add(6 * time.Minute),
+			},
+			wantOK: true,
+		},
+		{
+			name:   "only remaining requests",
+			header: map[string]string{"x-ratelimit-remaining-requests": "3"},
+			want:   RateLimits{LimitRequests: -1, LimitTokens: -1, RemainingRequests: 3, RemainingTokens: -1},
+			wantOK: true,
+		},
+		{
+			name:   "reset as unix timestamp",
+			header: map[string]string{"x-ratelimit-reset-tokens": "1709301600"},
+			want:   RateLimits{LimitRequests: -1, LimitTokens: -1, RemainingRequests: -1, RemainingTokens: -1, ResetTokens: time.Unix(1709301600, 0)},
+			wantOK: true,
+		},
+		{
+			name:   "garbage",
+			header: map[string]string{"x-ratelimit-remaining-requests": "many", "x-ratelimit-limit-tokens": "-1", "x-ratelimit-reset-requests": "later"},
+			want:   RateLimits{LimitRequests: -1, LimitTokens: -1, RemainingRequests: -1, RemainingTokens: -1},
+		},
+		{
+			name: "none",
+			want: RateLimits{LimitRequests: -1, LimitTokens: -1, RemainingRequests: -1, RemainingTokens: -1},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := http.Header{}
+			for name, value := range tc.header {
+				h.Set(name, value)
+			}
+			got, ok := parseRateLimitHeaders(h, now)
+			if ok != tc.wantOK {
+				t.Errorf("got found %v, want %v", ok, tc.wantOK)
+			}
+			if got.LimitRequests != tc.want.LimitRequests || got.LimitTokens != tc.want.LimitTokens ||
+				got.RemainingRequests != tc.want.RemainingRequests || got.RemainingTokens != tc.want.RemainingTokens ||
+				!got.ResetRequests.Equal(tc.want.ResetRequests) || !got.ResetTokens.Equal(tc.want.ResetTokens) {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitThresholdWaitsForReset(t *testing.T) {
+	clk := newFakeClock()
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ratelimit-remaining-requests", "1")
+		w.Header().Set("x-ratelimit-reset-requests", "20s")
+		respondJSON(http.StatusOK, chatCompletionJSON("Hello there"))(w, r)
+	}
+	_, client := newFakeServer(t, handler, withClock(clk), WithRateLimitThreshold(2, 0))
+
+	result, err := client.Complete(context.Background(), "Say hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Relative resets count from the time of the client clock
+	if want := clk.Now().Add(20 * time.Second); result.RateLimits == nil || !result.RateLimits.ResetRequests.Equal(want) {
+		t.Fatalf("got rate limits %+v, want requests reset at %v", result.RateLimits, want)
+	}
+	if limits, ok := client.RateLimits(); !ok || !limits.ResetRequests.Equal(clk.Now().Add(20*time.Second)) {
+		t.Fatalf("client got rate limits %+v", limits)
+	}
+
+	clk.Advance(5 * time.Second)
+	if _, err := client.Complete(context.Background(), "Say hello"); err != nil {
+		t.Fatal(err)
+	}
+	if slept := clk.slept(); !slices.Equal(slept, []time.Duration{15 * time.Second}) {
+		t.Errorf("waited %v, want the 15s left until reset", slept)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Number of trailing characters of a secret left visible when redacting it
+const REDACT_VISIBLE_CHARS = 4
+
+// Headers carrying credentials
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "X-Api-Key", "Api-Key", "X-Amz-Security-Token"}
+
+// Query parameters carrying credentials, e.g. key of Azure or Gemini style URLs
+var sensitiveQueryParams = []string{"key", "api_key", "api-key", "token", "access_token"}
+
+// Replace all but the last characters of secret with asterisks, e.g. "****abcd".
+// Short secrets are masked completely.
+func redactKey(secret string) string {
+	if len(secret) <= REDACT_VISIBLE_CHARS*2 {
+		return strings.Repeat("*", len(secret))
+	}
+	return strings.Repeat("*", len(secret)-REDACT_VISIBLE_CHARS) + secret[len(secret)-REDACT_VISIBLE_CHARS:]
+}
+
+// Replace every occurrence of secret in s with its redacted form
+func redactSecret(s, secret string) string {
+	if secret == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, secret, redactKey(secret))
+}
+
+// Copy header with credentials redacted, keeping the auth scheme such as Bearer readable
+func redactHeader(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, key := range sensitiveHeaders {
+		values := redacted.Values(key)
+		for i, value := range values {
+			scheme, credentials, ok := strings.Cut(value, " ")
+			if ok {
+				values[i] = scheme + " " + redactKey(credentials)
+			} else {
+				values[i] = redactKey(value)
+			}
+		}
+	}
+	return redacted
+}
+
+// Format URL with password and credential query parameters redacted, e.g. of a proxy
+func redactURL(u *url.URL) string {
+	redacted := *u
+	password, hasPassword := u.User.Password()
+	if hasPassword {
+		//Asterisks would be percent-encoded, so they are put in after formatting
+		redacted.User = url.UserPassword(u.User.Username(), "REDACTED")
+	}
+	query := u.Query()
+	changed := false
+	for _, key := range sensitiveQueryParams {
+		if values, ok := query[key]; ok {
+			for i, value := range values {
+				values[i] = redactKey(value)
+			}
+			changed = true
+		}
+	}
+	if changed {
+		redacted.RawQuery = strings.ReplaceAll(query.Encode(), "%2A", "*")
+	}
+	s := redacted.String()
+	if hasPassword {
+		s = strings.Replace(s, ":REDACTED@", ":"+redactKey(password)+"@", 1)
+	}
+	return s
+}
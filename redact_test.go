@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Realistic keys, long enough to keep their last characters visible when redacted
+const (
+	leakedKey      = "sk-live-4f9c2b7e8d1a6053"
+	leakedOtherKey = "sk-live-b83e0d5c71f2a946"
+)
+
+func TestRedactKey(t *testing.T) {
+	tests := []struct {
+		secret string
+		want   string
+	}{
+		{secret: "", want: ""},
+		{secret: "abc", want: "***"},
+		{secret: "12345678", want: "********"},
+		{secret: "123456789", want: "*****6789"},
+		{secret: leakedKey, want: "********************6053"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.secret, func(t *testing.T) {
+			if got := redactKey(tc.secret); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRedactHeader(t *testing.T) {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+leakedKey)
+	header.Set("X-Api-Key", leakedKey)
+	header.Set("Proxy-Authorization", "Basic "+leakedOtherKey)
+	header.Set("X-Request-ID", "req-1")
+
+	redacted := redactHeader(header)
+	if got := fmt.Sprint(redacted); strings.Contains(got, leakedKey) || strings.Contains(got, leakedOtherKey) {
+		t.Errorf("redacted header has a key: %s", got)
+	}
+	if got := redacted.Get("Authorization"); got != "Bearer "+redactKey(leakedKey) {
+		t.Errorf("got Authorization %q, want the scheme kept", got)
+	}
+	if got := redacted.Get("X-Request-ID"); got != "req-1" {
+		t.Errorf("got X-Request-ID %q, want it untouched", got)
+	}
+	if header.Get("Authorization") != "Bearer "+leakedKey {
+		t.Error("redacting modified the original header")
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{name: "no credentials", url: "http://proxy.local:3128", want: "http://proxy.local:3128"},
+		{name: "proxy password", url: "http://alice:" + leakedKey + "@proxy.local:3128", want: "http://alice:" + redactKey(leakedKey) + "@proxy.local:3128"},
+		{name: "query key", url: "https://llama.local/v1?key=" + leakedKey + "&alt=sse", want: "https://llama.local/v1?alt=sse&key=" + redactKey(leakedKey)},
+		{name: "user only", url: "http://alice@proxy.local:3128", want: "http://alice@proxy.local:3128"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := url.Parse(tc.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := redactURL(u); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// Handler quoting the credential of the request back in its body, as careless servers do
+func echoCredential(status int, contentType, format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		fmt.Fprintf(w, format, key)
+	}
+}
+
+func TestAPIKeyNotLogged(t *testing.T) {
+	authError := `{"error":{"message":"Incorrect API key provided: %s"}}`
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		opts    []Option
+		run     func(client *Client) error
+	}{
+		{
+			name:    "request headers",
+			handler: respondChat("Hello there"),
+			run:     complete,
+		},
+		{
+			name:    "error body",
+			handler: echoCredential(http.StatusUnauthorized, "application/json", authError),
+			run:     complete,
+		},
+		{
+			name:    "error page",
+			handler: echoCredential(http.StatusBadGateway, "text/html", "<html><body>Bad gateway for %s</body></html>"),
+			opts:    []Option{WithRetryPolicy(retryOnce)},
+			run:     complete,
+		},
+		{
+			name:    "unexpected content type",
+			handler: echoCredential(http.StatusOK, "text/plain", "Welcome %s"),
+			run:     complete,
+		},
+		{
+			name:    "stream error",
+			handler: echoCredential(http.StatusOK, "text/event-stream", "data: {\"error\":{\"message\":\"Key %s revoked\"}}\n\n"),
+			run: func(client *Client) error {
+				_, err := client.GenerateStream(context.Background(), "Say hello", func(string) error { return nil })
+				return err
+			},
+		},
+		{
+			name:    "rotated keys",
+			handler: echoCredential(http.StatusTooManyRequests, "application/json", authError),
+			opts:    []Option{WithAPIKeys(leakedKey, leakedOtherKey), WithRetryPolicy(retryOnce)},
+			run:     complete,
+		},
+		{
+			name:    "models",
+			handler: echoCredential(http.StatusUnauthorized, "application/json", authError),
+			run: func(client *Client) error {
+				_, err := client.ListModels(context.Background())
+				return err
+			},
+		},
+		{
+			name:    "ollama pull",
+			handler: echoCredential(http.StatusUnauthorized, "application/json", `{"error":"unauthorized: %s"}`),
+			run: func(client *Client) error {
+				return client.PullModel(context.Background(), "llama3", func(PullProgress) {})
+			},
+		},
+		{
+			name:    "ollama pull stream error",
+			handler: echoCredential(http.StatusOK, "application/x-ndjson", "{\"status\":\"pulling manifest\"}\n{\"error\":\"key %s may not pull\"}\n"),
+			run: func(client *Client) error {
+				return client.PullModel(context.Background(), "llama3", func(PullProgress) {})
+			},
+		},
+		{
+			name: "proxy",
+			opts: []Option{WithProxy("http://alice:" + leakedKey + "@proxy.local:3128")},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			handler := tc.handler
+			if handler == nil {
+				handler = respondChat("Hello there")
+			}
+			opts := append([]Option{WithAPIKey(leakedKey), WithDebug(true)}, tc.opts...)
+			_, client := newFakeServer(t, handler, opts...)
+
+			output := ""
+			if tc.run != nil {
+				if err := tc.run(client); err != nil {
+					output = fmt.Sprintf("%v\n%+v\n%#v", err, err, err)
+				}
+			}
+			output += logs.String()
+			for _, key := range []string{leakedKey, leakedOtherKey} {
+				if strings.Contains(output, key) {
+					t.Errorf("output has the raw key %s:\n%s", key, output)
+				}
+			}
+			//Make sure the key did go through the output, redacted
+			if !strings.Contains(output, redactKey(leakedKey)) {
+				t.Errorf("output lacks the redacted key:\n%s", output)
+			}
+		})
+	}
+}
+
+func complete(client *Client) error {
+	_, err := client.Complete(context.Background(), "Say hello")
+	return err
+}
+
+func TestAPIKeyNotPrinted(t *testing.T) {
+	f, _ := newFakeServer(t, echoCredential(http.StatusUnauthorized, "application/json", `{"error":{"message":"Incorrect API key provided: %s"}}`))
+
+	tests := []struct {
+		name string
+		run  func(stdout io.Writer) error
+	}{
+		{name: "doctor", run: func(stdout io.Writer) error {
+			return runDoctor([]string{"-ping-timeout", "5s"}, stdout)
+		}},
+		{name: "doctor through proxy", run: func(stdout io.Writer) error {
+			return runDoctor([]string{"-proxy", "http://alice:" + leakedKey + "@127.0.0.1:1", "-ping-timeout", "5s"}, stdout)
+		}},
+		{name: "config show", run: func(stdout io.Writer) error {
+			return runConfig([]string{"show", "-proxy", "http://alice:" + leakedKey + "@proxy.local:3128"}, stdout)
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clearClientEnv(t)
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			t.Setenv("LLAMA_API_KEY", leakedKey)
+			t.Setenv("LLAMA_API_URL", f.URL)
+			logs := captureLogs(t)
+
+			var stdout bytes.Buffer
+			err := tc.run(&stdout)
+			output := stdout.String() + logs.String()
+			if err != nil {
+				output += err.Error()
+			}
+			if strings.Contains(output, leakedKey) {
+				t.Errorf("output has the raw key:\n%s", output)
+			}
+			if !strings.Contains(output, redactKey(leakedKey)) {
+				t.Errorf("output lacks the redacted key:\n%s", output)
+			}
+		})
+	}
+}
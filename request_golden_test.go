@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Rewrite golden files with the current output")
+
+// Re-encode JSON indented with object keys sorted, so that goldens do not depend on field order or formatting
+func canonicalJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Compare JSON in canonical form with the golden file at path, rewriting the file instead with -update
+func checkGolden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	canonical, err := canonicalJSON(got)
+	if err != nil {
+		t.Fatalf("Invalid JSON %s: %v", got, err)
+	}
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, canonical, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read golden file, run with -update to create it: %v", err)
+	}
+	if !bytes.Equal(canonical, want) {
+		t.Errorf("Request differs from %s, run with -update if the change is intended\ngot:\n%s\nwant:\n%s", path, canonical, want)
+	}
+}
+
+// Function of the scenarios calling functions
+var weatherFunction = function{
+	Name:        "get_weather",
+	Description: "Get current weather of a city",
+	Parameters: parameters{
+		Type: "object",
+		Properties: map[string]property{
+			"city": {Type: "string", Description: "Name of the city"},
+			"unit": {Type: "string", Description: "celsius or fahrenheit"},
+		},
+	},
+	Required: []string{"city"},
+}
+
+func TestRequestGolden(t *testing.T) {
+	prompt := func(text string, opts ...CallOption) func(ctx context.Context, c *Client) error {
+		return func(ctx context.Context, c *Client) error {
+			_, err := c.Complete(ctx, text, opts...)
+			return err
+		}
+	}
+	chat := func(messages ...reqMessage) func(ctx context.Context, c *Client) error {
+		return func(ctx context.Context, c *Client) error {
+			_, err := c.Chat(ctx, messages)
+			return err
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts []Option
+		send func(ctx context.Context, c *Client) error
+	}{
+		{
+			name: "prompt",
+			send: prompt("Translate 'cat' into French."),
+		},
+		{
+			name: "system_prompt",
+			opts: []Option{WithDefaultSystemPrompt("You are a concise translator.")},
+			send: prompt("Translate 'cat' into French."),
+		},
+		{
+			name: "system_prompt_per_call",
+			opts: []Option{WithDefaultSystemPrompt("You are a concise translator.")},
+			send: prompt("Translate 'cat' into French.", WithSystemPrompt("Answer in one word.")),
+		},
+		{
+			name: "few_shot",
+			send: chat(
+				reqMessage{Role: "system", Content: "Give the plural of the noun."},
+				reqMessage{Role: "user", Content: "mouse"},
+				reqMessage{Role: "assistant", Content: "mice"},
+				reqMessage{Role: "user", Content: "child"},
+				reqMessage{Role: "assistant", Content: "children"},
+				reqMessage{Role: "user", Content: "goose"},
+			),
+		},
+		{
+			name: "image",
+			send: chat(ImageMessage("What is in this picture?", "https://example.com/cat.png")),
+		},
+		{
+			name: "functions",
+			opts: []Option{WithFunctions(weatherFunction), WithFunctionCall("auto")},
+			send: prompt("What is the weather in Paris?"),
+		},
+		{
+			name: "functions_sequential",
+			opts: []Option{WithFunctions(weatherFunction), WithParallelToolCalls(false)},
+			send: prompt("Compare the weather in Paris and Rome."),
+		},
+		{
+			name: "function_result",
+			opts: []Option{WithFunctions(weatherFunction)},
+			send: chat(
+				reqMessage{Role: "user", Content: "What is the weather in Paris?"},
+				AssistantMessage(resMessage{Role: "assistant", FunctionCall: functionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`}}),
+				FunctionResultMessage("get_weather", `{"temperature":18,"unit":"celsius"}`),
+			),
+		},
+		{
+			name: "sampling",
+			opts: []Option{WithTemperature(0.7), WithMaxTokens(128)},
+			send: prompt("Write a haiku about autumn."),
+		},
+		{
+			name: "temperature_zero",
+			opts: []Option{WithTemperature(0)},
+			send: prompt("Write a haiku about autumn."),
+		},
+		{
+			name: "logit_bias",
+			opts: []Option{WithLogitBias(map[string]float64{"50256": -100, "1234": 5.5})},
+			send: prompt("Pick a color."),
+		},
+		{
+			name: "logprobs",
+			opts: []Option{WithLogprobs(3)},
+			send: prompt("Pick a color."),
+		},
+		{
+			name: "stop",
+			opts: []Option{WithStop("\n", "###")},
+			send: prompt("List three fruits."),
+		},
+		{
+			name: "n_and_stop",
+			send: func(ctx context.Context, c *Client) error {
+				chatReq, err := NewRequest().User("Suggest a name for a cat.").Temperature(1).Build()
+				if err != nil {
+					return err
+				}
+				chatReq.N = 3
+				chatReq.Stop = []string{"\n"}
+				_, err = c.Send(ctx, chatReq)
+				return err
+			},
+		},
+		{
+			name: "json_schema",
+			opts: []Option{WithJSONSchema("answer", json.RawMessage(`{"type":"object","properties":{"answer":{"type":"string"}},"required":["answer"]}`), true)},
+			send: prompt("What is the capital of France?"),
+		},
+		{
+			name: "model_per_call",
+			send: prompt("Say hello", WithModel("llama3-8b")),
+		},
+		{
+			name: "builder",
+			send: func(ctx context.Context, c *Client) error {
+				chatReq, err := NewRequest().Model("llama3-8b").System("Be brief.").User("Say hello").MaxTokens(16).Build()
+				if err != nil {
+					return err
+				}
+				_, err = c.Send(ctx, chatReq)
+				return err
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, client := newFakeServer(t, respondJSON(http.StatusOK, `{"choices":[{"message":{"role":"assistant","content":"{\"answer\":\"Paris\"}"},"finish_reason":"stop"}]}`), tc.opts...)
+			if err := tc.send(context.Background(), client); err != nil {
+				t.Fatalf("Failed to send request: %v", err)
+			}
+			requests := f.captured()
+			if len(requests) != 1 {
+				t.Fatalf("server got %d requests, want 1", len(requests))
+			}
+			checkGolden(t, filepath.Join("testdata", "golden", tc.name+".json"), requests[0].Body)
+		})
+	}
+}
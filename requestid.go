@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+type contextKey string
+
+// Context key of the request ID sent as X-Request-ID header and printed in log lines.
+// Set it with ContextWithRequestID to tie llama API calls to the originating request.
+// A random ID is generated for calls whose context has none.
+const RequestIDContextKey contextKey = "go-llama-request-id"
+
+// Return a copy of ctx carrying given request ID
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, RequestIDContextKey, id)
+}
+
+// Get request ID carried by ctx
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(RequestIDContextKey).(string)
+	return id, ok && id != ""
+}
+
+// Context key of the idempotency key sent with every attempt of a request.
+// Set it with ContextWithIdempotencyKey to deduplicate calls which the caller itself repeats.
+// A random UUID is generated per call for contexts without one.
+const IdempotencyKeyContextKey contextKey = "go-llama-idempotency-key"
+
+// Return a copy of ctx carrying given idempotency key
+func ContextWithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, IdempotencyKeyContextKey, key)
+}
+
+// Get idempotency key carried by ctx
+func IdempotencyKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(IdempotencyKeyContextKey).(string)
+	return key, ok && key != ""
+}
+
+// Get idempotency key from ctx, generating a random UUID when absent
+func idempotencyKeyFor(ctx context.Context) string {
+	if key, ok := IdempotencyKeyFromContext(ctx); ok {
+		return key
+	}
+	return newUUID()
+}
+
+// Get request ID from ctx, generating a random one when absent
+func ensureRequestID(ctx context.Context) (context.Context, string) {
+	if id, ok := RequestIDFromContext(ctx); ok {
+		return ctx, id
+	}
+	id := newRequestID()
+	return ContextWithRequestID(ctx, id), id
+}
+
+// Generate random request ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Generate random version 4 UUID
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
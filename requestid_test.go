@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Round tripper recording given header of every request it sends
+type headerCapture struct {
+	next   http.RoundTripper
+	name   string
+	mu     sync.Mutex
+	values []string
+}
+
+func (c *headerCapture) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.values = append(c.values, req.Header.Get(c.name))
+	c.mu.Unlock()
+	return c.next.RoundTrip(req)
+}
+
+func (c *headerCapture) captured() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.values...)
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestIdempotencyKeySharedByAttempts(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		ctx    context.Context
+		// Key sent, a random UUID when empty
+		wantKey string
+	}{
+		{name: "generated", header: DEFAULT_IDEMPOTENCY_HEADER},
+		{name: "custom header", header: "X-Request-Key"},
+		{name: "from context", header: DEFAULT_IDEMPOTENCY_HEADER, ctx: ContextWithIdempotencyKey(context.Background(), "order-42"), wantKey: "order-42"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			//Fail the first two attempts of every operation so that each takes three
+			var requests atomic.Int64
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1)%3 != 0 {
+					respondJSON(http.StatusInternalServerError, `{"error":{"message":"Try again"}}`)(w, r)
+					return
+				}
+				respondJSON(http.StatusOK, chatCompletionJSON("Hello there"))(w, r)
+			}
+			capture := &headerCapture{name: tc.header}
+			policy := fixedBackoff{ExponentialBackoff: ExponentialBackoff{MaxRetries: 2}, delay: time.Second}
+			_, client := newFakeServer(t, handler, withClock(newFakeClock()), WithRetryPolicy(policy), WithIdempotencyKeyHeader(tc.header),
+				WithTransportWrapper(func(next http.RoundTripper) http.RoundTripper {
+					capture.next = next
+					return capture
+				}))
+			ctx := tc.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+
+			for i := 0; i < 2; i++ {
+				if _, err := client.Complete(ctx, "Say hello"); err != nil {
+					t.Fatalf("Complete %d: %v", i+1, err)
+				}
+			}
+
+			keys := capture.captured()
+			if len(keys) != 6 {
+				t.Fatalf("sent %d requests, want three attempts of two operations", len(keys))
+			}
+			for op := 0; op < 2; op++ {
+				attempts := keys[op*3 : op*3+3]
+				if attempts[0] != attempts[1] || attempts[1] != attempts[2] {
+					t.Errorf("operation %d sent keys %q, want the same key on every attempt", op+1, attempts)
+				}
+				if tc.wantKey != "" && attempts[0] != tc.wantKey {
+					t.Errorf("operation %d sent key %q, want %q", op+1, attempts[0], tc.wantKey)
+				}
+				if tc.wantKey == "" && !uuidPattern.MatchString(attempts[0]) {
+					t.Errorf("operation %d sent key %q, want a UUID", op+1, attempts[0])
+				}
+			}
+			if sameKey := keys[0] == keys[3]; sameKey != (tc.wantKey != "") {
+				t.Errorf("operations sent keys %q and %q, want them shared only when given by context", keys[0], keys[3])
+			}
+		})
+	}
+}
+
+func TestIdempotencyKeySharedAcrossFailover(t *testing.T) {
+	f, _ := newFakeServer(t, respondJSON(http.StatusOK, chatCompletionJSON("Hello there")))
+	down := newFakeServer503(t)
+	capture := &headerCapture{name: DEFAULT_IDEMPOTENCY_HEADER}
+	captureLogs(t)
+	client := f.newClient(t, WithAPIKey(testAPIKey), WithRetryPolicy(NoRetry), WithBaseURLs(down, f.URL),
+		WithTransportWrapper(func(next http.RoundTripper) http.RoundTripper {
+			capture.next = next
+			return capture
+		}))
+
+	if _, err := client.Complete(context.Background(), "Say hello"); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if keys := capture.captured(); len(keys) != 2 || keys[0] != keys[1] || keys[0] == "" {
+		t.Errorf("sent keys %q, want the same key to both endpoints", keys)
+	}
+}
+
+func TestIdempotencyKeyHeaderDisabled(t *testing.T) {
+	f, client := newFakeServer(t, respondJSON(http.StatusOK, chatCompletionJSON("Hello there")), WithIdempotencyKeyHeader(""))
+	if _, err := client.Complete(context.Background(), "Say hello"); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if key := f.captured()[0].Header.Get(DEFAULT_IDEMPOTENCY_HEADER); key != "" {
+		t.Errorf("sent key %q, want no header", key)
+	}
+}
+
+// Handler answering with scripted statuses in turn, numbering the provider IDs of each response
+func numberedProviderIDs(statuses ...int) http.HandlerFunc {
+	var requests atomic.Int64
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		w.Header().Set("X-Request-Id", fmt.Sprintf("req-%d", n))
+		w.Header().Set("Cf-Ray", fmt.Sprintf("ray-%d", n))
+		if status := statuses[min(n, len(statuses))-1]; status != http.StatusOK {
+			respondJSON(status, `{"error":{"message":"Failed"}}`)(w, r)
+			return
+		}
+		respondChat("Hello there")(w, r)
+	}
+}
+
+func TestProviderRequestIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		stream   bool
+		// Request ID of the result or the APIError
+		wantID string
+		// Request IDs of failed attempts logged at debug level
+		wantLogged []string
+		wantErr    bool
+	}{
+		{name: "success", statuses: []int{http.StatusOK}, wantID: "req-1"},
+		{name: "stream", statuses: []int{http.StatusOK}, stream: true, wantID: "req-1"},
+		{name: "error", statuses: []int{http.StatusBadRequest}, wantID: "req-1", wantLogged: []string{"req-1"}, wantErr: true},
+		{name: "stream error", statuses: []int{http.StatusBadRequest}, stream: true, wantID: "req-1", wantLogged: []string{"req-1"}, wantErr: true},
+		{name: "retried", statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK}, wantID: "req-3", wantLogged: []string{"req-1", "req-2"}},
+		{name: "retries exhausted", statuses: []int{http.StatusServiceUnavailable}, wantID: "req-3", wantLogged: []string{"req-1", "req-2", "req-3"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			policy := fixedBackoff{ExponentialBackoff: ExponentialBackoff{MaxRetries: 2}, delay: time.Second}
+			_, client := newFakeServer(t, numberedProviderIDs(tc.statuses...), withClock(newFakeClock()), WithRetryPolicy(policy), WithDebug(true))
+
+			messages := []reqMessage{{Role: "user", Content: "Say hello"}}
+			var result *GenerateResult
+			var err error
+			if tc.stream {
+				result, err = client.ChatStream(context.Background(), messages, func(string) error { return nil })
+			} else {
+				result, err = client.Chat(context.Background(), messages)
+			}
+
+			wantRay := strings.Replace(tc.wantID, "req", "ray", 1)
+			if tc.wantErr {
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) {
+					t.Fatalf("got error %v, want APIError", err)
+				}
+				if apiErr.RequestID != tc.wantID || apiErr.CFRay != wantRay {
+					t.Errorf("got request id %q and cf-ray %q, want %s and %s", apiErr.RequestID, apiErr.CFRay, tc.wantID, wantRay)
+				}
+				if want := fmt.Sprintf("(request id: %s, cf-ray: %s)", tc.wantID, wantRay); !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q lacks %q", err, want)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Chat: %v", err)
+				}
+				if result.ProviderRequestID != tc.wantID || result.CFRay != wantRay {
+					t.Errorf("got request id %q and cf-ray %q, want %s and %s", result.ProviderRequestID, result.CFRay, tc.wantID, wantRay)
+				}
+			}
+			for i, id := range tc.wantLogged {
+				want := fmt.Sprintf("Attempt %d failed (request id: %s, cf-ray: %s)", i+1, id, strings.Replace(id, "req", "ray", 1))
+				if !strings.Contains(logs.String(), want) {
+					t.Errorf("log lacks %q: %s", want, logs)
+				}
+			}
+		})
+	}
+}
+
+func TestProviderRequestIDsAbsent(t *testing.T) {
+	_, client := newFakeServer(t, respondJSON(http.StatusBadRequest, `{"error":{"message":"Failed"}}`))
+	captureLogs(t)
+
+	_, err := client.Complete(context.Background(), "Say hello")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got error %v, want APIError", err)
+	}
+	if apiErr.RequestID != "" || apiErr.CFRay != "" || strings.Contains(err.Error(), "request id") {
+		t.Errorf("got provider IDs in %q without the headers", err)
+	}
+}
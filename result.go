@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+)
+
+// Result of a generation aggregating the first choice and metadata of the response
+type GenerateResult struct {
+	Content      string
+	Role         string
+	FinishReason string
+	Usage        usage
+	FunctionCall functionCall
+
+	// Model which answered, differs from the requested one after falling back or when a router picked another
+	Model string
+	// Model named in the request which was answered
+	RequestedModel string
+	// Upstream provider which served the request, reported by routers such as OpenRouter
+	UpstreamProvider string
+
+	// Base URL which served the response, empty for cached responses
+	Endpoint string
+
+	// Whether the response came from the on-disk cache
+	Cached bool
+
+	// Rate limits reported with the response, nil when not reported
+	RateLimits *RateLimits
+
+	// Log probabilities of generated tokens, nil unless requested with WithLogprobs
+	Logprobs *logprobs
+
+	// Request ID and Cloudflare ray ID assigned by the provider, empty for cached responses
+	ProviderRequestID string
+	CFRay             string
+
+	// Index of the API key which served the response, -1 for cached responses
+	KeyIndex int
+
+	// Raw response body for fields not modelled here, nil unless requested with WithRawResponse
+	RawBody []byte
+}
+
+// Get reply as a message to append to the history of the next request, including its function call
+func (r *GenerateResult) Message() reqMessage {
+	return AssistantMessage(resMessage{Role: r.Role, Content: r.Content, FunctionCall: r.FunctionCall})
+}
+
+// Send a prompt to llama API and return generated text along with metadata of the response
+func (c *Client) Complete(ctx context.Context, prompt string, opts ...CallOption) (*GenerateResult, error) {
+	result, err := c.Chat(ctx, []reqMessage{
+		reqMessage{Role: "user", Content: prompt},
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if c.postprocessor != nil {
+		result.Content = c.postprocessor(result.Content)
+	}
+	return result, nil
+}
+
+// Send messages, e.g. a conversation or an ImageMessage, and return the reply
+func (c *Client) Chat(ctx context.Context, messages []reqMessage, opts ...CallOption) (*GenerateResult, error) {
+	call, err := c.newCallOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	comp, err := c.createChatCompletion(ctx, c.newChatRequest(messages, call), call)
+	if err != nil {
+		return nil, err
+	}
+	return newGenerateResult(comp, call), nil
+}
+
+// Send request built with NewRequest as is, without applying client defaults such as max tokens
+func (c *Client) Send(ctx context.Context, chatReq *chatRequest, opts ...CallOption) (*GenerateResult, error) {
+	call, err := c.newCallOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	comp, err := c.createChatCompletion(ctx, chatReq, call)
+	if err != nil {
+		return nil, err
+	}
+	return newGenerateResult(comp, call), nil
+}
+
+// Build result from first choice of completion
+func newGenerateResult(comp *completion, call *callOptions) *GenerateResult {
+	choice := comp.response.Choices[0]
+	result := &GenerateResult{
+		Content:          choice.Message.Content,
+		Role:             choice.Message.Role,
+		FinishReason:     choice.FinishReason,
+		Usage:            comp.response.Usage,
+		FunctionCall:     choice.Message.FunctionCall,
+		Model:            comp.response.Model,
+		RequestedModel:   comp.requestedModel,
+		UpstreamProvider: comp.response.Provider,
+		Endpoint:         comp.endpoint,
+		Cached:           comp.cached,
+		KeyIndex:         comp.keyIndex,
+		Logprobs:         choice.Logprobs,
+	}
+	if call.rawResponse {
+		//Copy since the body may be shared with other callers through singleflight
+		result.RawBody = bytes.Clone(comp.body)
+	}
+	if comp.header != nil {
+		result.ProviderRequestID, result.CFRay = providerRequestIDs(comp.header)
+		if limits, ok := parseRateLimitHeaders(comp.header, comp.receivedAt); ok {
+			result.RateLimits = &limits
+		}
+	}
+	return result
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Default values of retry settings
+const (
+	DEFAULT_MAX_RETRIES     = 3
+	DEFAULT_BASE_BACKOFF    = 500 * time.Millisecond
+	DEFAULT_MAX_BACKOFF     = 30 * time.Second
+	DEFAULT_MAX_RETRY_AFTER = 60 * time.Second
+	DEFAULT_TIMEOUT         = 5 * time.Minute
+	DEFAULT_ATTEMPT_TIMEOUT = 60 * time.Second
+)
+
+// Returned when a single attempt exceeds the per-attempt timeout, it is retried like a network timeout
+var ErrAttemptTimeout = errors.New("Attempt timed out")
+
+// RetryPolicy decides whether a failed request is sent again and how long to wait before it
+type RetryPolicy interface {
+	// Report whether a request should be retried after given attempt (0 for the first request).
+	// res is nil when no response was received.
+	ShouldRetry(res *http.Response, err error, attempt int) bool
+
+	// Wait time before the next request after given attempt
+	Backoff(attempt int) time.Duration
+}
+
+// Retry policy with exponential backoff and full jitter
+type ExponentialBackoff struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+
+	// Status codes to retry, 429 and 5xx when empty
+	RetryableStatus []int
+}
+
+var (
+	// Policy which never retries
+	NoRetry RetryPolicy = ExponentialBackoff{}
+
+	// Policy with many quick retries, e.g. for internal gateways
+	AggressivePolicy RetryPolicy = ExponentialBackoff{
+		MaxRetries: 8,
+		BaseDelay:  100 * time.Millisecond,
+		MaxDelay:   5 * time.Second,
+	}
+)
+
+// Get default retry policy
+func DefaultRetryPolicy() ExponentialBackoff {
+	return ExponentialBackoff{
+		MaxRetries: DEFAULT_MAX_RETRIES,
+		BaseDelay:  DEFAULT_BASE_BACKOFF,
+		MaxDelay:   DEFAULT_MAX_BACKOFF,
+	}
+}
+
+func (p ExponentialBackoff) ShouldRetry(res *http.Response, err error, attempt int) bool {
+	if attempt >= p.MaxRetries {
+		return false
+	}
+
+	if res != nil && res.StatusCode != http.StatusOK {
+		if len(p.RetryableStatus) > 0 {
+			return slices.Contains(p.RetryableStatus, res.StatusCode)
+		}
+		return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	}
+
+	return IsTransientError(err)
+}
+
+func (p ExponentialBackoff) Backoff(attempt int) time.Duration {
+	d := p.BaseDelay << attempt
+	if d <= 0 || (p.MaxDelay > 0 && d > p.MaxDelay) {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	//Full jitter spreads retries of concurrent callers
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// Send request body to llama API, retrying as decided by retry policy.
+// Overall timeout bounds the whole operation including backoff, while attempt timeout bounds each request.
+// Streams are bounded by the overall timeout of their caller since their body outlives this function.
+func (c *Client) sendWithRetry(ctx context.Context, op *operation) (*http.Response, []byte, error) {
+	if op.timeout > 0 && !op.stream {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, op.timeout)
+		defer cancel()
+	}
+
+	for attempt := 0; ; attempt++ {
+		//Wait for a free slot of requests per minute
+		if c.limiter != nil {
+			if err := c.limiter.wait(ctx); err != nil {
+				err = fmt.Errorf("Interrupted while waiting for rate limiter: %w", err)
+				op.logf("Failed to send request: %v", err)
+				return nil, nil, err
+			}
+		}
+
+		//Wait for quota reset when the API reports remaining quota below threshold
+		if err := c.waitForRateLimitReset(ctx); err != nil {
+			err = fmt.Errorf("Interrupted while waiting for rate limit reset: %w", err)
+			op.logf("Failed to send request: %v", err)
+			return nil, nil, err
+		}
+
+		//Fail fast while the provider is considered down
+		if c.breaker != nil {
+			if err := c.breaker.allow(); err != nil {
+				op.logf("Failed to send request: %v", err)
+				return nil, nil, err
+			}
+		}
+
+		res, body, err := c.sendAttempt(ctx, op)
+		if c.breaker != nil {
+			c.breaker.done(res, err)
+			op.debugf("Circuit breaker is %v", c.breaker.currentState())
+		}
+		if err == nil {
+			return res, body, nil
+		}
+		if c.metrics != nil {
+			c.metrics.observeError(op.model, statusClass(res))
+		}
+
+		if res != nil {
+			if ids := formatProviderIDs(providerRequestIDs(res.Header)); ids != "" {
+				op.debugf("Attempt %d failed (%s)", attempt+1, ids)
+			}
+		}
+
+		if !c.retryPolicy.ShouldRetry(res, err, attempt) {
+			return res, nil, err
+		}
+
+		//Prefer the wait time requested by the server over computed backoff
+		wait := c.retryPolicy.Backoff(attempt)
+		source := "backoff"
+		if d, ok := c.retryAfter(res); ok {
+			wait = d
+			source = "Retry-After"
+		}
+		//Give up now instead of sleeping past the overall deadline
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= wait {
+			err := fmt.Errorf("Deadline would expire before next retry: %w, last error: %w", context.DeadlineExceeded, err)
+			op.logf("Failed to retry: %v", err)
+			return res, nil, err
+		}
+		op.debugf("Retrying request (attempt %d) after %v from %s: %v", attempt+1, wait, source, err)
+
+		if err := c.clock.Sleep(ctx, wait); err != nil {
+			err = fmt.Errorf("Interrupted while waiting for retry: %w", err)
+			op.logf("Failed to wait for retry: %v", err)
+			return nil, nil, err
+		}
+		if c.metrics != nil {
+			c.metrics.observeRetry(op.model)
+		}
+	}
+}
+
+// Execute a single request bounded by per-attempt timeout
+func (c *Client) sendAttempt(ctx context.Context, op *operation) (*http.Response, []byte, error) {
+	if op.stream {
+		return c.sendStreamAttempt(ctx, op)
+	}
+
+	send := c.send
+	if c.hedgeDelay > 0 {
+		send = c.sendHedged
+	}
+
+	if op.attemptTimeout <= 0 {
+		res, body, err := send(ctx, op)
+		return res, body, transportTimeout(ctx, err)
+	}
+
+	//Attempt never outlives the overall deadline, which WithTimeout keeps as the earlier one
+	attemptCtx, cancel := context.WithTimeout(ctx, op.attemptTimeout)
+	defer cancel()
+
+	res, body, err := send(attemptCtx, op)
+
+	//Distinguish expiry of this attempt from the overall deadline so that it can be retried
+	if err != nil && ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("%w after %v", ErrAttemptTimeout, op.attemptTimeout)
+	}
+	return res, body, transportTimeout(ctx, err)
+}
+
+// Wrap timeout of the transport, e.g. its response header timeout, into ErrAttemptTimeout while ctx is not done,
+// so that it is retried. Such errors match context.DeadlineExceeded, which is not retried otherwise.
+func transportTimeout(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() != nil || errors.Is(err, ErrAttemptTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrAttemptTimeout, err)
+}
+
+// Check if error is a transient network failure which is safe to retry,
+// e.g. connection reset by peer, unexpected EOF or network timeout.
+// Context cancellation and TLS certificate errors are never transient.
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, ErrAttemptTimeout) {
+		return true
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	//Certificate problems will not be fixed by sending the request again
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var certInvalidErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	var verificationErr *tls.CertificateVerificationError
+	if errors.As(err, &unknownAuthorityErr) || errors.As(err, &certInvalidErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &verificationErr) {
+		return false
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	//Connection closed by the server before it answered, net/http does not export this error
+	if strings.Contains(err.Error(), "server closed idle connection") {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Get wait time from Retry-After header of 429 or 503 response, capped by configured maximum
+func (c *Client) retryAfter(res *http.Response) (time.Duration, bool) {
+	if res == nil || (res.StatusCode != http.StatusTooManyRequests && res.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+
+	d, ok := parseRetryAfter(res.Header.Get("Retry-After"), c.clock.Now())
+	if !ok {
+		return 0, false
+	}
+	if c.maxRetryAfter > 0 && d > c.maxRetryAfter {
+		d = c.maxRetryAfter
+	}
+	return d, true
+}
+
+// Parse Retry-After header given in either seconds or HTTP-date format
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		//Absurd values would overflow Duration and wrap around
+		if seconds < 0 || seconds > int(math.MaxInt64/time.Second) {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	d := date.Sub(now)
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+// Wait for given duration unless context is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
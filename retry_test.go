@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/takumi616/go-llama/chaos"
+)
+
+// Policy retrying once almost immediately, so that tests measure timeouts rather than backoff
+var retryOnce = ExponentialBackoff{MaxRetries: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+// Inject faults of rules into requests of the client, counting them on the returned transport
+func withChaos(rules ...*chaos.Rule) (Option, *chaos.ChaosTransport) {
+	transport := chaos.NewChaosTransport(nil, rules...)
+	return WithTransportWrapper(func(next http.RoundTripper) http.RoundTripper {
+		transport.Next = next
+		return transport
+	}), transport
+}
+
+// Handler holding the first slow requests until the client gives up, then answering with a completion
+func slowHandler(slow int64) http.HandlerFunc {
+	var requests atomic.Int64
+	return func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= slow {
+			<-r.Context().Done()
+			return
+		}
+		respondJSON(http.StatusOK, chatCompletionJSON("Hello there"))(w, r)
+	}
+}
+
+func TestAttemptTimeoutRetriesHungAttempt(t *testing.T) {
+	hang, transport := withChaos(chaos.When(chaos.OnRequest(1), chaos.Latency(time.Hour)))
+	f, client := newFakeServer(t, respondJSON(http.StatusOK, chatCompletionJSON("Hello there")), hang, WithRetryPolicy(retryOnce), WithAttemptTimeout(100*time.Millisecond), WithTimeout(5*time.Second))
+
+	result, err := client.Complete(context.Background(), "Say hello")
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if result.Content != "Hello there" {
+		t.Errorf("got content %q", result.Content)
+	}
+	if n := transport.Requests(); n != 2 {
+		t.Errorf("sent %d requests, want the hung one and its retry", n)
+	}
+	if n := len(f.captured()); n != 1 {
+		t.Errorf("server got %d requests, want only the retry", n)
+	}
+}
+
+func TestOverallTimeoutBoundsRetries(t *testing.T) {
+	policy := ExponentialBackoff{MaxRetries: 100, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	hang, _ := withChaos(chaos.When(chaos.Always(), chaos.Latency(time.Hour)))
+	_, client := newFakeServer(t, respondJSON(http.StatusOK, chatCompletionJSON("Hello there")), hang, WithRetryPolicy(policy), WithAttemptTimeout(50*time.Millisecond), WithTimeout(300*time.Millisecond))
+
+	start := time.Now()
+	_, err := client.Complete(context.Background(), "Say hello")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("gave up after %v, want about the 300ms overall timeout", elapsed)
+	}
+}
+
+func TestDeadlineBeforeRetryKeepsLastError(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "10")
+		respondJSON(http.StatusServiceUnavailable, `{"error":{"message":"Overloaded"}}`)(w, r)
+	}
+	_, client := newFakeServer(t, handler, WithRetryPolicy(DefaultRetryPolicy()))
+	op := &operation{requestID: "test", model: DEFAULT_MODEL, body: newRequestBody([]byte(`{}`)), timeout: time.Second}
+
+	start := time.Now()
+	res, _, err := client.sendWithRetry(context.Background(), op)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("returned after %v, want immediately instead of sleeping past the deadline", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want context.DeadlineExceeded", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got error %v, want the last APIError in the chain", err)
+	}
+	if res == nil || res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got response %v, want the last one", res)
+	}
+}
+
+// Policy retrying with the same delay each time, so that tests can tell backoff from Retry-After
+type fixedBackoff struct {
+	ExponentialBackoff
+	delay time.Duration
+}
+
+func (p fixedBackoff) Backoff(attempt int) time.Duration {
+	return p.delay
+}
+
+// Fault answering status with Retry-After set to value, left out when empty
+func retryAfterFault(status int, value string) chaos.Fault {
+	fault := chaos.Status(status)
+	do := fault.Do
+	fault.Do = func(req *http.Request, next http.RoundTripper) (*http.Response, error) {
+		res, err := do(req, next)
+		if err == nil && value != "" {
+			res.Header.Set("Retry-After", value)
+		}
+		return res, err
+	}
+	return fault
+}
+
+func TestRetryAfter(t *testing.T) {
+	//Time of the fake clock each case starts with
+	now := newFakeClock().Now()
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		maxWait    time.Duration
+		wantSleep  time.Duration
+	}{
+		{name: "integer seconds", status: http.StatusTooManyRequests, retryAfter: "7", wantSleep: 7 * time.Second},
+		{name: "seconds with whitespace", status: http.StatusTooManyRequests, retryAfter: " 3 ", wantSleep: 3 * time.Second},
+		{name: "HTTP-date", status: http.StatusTooManyRequests, retryAfter: now.Add(20 * time.Second).Format(http.TimeFormat), wantSleep: 20 * time.Second},
+		{name: "HTTP-date in the past", status: http.StatusTooManyRequests, retryAfter: now.Add(-time.Hour).Format(http.TimeFormat), wantSleep: 0},
+		{name: "503 is honored too", status: http.StatusServiceUnavailable, retryAfter: "4", wantSleep: 4 * time.Second},
+		{name: "cap hit", status: http.StatusTooManyRequests, retryAfter: "3600", maxWait: 10 * time.Second, wantSleep: 10 * time.Second},
+		{name: "default cap hit", status: http.StatusTooManyRequests, retryAfter: "3600", wantSleep: DEFAULT_MAX_RETRY_AFTER},
+		{name: "HTTP-date cap hit", status: http.StatusTooManyRequests, retryAfter: now.Add(time.Hour).Format(http.TimeFormat), maxWait: 10 * time.Second, wantSleep: 10 * time.Second},
+		{name: "missing header falls back to backoff", status: http.StatusTooManyRequests, wantSleep: 250 * time.Millisecond},
+		{name: "malformed value falls back to backoff", status: http.StatusTooManyRequests, retryAfter: "soon", wantSleep: 250 * time.Millisecond},
+		{name: "negative seconds fall back to backoff", status: http.StatusTooManyRequests, retryAfter: "-5", wantSleep: 250 * time.Millisecond},
+		{name: "ignored on other statuses", status: http.StatusInternalServerError, retryAfter: "7", wantSleep: 250 * time.Millisecond},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clk := newFakeClock()
+			logs := captureLogs(t)
+			inject, transport := withChaos(chaos.When(chaos.OnRequest(1), retryAfterFault(tc.status, tc.retryAfter)))
+			opts := []Option{withClock(clk), inject, WithDebug(true), WithRetryPolicy(fixedBackoff{ExponentialBackoff: ExponentialBackoff{MaxRetries: 1}, delay: 250 * time.Millisecond})}
+			if tc.maxWait > 0 {
+				opts = append(opts, WithMaxRetryAfter(tc.maxWait))
+			}
+			f, client := newFakeServer(t, respondJSON(http.StatusOK, chatCompletionJSON("Hello there")), opts...)
+
+			if _, err := client.Complete(context.Background(), "Say hello"); err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			if n := transport.Requests(); n != 2 {
+				t.Errorf("sent %d requests, want 2", n)
+			}
+			if n := len(f.captured()); n != 1 {
+				t.Errorf("server got %d requests, want only the retry", n)
+			}
+			if got := clk.slept(); len(got) != 1 || got[0] != tc.wantSleep {
+				t.Errorf("slept %v, want [%v]", got, tc.wantSleep)
+			}
+			//The chosen wait is logged along with where it came from
+			source := "backoff"
+			if tc.wantSleep != 250*time.Millisecond {
+				source = "Retry-After"
+			}
+			if want := fmt.Sprintf("after %v from %s", tc.wantSleep, source); !strings.Contains(logs.String(), want) {
+				t.Errorf("debug log lacks %q: %s", want, logs)
+			}
+		})
+	}
+}
+
+func TestRetryPolicies(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       RetryPolicy
+		wantRequests int
+		// Upper bound of each sleep, exact when jitter is not involved
+		maxSleeps []time.Duration
+		exact     bool
+	}{
+		{name: "NoRetry", policy: NoRetry, wantRequests: 1},
+		{name: "default", policy: DefaultRetryPolicy(), wantRequests: 4, maxSleeps: []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second}},
+		{name: "AggressivePolicy", policy: AggressivePolicy, wantRequests: 9, maxSleeps: []time.Duration{
+			100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond,
+			1600 * time.Millisecond, 3200 * time.Millisecond, 5 * time.Second, 5 * time.Second,
+		}},
+		{name: "custom backoff", policy: fixedBackoff{ExponentialBackoff: ExponentialBackoff{MaxRetries: 2}, delay: 2 * time.Second}, wantRequests: 3, maxSleeps: []time.Duration{2 * time.Second, 2 * time.Second}, exact: true},
+		{name: "status not retryable", policy: ExponentialBackoff{MaxRetries: 5, BaseDelay: time.Second, RetryableStatus: []int{http.StatusBadGateway}}, wantRequests: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clk := newFakeClock()
+			overloaded, transport := withChaos(chaos.When(chaos.Always(), chaos.Status(http.StatusServiceUnavailable)))
+			f, client := newFakeServer(t, respondJSON(http.StatusOK, chatCompletionJSON("Hello there")), withClock(clk), overloaded, WithRetryPolicy(tc.policy))
+
+			_, err := client.Complete(context.Background(), "Say hello")
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+				t.Fatalf("got error %v, want APIError 503", err)
+			}
+			if n := transport.Injected()["503"]; n != tc.wantRequests {
+				t.Errorf("injected %d faults, want %d", n, tc.wantRequests)
+			}
+			if n := len(f.captured()); n != 0 {
+				t.Errorf("server got %d requests, want none", n)
+			}
+			slept := clk.slept()
+			if len(slept) != len(tc.maxSleeps) {
+				t.Fatalf("slept %v, want %d sleeps", slept, len(tc.maxSleeps))
+			}
+			for i, d := range slept {
+				if d < 0 || d > tc.maxSleeps[i] || (tc.exact && d != tc.maxSleeps[i]) {
+					t.Errorf("sleep %d was %v, want at most %v", i+1, d, tc.maxSleeps[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRetryOnInjectedFaults(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []*chaos.Rule
+		// Calls of Complete, each expected to succeed unless wantErr is set
+		calls        int
+		wantErr      bool
+		wantRequests int
+		wantServed   int
+		wantSleeps   []time.Duration
+	}{
+		{
+			name:  "429 with Retry-After on the second request",
+			rules: []*chaos.Rule{chaos.When(chaos.OnRequest(2), chaos.TooManyRequests(3*time.Second))},
+			calls: 2, wantRequests: 3, wantServed: 2, wantSleeps: []time.Duration{3 * time.Second},
+		},
+		{
+			name:  "connection reset",
+			rules: []*chaos.Rule{chaos.When(chaos.FirstN(2), chaos.ConnectionReset())},
+			calls: 1, wantRequests: 3, wantServed: 1, wantSleeps: []time.Duration{250 * time.Millisecond, 250 * time.Millisecond},
+		},
+		{
+			name:  "truncated body",
+			rules: []*chaos.Rule{chaos.When(chaos.OnRequest(1), chaos.TruncatedBody(10))},
+			calls: 1, wantRequests: 2, wantServed: 2, wantSleeps: []time.Duration{250 * time.Millisecond},
+		},
+		{
+			name:  "malformed JSON is not retried",
+			rules: []*chaos.Rule{chaos.When(chaos.OnRequest(1), chaos.MalformedJSON())},
+			calls: 1, wantErr: true, wantRequests: 1, wantServed: 1,
+		},
+		{
+			name:  "retries exhausted",
+			rules: []*chaos.Rule{chaos.When(chaos.Always(), chaos.ConnectionReset())},
+			calls: 1, wantErr: true, wantRequests: 4, wantSleeps: []time.Duration{250 * time.Millisecond, 250 * time.Millisecond, 250 * time.Millisecond},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clk := newFakeClock()
+			captureLogs(t)
+			inject, transport := withChaos(tc.rules...)
+			policy := fixedBackoff{ExponentialBackoff: ExponentialBackoff{MaxRetries: 3}, delay: 250 * time.Millisecond}
+			f, client := newFakeServer(t, respondJSON(http.StatusOK, chatCompletionJSON("Hello there")), withClock(clk), inject, WithRetryPolicy(policy))
+
+			for i := 0; i < tc.calls; i++ {
+				result, err := client.Complete(context.Background(), "Say hello")
+				if tc.wantErr {
+					var decodeErr *DecodeError
+					if err == nil || (tc.wantServed > 0 && !errors.As(err, &decodeErr)) {
+						t.Fatalf("got error %v, want the injected fault to surface", err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("Complete %d: %v", i+1, err)
+				}
+				if result.Content != "Hello there" {
+					t.Errorf("got content %q", result.Content)
+				}
+			}
+			if n := transport.Requests(); n != tc.wantRequests {
+				t.Errorf("sent %d requests, want %d", n, tc.wantRequests)
+			}
+			if n := len(f.captured()); n != tc.wantServed {
+				t.Errorf("server got %d requests, want %d", n, tc.wantServed)
+			}
+			if got := clk.slept(); !slices.Equal(got, tc.wantSleeps) {
+				t.Errorf("slept %v, want %v", got, tc.wantSleeps)
+			}
+			//Each rule injected its fault into every request it matched
+			for _, rule := range tc.rules {
+				if rule.Injected() == 0 {
+					t.Errorf("rule %q injected nothing", rule.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	untrusted := httptest.NewTLSServer(http.NotFoundHandler())
+	defer untrusted.Close()
+	_, tlsErr := http.Get(untrusted.URL)
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "ECONNRESET", err: reset, want: true},
+		{name: "ECONNRESET in url.Error", err: &url.Error{Op: "Post", URL: "http://a.test", Err: reset}, want: true},
+		{name: "ECONNABORTED", err: syscall.ECONNABORTED, want: true},
+		{name: "EPIPE", err: fmt.Errorf("write: %w", syscall.EPIPE), want: true},
+		{name: "EOF", err: io.EOF, want: true},
+		{name: "unexpected EOF", err: fmt.Errorf("read body: %w", io.ErrUnexpectedEOF), want: true},
+		{name: "network timeout", err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}, want: true},
+		{name: "ErrAttemptTimeout", err: fmt.Errorf("%w after 1s", ErrAttemptTimeout), want: true},
+		{name: "ErrAttemptTimeout of a transport deadline", err: transportTimeout(context.Background(), context.DeadlineExceeded), want: true},
+		{name: "server closed idle connection", err: errors.New("http: server closed idle connection"), want: true},
+		{name: "canceled", err: context.Canceled, want: false},
+		{name: "canceled in url.Error", err: &url.Error{Op: "Post", URL: "http://a.test", Err: context.Canceled}, want: false},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, want: false},
+		{name: "unknown authority", err: x509.UnknownAuthorityError{}, want: false},
+		{name: "hostname mismatch", err: x509.HostnameError{Host: "a.test"}, want: false},
+		{name: "expired certificate", err: x509.CertificateInvalidError{Reason: x509.Expired}, want: false},
+		{name: "certificate verification", err: &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}, want: false},
+		{name: "TLS handshake with untrusted server", err: tlsErr, want: false},
+		{name: "TLS record header", err: tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, want: false},
+		{name: "other error", err: errors.New("boom"), want: false},
+	}
+
+	if tlsErr == nil {
+		t.Fatal("want error from untrusted TLS server")
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := IsTransientError(tc.err); got != tc.want {
+				t.Errorf("IsTransientError(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+// Handler resetting the connection of the first request instead of answering, then answering with a completion
+func resetFirstConnection(t *testing.T) http.HandlerFunc {
+	var requests atomic.Int64
+	return func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) > 1 {
+			respondJSON(http.StatusOK, chatCompletionJSON("Hello there"))(w, r)
+			return
+		}
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("Failed to hijack connection: %v", err)
+			return
+		}
+		//Discarding unsent data on close makes the kernel send RST instead of FIN
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	}
+}
+
+func TestFlakyServerResetIsRetried(t *testing.T) {
+	logs := captureLogs(t)
+	f, client := newFakeServer(t, resetFirstConnection(t), WithRetryPolicy(retryOnce))
+
+	result, err := client.Complete(context.Background(), "Say hello")
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if result.Content != "Hello there" {
+		t.Errorf("got content %q", result.Content)
+	}
+	if n := len(f.captured()); n != 2 {
+		t.Errorf("server got %d requests, want the reset one and its retry", n)
+	}
+	if !strings.Contains(logs.String(), "connection reset by peer") && !strings.Contains(logs.String(), "EOF") {
+		t.Errorf("log lacks the reset: %s", logs)
+	}
+
+	//Without retries the reset surfaces as a transient error
+	_, client = newFakeServer(t, resetFirstConnection(t))
+	_, err = client.Complete(context.Background(), "Say hello")
+	if !IsTransientError(err) {
+		t.Errorf("got error %v, want a transient one", err)
+	}
+}
+
+func TestExponentialBackoffFullJitter(t *testing.T) {
+	policy := ExponentialBackoff{MaxRetries: 100, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	for _, attempt := range []int{0, 1, 3, 4, 62, 63, 64, 100} {
+		//Shifting past the cap or overflowing falls back to MaxDelay
+		limit := 10 * time.Second
+		if attempt < 4 {
+			limit = time.Second << attempt
+		}
+		distinct := map[time.Duration]bool{}
+		for i := 0; i < 100; i++ {
+			d := policy.Backoff(attempt)
+			if d < 0 || d > limit {
+				t.Fatalf("attempt %d got backoff %v, want between 0 and %v", attempt, d, limit)
+			}
+			distinct[d] = true
+		}
+		if len(distinct) < 2 {
+			t.Errorf("attempt %d got the same backoff every time, want jitter", attempt)
+		}
+	}
+	if d := (ExponentialBackoff{}).Backoff(3); d != 0 {
+		t.Errorf("zero policy got backoff %v, want 0", d)
+	}
+}
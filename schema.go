@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// Matches SchemaError with errors.Is
+var ErrSchemaMismatch = errors.New("Response does not match JSON schema")
+
+// Error returned when content of a response does not match the JSON schema of response_format
+type SchemaError struct {
+	// Location of the mismatch in the content, e.g. "$.words[0]"
+	Path    string
+	Message string
+	// Content which failed validation
+	Content string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("Response does not match JSON schema at %s: %s", e.Path, e.Message)
+}
+
+func (e *SchemaError) Is(target error) bool {
+	return target == ErrSchemaMismatch
+}
+
+// Subset of JSON schema checked on the client: type, enum, const, properties, required,
+// additionalProperties, items, anyOf and bounds of strings, numbers and arrays.
+// Other keywords such as $ref and pattern are left to the server.
+type jsonSchema struct {
+	Type                 schemaTypes            `json:"type"`
+	Enum                 []any                  `json:"enum"`
+	Const                json.RawMessage        `json:"const"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	AnyOf                []*jsonSchema          `json:"anyOf"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+
+	//Decoded form of AdditionalProperties: false forbids unknown properties, a schema constrains them
+	noAdditional bool
+	additional   *jsonSchema
+}
+
+// Allowed types, given as a single name or an array of names
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var name string
+		if err := json.Unmarshal(data, &name); err != nil {
+			return err
+		}
+		*t = schemaTypes{name}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+// Names of types which schemas may use
+var schemaTypeNames = map[string]bool{"object": true, "array": true, "string": true, "number": true, "integer": true, "boolean": true, "null": true}
+
+// Parse schema and check the keywords validated on the client are well formed
+func parseJSONSchema(raw json.RawMessage) (*jsonSchema, error) {
+	schema := &jsonSchema{}
+	if err := json.Unmarshal(raw, schema); err != nil {
+		return nil, fmt.Errorf("Invalid JSON schema: %w", err)
+	}
+	if err := schema.prepare("$"); err != nil {
+		return nil, fmt.Errorf("Invalid JSON schema: %w", err)
+	}
+	return schema, nil
+}
+
+// Check types and decode additionalProperties of schema and its subschemas
+func (s *jsonSchema) prepare(path string) error {
+	for _, name := range s.Type {
+		if !schemaTypeNames[name] {
+			return fmt.Errorf("unknown type %q at %s", name, path)
+		}
+	}
+
+	switch trimmed := string(bytes.TrimSpace(s.AdditionalProperties)); {
+	case trimmed == "" || trimmed == "true":
+	case trimmed == "false":
+		s.noAdditional = true
+	default:
+		s.additional = &jsonSchema{}
+		if err := json.Unmarshal(s.AdditionalProperties, s.additional); err != nil {
+			return fmt.Errorf("additionalProperties at %s: %w", path, err)
+		}
+		if err := s.additional.prepare(path + ".*"); err != nil {
+			return err
+		}
+	}
+
+	for name, property := range s.Properties {
+		if property == nil {
+			return fmt.Errorf("property %q at %s has no schema", name, path)
+		}
+		if err := property.prepare(path + "." + name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		if err := s.Items.prepare(path + "[]"); err != nil {
+			return err
+		}
+	}
+	for i, sub := range s.AnyOf {
+		if sub == nil {
+			return fmt.Errorf("anyOf[%d] at %s has no schema", i, path)
+		}
+		if err := sub.prepare(fmt.Sprintf("%s.anyOf[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parse content as JSON and validate it against schema
+func validateJSONContent(schema *jsonSchema, content string) error {
+	var value any
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		return &SchemaError{Path: "$", Message: "content is not valid JSON: " + err.Error(), Content: content}
+	}
+	if err := schema.validate("$", value); err != nil {
+		err.Content = content
+		return err
+	}
+	return nil
+}
+
+// Validate decoded JSON value, reporting the first mismatch
+func (s *jsonSchema) validate(path string, value any) *SchemaError {
+	mismatch := func(format string, args ...any) *SchemaError {
+		return &SchemaError{Path: path, Message: fmt.Sprintf(format, args...)}
+	}
+
+	if len(s.Type) > 0 && !s.allowsType(value) {
+		return mismatch("expected %s, got %s", strings.Join(s.Type, " or "), jsonTypeOf(value))
+	}
+	if len(s.Enum) > 0 && !containsValue(s.Enum, value) {
+		return mismatch("%s is not one of the enum values", formatJSONValue(value))
+	}
+	if len(s.Const) > 0 {
+		var want any
+		if err := json.Unmarshal(s.Const, &want); err == nil && !reflect.DeepEqual(want, value) {
+			return mismatch("expected %s, got %s", string(s.Const), formatJSONValue(value))
+		}
+	}
+	if len(s.AnyOf) > 0 {
+		matched := false
+		for _, sub := range s.AnyOf {
+			if sub.validate(path, value) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return mismatch("matches none of anyOf")
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return mismatch("required property %q is missing", name)
+			}
+		}
+		for name, property := range v {
+			sub, ok := s.Properties[name]
+			switch {
+			case ok:
+			case s.noAdditional:
+				return mismatch("property %q is not allowed", name)
+			case s.additional != nil:
+				sub = s.additional
+			default:
+				continue
+			}
+			if err := sub.validate(path+"."+name, property); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			return mismatch("expected at least %d items, got %d", *s.MinItems, len(v))
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			return mismatch("expected at most %d items, got %d", *s.MaxItems, len(v))
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			return mismatch("expected at least %d characters, got %d", *s.MinLength, length)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			return mismatch("expected at most %d characters, got %d", *s.MaxLength, length)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return mismatch("%v is less than minimum %v", v, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			return mismatch("%v is greater than maximum %v", v, *s.Maximum)
+		}
+	}
+	return nil
+}
+
+// Check value has one of the types of schema, integers count as numbers too
+func (s *jsonSchema) allowsType(value any) bool {
+	actual := jsonTypeOf(value)
+	for _, name := range s.Type {
+		if name == actual || (name == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// Get JSON schema type name of decoded value
+func jsonTypeOf(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func containsValue(values []any, value any) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatJSONValue(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// Validate content of every choice against the JSON schema of response format, if any
+func validateResponseSchema(format *responseFormat, chatRes *chatResponse) error {
+	if format == nil || format.JSONSchema == nil || format.JSONSchema.schema == nil {
+		return nil
+	}
+	for _, choice := range chatRes.Choices {
+		if choice.Message.FunctionCall.Name != "" {
+			continue
+		}
+		if err := validateJSONContent(format.JSONSchema.schema, choice.Message.Content); err != nil {
+			log.Printf("Failed to validate response against schema %s: %v", format.JSONSchema.Name, err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Schema of a list of words, both properties required
+var wordsSchema = json.RawMessage(`{
+	"type": "object",
+	"properties": {
+		"words": {"type": "array", "items": {"type": "string", "minLength": 1}, "minItems": 1},
+		"count": {"type": "integer", "minimum": 1}
+	},
+	"required": ["words", "count"],
+	"additionalProperties": false
+}`)
+
+func TestSchemaRequiredViolated(t *testing.T) {
+	content := `{"count":2}`
+	f, client := newFakeServer(t, respondChat(content), WithJSONSchema("words", wordsSchema, true))
+	captureLogs(t)
+
+	_, err := client.Complete(context.Background(), "Split: Hello llama")
+	if !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("got error %v, want ErrSchemaMismatch", err)
+	}
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("got error %T, want *SchemaError", err)
+	}
+	if schemaErr.Path != "$" || !strings.Contains(schemaErr.Message, `required property "words" is missing`) || schemaErr.Content != content {
+		t.Errorf("got error at %s: %s for %q, want the missing words at $", schemaErr.Path, schemaErr.Message, schemaErr.Content)
+	}
+
+	var body struct {
+		ResponseFormat struct {
+			Type       string `json:"type"`
+			JSONSchema struct {
+				Name   string          `json:"name"`
+				Schema json.RawMessage `json:"schema"`
+				Strict bool            `json:"strict"`
+			} `json:"json_schema"`
+		} `json:"response_format"`
+	}
+	if err := json.Unmarshal(f.captured()[0].Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	format := body.ResponseFormat
+	if format.Type != "json_schema" || format.JSONSchema.Name != "words" || !format.JSONSchema.Strict || len(format.JSONSchema.Schema) == 0 {
+		t.Errorf("sent response format %+v, want the words schema", format)
+	}
+}
+
+func TestSchemaMatched(t *testing.T) {
+	content := `{"words":["Hello","llama"],"count":2}`
+	_, client := newFakeServer(t, respondChat(content), WithJSONSchema("words", wordsSchema, false))
+
+	result, err := client.Complete(context.Background(), "Split: Hello llama")
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if result.Content != content {
+		t.Errorf("got content %q", result.Content)
+	}
+}
+
+func TestValidateJSONContent(t *testing.T) {
+	schema, err := parseJSONSchema(wordsSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		content  string
+		wantPath string
+		wantMsg  string
+	}{
+		{name: "not JSON", content: "Hello llama", wantPath: "$", wantMsg: "not valid JSON"},
+		{name: "not object", content: `["Hello"]`, wantPath: "$", wantMsg: "expected object, got array"},
+		{name: "missing count", content: `{"words":["Hello"]}`, wantPath: "$", wantMsg: `required property "count" is missing`},
+		{name: "unknown property", content: `{"words":["Hello"],"count":1,"lang":"en"}`, wantPath: "$", wantMsg: `property "lang" is not allowed`},
+		{name: "item type", content: `{"words":["Hello",2],"count":2}`, wantPath: "$.words[1]", wantMsg: "expected string, got integer"},
+		{name: "empty item", content: `{"words":[""],"count":1}`, wantPath: "$.words[0]", wantMsg: "at least 1 characters"},
+		{name: "too few items", content: `{"words":[],"count":1}`, wantPath: "$.words", wantMsg: "at least 1 items"},
+		{name: "not integer", content: `{"words":["Hello"],"count":1.5}`, wantPath: "$.count", wantMsg: "expected integer, got number"},
+		{name: "below minimum", content: `{"words":["Hello"],"count":0}`, wantPath: "$.count", wantMsg: "less than minimum"},
+		{name: "valid", content: `{"words":["Hello"],"count":1}`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateJSONContent(schema, tc.content)
+			if tc.wantMsg == "" {
+				if err != nil {
+					t.Errorf("got error %v, want none", err)
+				}
+				return
+			}
+			var schemaErr *SchemaError
+			if !errors.As(err, &schemaErr) {
+				t.Fatalf("got error %v, want *SchemaError", err)
+			}
+			if schemaErr.Path != tc.wantPath || !strings.Contains(schemaErr.Message, tc.wantMsg) {
+				t.Errorf("got error at %s: %s, want %s at %s", schemaErr.Path, schemaErr.Message, tc.wantMsg, tc.wantPath)
+			}
+		})
+	}
+}
+
+func TestParseJSONSchemaInvalid(t *testing.T) {
+	for _, raw := range []string{`{"type":"text"}`, `{"properties":{"a":null}}`, `{"additionalProperties":1}`, `[]`} {
+		if _, err := parseJSONSchema(json.RawMessage(raw)); err == nil {
+			t.Errorf("parsed invalid schema %s", raw)
+		}
+	}
+	clearClientEnv(t)
+	captureLogs(t)
+	if _, err := NewClient(WithAPIKey(testAPIKey), WithJSONSchema("", wordsSchema, false)); err == nil {
+		t.Error("NewClient accepted a schema without a name")
+	}
+}
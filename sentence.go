@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Example sentence generated from vocabulary words
+type Sentence struct {
+	Text string
+	// Words of the vocabulary list which the sentence uses
+	Words []string
+}
+
+// Arguments of exampleSentenceFunction
+type sentenceArguments struct {
+	Sentence string   `json:"sentence"`
+	Words    []string `json:"words"`
+}
+
+// Generate an English example sentence using given words, asking for it through function calling.
+// When the model answers with plain text instead of calling the function, the text is used as the sentence.
+func (c *Client) GenerateExampleSentence(ctx context.Context, words []string, opts ...CallOption) (Sentence, error) {
+	if len(words) == 0 {
+		return Sentence{}, fmt.Errorf("%w: no words to create a sentence from", ErrInvalidRequest)
+	}
+	call, err := c.newCallOptions(opts)
+	if err != nil {
+		return Sentence{}, err
+	}
+
+	//Create request offering the sentence function
+	prompt, err := RenderPrompt(EXAMPLE_SENTENCE_PROMPT, struct{ Words []string }{Words: words})
+	if err != nil {
+		return Sentence{}, err
+	}
+	chatReq := c.newChatRequest([]reqMessage{
+		reqMessage{Role: "user", Content: prompt},
+	}, call)
+	chatReq.Functions = []function{exampleSentenceFunction}
+	chatReq.FunctionCall = "auto"
+
+	comp, err := c.createChatCompletion(ctx, chatReq, call)
+	if err != nil {
+		return Sentence{}, err
+	}
+	return parseSentence(comp.response.Choices[0].Message, words)
+}
+
+// Get sentence from function call arguments, or from content when the model ignored the function
+func parseSentence(message resMessage, words []string) (Sentence, error) {
+	if message.FunctionCall.Name == exampleSentenceFunction.Name {
+		args, err := DecodeArguments[sentenceArguments](message.FunctionCall)
+		if err != nil {
+			log.Printf("Failed to decode function arguments: %v", err)
+			return Sentence{}, err
+		}
+		if strings.TrimSpace(args.Sentence) != "" {
+			if len(args.Words) == 0 {
+				args.Words = usedWords(args.Sentence, words)
+			}
+			return Sentence{Text: strings.TrimSpace(args.Sentence), Words: args.Words}, nil
+		}
+	}
+
+	//Model ignored the function, fall back to plain text reply
+	text := strings.TrimSpace(message.Content)
+	if text == "" {
+		return Sentence{}, fmt.Errorf("%w: neither sentence nor content in reply", ErrUnexpectedResponse)
+	}
+	return Sentence{Text: text, Words: usedWords(text, words)}, nil
+}
+
+// Find which of the words appear in text, ignoring case
+func usedWords(text string, words []string) []string {
+	lower := strings.ToLower(text)
+	used := []string{}
+	for _, word := range words {
+		if strings.Contains(lower, strings.ToLower(word)) {
+			used = append(used, word)
+		}
+	}
+	return used
+}
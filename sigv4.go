@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Settings of AWS Signature Version 4
+const (
+	SIGV4_ALGORITHM   = "AWS4-HMAC-SHA256"
+	SIGV4_TIME_FORMAT = "20060102T150405Z"
+	SIGV4_DATE_FORMAT = "20060102"
+)
+
+// AWS credentials signing requests
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	// Set for temporary credentials, e.g. of an assumed role
+	sessionToken string
+}
+
+// Load credentials like the AWS CLI does: from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY,
+// otherwise from the profile in AWS_PROFILE, or "default", of the shared credentials file
+// and then of the shared config file
+func loadAWSCredentials() (awsCredentials, error) {
+	creds := awsCredentials{
+		accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.accessKeyID != "" && creds.secretAccessKey != "" {
+		return creds, nil
+	}
+
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+	home, _ := os.UserHomeDir()
+	files := []struct {
+		path    string
+		section string
+	}{
+		{awsFilePath("AWS_SHARED_CREDENTIALS_FILE", home, "credentials"), profile},
+		//Profiles of the config file are named "profile x" except the default one
+		{awsFilePath("AWS_CONFIG_FILE", home, "config"), configSectionName(profile)},
+	}
+	for _, file := range files {
+		values, err := readAWSProfile(file.path, file.section)
+		if err != nil {
+			return awsCredentials{}, err
+		}
+		creds := awsCredentials{
+			accessKeyID:     values["aws_access_key_id"],
+			secretAccessKey: values["aws_secret_access_key"],
+			sessionToken:    values["aws_session_token"],
+		}
+		if creds.accessKeyID != "" && creds.secretAccessKey != "" {
+			return creds, nil
+		}
+	}
+	return awsCredentials{}, fmt.Errorf("No AWS credentials found in environment or for profile %q of shared files", profile)
+}
+
+// Get path of shared AWS file, from env or under ~/.aws
+func awsFilePath(env, home, name string) string {
+	if path := os.Getenv(env); path != "" {
+		return path
+	}
+	return filepath.Join(home, ".aws", name)
+}
+
+func configSectionName(profile string) string {
+	if profile == "default" {
+		return profile
+	}
+	return "profile " + profile
+}
+
+// Read keys of section in INI file, a missing file has no keys
+func readAWSProfile(path, section string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Failed to open AWS shared file: %v", err)
+		return nil, err
+	}
+	defer file.Close()
+
+	values := map[string]string{}
+	current := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if ok && current == section {
+			values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Failed to read AWS shared file: %v", err)
+		return nil, err
+	}
+	return values, nil
+}
+
+// Sign request with body for service in region, setting X-Amz-Date, X-Amz-Security-Token
+// for temporary credentials and Authorization. Host, Content-Type and X-Amz-* headers are signed.
+func signV4(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	req.Header.Set("X-Amz-Date", now.Format(SIGV4_TIME_FORMAT))
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	canonicalHeaders, signedHeaders := canonicalHeadersOf(req)
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req),
+		canonicalQuery(req),
+		canonicalHeaders,
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	date := now.Format(SIGV4_DATE_FORMAT)
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := SIGV4_ALGORITHM + "\n" + now.Format(SIGV4_TIME_FORMAT) + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	//Derive signing key from secret through date, region and service
+	key := hmacSHA256([]byte("AWS4"+creds.secretAccessKey), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		SIGV4_ALGORITHM, creds.accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Encode escaped path once more, as services other than S3 expect
+func canonicalURI(req *http.Request) string {
+	path := req.URL.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	return awsURIEncode(path, false)
+}
+
+// Sort query parameters by name and value, encoding both
+func canonicalQuery(req *http.Request) string {
+	var params []string
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			params = append(params, awsURIEncode(name, true)+"="+awsURIEncode(value, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// Get lower case signed headers with trimmed values, one per line, and their names joined by semicolons
+func canonicalHeadersOf(req *http.Request) (string, string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name != "content-type" && !strings.HasPrefix(name, "x-amz-") {
+			continue
+		}
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		headers[name] = strings.Join(trimmed, ",")
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	return canonical.String(), strings.Join(names, ";")
+}
+
+// Percent-encode everything but unreserved characters, and slashes unless encodeSlash is set
+func awsURIEncode(s string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			encoded.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			encoded.WriteByte(b)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return encoded.String()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Credentials and time of the AWS Signature Version 4 test suite
+var (
+	sigV4TestCreds = awsCredentials{accessKeyID: "AKIDEXAMPLE", secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	sigV4TestTime  = time.Date(2015, time.August, 30, 12, 36, 0, 0, time.UTC)
+)
+
+// Session token of post-sts-header-before of the test suite
+const SIGV4_TEST_SESSION_TOKEN = "AQoDYXdzEPT//////////wEXAMPLEtc764bNrC9SAPBSM22wDOk4x4HIZ8j4FZTwdQWLWsKWHGBuFqwAeMicRXmxfpSPfIeoIYRqTflfKD8YUuwthAx7mSEI/qkPpKPi/kMcGdQrmGdeehM4IC1NtBmUpp2wUE8phUZampKsburEDy0KPkyQDYwT7WZ0wq5VSXDvp75YU9HFvlRd8Tx6q6fE8YQcHNVXAkiY9q6d+xo0rKwT38xVqr7ZD0u0iPPkUL64lIZbqBAz+scqKmlzm8FDrypNC9Yjc8fPOLn9FX9KSYvKTr4rvx3iSIlTJabIQwj2ICCR/oLxBA=="
+
+// Vectors of the AWS Signature Version 4 test suite for service "service" in us-east-1.
+// Those with non-ASCII paths are left out, the suite encodes paths once while services other than S3 encode them twice.
+func TestSignV4Vectors(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		url           string
+		contentType   string
+		body          string
+		sessionToken  string
+		signedHeaders string
+		signature     string
+	}{
+		{name: "get-vanilla", method: "GET", url: "https://example.amazonaws.com/", signedHeaders: "host;x-amz-date", signature: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{name: "get-vanilla-query-order-key-case", method: "GET", url: "https://example.amazonaws.com/?Param2=value2&Param1=value1", signedHeaders: "host;x-amz-date", signature: "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+		{name: "get-vanilla-empty-query-key", method: "GET", url: "https://example.amazonaws.com/?Param1=value1", signedHeaders: "host;x-amz-date", signature: "a67d582fa61cc504c4bae71f336f98b97f1ea3c7a6bfe1b6e45aec72011b9aeb"},
+		{name: "get-unreserved", method: "GET", url: "https://example.amazonaws.com/-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz", signedHeaders: "host;x-amz-date", signature: "07ef7494c76fa4850883e2b006601f940f8a34d404d0cfa977f52a65bbf5f24f"},
+		{name: "post-vanilla", method: "POST", url: "https://example.amazonaws.com/", signedHeaders: "host;x-amz-date", signature: "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+		{name: "post-x-www-form-urlencoded", method: "POST", url: "https://example.amazonaws.com/", contentType: "application/x-www-form-urlencoded", body: "Param1=value1", signedHeaders: "content-type;host;x-amz-date", signature: "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a"},
+		{name: "post-sts-header-before", method: "POST", url: "https://example.amazonaws.com/", sessionToken: SIGV4_TEST_SESSION_TOKEN, signedHeaders: "host;x-amz-date;x-amz-security-token", signature: "85d96828115b5dc0cfc3bd16ad9e210dd772bbebba041836c64533a82be05ead"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			creds := sigV4TestCreds
+			creds.sessionToken = tc.sessionToken
+
+			signV4(req, []byte(tc.body), creds, "us-east-1", "service", sigV4TestTime)
+
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=" + tc.signedHeaders + ", Signature=" + tc.signature
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Errorf("got Authorization\n%s\nwant\n%s", got, want)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("got X-Amz-Date %q", got)
+			}
+		})
+	}
+}
+
+func TestCanonicalURIEncodesTwice(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/model/meta.llama3-8b-instruct-v1:0/converse", want: "/model/meta.llama3-8b-instruct-v1%3A0/converse"},
+		{path: "/model/meta.llama3-8b-instruct-v1%3A0/converse", want: "/model/meta.llama3-8b-instruct-v1%253A0/converse"},
+		{path: "/documents%20and%20settings/", want: "/documents%2520and%2520settings/"},
+		{path: "", want: "/"},
+	}
+	for _, tc := range tests {
+		req, err := http.NewRequest("POST", "https://bedrock-runtime.us-east-1.amazonaws.com"+tc.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := canonicalURI(req); got != tc.want {
+			t.Errorf("canonical URI of %q is %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+// Clear AWS environment variables and point shared files at a temporary directory for the rest of the test
+func clearAWSEnv(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	return dir
+}
+
+func TestLoadAWSCredentials(t *testing.T) {
+	const credentialsFile = `[default]
+aws_access_key_id = AKIDDEFAULT
+aws_secret_access_key = default-secret
+
+# Temporary credentials
+[work]
+aws_access_key_id=AKIDWORK
+aws_secret_access_key=work-secret
+aws_session_token=work-token
+`
+	const configFile = `[default]
+region = us-west-2
+
+[profile ci]
+aws_access_key_id = AKIDCI
+aws_secret_access_key = ci-secret
+`
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    awsCredentials
+		wantErr bool
+	}{
+		{name: "environment", env: map[string]string{"AWS_ACCESS_KEY_ID": "AKIDENV", "AWS_SECRET_ACCESS_KEY": "env-secret", "AWS_PROFILE": "work"}, want: awsCredentials{accessKeyID: "AKIDENV", secretAccessKey: "env-secret"}},
+		{name: "default profile", want: awsCredentials{accessKeyID: "AKIDDEFAULT", secretAccessKey: "default-secret"}},
+		{name: "profile with session token", env: map[string]string{"AWS_PROFILE": "work"}, want: awsCredentials{accessKeyID: "AKIDWORK", secretAccessKey: "work-secret", sessionToken: "work-token"}},
+		{name: "profile of config file", env: map[string]string{"AWS_PROFILE": "ci"}, want: awsCredentials{accessKeyID: "AKIDCI", secretAccessKey: "ci-secret"}},
+		{name: "unknown profile", env: map[string]string{"AWS_PROFILE": "missing"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := clearAWSEnv(t)
+			for name, value := range tc.env {
+				t.Setenv(name, value)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "credentials"), []byte(credentialsFile), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "config"), []byte(configFile), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			creds, err := loadAWSCredentials()
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("got credentials %+v, want error", creds)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadAWSCredentials: %v", err)
+			}
+			if creds != tc.want {
+				t.Errorf("got %+v, want %+v", creds, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+// Deduplicates concurrent identical requests so that only one of them calls llama API
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// In-flight call whose result is shared by all waiters
+type flightCall struct {
+	done chan struct{}
+	comp *completion
+	err  error
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: map[string]*flightCall{}}
+}
+
+// Execute fn once for all concurrent callers with the same key and share its result and error.
+// Waiters share the response of the first caller, so they also share its context cancellation.
+func (g *flightGroup) do(key string, fn func() (*completion, error)) (*completion, error) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.comp, call.err
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	call.comp, call.err = fn()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(call.done)
+
+	return call.comp, call.err
+}
+
+// Get SHA-256 hash of marshaled chat request
+func hashRequest(jsonData []byte) string {
+	sum := sha256.Sum256(jsonData)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSingleflight(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         []Option
+		status       int
+		prompt       func(i int) string
+		wantRequests int64
+	}{
+		{name: "identical calls share one request", opts: []Option{WithSingleflight()}, status: http.StatusOK, prompt: func(int) string { return "Say hello" }, wantRequests: 1},
+		{name: "identical calls share an error", opts: []Option{WithSingleflight()}, status: http.StatusInternalServerError, prompt: func(int) string { return "Say hello" }, wantRequests: 1},
+		{name: "distinct calls are not merged", opts: []Option{WithSingleflight()}, status: http.StatusOK, prompt: func(i int) string { return fmt.Sprintf("Say hello %d", i) }, wantRequests: 10},
+		{name: "off by default", status: http.StatusOK, prompt: func(int) string { return "Say hello" }, wantRequests: 10},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var requests atomic.Int64
+			release := make(chan struct{})
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				<-release
+				if tc.status != http.StatusOK {
+					respondJSON(tc.status, `{"error":{"message":"Down"}}`)(w, r)
+					return
+				}
+				respondJSON(http.StatusOK, chatCompletionJSON("Hello there"))(w, r)
+			}
+			_, client := newFakeServer(t, handler, tc.opts...)
+
+			var wg sync.WaitGroup
+			errs := make([]error, 10)
+			for i := range errs {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, errs[i] = client.Complete(context.Background(), tc.prompt(i))
+				}()
+			}
+
+			//Hold responses until the expected requests arrived and stragglers had time to join them
+			deadline := time.Now().Add(5 * time.Second)
+			for requests.Load() < tc.wantRequests && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(100 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			if n := requests.Load(); n != tc.wantRequests {
+				t.Errorf("server got %d requests, want %d", n, tc.wantRequests)
+			}
+			for i, err := range errs {
+				var apiErr *APIError
+				if tc.status == http.StatusOK && err != nil {
+					t.Errorf("call %d: %v", i+1, err)
+				}
+				if tc.status != http.StatusOK && (!errors.As(err, &apiErr) || apiErr.StatusCode != tc.status) {
+					t.Errorf("call %d got error %v, want APIError %d", i+1, err, tc.status)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+// Single server-sent event
+type sseEvent struct {
+	event string
+	data  string
+	id    string
+}
+
+// Reader of server-sent events, see https://html.spec.whatwg.org/multipage/server-sent-events.html
+type sseReader struct {
+	r *bufio.Reader
+	// Maximum bytes of a single event, 0 means no limit
+	limit int64
+}
+
+func newSSEReader(r io.Reader, limit int64) *sseReader {
+	return &sseReader{r: bufio.NewReader(r), limit: limit}
+}
+
+// Read next event which has data. Returns io.EOF when the stream ends between events.
+func (s *sseReader) next() (sseEvent, error) {
+	var event sseEvent
+	var data bytes.Buffer
+	var size int64
+	hasData := false
+	for {
+		line, err := s.readLine(size)
+		size += int64(len(line))
+		if s.limit > 0 && size > s.limit {
+			return sseEvent{}, &ResponseTooLargeError{Read: size, Limit: s.limit}
+		}
+		if err != nil && err != io.EOF {
+			return sseEvent{}, err
+		}
+		if err == io.EOF && line == "" {
+			//Dispatch event missing its trailing blank line
+			if hasData {
+				event.data = data.String()
+				return event, nil
+			}
+			return sseEvent{}, io.EOF
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		//Blank line dispatches the event, events without data are skipped
+		if line == "" {
+			if hasData {
+				event.data = data.String()
+				return event, nil
+			}
+			event = sseEvent{}
+			size = 0
+			continue
+		}
+		//Lines starting with colon are comments, e.g. keep-alive pings
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event.event = value
+		case "data":
+			if hasData {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+			hasData = true
+		case "id":
+			event.id = value
+		}
+	}
+}
+
+// Read next line, stopping early once the event would exceed the limit so that
+// a line without end is not buffered whole. size is the bytes of the event read before.
+func (s *sseReader) readLine(size int64) (string, error) {
+	var line []byte
+	for {
+		fragment, err := s.r.ReadSlice('\n')
+		line = append(line, fragment...)
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+		if s.limit > 0 && size+int64(len(line)) > s.limit {
+			return string(line), nil
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// Reading arbitrary streams must end with io.EOF or ResponseTooLargeError, never hang or return events over the limit.
+// Seeds are captured streams of the providers in testdata/fuzz/FuzzSSEReader.
+func FuzzSSEReader(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte, limit uint16) {
+		reader := newSSEReader(bytes.NewReader(data), int64(limit))
+		for events := 0; ; events++ {
+			event, err := reader.next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				if !errors.Is(err, ErrResponseTooLarge) {
+					t.Fatalf("got error %v, want io.EOF or ErrResponseTooLarge", err)
+				}
+				return
+			}
+			//Every event consumes at least one line of data
+			if events > len(data) {
+				t.Fatalf("got more events than bytes of %q", data)
+			}
+			if limit > 0 && len(event.data) > int(limit) {
+				t.Fatalf("got event of %d bytes, limit is %d", len(event.data), limit)
+			}
+		}
+	})
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Data of the event which terminates a stream
+const STREAM_DONE = "[DONE]"
+
+// Chunk of streamed chat completion
+type chatStreamChunk struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	Model   string `json:"model"`
+	// Upstream provider which served the stream, reported by OpenRouter
+	Provider string         `json:"provider,omitempty"`
+	Choices  []streamChoice `json:"choices"`
+	Error    *streamError   `json:"error,omitempty"`
+}
+
+type streamChoice struct {
+	Index        int        `json:"index"`
+	Delta        chunkDelta `json:"delta"`
+	FinishReason string     `json:"finish_reason"`
+}
+
+// Part of message added by a chunk
+type chunkDelta struct {
+	Role         string             `json:"role,omitempty"`
+	Content      string             `json:"content"`
+	FunctionCall *functionCallDelta `json:"function_call,omitempty"`
+	ToolCalls    []toolCallDelta    `json:"tool_calls,omitempty"`
+}
+
+// Part of function call added by a chunk, the name or a fragment of the arguments
+type functionCallDelta struct {
+	Name      string            `json:"name,omitempty"`
+	Arguments functionArguments `json:"arguments,omitempty"`
+}
+
+// Part of a call in tool_calls, the format of servers which dropped legacy functions
+type toolCallDelta struct {
+	Index    int               `json:"index"`
+	ID       string            `json:"id,omitempty"`
+	Type     string            `json:"type,omitempty"`
+	Function functionCallDelta `json:"function"`
+}
+
+// Error reported in the middle of a stream
+type streamError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+// Body which releases the context of its request when closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// Send a prompt to llama API and call onChunk with each delta of generated text as it arrives.
+// Returning an error from onChunk aborts the stream. Full generated text is returned at the end,
+// along with whatever had been received when the stream fails.
+func (c *Client) GenerateStream(ctx context.Context, prompt string, onChunk func(delta string) error, opts ...CallOption) (string, error) {
+	call, err := c.newCallOptions(opts)
+	if err != nil {
+		return "", err
+	}
+
+	chatReq := c.newChatRequest([]reqMessage{
+		reqMessage{Role: "user", Content: prompt},
+	}, call)
+	if call.model != "" {
+		chatReq.Model = call.model
+	}
+	msg, err := c.streamChat(ctx, chatReq, call, onChunk)
+	if msg == nil {
+		return "", err
+	}
+	return msg.Content, err
+}
+
+// Send messages with streaming enabled, calling onChunk with each delta of generated text.
+// Function calls, whose arguments arrive in fragments across many chunks, are assembled and
+// returned in FunctionCall of the result once the stream finishes with finish reason "function_call".
+// Usage is not reported by streams. Along with errors, the result holds whatever had been received.
+func (c *Client) ChatStream(ctx context.Context, messages []reqMessage, onChunk func(delta string) error, opts ...CallOption) (*GenerateResult, error) {
+	call, err := c.newCallOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	chatReq := c.newChatRequest(messages, call)
+	if call.model != "" {
+		chatReq.Model = call.model
+	}
+	msg, err := c.streamChat(ctx, chatReq, call, onChunk)
+	if msg == nil {
+		return nil, err
+	}
+
+	result := &GenerateResult{Content: msg.Content, Role: "assistant", FinishReason: msg.FinishReason, Model: msg.Model, RequestedModel: chatReq.Model, UpstreamProvider: msg.Provider}
+	if msg.FunctionCall != nil {
+		result.FunctionCall = *msg.FunctionCall
+	}
+	if result.Model == "" {
+		result.Model = chatReq.Model
+	}
+	if msg.header != nil {
+		result.ProviderRequestID, result.CFRay = providerRequestIDs(msg.header)
+	}
+	return result, err
+}
+
+// Send a prompt to llama API and write generated text to w as it arrives.
+// When w is an http.Flusher, e.g. an http.ResponseWriter, it is flushed after each delta
+// so that clients see tokens immediately. Writing stops once ctx is cancelled.
+func (c *Client) GenerateStreamTo(ctx context.Context, prompt string, w io.Writer, opts ...CallOption) (string, error) {
+	flusher, _ := w.(http.Flusher)
+	return c.GenerateStream(ctx, prompt, func(delta string) error {
+		//Client of a proxied stream may have gone away
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, delta); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}, opts...)
+}
+
+// Send chat request with streaming enabled and pass deltas of the first choice to onChunk
+func (c *Client) streamChat(ctx context.Context, chatReq *chatRequest, call *callOptions, onChunk func(delta string) error) (msg *streamedMessage, err error) {
+	//Record outcome and latency of the whole stream
+	if c.metrics != nil {
+		start := time.Now()
+		defer func() {
+			outcome := "success"
+			if err != nil {
+				outcome = "error"
+			}
+			c.metrics.observeRequest(chatReq.Model, outcome, time.Since(start))
+		}()
+	}
+
+	//Catch schema mistakes locally before the network call
+	if err := validateFunctions(chatReq.Functions); err != nil {
+		log.Printf("Failed to validate functions: %v", err)
+		return nil, err
+	}
+	chatReq, err = c.applyCapabilities(chatReq)
+	if err != nil {
+		return nil, err
+	}
+
+	//Tag the operation with request ID for tracing
+	ctx, requestID := ensureRequestID(ctx)
+	op := &operation{requestID: requestID, idempotencyKey: idempotencyKeyFor(ctx), model: chatReq.Model, header: call.header, secrets: c.secrets(), stream: true, debug: c.debug}
+	op.timeout, op.attemptTimeout = c.timeoutsFor(call)
+
+	if c.missingAPIKey() {
+		op.logf("Failed to get API KEY: %v", ErrMissingAPIKey)
+		return nil, ErrMissingAPIKey
+	}
+
+	//Marshal Go struct into Json
+	chatReq.Stream = true
+	op.body, err = c.encodeChatRequest(chatReq)
+	if err != nil {
+		op.logf("Failed to Marshal: %v", err)
+		return nil, err
+	}
+	defer op.body.release()
+
+	//Overall timeout bounds the whole stream, not only its start
+	if op.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, op.timeout)
+		defer cancel()
+	}
+
+	//Wait until estimated tokens fit into tokens per minute budget
+	var reservation *tokenEntry
+	if c.tokenLimiter != nil {
+		reservation, err = c.tokenLimiter.reserve(ctx, estimateRequestTokens(chatReq))
+		if err != nil {
+			err = fmt.Errorf("Interrupted while waiting for token budget: %w", err)
+			op.logf("Failed to send request: %v", err)
+			return nil, err
+		}
+	}
+
+	//Retry until the stream is opened, nothing has been passed to onChunk yet
+	res, _, err := c.sendWithRetry(ctx, op)
+	if err != nil {
+		if reservation != nil {
+			c.tokenLimiter.reconcile(reservation, 0)
+		}
+		return nil, err
+	}
+	defer closeBody(res.Body)
+
+	msg, err = c.readStream(op, res.Body, onChunk)
+	msg.header = res.Header
+	if reservation != nil {
+		c.tokenLimiter.reconcile(reservation, estimateRequestTokens(chatReq)-chatReq.MaxTokens+EstimateTokens(msg.text()))
+	}
+	return msg, err
+}
+
+// Read chunks from event stream until [DONE], passing content deltas of the first choice to onChunk
+// and accumulating its function call. The message received so far is returned along with errors.
+func (c *Client) readStream(op *operation, body io.Reader, onChunk func(delta string) error) (*streamedMessage, error) {
+	events := newSSEReader(body, c.maxResponseBytes)
+	acc := &streamAccumulator{limit: c.maxResponseBytes}
+	for {
+		event, err := events.next()
+		if err == io.EOF {
+			//Some servers close the stream after the final chunk without [DONE]
+			if acc.finishReason != "" {
+				return acc.finish(op)
+			}
+			err = fmt.Errorf("Stream ended before completion: %w", io.ErrUnexpectedEOF)
+			op.logf("Failed to read stream: %v", err)
+			return acc.message(), err
+		}
+		if err != nil {
+			err = fmt.Errorf("Failed to read stream: %w", err)
+			op.logf("%v", err)
+			return acc.message(), err
+		}
+		if event.data == STREAM_DONE {
+			return acc.finish(op)
+		}
+
+		//Unmarshal json chunk into Go struct
+		chunk, done, err := c.decodeStreamChunk([]byte(event.data))
+		if err != nil {
+			err := newDecodeError(nil, []byte(event.data), err)
+			op.logf("Failed to unmarshal: %v", err)
+			return acc.message(), err
+		}
+		if done {
+			return acc.finish(op)
+		}
+		if chunk == nil {
+			continue
+		}
+		if chunk.Error != nil {
+			err := fmt.Errorf("Stream error from llama API: %s", c.redact(chunk.Error.Message))
+			op.logf("%v", err)
+			return acc.message(), err
+		}
+		if acc.model == "" {
+			acc.model = chunk.Model
+		}
+		if acc.provider == "" {
+			acc.provider = chunk.Provider
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Index != 0 {
+				continue
+			}
+			if choice.FinishReason != "" {
+				acc.finishReason = normalizeFinishReason(choice.FinishReason)
+			}
+			acc.addFunctionCall(op, choice.Delta)
+			acc.content.WriteString(choice.Delta.Content)
+			//Bound the message, not just each event, against streams which never end
+			if acc.limit > 0 && acc.size() > acc.limit {
+				err := &ResponseTooLargeError{Read: acc.size(), Limit: acc.limit}
+				op.logf("Failed to read stream: %v", err)
+				return acc.message(), err
+			}
+			if choice.Delta.Content == "" {
+				continue
+			}
+			if err := onChunk(choice.Delta.Content); err != nil {
+				err = fmt.Errorf("Stream aborted by callback: %w", err)
+				op.logf("%v", err)
+				return acc.message(), err
+			}
+		}
+	}
+}
+
+// Message assembled from the deltas of a stream
+type streamedMessage struct {
+	Content      string
+	FinishReason string
+	// Set when the model called a function
+	FunctionCall *functionCall
+	// Model which served the stream, as the chunks name it
+	Model string
+	// Upstream provider which served the stream, reported by routers such as OpenRouter
+	Provider string
+	// Headers of the response carrying the stream
+	header http.Header
+}
+
+// State of a stream being read: content so far and the function call, whose name usually
+// comes in the first delta and whose arguments are split across many
+type streamAccumulator struct {
+	content      strings.Builder
+	finishReason string
+	model        string
+	provider     string
+	calling      bool
+	name         string
+	arguments    strings.Builder
+	// Whether a warning about parallel tool calls was logged
+	warned bool
+	// Maximum bytes of the message, 0 means no limit
+	limit int64
+}
+
+// Get bytes of the message received so far
+func (a *streamAccumulator) size() int64 {
+	return int64(a.content.Len() + len(a.name) + a.arguments.Len())
+}
+
+// Add function call delta, given either as legacy function_call or as the first of tool_calls
+func (a *streamAccumulator) addFunctionCall(op *operation, delta chunkDelta) {
+	if delta.FunctionCall != nil {
+		a.addCallDelta(*delta.FunctionCall)
+	}
+	for _, call := range delta.ToolCalls {
+		if call.Index != 0 {
+			if !a.warned {
+				op.logf("WARNING: Ignoring parallel tool call at index %d, only the first call is returned", call.Index)
+				a.warned = true
+			}
+			continue
+		}
+		a.addCallDelta(call.Function)
+	}
+}
+
+func (a *streamAccumulator) addCallDelta(delta functionCallDelta) {
+	a.calling = true
+	//Most servers send the name once, some repeat it in every delta
+	if delta.Name != "" && delta.Name != a.name {
+		a.name += delta.Name
+	}
+	a.arguments.WriteString(string(delta.Arguments))
+}
+
+// Get generated text, including arguments of the function call, e.g. to count tokens
+func (m *streamedMessage) text() string {
+	if m.FunctionCall == nil {
+		return m.Content
+	}
+	return m.Content + m.FunctionCall.Name + string(m.FunctionCall.Arguments)
+}
+
+// Get message received so far
+func (a *streamAccumulator) message() *streamedMessage {
+	msg := &streamedMessage{Content: a.content.String(), FinishReason: a.finishReason, Model: a.model, Provider: a.provider}
+	if a.calling {
+		msg.FunctionCall = &functionCall{Name: a.name, Arguments: functionArguments(a.arguments.String())}
+	}
+	return msg
+}
+
+// Get message of a completed stream, checking its function call is complete
+func (a *streamAccumulator) finish(op *operation) (*streamedMessage, error) {
+	msg := a.message()
+	if msg.FinishReason == "tool_calls" {
+		msg.FinishReason = "function_call"
+	}
+	if msg.FinishReason == "content_filter" {
+		err := &ContentFilteredError{Content: msg.Content, Model: msg.Model}
+		op.logf("Failed to read stream: %v", err)
+		return msg, err
+	}
+	if msg.FunctionCall == nil {
+		if msg.FinishReason == "function_call" {
+			err := fmt.Errorf("%w: stream finished with function_call but sent no function call", ErrUnexpectedResponse)
+			op.logf("Failed to read stream: %v", err)
+			return msg, err
+		}
+		return msg, nil
+	}
+
+	if msg.FunctionCall.Name == "" {
+		err := fmt.Errorf("%w: function call in stream has no name", ErrUnexpectedResponse)
+		op.logf("Failed to read stream: %v", err)
+		return msg, err
+	}
+	if args := msg.FunctionCall.Arguments; args != "" && !json.Valid([]byte(args)) {
+		err := fmt.Errorf("%w: arguments of function call %s in stream are not complete JSON: %s",
+			ErrUnexpectedResponse, msg.FunctionCall.Name, bodySnippet([]byte(args), ERROR_SNIPPET_BYTES))
+		op.logf("Failed to read stream: %v", err)
+		return msg, err
+	}
+	return msg, nil
+}
+
+// Decode data of a stream event, translating it when the provider has an adapter.
+// The chunk is nil for events without content and done is true for the event ending the stream.
+func (c *Client) decodeStreamChunk(data []byte) (*chatStreamChunk, bool, error) {
+	if adapter := c.provider.adapter; adapter != nil {
+		return adapter.decodeStreamEvent(data)
+	}
+	chunk := &chatStreamChunk{}
+	if err := c.decodeJSON(data, chunk); err != nil {
+		return nil, false, err
+	}
+	return chunk, false, nil
+}
+
+// Open stream bounded by per-attempt timeout until response headers arrive.
+// The attempt context lives on with the response body and is released when the body is closed.
+func (c *Client) sendStreamAttempt(ctx context.Context, op *operation) (*http.Response, []byte, error) {
+	attemptCtx, cancel := context.WithCancel(ctx)
+	var timer *time.Timer
+	if op.attemptTimeout > 0 {
+		timer = time.AfterFunc(op.attemptTimeout, cancel)
+	}
+
+	res, _, err := c.send(attemptCtx, op)
+
+	//Distinguish expiry of this attempt from the overall deadline so that it can be retried
+	if timer != nil && !timer.Stop() && ctx.Err() == nil {
+		if err == nil {
+			closeBody(res.Body)
+		}
+		err = fmt.Errorf("%w after %v", ErrAttemptTimeout, op.attemptTimeout)
+	}
+	err = transportTimeout(ctx, err)
+	if err != nil {
+		cancel()
+		return res, nil, err
+	}
+
+	res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+	return res, nil, nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Handler streaming each delta in its own chunk, flushing after every one
+func respondDeltas(deltas ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+		for i, delta := range deltas {
+			choice := streamChoice{Delta: chunkDelta{Content: delta}}
+			if i == len(deltas)-1 {
+				choice.FinishReason = "stop"
+			}
+			chunk, _ := json.Marshal(chatStreamChunk{Choices: []streamChoice{choice}})
+			io.WriteString(w, "data: "+string(chunk)+"\n\n")
+			flusher.Flush()
+		}
+		io.WriteString(w, "data: [DONE]\n\n")
+	}
+}
+
+// Writer recording writes and flushes in the order they happen
+type flushRecorder struct {
+	calls []string
+}
+
+func (w *flushRecorder) Write(p []byte) (int, error) {
+	w.calls = append(w.calls, "write "+string(p))
+	return len(p), nil
+}
+
+func (w *flushRecorder) Flush() {
+	w.calls = append(w.calls, "flush")
+}
+
+func TestGenerateStreamToFlushes(t *testing.T) {
+	_, client := newFakeServer(t, respondDeltas("Hel", "lo", " there"))
+
+	w := &flushRecorder{}
+	content, err := client.GenerateStreamTo(context.Background(), "Say hello", w)
+	if err != nil {
+		t.Fatalf("GenerateStreamTo: %v", err)
+	}
+	if content != "Hello there" {
+		t.Errorf("got content %q", content)
+	}
+	want := []string{"write Hel", "flush", "write lo", "flush", "write  there", "flush"}
+	if !slices.Equal(w.calls, want) {
+		t.Errorf("got calls %q, want %q", w.calls, want)
+	}
+}
+
+func TestGenerateStreamToResponseWriter(t *testing.T) {
+	_, client := newFakeServer(t, respondDeltas("Hel", "lo"))
+
+	rec := httptest.NewRecorder()
+	if _, err := client.GenerateStreamTo(context.Background(), "Say hello", rec); err != nil {
+		t.Fatalf("GenerateStreamTo: %v", err)
+	}
+	if !rec.Flushed || rec.Body.String() != "Hello" {
+		t.Errorf("got body %q flushed %v, want Hello flushed", rec.Body, rec.Flushed)
+	}
+}
+
+func TestGenerateStreamToPlainWriter(t *testing.T) {
+	_, client := newFakeServer(t, respondDeltas("Hel", "lo"))
+
+	//Hide all methods of the buffer but Write
+	var buf bytes.Buffer
+	w := struct{ io.Writer }{&buf}
+	if _, err := client.GenerateStreamTo(context.Background(), "Say hello", w); err != nil {
+		t.Fatalf("GenerateStreamTo: %v", err)
+	}
+	if buf.String() != "Hello" {
+		t.Errorf("got %q, want Hello", buf.String())
+	}
+}
+
+// Writer cancelling the stream once it got its first write, like a browser going away
+type cancellingWriter struct {
+	flushRecorder
+	cancel context.CancelFunc
+}
+
+func (w *cancellingWriter) Write(p []byte) (int, error) {
+	w.cancel()
+	return w.flushRecorder.Write(p)
+}
+
+func TestGenerateStreamToStopsWhenCancelled(t *testing.T) {
+	_, client := newFakeServer(t, respondDeltas("Hel", "lo", " there"))
+	captureLogs(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &cancellingWriter{cancel: cancel}
+	_, err := client.GenerateStreamTo(ctx, "Say hello", w)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+	if want := []string{"write Hel", "flush"}; !slices.Equal(w.calls, want) {
+		t.Errorf("got calls %q, want nothing after the cancelled write", w.calls)
+	}
+}
+
+// Writer failing every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func TestGenerateStreamToWriteError(t *testing.T) {
+	_, client := newFakeServer(t, respondDeltas("Hel", "lo"))
+	captureLogs(t)
+
+	if _, err := client.GenerateStreamTo(context.Background(), "Say hello", failingWriter{}); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("got error %v, want the write error", err)
+	}
+}
+
+// Handler streaming each chunk as an event, followed by [DONE]
+func respondEvents(chunks ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			io.WriteString(w, "data: "+chunk+"\n\n")
+		}
+		io.WriteString(w, "data: [DONE]\n\n")
+	}
+}
+
+func TestStreamFunctionCall(t *testing.T) {
+	weatherCall := functionCall{Name: "get_weather", Arguments: `{"city":"Tokyo","unit":"celsius"}`}
+
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		wantContent string
+		wantCall    functionCall
+		wantLog     string
+	}{
+		{
+			name:     "function_call fragments",
+			handler:  respondFixture(t, "openai", "stream_function_call.txt"),
+			wantCall: weatherCall,
+		},
+		{
+			name:     "tool_calls fragments",
+			handler:  respondFixture(t, "openai", "stream_tool_calls.txt"),
+			wantCall: weatherCall,
+			wantLog:  "Ignoring parallel tool call at index 1",
+		},
+		{
+			name: "name repeated in every delta",
+			handler: respondEvents(
+				`{"choices":[{"index":0,"delta":{"function_call":{"name":"get_weather","arguments":"{\"city\":"}}}]}`,
+				`{"choices":[{"index":0,"delta":{"function_call":{"name":"get_weather","arguments":"\"Tok"}}}]}`,
+				`{"choices":[{"index":0,"delta":{"function_call":{"name":"get_weather","arguments":"yo\",\"unit\":\"celsius\"}"}},"finish_reason":"function_call"}]}`,
+			),
+			wantCall: weatherCall,
+		},
+		{
+			name: "arguments as object",
+			handler: respondEvents(
+				`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"name":"get_weather","arguments":{"city":"Tokyo","unit":"celsius"}}}]},"finish_reason":"tool_calls"}]}`,
+			),
+			wantCall: weatherCall,
+		},
+		{
+			name: "content before call",
+			handler: respondEvents(
+				`{"choices":[{"index":0,"delta":{"role":"assistant","content":"Let me check."}}]}`,
+				`{"choices":[{"index":0,"delta":{"function_call":{"name":"get_weather","arguments":"{\"city\":\"Tokyo\",\"unit\":\"cel"}}}]}`,
+				`{"choices":[{"index":0,"delta":{"function_call":{"arguments":"sius\"}"}},"finish_reason":"function_call"}]}`,
+			),
+			wantContent: "Let me check.",
+			wantCall:    weatherCall,
+		},
+	}
+
+	messages := []reqMessage{{Role: "user", Content: "What is the weather in Tokyo?"}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, client := newFakeServer(t, tc.handler, WithFunctions(weatherFunction))
+			logs := captureLogs(t)
+
+			var deltas string
+			result, err := client.ChatStream(context.Background(), messages, func(delta string) error {
+				deltas += delta
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("ChatStream: %v", err)
+			}
+			if result.FinishReason != "function_call" || result.FunctionCall != tc.wantCall {
+				t.Errorf("got call %+v finishing %s, want %+v finishing function_call", result.FunctionCall, result.FinishReason, tc.wantCall)
+			}
+			if result.Content != tc.wantContent || deltas != tc.wantContent {
+				t.Errorf("got content %q streamed as %q, want %q without arguments", result.Content, deltas, tc.wantContent)
+			}
+			if !strings.Contains(logs.String(), tc.wantLog) {
+				t.Errorf("log lacks %q: %s", tc.wantLog, logs)
+			}
+			if _, err := DecodeArguments[struct{ City, Unit string }](result.FunctionCall); err != nil {
+				t.Errorf("DecodeArguments: %v", err)
+			}
+		})
+	}
+}
+
+func TestStreamFunctionCallIncomplete(t *testing.T) {
+	tests := []struct {
+		name    string
+		chunks  []string
+		wantErr string
+	}{
+		{
+			name: "truncated arguments",
+			chunks: []string{
+				`{"choices":[{"index":0,"delta":{"function_call":{"name":"get_weather","arguments":"{\"city\":\"To"}}}]}`,
+				`{"choices":[{"index":0,"delta":{},"finish_reason":"function_call"}]}`,
+			},
+			wantErr: "not complete JSON",
+		},
+		{
+			name: "no name",
+			chunks: []string{
+				`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{}"}}]}}]}`,
+				`{"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+			},
+			wantErr: "has no name",
+		},
+		{
+			name: "no call",
+			chunks: []string{
+				`{"choices":[{"index":0,"delta":{"content":"Hmm"}}]}`,
+				`{"choices":[{"index":0,"delta":{},"finish_reason":"function_call"}]}`,
+			},
+			wantErr: "sent no function call",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, client := newFakeServer(t, respondEvents(tc.chunks...), WithFunctions(weatherFunction))
+			captureLogs(t)
+
+			_, err := client.ChatStream(context.Background(), []reqMessage{{Role: "user", Content: "Weather?"}}, func(string) error { return nil })
+			if !errors.Is(err, ErrUnexpectedResponse) || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("got error %v, want ErrUnexpectedResponse with %q", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Estimate number of tokens in text, roughly 4 characters per token.
+// This is an approximation for budgeting MaxTokens and trimming prompts, not a real tokenizer,
+// so counts may differ from what the API reports.
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// Estimate tokens consumed by a chat request, including the completion allowance of max_tokens
+func estimateRequestTokens(chatReq *chatRequest) int {
+	tokens := chatReq.MaxTokens
+	for _, m := range chatReq.Messages {
+		tokens += EstimateTokens(m.text())
+	}
+	return tokens
+}
+
+// Drop the oldest messages until the estimated tokens of messages fit budget, 0 meaning no budget.
+// Leading system messages and the latest user message are kept even over budget, with a warning.
+func trimToTokens(messages []reqMessage, budget int) []reqMessage {
+	if budget <= 0 {
+		return messages
+	}
+	total := 0
+	for _, m := range messages {
+		total += EstimateTokens(m.text())
+	}
+	if total <= budget {
+		return messages
+	}
+
+	system := 0
+	for system < len(messages) && messages[system].Role == "system" {
+		system++
+	}
+	latestUser := len(messages)
+	for i := len(messages) - 1; i >= system; i-- {
+		if messages[i].Role == "user" {
+			latestUser = i
+			break
+		}
+	}
+
+	//Messages from system up to drop are left out, oldest first
+	drop := system
+	for drop < latestUser && total > budget {
+		total -= EstimateTokens(messages[drop].text())
+		drop++
+	}
+	if total > budget {
+		log.Printf("WARNING: Prompt of about %d tokens exceeds budget of %d even after dropping old messages", total, budget)
+	}
+	trimmed := make([]reqMessage, 0, len(messages)-drop+system)
+	trimmed = append(trimmed, messages[:system]...)
+	return append(trimmed, messages[drop:]...)
+}
+
+// Sliding window of tokens spent within the last minute, limiting tokens per minute
+type tokenWindow struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	entries []*tokenEntry
+	clock   clock
+}
+
+// Tokens reserved by a single request
+type tokenEntry struct {
+	at     time.Time
+	tokens int
+}
+
+func newTokenWindow(tpm int, clk clock) *tokenWindow {
+	return &tokenWindow{limit: tpm, window: time.Minute, clock: clk}
+}
+
+// Block until given tokens fit into the window, then reserve them.
+// A request larger than the limit is let through once the window is empty.
+func (w *tokenWindow) reserve(ctx context.Context, tokens int) (*tokenEntry, error) {
+	for {
+		w.mu.Lock()
+		now := w.clock.Now()
+		w.prune(now)
+		used := w.sum()
+		if used+tokens <= w.limit || len(w.entries) == 0 {
+			e := &tokenEntry{at: now, tokens: tokens}
+			w.entries = append(w.entries, e)
+			w.mu.Unlock()
+			return e, nil
+		}
+
+		//Wait until enough of the oldest entries slide out of the window, all of them for a request over the limit
+		need := used + tokens - w.limit
+		var wait time.Duration
+		freed := 0
+		for _, e := range w.entries {
+			freed += e.tokens
+			wait = e.at.Add(w.window).Sub(now)
+			if freed >= need {
+				break
+			}
+		}
+		w.mu.Unlock()
+
+		if err := w.clock.Sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Replace estimated tokens of a reservation with actual usage reported by the API
+func (w *tokenWindow) reconcile(e *tokenEntry, tokens int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	e.tokens = tokens
+}
+
+// Get tokens spent within the current window and the limit
+func (w *tokenWindow) usage() (int, int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.prune(w.clock.Now())
+	return w.sum(), w.limit
+}
+
+// Drop entries which are out of the window
+func (w *tokenWindow) prune(now time.Time) {
+	i := 0
+	for i < len(w.entries) && now.Sub(w.entries[i].at) >= w.window {
+		i++
+	}
+	w.entries = w.entries[i:]
+}
+
+func (w *tokenWindow) sum() int {
+	total := 0
+	for _, e := range w.entries {
+		total += e.tokens
+	}
+	return total
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Message whose text EstimateTokens counts as exactly tokens
+func messageOfTokens(role, name string, tokens int) reqMessage {
+	return reqMessage{Role: role, Content: name + strings.Repeat(".", 4*tokens-len(name))}
+}
+
+func TestTrimToTokens(t *testing.T) {
+	system := messageOfTokens("system", "system", 10)
+	oldUser := messageOfTokens("user", "old user", 10)
+	oldAssistant := messageOfTokens("assistant", "old assistant", 10)
+	user := messageOfTokens("user", "user", 10)
+	call := messageOfTokens("assistant", "call", 10)
+	result := messageOfTokens("function", "result", 10)
+	huge := messageOfTokens("user", "huge", 50)
+
+	tests := []struct {
+		name     string
+		messages []reqMessage
+		budget   int
+		want     []reqMessage
+		wantWarn bool
+	}{
+		{name: "no budget", messages: []reqMessage{system, oldUser, huge}, want: []reqMessage{system, oldUser, huge}},
+		{name: "within budget", messages: []reqMessage{system, oldUser, oldAssistant, user}, budget: 40, want: []reqMessage{system, oldUser, oldAssistant, user}},
+		{name: "oldest dropped first", messages: []reqMessage{system, oldUser, oldAssistant, user}, budget: 30, want: []reqMessage{system, oldAssistant, user}},
+		{name: "system and latest user kept", messages: []reqMessage{system, oldUser, oldAssistant, user}, budget: 20, want: []reqMessage{system, user}},
+		{name: "messages after latest user kept", messages: []reqMessage{system, oldUser, user, call, result}, budget: 40, want: []reqMessage{system, user, call, result}},
+		{name: "latest user kept over newer replies", messages: []reqMessage{system, user, call, result}, budget: 20, want: []reqMessage{system, user, call, result}, wantWarn: true},
+		{name: "system over budget warns", messages: []reqMessage{system, oldUser, user}, budget: 15, want: []reqMessage{system, user}, wantWarn: true},
+		{name: "only system messages warn", messages: []reqMessage{system, system}, budget: 15, want: []reqMessage{system, system}, wantWarn: true},
+		{name: "latest user over budget warns", messages: []reqMessage{system, oldUser, huge}, budget: 40, want: []reqMessage{system, huge}, wantWarn: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			got := trimToTokens(tc.messages, tc.budget)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %d messages %v, want %d messages %v", len(got), got, len(tc.want), tc.want)
+			}
+			if warned := strings.Contains(logs.String(), "WARNING"); warned != tc.wantWarn {
+				t.Errorf("got warning %v, want %v: %s", warned, tc.wantWarn, logs)
+			}
+		})
+	}
+}
+
+func TestMaxPromptTokensSendsOversizedMessage(t *testing.T) {
+	f, client := newFakeServer(t, respondChat("Hi"), WithMaxPromptTokens(20))
+	logs := captureLogs(t)
+	old := messageOfTokens("user", "old", 10)
+	huge := messageOfTokens("user", "huge", 50)
+
+	if _, err := client.Chat(context.Background(), []reqMessage{old, huge}); err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	reqs := f.captured()
+	if len(reqs) != 1 {
+		t.Fatalf("server got %d requests, want 1", len(reqs))
+	}
+	var body chatRequest
+	if err := json.Unmarshal(reqs[0].Body, &body); err != nil {
+		t.Fatalf("Failed to decode request: %v", err)
+	}
+	if len(body.Messages) != 1 || body.Messages[0].Content != huge.Content {
+		t.Errorf("got messages %v, want only the latest user message", body.Messages)
+	}
+	if !strings.Contains(logs.String(), "WARNING") {
+		t.Errorf("got no warning: %s", logs)
+	}
+}
+
+func TestTokenWindowSlides(t *testing.T) {
+	ctx := context.Background()
+	clk := newFakeClock()
+	window := newTokenWindow(100, clk)
+	reserve := func(tokens int) *tokenEntry {
+		t.Helper()
+		e, err := window.reserve(ctx, tokens)
+		if err != nil {
+			t.Fatalf("reserve %d: %v", tokens, err)
+		}
+		return e
+	}
+	wantUsage := func(want int) {
+		t.Helper()
+		if used, limit := window.usage(); used != want || limit != 100 {
+			t.Errorf("got usage %d of %d, want %d of 100", used, limit, want)
+		}
+	}
+
+	//Reservations within the limit go through at once
+	reserve(60)
+	clk.Advance(20 * time.Second)
+	reserve(30)
+	wantUsage(90)
+	if slept := clk.slept(); len(slept) != 0 {
+		t.Fatalf("slept %v within the limit", slept)
+	}
+
+	//Over the limit it waits until the oldest entry slides out, a minute after it was reserved
+	last := reserve(50)
+	if slept := clk.slept(); !slices.Equal(slept, []time.Duration{40 * time.Second}) {
+		t.Fatalf("slept %v, want [40s]", slept)
+	}
+	wantUsage(80)
+	clk.Advance(20 * time.Second)
+	wantUsage(50)
+
+	//Actual usage replaces the estimate
+	window.reconcile(last, 10)
+	wantUsage(10)
+
+	//A reservation larger than the limit waits for the window to empty
+	reserve(500)
+	if slept := clk.slept(); !slices.Equal(slept, []time.Duration{40 * time.Second, 40 * time.Second}) {
+		t.Errorf("slept %v, want [40s 40s]", slept)
+	}
+	wantUsage(500)
+
+	//Waiting gives up with the context
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := window.reserve(cancelled, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+}
+
+func TestTokenRateLimitReconcilesUsage(t *testing.T) {
+	clk := newFakeClock()
+	//Each request reserves max_tokens, far more than the 7 tokens of usage the server reports
+	_, client := newFakeServer(t, respondJSON(http.StatusOK, chatCompletionJSON("Hello there")), withClock(clk), WithTokenRateLimit(100), WithMaxTokens(60))
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.Complete(context.Background(), "Say hello"); err != nil {
+			t.Fatalf("Complete %d: %v", i+1, err)
+		}
+	}
+	if slept := clk.slept(); len(slept) != 0 {
+		t.Errorf("slept %v, want reservations reconciled to usage without waiting", slept)
+	}
+	if used, limit := client.TokenUsage(); used != 21 || limit != 100 {
+		t.Errorf("got token usage %d of %d, want 21 of 100", used, limit)
+	}
+	clk.Advance(time.Minute)
+	if used, _ := client.TokenUsage(); used != 0 {
+		t.Errorf("got token usage %d a minute later, want 0", used)
+	}
+}
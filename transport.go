@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Default values of transport timeouts
+const (
+	DEFAULT_DIAL_TIMEOUT            = 5 * time.Second
+	DEFAULT_TLS_HANDSHAKE_TIMEOUT   = 5 * time.Second
+	DEFAULT_RESPONSE_HEADER_TIMEOUT = 30 * time.Second
+	DEFAULT_EXPECT_CONTINUE_TIMEOUT = 1 * time.Second
+)
+
+// Default connection pool settings, tuned for talking to a single host
+const (
+	DEFAULT_MAX_IDLE_CONNS          = 100
+	DEFAULT_MAX_IDLE_CONNS_PER_HOST = 32
+	DEFAULT_IDLE_CONN_TIMEOUT       = 90 * time.Second
+)
+
+// Maximum bytes discarded from an unread body so its connection can be reused
+const MAX_DRAIN_BYTES = 256 << 10
+
+// Build default transport for llama API client which dials with given dialer
+func newTransport(dialer *net.Dialer) *http.Transport {
+	return &http.Transport{
+		//Honor HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          DEFAULT_MAX_IDLE_CONNS,
+		MaxIdleConnsPerHost:   DEFAULT_MAX_IDLE_CONNS_PER_HOST,
+		IdleConnTimeout:       DEFAULT_IDLE_CONN_TIMEOUT,
+		TLSHandshakeTimeout:   DEFAULT_TLS_HANDSHAKE_TIMEOUT,
+		ResponseHeaderTimeout: DEFAULT_RESPONSE_HEADER_TIMEOUT,
+		ExpectContinueTimeout: DEFAULT_EXPECT_CONTINUE_TIMEOUT,
+	}
+}
+
+// Build dialer used by default transport
+func newDialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:   DEFAULT_DIAL_TIMEOUT,
+		KeepAlive: 30 * time.Second,
+	}
+}
+
+// Get TLS configuration of client transport, creating it on first use
+func (c *Client) tlsConfig() *tls.Config {
+	if c.transport.TLSClientConfig == nil {
+		c.transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return c.transport.TLSClientConfig
+}
+
+// Drain what is left of response body and close it, returning the connection to the pool.
+// Bodies larger than MAX_DRAIN_BYTES are closed without draining, which costs the connection.
+func closeBody(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, MAX_DRAIN_BYTES))
+	body.Close()
+}
+
+// Add CA certificates in PEM to trusted roots, starting from system roots when none are set yet.
+// source names the PEM in error messages.
+func (c *Client) addCACert(pemBytes []byte, source string) error {
+	cfg := c.tlsConfig()
+	var pool *x509.CertPool
+	if cfg.RootCAs != nil {
+		//Copy so that a pool given with WithRootCAs is not modified
+		pool = cfg.RootCAs.Clone()
+	} else {
+		var err error
+		pool, err = x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+	}
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return fmt.Errorf("No valid certificates found in %s", source)
+	}
+	cfg.RootCAs = pool
+	return nil
+}
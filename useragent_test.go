@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"runtime"
+	"testing"
+)
+
+func TestUserAgent(t *testing.T) {
+	base := regexp.QuoteMeta("go-llama/") + `\d+\.\d+\.\d+\S* ` + regexp.QuoteMeta("("+runtime.GOOS+"; "+runtime.GOARCH+")")
+
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{name: "default", want: "^" + base + "$"},
+		{name: "suffix", opts: []Option{WithUserAgentSuffix("my-app/1.2")}, want: "^" + base + regexp.QuoteMeta(" my-app/1.2") + "$"},
+		{name: "suffix trimmed", opts: []Option{WithUserAgentSuffix("  my-app/1.2 ")}, want: "^" + base + regexp.QuoteMeta(" my-app/1.2") + "$"},
+		{name: "replaced with suffix", opts: []Option{WithUserAgent("gateway-bot/2"), WithUserAgentSuffix("my-app/1.2")}, want: "^" + regexp.QuoteMeta("gateway-bot/2 my-app/1.2") + "$"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			capture := &requestCapture{}
+			_, client := newFakeServer(t, respondChat("Hello there"), append([]Option{withRequestCapture(capture)}, tc.opts...)...)
+
+			if _, err := client.Complete(context.Background(), "Say hello"); err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			if _, err := client.GenerateStream(context.Background(), "Say hello", func(string) error { return nil }); err != nil {
+				t.Fatalf("GenerateStream: %v", err)
+			}
+			headers := capture.captured()
+			if len(headers) != 2 {
+				t.Fatalf("sent %d requests, want 2", len(headers))
+			}
+			for i, kind := range []string{"completion", "stream"} {
+				if got := headers[i].Get("User-Agent"); !regexp.MustCompile(tc.want).MatchString(got) {
+					t.Errorf("%s sent User-Agent %q, want match for %s", kind, got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestUserAgentInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Option
+	}{
+		{name: "empty", opt: WithUserAgent("")},
+		{name: "line break in suffix", opt: WithUserAgentSuffix("my-app/1.2\r\nX-Injected: 1")},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clearClientEnv(t)
+			captureLogs(t)
+			if _, err := NewClient(WithAPIKey(testAPIKey), tc.opt); err == nil {
+				t.Error("NewClient succeeded, want the user agent rejected")
+			}
+		})
+	}
+}
+
+func TestModuleVersionFallback(t *testing.T) {
+	//Test binaries are development builds without a tagged version
+	if got := moduleVersion(); got != VERSION {
+		t.Errorf("got version %q, want %q", got, VERSION)
+	}
+}
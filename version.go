@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Version of go-llama, used when build info has no module version, e.g. in development builds
+const VERSION = "0.1.0"
+
+// Import path of this module, looked up in build info
+const MODULE_PATH = "github.com/takumi616/go-llama"
+
+// Default User-Agent header sent with requests, e.g. "go-llama/0.1.0 (linux; amd64)"
+var defaultUserAgent = fmt.Sprintf("go-llama/%s (%s; %s)", moduleVersion(), runtime.GOOS, runtime.GOARCH)
+
+// Get version of this module from build info, falling back to VERSION
+func moduleVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return VERSION
+	}
+
+	version := ""
+	if info.Main.Path == MODULE_PATH {
+		version = info.Main.Version
+	} else {
+		for _, dep := range info.Deps {
+			if dep.Path == MODULE_PATH {
+				version = dep.Version
+				break
+			}
+		}
+	}
+
+	//Local builds report (devel) instead of a tagged version
+	if version == "" || version == "(devel)" {
+		return VERSION
+	}
+	return strings.TrimPrefix(version, "v")
+}